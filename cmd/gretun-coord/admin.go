@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/coord"
+)
+
+const adminUsage = `usage: gretun-coord admin [flags] <command> [args]
+
+commands:
+  nodes ls                     list registered nodes
+  nodes rm <node>              delete a node and free its tunnel IP
+  nodes disable <node>         kick a node and refuse it until re-enabled
+  nodes enable <node>          re-admit a disabled node
+  nodes rename <node> <name>   rename a node (sticks across re-registers)
+  nodes set-ip <node> <ip>     move a node to another tunnel IP in the pool
+  keys ls                      list auth keys
+  keys create [--reusable] [--expires DURATION] [--tags tag:a,tag:b] [--network NAME]
+                               issue an auth key and print its secret
+  keys revoke <id>             revoke an auth key
+
+<node> is a node ID (hex node key), a unique ID prefix, or a node name.
+
+flags:
+`
+
+// runAdmin implements "gretun-coord admin ...". It only ever talks to a
+// running coordinator over HTTP; it never opens the store itself.
+func runAdmin(args []string) error {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, adminUsage)
+		fs.PrintDefaults()
+	}
+	coordURL := fs.String("coordinator", envOr("GRETUN_COORD", "http://127.0.0.1:8443"),
+		"coordinator base URL (env GRETUN_COORD)")
+	tokenFile := fs.String("token-file", "", "file holding the admin token (default: env GRETUN_ADMIN_TOKEN)")
+	network := fs.String("network", "", "limit ls to one network (default: all)")
+	_ = fs.Parse(args)
+
+	token, err := adminToken(*tokenFile)
+	if err != nil {
+		return err
+	}
+	c := coord.NewAdminClient(*coordURL, token)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rest := fs.Args()
+	if len(rest) < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch rest[0] {
+	case "nodes":
+		return adminNodes(ctx, c, *network, rest[1], rest[2:])
+	case "keys":
+		return adminKeys(ctx, c, *network, rest[1], rest[2:])
+	default:
+		return fmt.Errorf("unknown admin command %q", rest[0])
+	}
+}
+
+func adminNodes(ctx context.Context, c *coord.AdminClient, network, cmd string, args []string) error {
+	need := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("nodes %s: want %d argument(s), got %d", cmd, n, len(args))
+		}
+		return nil
+	}
+	switch cmd {
+	case "ls", "list":
+		nodes, err := c.Nodes(ctx, network)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNETWORK\tNAME\tTUNNEL IP\tTAGS\tENDPOINTS\tLAST SEEN\tSTATUS")
+		for _, n := range nodes {
+			status := "enabled"
+			if n.Disabled {
+				status = "disabled"
+			}
+			tags := "-"
+			if len(n.Tags) > 0 {
+				tags = strings.Join(n.Tags, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", n.ID[:12], n.Network, n.Name, n.TunnelIP, tags,
+				len(n.Endpoints), time.Since(n.UpdatedAt).Round(time.Second), status)
+		}
+		return w.Flush()
+	case "rm", "delete":
+		if err := need(1); err != nil {
+			return err
+		}
+		return c.DeleteNode(ctx, args[0])
+	case "disable", "enable":
+		if err := need(1); err != nil {
+			return err
+		}
+		disabled := cmd == "disable"
+		_, err := c.UpdateNode(ctx, args[0], coord.AdminNodeUpdate{Disabled: &disabled})
+		return err
+	case "rename":
+		if err := need(2); err != nil {
+			return err
+		}
+		_, err := c.UpdateNode(ctx, args[0], coord.AdminNodeUpdate{Name: &args[1]})
+		return err
+	case "set-ip":
+		if err := need(2); err != nil {
+			return err
+		}
+		ip, err := netip.ParseAddr(args[1])
+		if err != nil {
+			return err
+		}
+		n, err := c.UpdateNode(ctx, args[0], coord.AdminNodeUpdate{TunnelIP: &ip})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s now at %s\n", n.Name, n.TunnelIP)
+		return nil
+	default:
+		return fmt.Errorf("unknown nodes command %q", cmd)
+	}
+}
+
+func adminKeys(ctx context.Context, c *coord.AdminClient, network, cmd string, args []string) error {
+	switch cmd {
+	case "ls", "list":
+		keys, err := c.AuthKeys(ctx, network)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNETWORK\tREUSABLE\tUSES\tEXPIRES\tTAGS\tSTATUS")
+		now := time.Now()
+		for _, k := range keys {
+			expires := "never"
+			if !k.ExpiresAt.IsZero() {
+				expires = k.ExpiresAt.Format(time.RFC3339)
+			}
+			status := "valid"
+			switch {
+			case k.Revoked:
+				status = "revoked"
+			case k.Expired(now):
+				status = "expired"
+			case !k.Reusable && k.Uses > 0:
+				status = "used"
+			}
+			tags := "-"
+			if len(k.Tags) > 0 {
+				tags = strings.Join(k.Tags, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%s\t%s\t%s\n", k.ID, k.Network, k.Reusable, k.Uses, expires, tags, status)
+		}
+		return w.Flush()
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+		reusable := fs.Bool("reusable", false, "allow more than one node to join with this key")
+		expires := fs.Duration("expires", 0, "key lifetime (0 = never expires)")
+		tagList := fs.String("tags", "", "comma-separated tags given to nodes that join with this key")
+		keyNet := fs.String("network", network, "network the key admits to (default: the coordinator's default)")
+		_ = fs.Parse(args)
+		tags, err := coord.ParseTags(*tagList)
+		if err != nil {
+			return err
+		}
+		req := coord.AdminKeyCreateReq{Reusable: *reusable, Tags: tags, Network: *keyNet}
+		if *expires > 0 {
+			req.ExpiresAt = time.Now().Add(*expires).UTC()
+		}
+		resp, err := c.CreateAuthKey(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "created key %s; the secret is shown only once:\n", resp.Key.ID)
+		fmt.Println(resp.Secret)
+		return nil
+	case "revoke":
+		if len(args) != 1 {
+			return errors.New("keys revoke: want 1 argument")
+		}
+		return c.RevokeAuthKey(ctx, args[0])
+	default:
+		return fmt.Errorf("unknown keys command %q", cmd)
+	}
+}
+
+// adminToken reads the admin token from path, falling back to the
+// GRETUN_ADMIN_TOKEN environment variable. Tokens never go on the command
+// line, where they'd show up in ps.
+func adminToken(path string) (string, error) {
+	if path == "" {
+		if tok := os.Getenv("GRETUN_ADMIN_TOKEN"); tok != "" {
+			return tok, nil
+		}
+		return "", errors.New("no admin token: pass --token-file or set GRETUN_ADMIN_TOKEN")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	tok := strings.TrimSpace(string(b))
+	if tok == "" {
+		return "", fmt.Errorf("%s: empty admin token", path)
+	}
+	return tok, nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
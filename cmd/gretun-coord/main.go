@@ -5,25 +5,69 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/netip"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/HueCodes/gretun/internal/coord"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdmin(os.Args[2:]); err != nil {
+			fatal("admin: %v", err)
+		}
+		return
+	}
+
 	listen := flag.String("listen", ":8443", "listen address")
-	poolStr := flag.String("pool", "100.64.0.0/24", "CIDR from which to assign tunnel IPs")
+	poolStr := flag.String("pool", "100.64.0.0/24", "CIDR, IPv4 or IPv6, from which to assign tunnel IPs")
 	certFile := flag.String("cert", "", "TLS cert file (enables HTTPS)")
 	keyFile := flag.String("key", "", "TLS key file (enables HTTPS)")
+	storeSpec := flag.String("store", "memory", `registry backend: "memory" or "file:PATH" (survives restarts)`)
+	requireKey := flag.Bool("require-auth-key", false, "refuse registration of new nodes without a valid auth key")
+	authKeyFile := flag.String("auth-key-file", "", "load auth keys from this file (implies --require-auth-key)")
+	reservationFile := flag.String("reservations", "", "pin node keys to tunnel IPs: one \"NODEKEY IP [network=NAME]\" per line")
+	peerTTL := flag.Duration("peer-ttl", 5*time.Minute, "evict peers not heard from in this long (0 = never)")
+	ipGrace := flag.Duration("ip-grace", time.Hour, "keep an expired peer's tunnel IP reserved this long")
+	aclFile := flag.String("acl-file", "", "JSON ACL policy limiting which nodes see and signal each other (reloaded on SIGHUP)")
+	networks := map[string]netip.Prefix{}
+	flag.Func("network", "extra isolated network as NAME=CIDR (repeatable); --pool is the \"default\" network",
+		func(v string) error {
+			name, cidr, ok := strings.Cut(v, "=")
+			if !ok || name == "" || name == coord.DefaultNetwork {
+				return errors.New("want NAME=CIDR with NAME other than \"default\"")
+			}
+			p, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return err
+			}
+			networks[name] = p
+			return nil
+		})
+	adminTokenFile := flag.String("admin-token-file", "", "enable the /admin/v1 API with the bearer token in this file")
+	rateLimit := flag.Bool("rate-limit", true, "throttle register, endpoints and signal per node key and source IP")
+	signingKeyFile := flag.String("signing-key", "", "sign peer lists with the Ed25519 key in this file, creating it if missing (daemons pin it with --coord-pubkey)")
+	haLock := flag.String("ha-lock", "", "run as one of several replicas sharing a file: store; the one holding this lock file serves, the rest answer 503")
+	advertise := flag.String("advertise", "", "URL standbys point clients at while this replica leads (with --ha-lock)")
+	stunListen := flag.String("stun-listen", "", "answer STUN binding requests on this UDP host:port and advertise it to nodes (e.g. :3478)")
+	stunAdvertise := flag.String("stun-advertise", "", "host:port nodes should use for --stun-listen (default: the host they reached the coordinator on)")
+	relay := flag.Bool("relay", false, "relay FOU datagrams between nodes that can't punch a direct path")
+	metricsAddr := flag.String("metrics-addr", "", "expose Prometheus /metrics on this host:port (empty = disabled)")
 	verbose := flag.Bool("v", false, "debug logging")
 	flag.Parse()
 
@@ -35,20 +79,155 @@ func main() {
 	if err != nil {
 		fatal("invalid --pool: %v", err)
 	}
+	if *haLock != "" && !strings.HasPrefix(*storeSpec, "file:") {
+		fatal("--ha-lock needs a file: store that every replica shares")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var reg *prometheus.Registry
+	if *metricsAddr != "" {
+		reg = prometheus.NewRegistry()
+		serveMetrics(ctx, *metricsAddr, reg)
+	}
+
+	// lead opens the stores and starts everything that must run on one
+	// replica only. With --ha-lock it runs once this replica holds the lock,
+	// so it reads whatever the previous leader last wrote.
+	lead := func() *coord.Server {
+		stores := map[string]coord.Store{}
+		store, err := openStore(*storeSpec, coord.DefaultNetwork, pool)
+		if err != nil {
+			fatal("--store: %v", err)
+		}
+		stores[coord.DefaultNetwork] = store
+		var opts []coord.Option
+		for name, p := range networks {
+			st, err := openStore(*storeSpec, name, p)
+			if err != nil {
+				fatal("--store for network %s: %v", name, err)
+			}
+			stores[name] = st
+			opts = append(opts, coord.WithNetwork(name, st))
+		}
+		if *authKeyFile != "" {
+			if err := loadAuthKeys(stores, *authKeyFile); err != nil {
+				fatal("--auth-key-file: %v", err)
+			}
+			*requireKey = true
+		}
+		if *reservationFile != "" {
+			if err := loadReservations(stores, *reservationFile); err != nil {
+				fatal("--reservations: %v", err)
+			}
+		}
+		if *requireKey {
+			opts = append(opts, coord.WithRequireAuthKey())
+		}
+		if *adminTokenFile != "" {
+			tok, err := adminToken(*adminTokenFile)
+			if err != nil {
+				fatal("--admin-token-file: %v", err)
+			}
+			opts = append(opts, coord.WithAdminToken(tok))
+		}
+		if *aclFile != "" {
+			policy, err := loadACLPolicy(*aclFile)
+			if err != nil {
+				fatal("--acl-file: %v", err)
+			}
+			opts = append(opts, coord.WithACLPolicy(policy))
+		}
+		if *rateLimit {
+			opts = append(opts, coord.WithRateLimits(coord.DefaultRateLimits))
+		}
+		if *signingKeyFile != "" {
+			priv, err := coord.LoadOrCreateSigningKey(*signingKeyFile)
+			if err != nil {
+				fatal("--signing-key: %v", err)
+			}
+			opts = append(opts, coord.WithSigningKey(priv))
+			slog.Info("signing peer lists", "pubkey", base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)))
+		}
+		if *stunListen != "" {
+			adv := *stunAdvertise
+			if adv == "" {
+				adv = *stunListen
+			}
+			opts = append(opts, coord.WithSTUNAddr(adv))
+		}
+		if *relay {
+			opts = append(opts, coord.WithRelay())
+		}
+		if reg != nil {
+			opts = append(opts, coord.WithMetrics(reg))
+		}
+		srv := coord.NewServer(store, opts...)
 
-	store := coord.NewMemStore(pool)
-	srv := coord.NewServer(store)
+		if *peerTTL > 0 {
+			for _, st := range stores {
+				go coord.RunReaper(ctx, st, *peerTTL, *ipGrace)
+			}
+		}
+		if *haLock != "" {
+			for _, st := range stores {
+				if fs, ok := st.(*coord.FileStore); ok {
+					go fs.MirrorSignals(ctx, signalMirrorEvery)
+				}
+			}
+		}
+		if *aclFile != "" {
+			go reloadACLOnHUP(ctx, srv, *aclFile)
+		}
+		slog.Info("gretun-coord serving", "addr", *listen, "pool", pool.String(), "networks", len(stores),
+			"store", *storeSpec,
+			"require_auth_key", *requireKey, "acl", *aclFile, "admin_api", *adminTokenFile != "")
+		return srv
+	}
+
+	// Every replica answers STUN: it needs no state, and nodes may still
+	// hold a standby's address from an earlier registration.
+	if *stunListen != "" {
+		conn, err := net.ListenPacket("udp", *stunListen)
+		if err != nil {
+			fatal("--stun-listen: %v", err)
+		}
+		go func() {
+			if err := coord.ServeSTUN(ctx, conn); err != nil {
+				slog.Error("STUN server", "err", err)
+			}
+		}()
+		slog.Info("STUN listening", "addr", conn.LocalAddr())
+	}
 
 	httpServer := &http.Server{
 		Addr:         *listen,
-		Handler:      srv,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 35 * time.Second,
 		IdleTimeout:  90 * time.Second,
 	}
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	if *haLock == "" {
+		httpServer.Handler = lead()
+	} else {
+		standby := coord.NewStandby(*haLock)
+		httpServer.Handler = standby
+		go func() {
+			slog.Info("standing by for leadership", "lock", *haLock, "leader", coord.CurrentLeader(*haLock))
+			lock, err := coord.AcquireLeadership(ctx, *haLock, *advertise)
+			if err != nil {
+				if ctx.Err() == nil {
+					fatal("--ha-lock: %v", err)
+				}
+				return
+			}
+			// Exiting releases the lock; keep it until then.
+			defer lock.Release()
+			slog.Info("acquired leadership", "lock", *haLock, "advertise", *advertise)
+			standby.Promote(lead())
+			<-ctx.Done()
+		}()
+	}
 
 	go func() {
 		<-ctx.Done()
@@ -58,7 +237,7 @@ func main() {
 		_ = httpServer.Shutdown(shutdownCtx)
 	}()
 
-	slog.Info("gretun-coord listening", "addr", *listen, "pool", pool.String())
+	slog.Info("gretun-coord listening", "addr", *listen, "ha_lock", *haLock)
 
 	var runErr error
 	if *certFile != "" && *keyFile != "" {
@@ -71,6 +250,152 @@ func main() {
 	}
 }
 
+// signalMirrorEvery is how often the leader writes the signal queues for a
+// standby to pick up. Anything queued since the last write is lost on
+// failover; daemons re-send.
+const signalMirrorEvery = time.Second
+
+// serveMetrics exposes reg on its own listener until ctx is done.
+func serveMetrics(ctx context.Context, addr string, reg *prometheus.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	metricsServer := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 35 * time.Second,
+		IdleTimeout:  90 * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("metrics server", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = metricsServer.Close()
+	}()
+	slog.Info("metrics listening", "addr", addr)
+}
+
+// openStore parses a --store spec. "memory" keeps everything in RAM;
+// "file:PATH" mirrors the registry to PATH so tunnel IPs are stable across
+// restarts. Networks other than the default get a sibling file:
+// registry.json → registry.NAME.json.
+func openStore(spec, network string, pool netip.Prefix) (coord.Store, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return coord.NewMemStore(pool), nil
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, errors.New("file: needs a path")
+		}
+		if network != coord.DefaultNetwork {
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + "." + network + ext
+		}
+		return coord.OpenFileStore(path, pool)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", spec)
+	}
+}
+
+// loadAuthKeys seeds each network's store from an auth key file. Keys
+// already in a store keep their use counts.
+func loadAuthKeys(stores map[string]coord.Store, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keys, err := coord.ParseAuthKeyFile(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, k := range keys {
+		network := k.Network
+		if network == "" {
+			network = coord.DefaultNetwork
+		}
+		store, ok := stores[network]
+		if !ok {
+			return fmt.Errorf("%s: key %s names unknown network %q", path, k.ID, network)
+		}
+		if err := store.AddAuthKey(context.Background(), k); err != nil {
+			return err
+		}
+	}
+	slog.Info("loaded auth keys", "file", path, "count", len(keys))
+	return nil
+}
+
+// loadReservations pins node keys to tunnel IPs in their network's store.
+func loadReservations(stores map[string]coord.Store, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rs, err := coord.ParseReservationFile(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, r := range rs {
+		network := r.Network
+		if network == "" {
+			network = coord.DefaultNetwork
+		}
+		store, ok := stores[network]
+		if !ok {
+			return fmt.Errorf("%s: reservation for %s names unknown network %q", path, r.TunnelIP, network)
+		}
+		if err := store.Reserve(context.Background(), r.NodeKey, r.TunnelIP); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	slog.Info("loaded reservations", "file", path, "count", len(rs))
+	return nil
+}
+
+func loadACLPolicy(path string) (*coord.ACLPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := coord.ParseACLPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// reloadACLOnHUP re-reads the policy file on SIGHUP. A broken file keeps
+// the old policy in force rather than failing open.
+func reloadACLOnHUP(ctx context.Context, srv *coord.Server, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			p, err := loadACLPolicy(path)
+			if err != nil {
+				slog.Error("ACL reload failed; keeping previous policy", "err", err)
+				continue
+			}
+			if err := srv.SetACLPolicy(ctx, p); err != nil {
+				slog.Error("ACL reload", "err", err)
+				continue
+			}
+			slog.Info("ACL policy reloaded", "file", path, "rules", len(p.ACLs))
+		}
+	}
+}
+
 func fatal(format string, args ...any) {
 	fmt.Fprintf(os.Stderr, "gretun-coord: "+format+"\n", args...)
 	os.Exit(1)
@@ -35,7 +35,7 @@ func init() {
 	createCmd.Flags().Uint16("encap-sport", 0, "outer UDP source port (0 = flow-hash)")
 	createCmd.Flags().Uint16("encap-dport", 0, "outer UDP destination port (required if --encap != none)")
 	createCmd.Flags().Bool("encap-csum", true, "emit UDP checksum on outer packets")
-	createCmd.Flags().Int("mtu", 0, "interface MTU (0 = auto; 1468 for FOU/IPv4)")
+	createCmd.Flags().Int("mtu", 0, "interface MTU (0 = auto; 1468 for FOU/IPv4, 1448 for FOU/IPv6)")
 
 	_ = createCmd.MarkFlagRequired("name")
 	_ = createCmd.MarkFlagRequired("local")
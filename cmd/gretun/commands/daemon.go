@@ -0,0 +1,119 @@
+//go:build linux
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Query the running daemon over its control socket",
+	Long: `Talk to the daemon started by "gretun up" over its control socket
+(--control-socket on both sides; /run/gretun.sock by default). Only root
+and the user running the daemon may connect.`,
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "Show the daemon's identity, endpoints and NAT type",
+	Example: "  sudo gretun daemon status",
+	Args:    cobra.NoArgs,
+	RunE:    runDaemonStatus,
+}
+
+var daemonPingCmd = &cobra.Command{
+	Use:   "ping <peer>",
+	Short: "Send a disco ping to a peer and print the round trip",
+	Long: `Ping a peer, named by node name, tunnel IP or node key prefix, along
+the path the daemon currently uses for it: the FOU port once that is
+proven, the disco socket otherwise, or every endpoint the peer advertises
+while it is still being punched. Fails if no pong arrives within
+--timeout.`,
+	Example: `  sudo gretun daemon ping site-b
+  sudo gretun daemon ping 100.64.0.2 --count 5`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDaemonPing,
+}
+
+func init() {
+	daemonCmd.PersistentFlags().String("control-socket", daemon.DefaultControlSocket, "daemon control socket")
+	daemonCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+	daemonPingCmd.Flags().IntP("count", "c", 1, "number of pings")
+	daemonPingCmd.Flags().Duration("timeout", 5*time.Second, "how long to wait for each pong")
+	daemonCmd.AddCommand(daemonStatusCmd, daemonPingCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
+
+func runDaemonStatus(cmd *cobra.Command, args []string) error {
+	sock, _ := cmd.Flags().GetString("control-socket")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	st, err := daemon.NewControlClient(sock).Status(ctx)
+	if err != nil {
+		return fmt.Errorf("daemon at %s: %w", sock, err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	eps := make([]string, 0, len(st.Endpoints))
+	for _, e := range st.Endpoints {
+		eps = append(eps, fmt.Sprintf("%s (%s)", e.Addr, e.Source))
+	}
+	fmt.Printf("Node:         %s\n", st.NodeName)
+	fmt.Printf("Node key:     %s\n", st.NodeKey)
+	fmt.Printf("Tunnel IP:    %s\n", st.TunnelIP)
+	fmt.Printf("Coordinator:  %s\n", st.Coordinator)
+	fmt.Printf("Disco socket: %s\n", st.DiscoAddr)
+	fmt.Printf("FOU port:     %d\n", st.FOUPort)
+	fmt.Printf("NAT type:     %s\n", dash(string(st.NAT)))
+	fmt.Printf("Endpoints:    %s\n", dash(strings.Join(eps, ", ")))
+	fmt.Printf("Peers:        %d\n", st.Peers)
+	fmt.Printf("Up:           %s\n", time.Since(st.Started).Round(time.Second))
+	return nil
+}
+
+func runDaemonPing(cmd *cobra.Command, args []string) error {
+	sock, _ := cmd.Flags().GetString("control-socket")
+	count, _ := cmd.Flags().GetInt("count")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	client := daemon.NewControlClient(sock)
+
+	var results []daemon.PingResp
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		// The daemon gives up after timeout; allow for the round trip to it.
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
+		res, err := client.Ping(ctx, args[0], timeout)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			results = append(results, res)
+			continue
+		}
+		fmt.Printf("pong from %s (%s): %.2fms\n", res.Peer, res.From, res.RTTMillis)
+	}
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	return nil
+}
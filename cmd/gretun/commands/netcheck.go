@@ -0,0 +1,91 @@
+//go:build linux
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/spf13/cobra"
+)
+
+var netcheckCmd = &cobra.Command{
+	Use:   "netcheck",
+	Short: "Classify this host's NAT (RFC 5780)",
+	Long: `Open an IPv4 UDP socket and ask several STUN servers for its public
+mapping. Comparing the answers tells an endpoint-independent NAT (one
+mapping for everyone, easy to punch) from an address-dependent or
+symmetric one (a mapping per destination). Also reports whether the NAT
+kept the local port and whether it hairpins traffic sent to our own
+public address. The daemon runs the same check at startup and publishes
+the result to the coordinator.
+
+Telling NAT types apart needs at least two servers on distinct IPs.`,
+	Example: `  gretun netcheck
+  gretun netcheck --server stun.l.google.com:19302 --server stun.cloudflare.com:3478 --json`,
+	RunE: runNetcheck,
+}
+
+func init() {
+	netcheckCmd.Flags().StringSlice("server", nil, "STUN server host:port (repeatable); defaults to pion/Cloudflare/Google")
+	netcheckCmd.Flags().Duration("timeout", 5*time.Second, "overall timeout")
+	// Like stun, this needs no CAP_NET_ADMIN.
+	netcheckCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+	rootCmd.AddCommand(netcheckCmd)
+}
+
+func runNetcheck(cmd *cobra.Command, args []string) error {
+	servers, _ := cmd.Flags().GetStringSlice("server")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return fmt.Errorf("listen udp4: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rep, err := disco.Netcheck(ctx, conn, servers)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		type mapping struct {
+			Server string `json:"server"`
+			Addr   string `json:"addr"`
+		}
+		out := struct {
+			NAT           disco.NATType `json:"nat"`
+			PortPreserved bool          `json:"port_preserved"`
+			Hairpin       bool          `json:"hairpin"`
+			Mappings      []mapping     `json:"mappings"`
+		}{NAT: rep.NAT, PortPreserved: rep.PortPreserved, Hairpin: rep.Hairpin}
+		for _, m := range rep.Mappings {
+			out.Mappings = append(out.Mappings, mapping{Server: m.Server, Addr: m.Addr.String()})
+		}
+		return json.NewEncoder(os.Stdout).Encode(out)
+	}
+
+	for _, m := range rep.Mappings {
+		fmt.Printf("%-22s  (via %s)\n", m.Addr.String(), m.Server)
+	}
+	fmt.Printf("NAT type:        %s\n", rep.NAT)
+	fmt.Printf("Port preserved:  %s\n", yesNo(rep.PortPreserved))
+	fmt.Printf("Hairpinning:     %s\n", yesNo(rep.Hairpin))
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "List the running daemon's peers and how each is reached",
+	Long: `Ask the daemon started by "gretun up" for its peers over the control
+socket: each peer's state (punching, direct or relayed) and since when,
+the tunnel interface and remote it uses, the endpoints it punches, and
+the round-trip time of its last answered disco ping.`,
+	Example: `  sudo gretun peers
+  sudo gretun peers --json`,
+	RunE: runPeers,
+}
+
+func init() {
+	peersCmd.Flags().String("control-socket", daemon.DefaultControlSocket, "daemon control socket")
+	// The daemon does the privileged work; the socket's permissions decide
+	// who may ask.
+	peersCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+	rootCmd.AddCommand(peersCmd)
+}
+
+func runPeers(cmd *cobra.Command, args []string) error {
+	sock, _ := cmd.Flags().GetString("control-socket")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peers, err := daemon.NewControlClient(sock).Peers(ctx)
+	if err != nil {
+		return fmt.Errorf("daemon at %s: %w", sock, err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(peers)
+	}
+
+	if len(peers) == 0 {
+		fmt.Println("no peers")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTUNNEL IP\tSTATE\tSINCE\tIFACE\tREMOTE\tRTT\tLAST PONG")
+	for _, p := range peers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Name, p.TunnelIP, p.State, ago(p.Since), dash(p.Iface), dash(p.Remote), rtt(p.RTTMillis), ago(p.LastPong))
+	}
+	return w.Flush()
+}
+
+// ago renders t as a duration before now, rounded to the second.
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func rtt(ms float64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fms", ms)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -4,7 +4,10 @@ package commands
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -21,9 +24,19 @@ var upCmd = &cobra.Command{
 	Long: `Long-lived daemon. Loads or generates node + disco keypairs from
 --state-dir, opens a disco UDP socket, STUN-discovers this host's public
 endpoint, registers with the given coordinator, and brings up GRE-over-FOU
-tunnels to each reachable peer. SIGINT/SIGTERM tears everything down.`,
+tunnels to each reachable peer. SIGINT/SIGTERM tears everything down.
+
+Each tunnel's local address is the source the kernel would pick on its
+route to the peer. --bind-iface keeps the outer path on one interface;
+--local-ip fixes the address outright. Either also binds the disco, STUN
+and FOU-port sockets, so punching happens on the same path.`,
 	Example: `  sudo gretun up --coordinator http://coord.example.com:8443
-  sudo gretun up --coordinator https://coord.example.com --node-name site-a --fou-port 7777`,
+  sudo gretun up --coordinator https://coord.example.com --node-name site-a --fou-port 7777
+  sudo gretun up --coordinator https://coord.example.com --auth-key gretun-auth-3f9c...
+  sudo gretun up --coordinator https://coord.example.com --tunnel-ip 100.64.0.10
+  sudo gretun up --coordinator https://coord-a.example.com,https://coord-b.example.com
+  sudo gretun up --coordinator https://coord.example.com --bind-iface eth1
+  sudo gretun up --coordinator http://coord.example.com:8443 --coord-pubkey 3q2+7w...=`,
 	RunE: runUp,
 }
 
@@ -31,28 +44,63 @@ func init() {
 	host, _ := os.Hostname()
 	def := filepath.Join(os.Getenv("HOME"), ".config", "gretun")
 
-	upCmd.Flags().String("coordinator", "", "coordinator URL (required)")
+	upCmd.Flags().StringSlice("coordinator", nil, "coordinator URL (required); repeat or comma-separate replicas to fail over between")
 	upCmd.Flags().String("iface", "gretun%d", "interface name pattern (%d → peer index)")
 	upCmd.Flags().Uint16("fou-port", 7777, "kernel FOU RX port for GRE-over-UDP")
 	upCmd.Flags().String("node-name", host, "human-readable node name")
+	upCmd.Flags().String("auth-key", "", "pre-auth key for first registration with a coordinator that requires one")
+	upCmd.Flags().String("network", "", "coordinator network to join (default: the auth key's, else the coordinator default)")
+	upCmd.Flags().String("tunnel-ip", "", "tunnel address to request from the coordinator (granted only if free and in its pool)")
+	upCmd.Flags().String("coord-pubkey", "", "coordinator's netmap signing key (base64 or hex); unsigned or tampered peer lists are refused")
 	upCmd.Flags().String("state-dir", def, "directory for persistent keys")
 	upCmd.Flags().Bool("aggressive-punch", false, "use 256-port probing for symmetric NAT (experimental)")
-	upCmd.Flags().StringSlice("stun-server", nil, "STUN server host:port (repeatable)")
+	upCmd.Flags().Bool("portmap", true, "ask the gateway (PCP, NAT-PMP, UPnP IGD) to forward the disco and FOU ports")
+	upCmd.Flags().StringSlice("stun-server", nil, "STUN server host:port (repeatable; default: the coordinator's, else public servers)")
 	upCmd.Flags().String("metrics-addr", "", "expose Prometheus /metrics on this host:port (empty = disabled)")
+	upCmd.Flags().String("bind-iface", "", "send tunnel traffic out of this interface only (default: whichever the route to each peer uses)")
+	upCmd.Flags().String("local-ip", "", "local address for every tunnel (default: the source of the route to each peer)")
+	upCmd.Flags().String("control-socket", daemon.DefaultControlSocket, "Unix socket the peers and daemon commands talk to (empty = disabled)")
 
 	_ = upCmd.MarkFlagRequired("coordinator")
 	rootCmd.AddCommand(upCmd)
 }
 
 func runUp(cmd *cobra.Command, args []string) error {
-	coordURL, _ := cmd.Flags().GetString("coordinator")
+	coordURLs, _ := cmd.Flags().GetStringSlice("coordinator")
 	iface, _ := cmd.Flags().GetString("iface")
 	fouPort, _ := cmd.Flags().GetUint16("fou-port")
 	name, _ := cmd.Flags().GetString("node-name")
+	authKey, _ := cmd.Flags().GetString("auth-key")
+	network, _ := cmd.Flags().GetString("network")
+	tunnelIP, _ := cmd.Flags().GetString("tunnel-ip")
+	coordPubStr, _ := cmd.Flags().GetString("coord-pubkey")
 	stateDir, _ := cmd.Flags().GetString("state-dir")
 	aggressive, _ := cmd.Flags().GetBool("aggressive-punch")
+	portMap, _ := cmd.Flags().GetBool("portmap")
 	stunServers, _ := cmd.Flags().GetStringSlice("stun-server")
 	metricsAddr, _ := cmd.Flags().GetString("metrics-addr")
+	controlSocket, _ := cmd.Flags().GetString("control-socket")
+	bindIface, _ := cmd.Flags().GetString("bind-iface")
+	localIPStr, _ := cmd.Flags().GetString("local-ip")
+
+	if tunnelIP != "" {
+		if _, err := netip.ParseAddr(tunnelIP); err != nil {
+			return fmt.Errorf("--tunnel-ip: %q is not an IP address", tunnelIP)
+		}
+	}
+
+	localIP, err := checkLocalPath(bindIface, localIPStr)
+	if err != nil {
+		return err
+	}
+
+	var coordPub ed25519.PublicKey
+	if coordPubStr != "" {
+		var err error
+		if coordPub, err = disco.ParseCoordKey(coordPubStr); err != nil {
+			return fmt.Errorf("--coord-pubkey: %w", err)
+		}
+	}
 
 	nk, dk, err := disco.LoadOrCreateKeys(stateDir)
 	if err != nil {
@@ -60,14 +108,22 @@ func runUp(cmd *cobra.Command, args []string) error {
 	}
 
 	d := daemon.New(daemon.Config{
-		Coordinator: coordURL,
-		NodeName:    name,
-		StateDir:    stateDir,
-		Iface:       iface,
-		FOUPort:     fouPort,
-		STUNServers: stunServers,
-		Aggressive:  aggressive,
-		MetricsAddr: metricsAddr,
+		Coordinators:  coordURLs,
+		NodeName:      name,
+		AuthKey:       authKey,
+		Network:       network,
+		TunnelIP:      tunnelIP,
+		CoordPubkey:   coordPub,
+		StateDir:      stateDir,
+		Iface:         iface,
+		FOUPort:       fouPort,
+		STUNServers:   stunServers,
+		Aggressive:    aggressive,
+		PortMap:       portMap,
+		MetricsAddr:   metricsAddr,
+		ControlSocket: controlSocket,
+		BindIface:     bindIface,
+		LocalIP:       localIP,
 	}, nl, nk, dk)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -75,3 +131,44 @@ func runUp(cmd *cobra.Command, args []string) error {
 
 	return d.Run(ctx)
 }
+
+// checkLocalPath validates --bind-iface and --local-ip: the interface must
+// exist, and the address must be one of this host's, on that interface if
+// both are given.
+func checkLocalPath(bindIface, localIP string) (netip.Addr, error) {
+	var ifi *net.Interface
+	if bindIface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(bindIface); err != nil {
+			return netip.Addr{}, fmt.Errorf("--bind-iface: no interface %q", bindIface)
+		}
+	}
+	if localIP == "" {
+		return netip.Addr{}, nil
+	}
+	ip, err := netip.ParseAddr(localIP)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("--local-ip: %q is not an IP address", localIP)
+	}
+	ip = ip.Unmap()
+
+	var addrs []net.Addr
+	where := "this host"
+	if ifi != nil {
+		addrs, err = ifi.Addrs()
+		where = bindIface
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("--local-ip: listing addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			if have, ok := netip.AddrFromSlice(ipn.IP); ok && have.Unmap() == ip {
+				return ip, nil
+			}
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("--local-ip: %s is not an address of %s", ip, where)
+}
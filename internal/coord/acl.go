@@ -0,0 +1,161 @@
+package coord
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ACLPolicy decides which pairs of nodes may see and signal each other.
+// With a policy loaded, anything not allowed by a rule is denied; with no
+// policy (nil), every node sees every other node.
+//
+// Selectors name a set of nodes:
+//
+//	"*"          every node
+//	tag:NAME     nodes carrying the tag (from their auth key or from Tags)
+//	name:NAME    nodes registered under that node name
+//	node:HEX     the node with that hex node key
+//
+// Node names are chosen by the node itself, so name: selectors are only as
+// trustworthy as the auth keys that let the node in; prefer tags from
+// auth keys for anything security-relevant.
+type ACLPolicy struct {
+	// Tags assigns tags to nodes in addition to the ones their auth key
+	// carried: "tag:prod": ["name:db-1", "node:3f9c..."].
+	Tags map[string][]string `json:"tags,omitempty"`
+	ACLs []ACLRule           `json:"acls"`
+}
+
+// ACLRule lets every node matching Src and every node matching Dst see and
+// signal each other. Rules are symmetric because a tunnel needs both ends
+// to punch.
+type ACLRule struct {
+	Src []string `json:"src"`
+	Dst []string `json:"dst"`
+}
+
+// ParseACLPolicy decodes and validates a JSON policy.
+func ParseACLPolicy(r io.Reader) (*ACLPolicy, error) {
+	var p ACLPolicy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("decode policy: %w", err)
+	}
+	for tag, members := range p.Tags {
+		if !validTag(tag) {
+			return nil, fmt.Errorf("tags: %q is not a tag:NAME", tag)
+		}
+		for _, m := range members {
+			if !strings.HasPrefix(m, "name:") && !strings.HasPrefix(m, "node:") {
+				return nil, fmt.Errorf("tags[%s]: member %q must be a name: or node: selector", tag, m)
+			}
+			if err := validateSelector(m); err != nil {
+				return nil, fmt.Errorf("tags[%s]: %w", tag, err)
+			}
+		}
+	}
+	for i, rule := range p.ACLs {
+		if len(rule.Src) == 0 || len(rule.Dst) == 0 {
+			return nil, fmt.Errorf("acls[%d]: src and dst must both be non-empty", i)
+		}
+		for _, sel := range append(append([]string(nil), rule.Src...), rule.Dst...) {
+			if err := validateSelector(sel); err != nil {
+				return nil, fmt.Errorf("acls[%d]: %w", i, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+func validateSelector(sel string) error {
+	if sel == "*" {
+		return nil
+	}
+	kind, val, ok := strings.Cut(sel, ":")
+	if !ok || val == "" {
+		return fmt.Errorf("bad selector %q", sel)
+	}
+	switch kind {
+	case "tag", "name":
+		return nil
+	case "node":
+		if b, err := hex.DecodeString(val); err != nil || len(b) == 0 {
+			return fmt.Errorf("bad node key in selector %q", sel)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown selector kind in %q", sel)
+	}
+}
+
+// Allowed reports whether a and b may see and signal each other. A node is
+// always allowed to see itself.
+func (p *ACLPolicy) Allowed(a, b Peer) bool {
+	if p == nil || string(a.NodeKey) == string(b.NodeKey) {
+		return true
+	}
+	ta, tb := p.tagsOf(a), p.tagsOf(b)
+	for _, rule := range p.ACLs {
+		if p.matchAny(rule.Src, a, ta) && p.matchAny(rule.Dst, b, tb) ||
+			p.matchAny(rule.Src, b, tb) && p.matchAny(rule.Dst, a, ta) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible filters peers down to the ones viewer is allowed to see.
+func (p *ACLPolicy) Visible(viewer Peer, peers []Peer) []Peer {
+	if p == nil {
+		return peers
+	}
+	out := make([]Peer, 0, len(peers))
+	for _, q := range peers {
+		if p.Allowed(viewer, q) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// tagsOf is the union of the peer's own tags and those the policy assigns.
+func (p *ACLPolicy) tagsOf(peer Peer) map[string]bool {
+	tags := make(map[string]bool, len(peer.Tags))
+	for _, t := range peer.Tags {
+		tags[t] = true
+	}
+	for tag, members := range p.Tags {
+		if p.matchAny(members, peer, nil) {
+			tags[tag] = true
+		}
+	}
+	return tags
+}
+
+func (p *ACLPolicy) matchAny(sels []string, peer Peer, tags map[string]bool) bool {
+	for _, sel := range sels {
+		if sel == "*" {
+			return true
+		}
+		kind, val, _ := strings.Cut(sel, ":")
+		switch kind {
+		case "tag":
+			if tags[sel] {
+				return true
+			}
+		case "name":
+			if peer.Name == val {
+				return true
+			}
+		case "node":
+			if strings.EqualFold(hex.EncodeToString(peer.NodeKey), val) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package coord
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustPolicy(t *testing.T, js string) *ACLPolicy {
+	t.Helper()
+	p, err := ParseACLPolicy(strings.NewReader(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestACLPolicy_Allowed(t *testing.T) {
+	prod1 := makePeer(t, "db-1")
+	prod1.Tags = []string{"tag:prod"}
+	prod2 := makePeer(t, "web-1")
+	lab := makePeer(t, "lab-box")
+	lab.Tags = []string{"tag:lab"}
+	ops := makePeer(t, "ops")
+
+	p := mustPolicy(t, `{
+		"tags": {"tag:prod": ["name:web-1"], "tag:ops": ["node:`+hex.EncodeToString(ops.NodeKey)+`"]},
+		"acls": [
+			{"src": ["tag:prod"], "dst": ["tag:prod"]},
+			{"src": ["tag:ops"], "dst": ["*"]}
+		]
+	}`)
+
+	cases := []struct {
+		a, b Peer
+		want bool
+	}{
+		{prod1, prod2, true}, // key tag + policy tag
+		{prod1, lab, false},
+		{lab, prod2, false},
+		{ops, lab, true},
+		{lab, ops, true}, // rules are symmetric
+		{lab, lab, true}, // self
+	}
+	for _, c := range cases {
+		if got := p.Allowed(c.a, c.b); got != c.want {
+			t.Errorf("Allowed(%s, %s) = %v, want %v", c.a.Name, c.b.Name, got, c.want)
+		}
+	}
+
+	var nilPolicy *ACLPolicy
+	if !nilPolicy.Allowed(prod1, lab) {
+		t.Error("nil policy should allow everything")
+	}
+}
+
+func TestParseACLPolicy_Errors(t *testing.T) {
+	for _, js := range []string{
+		`{"acls": [{"src": ["tag:a"]}]}`,
+		`{"acls": [{"src": ["group:a"], "dst": ["*"]}]}`,
+		`{"acls": [{"src": ["node:zz"], "dst": ["*"]}]}`,
+		`{"tags": {"prod": ["name:a"]}, "acls": []}`,
+		`{"tags": {"tag:prod": ["tag:other"]}, "acls": []}`,
+		`{"acl": []}`,
+	} {
+		if _, err := ParseACLPolicy(strings.NewReader(js)); err == nil {
+			t.Errorf("expected error for %s", js)
+		}
+	}
+}
+
+func TestServer_ACLFiltersPeersAndSignals(t *testing.T) {
+	store := newTestStore(t)
+	for secret, tag := range map[string]string{"prod-key": "tag:prod", "lab-key": "tag:lab"} {
+		k := NewAuthKey(secret, true, time.Time{})
+		k.Tags = []string{tag}
+		_ = store.AddAuthKey(context.Background(), k)
+	}
+	policy := mustPolicy(t, `{"acls": [{"src": ["tag:prod"], "dst": ["tag:prod"]}]}`)
+	srv := NewServer(store, WithACLPolicy(policy))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	join := func(key string) *testClient {
+		c := newTestClient(t, ts.URL)
+		resp := postRegister(t, ts.URL, RegisterReq{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub, AuthKey: key})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("register with %s: %d", key, resp.StatusCode)
+		}
+		return c
+	}
+	p1, p2, lab := join("prod-key"), join("prod-key"), join("lab-key")
+
+	peersOf := func(c *testClient) int {
+		resp := c.do(t, "GET", "/v1/peers", nil)
+		defer resp.Body.Close()
+		var out PeersResp
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return len(out.Peers)
+	}
+	if n := peersOf(p1); n != 2 {
+		t.Errorf("prod node should see itself and the other prod node, got %d", n)
+	}
+	if n := peersOf(lab); n != 1 {
+		t.Errorf("lab node should only see itself, got %d", n)
+	}
+
+	signal := func(from, to *testClient) int {
+		resp := from.do(t, "POST", "/v1/signal", SignalReq{To: to.dk.Pub, Sealed: []byte("x")})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := signal(p1, p2); code != http.StatusOK {
+		t.Errorf("prod→prod signal: want 200, got %d", code)
+	}
+	if code := signal(lab, p1); code != http.StatusForbidden {
+		t.Errorf("lab→prod signal: want 403, got %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/debug/peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("/debug/peers under a policy: want 403, got %d", resp.StatusCode)
+	}
+
+	// Opening everything up takes effect without a restart.
+	if err := srv.SetACLPolicy(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := peersOf(lab); n != 3 {
+		t.Errorf("after clearing the policy lab should see all 3, got %d", n)
+	}
+}
+
+func TestParseAuthKeyFile_Tags(t *testing.T) {
+	keys, err := ParseAuthKeyFile(strings.NewReader("k1 tags=tag:a,tag:b reusable\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys[0].Tags, ","); got != "tag:a,tag:b" {
+		t.Errorf("tags = %q", got)
+	}
+	if _, err := ParseAuthKeyFile(strings.NewReader("k1 tags=prod\n")); err == nil {
+		t.Error("untagged tag name should be rejected")
+	}
+}
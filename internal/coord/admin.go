@@ -0,0 +1,309 @@
+package coord
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// AdminNode is a registered peer as the admin API shows it. ID is the hex
+// node key, which is what the /admin/v1/nodes/{id} routes take (a node
+// name or unique ID prefix works too).
+type AdminNode struct {
+	ID      string `json:"id"`
+	Network string `json:"network"`
+	Peer
+}
+
+// AdminNodesResp is the body returned by GET /admin/v1/nodes.
+type AdminNodesResp struct {
+	Nodes []AdminNode `json:"nodes"`
+}
+
+// AdminNodeUpdate is the body of PATCH /admin/v1/nodes/{id}. Nil fields are
+// left alone; the rest are applied all together or not at all.
+type AdminNodeUpdate struct {
+	Name     *string     `json:"name,omitempty"`
+	Disabled *bool       `json:"disabled,omitempty"`
+	TunnelIP *netip.Addr `json:"tunnel_ip,omitempty"`
+}
+
+// AdminKeysResp is the body returned by GET /admin/v1/keys.
+type AdminKeysResp struct {
+	Keys []AuthKey `json:"keys"`
+}
+
+// AdminKeyCreateReq is the body of POST /admin/v1/keys.
+type AdminKeyCreateReq struct {
+	Reusable  bool      `json:"reusable"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Network   string    `json:"network,omitempty"` // default network if empty
+}
+
+// AdminKeyCreateResp carries the new key's secret. It is never retrievable
+// again: the coordinator only keeps its hash.
+type AdminKeyCreateResp struct {
+	Secret string  `json:"secret"`
+	Key    AuthKey `json:"key"`
+}
+
+// WithAdminToken enables the /admin/v1 API, guarded by a bearer token.
+// Without it the admin routes aren't registered at all.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		if token != "" {
+			h := sha256.Sum256([]byte(token))
+			s.adminTokenHash = h[:]
+		}
+	}
+}
+
+func (s *Server) registerAdmin() {
+	s.mux.HandleFunc("GET /admin/v1/nodes", s.admin(s.handleAdminNodes))
+	s.mux.HandleFunc("PATCH /admin/v1/nodes/{id}", s.admin(s.handleAdminNodeUpdate))
+	s.mux.HandleFunc("DELETE /admin/v1/nodes/{id}", s.admin(s.handleAdminNodeDelete))
+	s.mux.HandleFunc("GET /admin/v1/keys", s.admin(s.handleAdminKeys))
+	s.mux.HandleFunc("POST /admin/v1/keys", s.admin(s.handleAdminKeyCreate))
+	s.mux.HandleFunc("DELETE /admin/v1/keys/{id}", s.admin(s.handleAdminKeyRevoke))
+}
+
+// admin checks the bearer token. Both sides are hashed first so the
+// comparison is constant-time regardless of the presented length.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(tok))
+		if !ok || subtle.ConstantTimeCompare(got[:], s.adminTokenHash) != 1 {
+			s.metrics.authFailure(errBadAdminToken)
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// adminNetworks is the set of networks a list request covers: the one named
+// by ?network=, or all of them.
+func (s *Server) adminNetworks(w http.ResponseWriter, r *http.Request) ([]*network, bool) {
+	name := r.URL.Query().Get("network")
+	if name == "" {
+		return s.sortedNetworks(), true
+	}
+	nw, err := s.network(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return []*network{nw}, true
+}
+
+func (s *Server) handleAdminNodes(w http.ResponseWriter, r *http.Request) {
+	nws, ok := s.adminNetworks(w, r)
+	if !ok {
+		return
+	}
+	out := AdminNodesResp{Nodes: []AdminNode{}}
+	for _, nw := range nws {
+		peers, _, err := nw.store.Peers(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, p := range peers {
+			out.Nodes = append(out.Nodes, adminNode(nw, p))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func adminNode(nw *network, p Peer) AdminNode {
+	return AdminNode{ID: hex.EncodeToString(p.NodeKey), Network: nw.name, Peer: p}
+}
+
+func (s *Server) handleAdminNodeUpdate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req AdminNodeUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	nw, p, ok := s.resolveNode(w, r, false)
+	if !ok {
+		return
+	}
+	if err := nw.store.UpdatePeer(r.Context(), p.NodeKey, PeerUpdate(req)); err != nil {
+		http.Error(w, err.Error(), adminErrorStatus(err))
+		return
+	}
+	updated, err := nw.store.Lookup(r.Context(), p.NodeKey)
+	if err != nil {
+		http.Error(w, err.Error(), adminErrorStatus(err))
+		return
+	}
+	s.log.Info("admin updated node", "id", hex.EncodeToString(p.NodeKey), "network", nw.name,
+		"name", updated.Name, "disabled", updated.Disabled, "tunnel_ip", updated.TunnelIP)
+	writeJSON(w, http.StatusOK, adminNode(nw, updated))
+}
+
+func (s *Server) handleAdminNodeDelete(w http.ResponseWriter, r *http.Request) {
+	// An expired node can still rejoin on its node key for a while, so a
+	// delete has to reach it too.
+	nw, p, ok := s.resolveNode(w, r, true)
+	if !ok {
+		return
+	}
+	if err := nw.store.DeletePeer(r.Context(), p.NodeKey); err != nil {
+		http.Error(w, err.Error(), adminErrorStatus(err))
+		return
+	}
+	s.log.Info("admin deleted node", "id", hex.EncodeToString(p.NodeKey), "network", nw.name, "name", p.Name)
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (s *Server) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	nws, ok := s.adminNetworks(w, r)
+	if !ok {
+		return
+	}
+	out := AdminKeysResp{Keys: []AuthKey{}}
+	for _, nw := range nws {
+		keys, err := nw.store.AuthKeys(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, k := range keys {
+			k.Network = nw.name
+			out.Keys = append(out.Keys, k)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleAdminKeyCreate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req AdminKeyCreateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	for _, t := range req.Tags {
+		if !validTag(t) {
+			http.Error(w, fmt.Sprintf("bad tag %q", t), http.StatusBadRequest)
+			return
+		}
+	}
+	nw, err := s.network(req.Network)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	secret, err := GenerateAuthKeySecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	k := NewAuthKey(secret, req.Reusable, req.ExpiresAt)
+	k.Tags = req.Tags
+	k.Network = nw.name
+	if err := nw.store.AddAuthKey(r.Context(), k); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.log.Info("admin created auth key", "id", k.ID, "network", nw.name, "reusable", k.Reusable,
+		"tags", k.Tags, "expires_at", k.ExpiresAt)
+	writeJSON(w, http.StatusOK, AdminKeyCreateResp{Secret: secret, Key: k})
+}
+
+func (s *Server) handleAdminKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	for _, nw := range s.sortedNetworks() {
+		err := nw.store.RevokeAuthKey(r.Context(), id)
+		if errors.Is(err, ErrAuthKeyInvalid) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), adminErrorStatus(err))
+			return
+		}
+		s.log.Info("admin revoked auth key", "id", id, "network", nw.name)
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+		return
+	}
+	http.Error(w, ErrAuthKeyInvalid.Error(), http.StatusNotFound)
+}
+
+var errAmbiguousNode = errors.New("node id matches more than one node")
+
+// resolveNode finds the peer named by the {id} path value: a full hex node
+// key, an exact node name, or a unique hex prefix, in that order. ?network=
+// narrows the search; otherwise every network is searched. With departed,
+// expired nodes that are still admitted are candidates too. It writes the
+// error response itself when there's no single match.
+func (s *Server) resolveNode(w http.ResponseWriter, r *http.Request, departed bool) (*network, Peer, bool) {
+	nws, ok := s.adminNetworks(w, r)
+	if !ok {
+		return nil, Peer{}, false
+	}
+	type match struct {
+		nw *network
+		p  Peer
+	}
+	id := strings.ToLower(r.PathValue("id"))
+	var byName, byPrefix []match
+	for _, nw := range nws {
+		peers, _, err := nw.store.Peers(r.Context())
+		if err == nil && departed {
+			var gone []Peer
+			gone, err = nw.store.Departed(r.Context())
+			peers = append(peers, gone...)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, Peer{}, false
+		}
+		for _, p := range peers {
+			hexKey := hex.EncodeToString(p.NodeKey)
+			switch {
+			case hexKey == id:
+				return nw, p, true
+			case strings.ToLower(p.Name) == id:
+				byName = append(byName, match{nw, p})
+			case id != "" && strings.HasPrefix(hexKey, id):
+				byPrefix = append(byPrefix, match{nw, p})
+			}
+		}
+	}
+	for _, matches := range [][]match{byName, byPrefix} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0].nw, matches[0].p, true
+		default:
+			http.Error(w, errAmbiguousNode.Error(), http.StatusConflict)
+			return nil, Peer{}, false
+		}
+	}
+	http.Error(w, ErrUnknownPeer.Error(), http.StatusNotFound)
+	return nil, Peer{}, false
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownPeer), errors.Is(err, ErrAuthKeyInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTunnelIPTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,276 @@
+package coord
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "s3cret-admin"
+
+func newAdminTestServer(t *testing.T) (*MemStore, *httptest.Server, *AdminClient) {
+	t.Helper()
+	store := newTestStore(t)
+	srv := httptest.NewServer(NewServer(store, WithAdminToken(testAdminToken)))
+	t.Cleanup(srv.Close)
+	return store, srv, NewAdminClient(srv.URL, testAdminToken)
+}
+
+func TestAdmin_RejectsBadToken(t *testing.T) {
+	_, srv, _ := newAdminTestServer(t)
+	for _, tok := range []string{"", "wrong"} {
+		_, err := NewAdminClient(srv.URL, tok).Nodes(context.Background(), "")
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("token %q: want 401, got %v", tok, err)
+		}
+	}
+}
+
+func TestAdmin_DisabledWithoutToken(t *testing.T) {
+	srv := httptest.NewServer(NewServer(newTestStore(t)))
+	defer srv.Close()
+	_, err := NewAdminClient(srv.URL, "").Nodes(context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("admin API should not exist without a token, got %v", err)
+	}
+}
+
+func TestAdmin_ListAndResolve(t *testing.T) {
+	_, srv, admin := newAdminTestServer(t)
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+
+	nodes, err := admin.Nodes(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != hex.EncodeToString(a.nk.Pub) {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+
+	// Name, full ID and ID prefix all address the same node.
+	for i, id := range []string{nodes[0].Name, nodes[0].ID, nodes[0].ID[:8]} {
+		name := "renamed-" + string(rune('a'+i))
+		n, err := admin.UpdateNode(context.Background(), id, AdminNodeUpdate{Name: &name})
+		if err != nil {
+			t.Fatalf("resolve %q: %v", id, err)
+		}
+		if n.Name != name {
+			t.Errorf("got name %q, want %q", n.Name, name)
+		}
+	}
+	if _, err := admin.UpdateNode(context.Background(), "nobody", AdminNodeUpdate{}); err == nil ||
+		!strings.Contains(err.Error(), "404") {
+		t.Errorf("unknown node: want 404, got %v", err)
+	}
+}
+
+func TestAdmin_RenameSurvivesReregister(t *testing.T) {
+	store, srv, admin := newAdminTestServer(t)
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+	name := "db-primary"
+	if _, err := admin.UpdateNode(context.Background(), hex.EncodeToString(a.nk.Pub),
+		AdminNodeUpdate{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	a.register(t)
+	p, _ := store.Lookup(context.Background(), a.nk.Pub)
+	if p.Name != name {
+		t.Errorf("re-register undid admin rename: %q", p.Name)
+	}
+}
+
+func TestAdmin_DisableKicksNode(t *testing.T) {
+	store, srv, admin := newAdminTestServer(t)
+	a, b := newTestClient(t, srv.URL), newTestClient(t, srv.URL)
+	a.register(t)
+	b.register(t)
+	id := hex.EncodeToString(a.nk.Pub)
+
+	disabled := true
+	if _, err := admin.UpdateNode(context.Background(), id, AdminNodeUpdate{Disabled: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := a.do(t, "GET", "/v1/peers", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disabled node polling: want 403, got %d", resp.StatusCode)
+	}
+	resp = a.do(t, "POST", "/v1/register", RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disabled node re-registering: want 403, got %d", resp.StatusCode)
+	}
+
+	resp = b.do(t, "GET", "/v1/peers", nil)
+	var peers PeersResp
+	err := json.NewDecoder(resp.Body).Decode(&peers)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers.Peers) != 1 {
+		t.Errorf("others should no longer see the disabled node, got %d peers", len(peers.Peers))
+	}
+
+	// Disabled nodes don't expire: they'd come back as new nodes.
+	if evicted, _ := store.Expire(context.Background(), time.Now().Add(time.Hour), 0); len(evicted) != 1 {
+		t.Errorf("want only the enabled node evicted, got %d", len(evicted))
+	}
+
+	disabled = false
+	if _, err := admin.UpdateNode(context.Background(), id, AdminNodeUpdate{Disabled: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+	resp = a.do(t, "GET", "/v1/peers", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("re-enabled node: want 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdmin_DeleteFreesAddress(t *testing.T) {
+	store, srv, admin := newAdminTestServer(t)
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+	before, _ := store.Lookup(context.Background(), a.nk.Pub)
+
+	if err := admin.DeleteNode(context.Background(), hex.EncodeToString(a.nk.Pub)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(context.Background(), a.nk.Pub); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("node still registered: %v", err)
+	}
+	ip, err := store.Register(context.Background(), makePeer(t, "newcomer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != before.TunnelIP {
+		t.Errorf("deleted node's address not reused: got %v, want %v", ip, before.TunnelIP)
+	}
+}
+
+func TestAdmin_DeleteReapedNode(t *testing.T) {
+	store := newTestStore(t)
+	_ = store.AddAuthKey(context.Background(), NewAuthKey("join-me", false, time.Time{}))
+	srv := httptest.NewServer(NewServer(store, WithAdminToken(testAdminToken), WithRequireAuthKey()))
+	defer srv.Close()
+	admin := NewAdminClient(srv.URL, testAdminToken)
+
+	a := newTestClient(t, srv.URL)
+	req := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, NodeName: "rogue", AuthKey: "join-me"}
+	resp := a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("register: want 200, got %d", resp.StatusCode)
+	}
+	if _, err := store.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := admin.DeleteNode(context.Background(), "rogue"); err != nil {
+		t.Fatalf("deleting a reaped node: %v", err)
+	}
+	req.AuthKey = ""
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("rejoin after delete: want 401, got %d", resp.StatusCode)
+	}
+	if err := admin.DeleteNode(context.Background(), "rogue"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("second delete: want 404, got %v", err)
+	}
+}
+
+func TestAdmin_SetTunnelIP(t *testing.T) {
+	_, srv, admin := newAdminTestServer(t)
+	a, b := newTestClient(t, srv.URL), newTestClient(t, srv.URL)
+	a.register(t)
+	b.register(t)
+	nodes, _ := admin.Nodes(context.Background(), "")
+
+	want := netip.MustParseAddr("100.64.0.77")
+	n, err := admin.UpdateNode(context.Background(), nodes[0].ID, AdminNodeUpdate{TunnelIP: &want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.TunnelIP != want {
+		t.Errorf("got %v, want %v", n.TunnelIP, want)
+	}
+
+	taken := nodes[1].TunnelIP
+	if _, err := admin.UpdateNode(context.Background(), nodes[0].ID, AdminNodeUpdate{TunnelIP: &taken}); err == nil ||
+		!strings.Contains(err.Error(), "409") {
+		t.Errorf("taken address: want 409, got %v", err)
+	}
+	outside := netip.MustParseAddr("10.9.9.9")
+	if _, err := admin.UpdateNode(context.Background(), nodes[0].ID, AdminNodeUpdate{TunnelIP: &outside}); err == nil {
+		t.Error("address outside the pool should be refused")
+	}
+}
+
+func TestAdmin_UpdateIsAtomic(t *testing.T) {
+	_, srv, admin := newAdminTestServer(t)
+	a, b := newTestClient(t, srv.URL), newTestClient(t, srv.URL)
+	a.register(t)
+	b.register(t)
+	nodes, _ := admin.Nodes(context.Background(), "")
+	before := nodes[0]
+
+	name, disabled, taken := "renamed", true, nodes[1].TunnelIP
+	if _, err := admin.UpdateNode(context.Background(), before.ID,
+		AdminNodeUpdate{Name: &name, Disabled: &disabled, TunnelIP: &taken}); err == nil ||
+		!strings.Contains(err.Error(), "409") {
+		t.Fatalf("taken address: want 409, got %v", err)
+	}
+	empty := ""
+	if _, err := admin.UpdateNode(context.Background(), before.ID, AdminNodeUpdate{Name: &empty}); err == nil ||
+		!strings.Contains(err.Error(), "400") {
+		t.Errorf("empty name: want 400, got %v", err)
+	}
+
+	nodes, _ = admin.Nodes(context.Background(), "")
+	for _, n := range nodes {
+		if n.ID == before.ID && (n.Name != before.Name || n.Disabled) {
+			t.Errorf("failed update left changes behind: %+v", n.Peer)
+		}
+	}
+}
+
+func TestAdmin_KeysLifecycle(t *testing.T) {
+	store, _, admin := newAdminTestServer(t)
+	created, err := admin.CreateAuthKey(context.Background(), AdminKeyCreateReq{Reusable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" || created.Key.ID == "" {
+		t.Fatalf("unexpected create response %+v", created)
+	}
+	keys, err := admin.AuthKeys(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != created.Key.ID {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	if err := admin.RevokeAuthKey(context.Background(), created.Key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.RevokeAuthKey(context.Background(), "nope"); err == nil {
+		t.Error("revoking an unknown key should fail")
+	}
+
+	if _, err := store.UseAuthKey(context.Background(), created.Secret); !errors.Is(err, ErrAuthKeyRevoked) {
+		t.Errorf("want ErrAuthKeyRevoked, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package coord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AdminClient talks to the /admin/v1 API with a bearer token.
+type AdminClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewAdminClient constructs a client against base URL (e.g. http://coord:8443).
+func NewAdminClient(base, token string) *AdminClient {
+	return &AdminClient{
+		base:  strings.TrimRight(base, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Nodes lists registered nodes, disabled ones included, in one network or
+// (network == "") in all of them.
+func (c *AdminClient) Nodes(ctx context.Context, network string) ([]AdminNode, error) {
+	var out AdminNodesResp
+	if err := c.do(ctx, "GET", "/admin/v1/nodes"+networkQuery(network), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Nodes, nil
+}
+
+// UpdateNode applies upd to the node identified by id (hex key, name, or
+// unique key prefix) and returns the result.
+func (c *AdminClient) UpdateNode(ctx context.Context, id string, upd AdminNodeUpdate) (AdminNode, error) {
+	var out AdminNode
+	err := c.do(ctx, "PATCH", "/admin/v1/nodes/"+url.PathEscape(id), upd, &out)
+	return out, err
+}
+
+// DeleteNode removes a node and frees its tunnel IP.
+func (c *AdminClient) DeleteNode(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/admin/v1/nodes/"+url.PathEscape(id), nil, nil)
+}
+
+// AuthKeys lists auth keys in one network or all of them. Secrets are not
+// included.
+func (c *AdminClient) AuthKeys(ctx context.Context, network string) ([]AuthKey, error) {
+	var out AdminKeysResp
+	if err := c.do(ctx, "GET", "/admin/v1/keys"+networkQuery(network), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Keys, nil
+}
+
+// CreateAuthKey issues a new auth key and returns its secret.
+func (c *AdminClient) CreateAuthKey(ctx context.Context, req AdminKeyCreateReq) (AdminKeyCreateResp, error) {
+	var out AdminKeyCreateResp
+	err := c.do(ctx, "POST", "/admin/v1/keys", req, &out)
+	return out, err
+}
+
+// RevokeAuthKey revokes the key with the given ID.
+func (c *AdminClient) RevokeAuthKey(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/admin/v1/keys/"+url.PathEscape(id), nil, nil)
+}
+
+func networkQuery(network string) string {
+	if network == "" {
+		return ""
+	}
+	return "?network=" + url.QueryEscape(network)
+}
+
+func (c *AdminClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
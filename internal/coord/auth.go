@@ -3,6 +3,7 @@ package coord
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,16 +21,24 @@ const (
 	headerAuth   = "Authorization"
 	headerTs     = "X-Gretun-Timestamp"
 	headerKey    = "X-Gretun-Node"
+	headerNonce  = "X-Gretun-Nonce"
 	maxClockSkew = 60 * time.Second
+	// maxNonceLen bounds what the replay cache stores per request.
+	maxNonceLen = 64
 )
 
 // SignRequest computes the Authorization header value for a request body.
-// Signed material: timestamp || "\n" || method || "\n" || path || "\n" || sha256(body).
-// Returned values should be set as X-Gretun-Timestamp, X-Gretun-Node, and
-// Authorization on the outgoing HTTP request.
-func SignRequest(priv ed25519.PrivateKey, pub ed25519.PublicKey, method, path string, body []byte) (ts, nodeB64, authHeader string) {
+// Signed material:
+// timestamp || "\n" || nonce || "\n" || method || "\n" || path || "\n" || sha256(body),
+// where path includes the query string. Returned values should be set as
+// X-Gretun-Timestamp, X-Gretun-Nonce, X-Gretun-Node, and Authorization on
+// the outgoing HTTP request.
+func SignRequest(priv ed25519.PrivateKey, pub ed25519.PublicKey, method, path string, body []byte) (ts, nonce, nodeB64, authHeader string) {
 	ts = strconv.FormatInt(time.Now().UTC().Unix(), 10)
-	digest := signingMaterial(ts, method, path, body)
+	var nb [16]byte
+	_, _ = rand.Read(nb[:])
+	nonce = base64.RawURLEncoding.EncodeToString(nb[:])
+	digest := signingMaterial(ts, nonce, method, path, body)
 	sig := ed25519.Sign(priv, digest)
 	nodeB64 = base64.StdEncoding.EncodeToString(pub)
 	authHeader = authScheme + " " + base64.StdEncoding.EncodeToString(sig)
@@ -38,13 +48,18 @@ func SignRequest(priv ed25519.PrivateKey, pub ed25519.PublicKey, method, path st
 // VerifyRequest validates the signature on an incoming HTTP request.
 // On success, it returns the requester's Ed25519 public key and the already-
 // consumed request body (readers can't be re-read after verification).
+// It doesn't remember nonces; the Server's replay cache does.
 func VerifyRequest(r *http.Request) (ed25519.PublicKey, []byte, error) {
 	ts := r.Header.Get(headerTs)
+	nonce := r.Header.Get(headerNonce)
 	nodeB64 := r.Header.Get(headerKey)
 	auth := r.Header.Get(headerAuth)
-	if ts == "" || nodeB64 == "" || auth == "" {
+	if ts == "" || nonce == "" || nodeB64 == "" || auth == "" {
 		return nil, nil, errors.New("missing auth headers")
 	}
+	if len(nonce) > maxNonceLen {
+		return nil, nil, errors.New("nonce too long")
+	}
 	if !strings.HasPrefix(auth, authScheme+" ") {
 		return nil, nil, errors.New("bad auth scheme")
 	}
@@ -74,14 +89,14 @@ func VerifyRequest(r *http.Request) (ed25519.PublicKey, []byte, error) {
 		return nil, nil, fmt.Errorf("read body: %w", err)
 	}
 	_ = r.Body.Close()
-	digest := signingMaterial(ts, r.Method, r.URL.Path, body)
+	digest := signingMaterial(ts, nonce, r.Method, r.URL.RequestURI(), body)
 	if !ed25519.Verify(pubBytes, digest, sig) {
 		return nil, nil, errors.New("signature verify failed")
 	}
 	return ed25519.PublicKey(pubBytes), body, nil
 }
 
-func signingMaterial(ts, method, path string, body []byte) []byte {
+func signingMaterial(ts, nonce, method, path string, body []byte) []byte {
 	h := sha256.New()
 	h.Write(body)
 	bodyHash := h.Sum(nil)
@@ -89,6 +104,8 @@ func signingMaterial(ts, method, path string, body []byte) []byte {
 	var buf bytes.Buffer
 	buf.WriteString(ts)
 	buf.WriteByte('\n')
+	buf.WriteString(nonce)
+	buf.WriteByte('\n')
 	buf.WriteString(method)
 	buf.WriteByte('\n')
 	buf.WriteString(path)
@@ -96,3 +113,59 @@ func signingMaterial(ts, method, path string, body []byte) []byte {
 	buf.Write(bodyHash)
 	return buf.Bytes()
 }
+
+// maxReplayEntries caps the replay cache. Only registered nodes' requests
+// are remembered, each source IP's under the signed-request budget, so a
+// full cache means many sources at once; new requests are then turned
+// away rather than let through unchecked.
+const maxReplayEntries = 1 << 16
+
+// errReplayCacheFull is fresh's answer when the cache is at its cap.
+var errReplayCacheFull = errors.New("replay cache full")
+
+// replayCache remembers (node key, nonce) pairs for as long as their
+// timestamp would still pass VerifyRequest, so a captured request can't be
+// sent again inside the skew window. It holds at most maxReplayEntries, so
+// a sweep is bounded too.
+type replayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // node key + nonce → when it can be forgotten
+	lastSweep time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// fresh records the request's nonce. It returns errReplayed if the nonce
+// was seen before and errReplayCacheFull if there is no room to remember
+// it. r must already have passed VerifyRequest.
+func (c *replayCache) fresh(pub ed25519.PublicKey, r *http.Request, now time.Time) error {
+	ts, err := strconv.ParseInt(r.Header.Get(headerTs), 10, 64)
+	if err != nil {
+		return errReplayed
+	}
+	key := string(pub) + r.Header.Get(headerNonce)
+	// The timestamp stops passing the skew check at ts+maxClockSkew; one
+	// extra second covers the check's whole-second rounding.
+	forget := time.Unix(ts, 0).Add(maxClockSkew + time.Second)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > maxClockSkew/4 || (len(c.seen) >= maxReplayEntries && now.Sub(c.lastSweep) > time.Second) {
+		for k, t := range c.seen {
+			if now.After(t) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	if _, dup := c.seen[key]; dup {
+		return errReplayed
+	}
+	if len(c.seen) >= maxReplayEntries {
+		return errReplayCacheFull
+	}
+	c.seen[key] = forget
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -21,8 +22,9 @@ func signedRequest(t *testing.T, body []byte) (*http.Request, ed25519.PublicKey)
 		t.Fatal(err)
 	}
 	req := httptest.NewRequest("POST", "/v1/endpoints", bytes.NewReader(body))
-	ts, nodeB64, auth := SignRequest(priv, pub, "POST", "/v1/endpoints", body)
+	ts, nonce, nodeB64, auth := SignRequest(priv, pub, "POST", "/v1/endpoints", body)
 	req.Header.Set(headerTs, ts)
+	req.Header.Set(headerNonce, nonce)
 	req.Header.Set(headerKey, nodeB64)
 	req.Header.Set(headerAuth, auth)
 	return req, pub
@@ -44,7 +46,7 @@ func TestVerifyRequest_OK(t *testing.T) {
 }
 
 func TestVerifyRequest_MissingHeaders(t *testing.T) {
-	for _, h := range []string{headerTs, headerKey, headerAuth} {
+	for _, h := range []string{headerTs, headerNonce, headerKey, headerAuth} {
 		t.Run(h, func(t *testing.T) {
 			req, _ := signedRequest(t, []byte("b"))
 			req.Header.Del(h)
@@ -119,9 +121,10 @@ func TestVerifyRequest_BodyTamper(t *testing.T) {
 	// Sign against body A, replace with body B: signature must fail.
 	pub, priv, _ := ed25519.GenerateKey(nil)
 	orig := []byte(`{"x":1}`)
-	ts, nodeB64, auth := SignRequest(priv, pub, "POST", "/v1/endpoints", orig)
+	ts, nonce, nodeB64, auth := SignRequest(priv, pub, "POST", "/v1/endpoints", orig)
 	req := httptest.NewRequest("POST", "/v1/endpoints", bytes.NewReader([]byte(`{"x":2}`)))
 	req.Header.Set(headerTs, ts)
+	req.Header.Set(headerNonce, nonce)
 	req.Header.Set(headerKey, nodeB64)
 	req.Header.Set(headerAuth, auth)
 
@@ -131,16 +134,131 @@ func TestVerifyRequest_BodyTamper(t *testing.T) {
 }
 
 func TestSigningMaterial_Shape(t *testing.T) {
-	out := signingMaterial("123", "GET", "/x", []byte("body"))
-	parts := bytes.SplitN(out, []byte("\n"), 4)
-	if len(parts) != 4 {
-		t.Fatalf("want 4 fields, got %d", len(parts))
+	out := signingMaterial("123", "n0", "GET", "/x?since=e", []byte("body"))
+	parts := bytes.SplitN(out, []byte("\n"), 5)
+	if len(parts) != 5 {
+		t.Fatalf("want 5 fields, got %d", len(parts))
 	}
-	if string(parts[0]) != "123" || string(parts[1]) != "GET" || string(parts[2]) != "/x" {
-		t.Errorf("header fields wrong: %q %q %q", parts[0], parts[1], parts[2])
+	if string(parts[0]) != "123" || string(parts[1]) != "n0" || string(parts[2]) != "GET" || string(parts[3]) != "/x?since=e" {
+		t.Errorf("header fields wrong: %q %q %q %q", parts[0], parts[1], parts[2], parts[3])
 	}
-	if len(parts[3]) != 32 {
-		t.Errorf("body hash should be 32 bytes, got %d", len(parts[3]))
+	if len(parts[4]) != 32 {
+		t.Errorf("body hash should be 32 bytes, got %d", len(parts[4]))
 	}
 }
 
+
+func TestVerifyRequest_QueryTamper(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	ts, nonce, nodeB64, auth := SignRequest(priv, pub, "GET", "/v1/peers?since=a", nil)
+	req := httptest.NewRequest("GET", "/v1/peers?since=b", nil)
+	req.Header.Set(headerTs, ts)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerKey, nodeB64)
+	req.Header.Set(headerAuth, auth)
+	if _, _, err := VerifyRequest(req); err == nil {
+		t.Error("a swapped query string should fail signature verification")
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	c := newReplayCache()
+	req, pub := signedRequest(t, []byte("b"))
+	now := time.Now()
+	if err := c.fresh(pub, req, now); err != nil {
+		t.Fatalf("first use should be fresh: %v", err)
+	}
+	if err := c.fresh(pub, req, now); !errors.Is(err, errReplayed) {
+		t.Errorf("second use: want errReplayed, got %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := c.fresh(other, req, now); err != nil {
+		t.Errorf("nonces are per node key: %v", err)
+	}
+	// Once the timestamp is past the skew window the entry is swept.
+	later, _ := signedRequest(t, nil)
+	c.fresh(other, later, now.Add(2*maxClockSkew))
+	if len(c.seen) != 1 {
+		t.Errorf("%d entries survived the sweep", len(c.seen))
+	}
+}
+
+func TestReplayCache_Capped(t *testing.T) {
+	c := newReplayCache()
+	now := time.Now()
+	c.lastSweep = now
+	for i := 0; i < maxReplayEntries; i++ {
+		c.seen[strconv.Itoa(i)] = now.Add(maxClockSkew)
+	}
+	req, pub := signedRequest(t, nil)
+	if err := c.fresh(pub, req, now); !errors.Is(err, errReplayCacheFull) {
+		t.Errorf("full cache: want errReplayCacheFull, got %v", err)
+	}
+	if len(c.seen) != maxReplayEntries {
+		t.Errorf("cache grew to %d past its cap", len(c.seen))
+	}
+}
+
+func TestServer_UnknownKeysLeaveNoNonce(t *testing.T) {
+	srv := NewServer(newTestStore(t))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	for i := 0; i < 5; i++ {
+		stranger := newTestClient(t, ts.URL)
+		resp := stranger.do(t, "GET", "/v1/peers", nil)
+		resp.Body.Close()
+	}
+	if n := len(srv.replay.seen); n != 0 {
+		t.Errorf("replay cache holds %d nonces of unregistered keys", n)
+	}
+	// Not remembered, so not allowed to do anything a replay could repeat.
+	resp := newTestClient(t, ts.URL).do(t, "POST", "/v1/signal", SignalReq{Sealed: []byte("hi")})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("signal from an unregistered key: want 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_SignedBudgetPerIP(t *testing.T) {
+	limits := RateLimits{Signed: RateLimit{Rate: 0.001, Burst: 3}}
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithRateLimits(limits)))
+	defer ts.Close()
+	var codes []int
+	for i := 0; i < 4; i++ {
+		resp := newTestClient(t, ts.URL).do(t, "GET", "/v1/peers", nil)
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+	if codes[2] == http.StatusTooManyRequests || codes[3] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want the fourth fresh key throttled", codes)
+	}
+}
+
+func TestServer_RejectsReplay(t *testing.T) {
+	srv := httptest.NewServer(NewServer(newTestStore(t)))
+	defer srv.Close()
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+
+	body := []byte(`{"endpoints":[]}`)
+	ts, nonce, nodeB64, auth := SignRequest(a.nk.Priv, a.nk.Pub, "POST", "/v1/endpoints", body)
+	send := func() int {
+		req, _ := http.NewRequest("POST", srv.URL+"/v1/endpoints", bytes.NewReader(body))
+		req.Header.Set(headerTs, ts)
+		req.Header.Set(headerNonce, nonce)
+		req.Header.Set(headerKey, nodeB64)
+		req.Header.Set(headerAuth, auth)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("original: %d", code)
+	}
+	if code := send(); code != http.StatusUnauthorized {
+		t.Errorf("replay: want 401, got %d", code)
+	}
+}
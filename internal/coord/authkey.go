@@ -0,0 +1,160 @@
+package coord
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Reasons an auth key is refused. All map to 401 on /v1/register.
+var (
+	ErrAuthKeyMissing = errors.New("auth key required")
+	ErrAuthKeyInvalid = errors.New("auth key not recognised")
+	ErrAuthKeyExpired = errors.New("auth key expired")
+	ErrAuthKeyUsed    = errors.New("auth key already used")
+	ErrAuthKeyRevoked = errors.New("auth key revoked")
+)
+
+// AuthKey is an admin-issued pre-authorization to join the mesh. The store
+// only ever holds a hash of the secret, so a leaked registry file can't be
+// replayed into new registrations.
+type AuthKey struct {
+	ID        string    `json:"id"`   // short, non-secret handle for admins
+	Hash      string    `json:"hash"` // hex SHA-256 of the secret
+	Reusable  bool      `json:"reusable"`
+	ExpiresAt time.Time `json:"expires_at"` // zero = never
+	CreatedAt time.Time `json:"created_at"`
+	Uses      int       `json:"uses"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`    // given to every node that joins with this key
+	Network   string    `json:"network,omitempty"` // network the key admits to; empty = default
+}
+
+// Expired reports whether the key has a deadline that has passed.
+func (k AuthKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// usable returns nil if the key may authorize one more registration.
+func (k AuthKey) usable(now time.Time) error {
+	if k.Revoked {
+		return ErrAuthKeyRevoked
+	}
+	if k.Expired(now) {
+		return ErrAuthKeyExpired
+	}
+	if !k.Reusable && k.Uses > 0 {
+		return ErrAuthKeyUsed
+	}
+	return nil
+}
+
+// NewAuthKey builds an AuthKey for an existing secret.
+func NewAuthKey(secret string, reusable bool, expires time.Time) AuthKey {
+	h := hashAuthKey(secret)
+	return AuthKey{
+		ID:        h[:12],
+		Hash:      h,
+		Reusable:  reusable,
+		ExpiresAt: expires,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// GenerateAuthKeySecret returns a fresh random secret suitable for NewAuthKey.
+func GenerateAuthKeySecret() (string, error) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "gretun-auth-" + hex.EncodeToString(b[:]), nil
+}
+
+func hashAuthKey(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// ParseAuthKeyFile reads one key per line: the secret, optionally followed
+// by any of "reusable", "expires=<RFC 3339>", "tags=tag:a,tag:b" and
+// "network=NAME". Blank lines and #-comments are ignored. Keys are
+// single-use, never expire, and admit to the default network unless told
+// otherwise.
+func ParseAuthKeyFile(r io.Reader) ([]AuthKey, error) {
+	var out []AuthKey
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		var (
+			reusable bool
+			expires  time.Time
+			tags     []string
+			network  string
+		)
+		for _, opt := range fields[1:] {
+			switch {
+			case opt == "reusable":
+				reusable = true
+			case strings.HasPrefix(opt, "expires="):
+				t, err := time.Parse(time.RFC3339, strings.TrimPrefix(opt, "expires="))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				expires = t
+			case strings.HasPrefix(opt, "tags="):
+				var err error
+				if tags, err = ParseTags(strings.TrimPrefix(opt, "tags=")); err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+			case strings.HasPrefix(opt, "network="):
+				network = strings.TrimPrefix(opt, "network=")
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", line, opt)
+			}
+		}
+		k := NewAuthKey(fields[0], reusable, expires)
+		k.Tags = tags
+		k.Network = network
+		out = append(out, k)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ParseTags splits a comma-separated tag list, checking each is tag:NAME.
+func ParseTags(s string) ([]string, error) {
+	var out []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !validTag(t) {
+			return nil, fmt.Errorf("%q is not a tag:NAME", t)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func validTag(t string) bool {
+	name, ok := strings.CutPrefix(t, "tag:")
+	return ok && name != "" && !strings.ContainsAny(name, ", \t")
+}
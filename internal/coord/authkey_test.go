@@ -0,0 +1,210 @@
+package coord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUseAuthKey_SingleUse(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.AddAuthKey(context.Background(), NewAuthKey("secret", false, time.Time{})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UseAuthKey(context.Background(), "secret"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := s.UseAuthKey(context.Background(), "secret"); !errors.Is(err, ErrAuthKeyUsed) {
+		t.Errorf("second use: want ErrAuthKeyUsed, got %v", err)
+	}
+}
+
+func TestUseAuthKey_Reusable(t *testing.T) {
+	s := newTestStore(t)
+	_ = s.AddAuthKey(context.Background(), NewAuthKey("secret", true, time.Time{}))
+	for i := 0; i < 3; i++ {
+		if _, err := s.UseAuthKey(context.Background(), "secret"); err != nil {
+			t.Fatalf("use %d: %v", i, err)
+		}
+	}
+	keys, _ := s.AuthKeys(context.Background())
+	if len(keys) != 1 || keys[0].Uses != 3 {
+		t.Errorf("want 1 key with 3 uses, got %+v", keys)
+	}
+}
+
+func TestUseAuthKey_Rejections(t *testing.T) {
+	s := newTestStore(t)
+	_ = s.AddAuthKey(context.Background(), NewAuthKey("old", true, time.Now().Add(-time.Minute)))
+
+	cases := map[string]error{
+		"":     ErrAuthKeyMissing,
+		"nope": ErrAuthKeyInvalid,
+		"old":  ErrAuthKeyExpired,
+	}
+	for secret, want := range cases {
+		if _, err := s.UseAuthKey(context.Background(), secret); !errors.Is(err, want) {
+			t.Errorf("UseAuthKey(%q) = %v, want %v", secret, err, want)
+		}
+	}
+}
+
+func TestAddAuthKey_KeepsUseCount(t *testing.T) {
+	s := newTestStore(t)
+	_ = s.AddAuthKey(context.Background(), NewAuthKey("secret", false, time.Time{}))
+	_, _ = s.UseAuthKey(context.Background(), "secret")
+
+	// Reloading the key file must not make a spent key usable again.
+	_ = s.AddAuthKey(context.Background(), NewAuthKey("secret", false, time.Time{}))
+	if _, err := s.UseAuthKey(context.Background(), "secret"); !errors.Is(err, ErrAuthKeyUsed) {
+		t.Errorf("want ErrAuthKeyUsed after reload, got %v", err)
+	}
+}
+
+func TestAuthKey_StoresOnlyHash(t *testing.T) {
+	k := NewAuthKey("hunter2", false, time.Time{})
+	if strings.Contains(k.Hash, "hunter2") || strings.Contains(k.ID, "hunter2") {
+		t.Error("secret leaked into stored key")
+	}
+	if !strings.HasPrefix(k.Hash, k.ID) {
+		t.Errorf("ID %q should be a prefix of the hash", k.ID)
+	}
+}
+
+func TestGenerateAuthKeySecret_Unique(t *testing.T) {
+	a, err := GenerateAuthKeySecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateAuthKeySecret()
+	if a == b || !strings.HasPrefix(a, "gretun-auth-") {
+		t.Errorf("unexpected secrets %q %q", a, b)
+	}
+}
+
+func TestParseAuthKeyFile(t *testing.T) {
+	in := `
+# comment line
+alpha
+beta   reusable
+gamma  expires=2030-01-02T03:04:05Z reusable  # trailing comment
+`
+	keys, err := ParseAuthKeyFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("want 3 keys, got %d", len(keys))
+	}
+	if keys[0].Reusable || !keys[0].ExpiresAt.IsZero() {
+		t.Errorf("alpha should be single-use, no expiry: %+v", keys[0])
+	}
+	if !keys[1].Reusable {
+		t.Error("beta should be reusable")
+	}
+	if !keys[2].Reusable || keys[2].ExpiresAt.Year() != 2030 {
+		t.Errorf("gamma options lost: %+v", keys[2])
+	}
+	if keys[0].Hash != hashAuthKey("alpha") {
+		t.Error("hash should be of the secret field only")
+	}
+}
+
+func TestParseAuthKeyFile_Errors(t *testing.T) {
+	for _, in := range []string{"k bogus-option", "k expires=tomorrow"} {
+		if _, err := ParseAuthKeyFile(strings.NewReader(in)); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestFileStore_AuthKeyUsesSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	pool := netip.MustParsePrefix("100.64.0.0/24")
+	s1, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s1.AddAuthKey(context.Background(), NewAuthKey("once", false, time.Time{}))
+	if _, err := s1.UseAuthKey(context.Background(), "once"); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.UseAuthKey(context.Background(), "once"); !errors.Is(err, ErrAuthKeyUsed) {
+		t.Errorf("spent key usable after restart: %v", err)
+	}
+}
+
+func postRegister(t *testing.T, base string, req RegisterReq) *http.Response {
+	t.Helper()
+	buf, _ := json.Marshal(req)
+	resp, err := http.Post(base+"/v1/register", "application/json", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer_RequireAuthKey(t *testing.T) {
+	store := newTestStore(t)
+	_ = store.AddAuthKey(context.Background(), NewAuthKey("join-me", false, time.Time{}))
+	srv := httptest.NewServer(NewServer(store, WithRequireAuthKey()))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	req := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, NodeName: "a"}
+
+	resp := postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("no key: want 401, got %d", resp.StatusCode)
+	}
+
+	req.AuthKey = "join-me"
+	resp = postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("valid key: want 200, got %d", resp.StatusCode)
+	}
+
+	// Same node re-registering (daemon restart) doesn't need the spent key,
+	// but does have to sign with its node key.
+	req.AuthKey = ""
+	resp = postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned re-register: want 401, got %d", resp.StatusCode)
+	}
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("re-register: want 200, got %d", resp.StatusCode)
+	}
+
+	// A different node can't reuse the single-use key.
+	b := newTestClient(t, srv.URL)
+	resp = postRegister(t, srv.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub, AuthKey: "join-me"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("reused single-use key: want 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_AuthKeyNotRequiredByDefault(t *testing.T) {
+	srv := httptest.NewServer(NewServer(newTestStore(t)))
+	defer srv.Close()
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+}
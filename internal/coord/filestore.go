@@ -0,0 +1,220 @@
+package coord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// registrySnapshotVersion is bumped whenever the on-disk layout changes in a
+// way an older binary can't read.
+const registrySnapshotVersion = 1
+
+// registrySnapshot is the on-disk shape of the registry.
+type registrySnapshot struct {
+	Version    int         `json:"version"`
+	Peers      []Peer      `json:"peers"`
+	Tombstones []tombstone `json:"tombstones,omitempty"`
+	Admitted   []admission `json:"admitted,omitempty"`
+	AuthKeys   []AuthKey   `json:"auth_keys,omitempty"`
+}
+
+// tombstone keeps an expired peer's tunnel IP out of the pool until the
+// grace period runs out.
+type tombstone struct {
+	NodeKey   string     `json:"node_key"` // base64Encode(NodeKey)
+	TunnelIP  netip.Addr `json:"tunnel_ip"`
+	ExpiredAt time.Time  `json:"expired_at"`
+}
+
+// admission records that an expired node key was admitted, and by which
+// auth key, so it can rejoin without a fresh one while its tombstone lasts.
+type admission struct {
+	NodeKey   string   `json:"node_key"` // base64Encode(NodeKey)
+	Name      string   `json:"name,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	AuthKeyID string   `json:"auth_key_id,omitempty"`
+}
+
+// signalSnapshot is the on-disk shape of the signal queues, written by
+// MirrorSignals.
+type signalSnapshot struct {
+	Version int           `json:"version"`
+	Queues  []signalQueue `json:"queues"`
+}
+
+type signalQueue struct {
+	To        [32]byte   `json:"to"`
+	Envelopes []Envelope `json:"envelopes"`
+}
+
+// FileStore is a MemStore whose registry is mirrored to a JSON file so the
+// nodeKey → tunnel_ip mapping survives a coordinator restart. Signal queues
+// stay in memory unless MirrorSignals runs: envelopes are only useful for
+// ~30s and daemons re-send call_me_maybe on their own, so only a replica
+// taking over from a live leader needs them.
+type FileStore struct {
+	*MemStore
+	path string
+}
+
+// OpenFileStore loads the registry at path (if it exists) and returns a
+// store that rewrites it after every registration. The parent directory is
+// created 0700 if missing.
+func OpenFileStore(path string, pool netip.Prefix) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	fs := &FileStore{MemStore: NewMemStore(pool), path: path}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var snap registrySnapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+		if snap.Version > registrySnapshotVersion {
+			return nil, fmt.Errorf("%s: snapshot version %d is newer than supported %d",
+				path, snap.Version, registrySnapshotVersion)
+		}
+		if err := fs.restore(snap); err != nil {
+			return nil, fmt.Errorf("restore %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+	default:
+		return nil, err
+	}
+	if err := fs.loadSignals(); err != nil {
+		return nil, err
+	}
+
+	fs.persist = fs.write
+	return fs, nil
+}
+
+// signalsPath is where MirrorSignals keeps the signal queues.
+func (fs *FileStore) signalsPath() string { return fs.path + ".signals" }
+
+func (fs *FileStore) loadSignals() error {
+	b, err := os.ReadFile(fs.signalsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap signalSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("decode %s: %w", fs.signalsPath(), err)
+	}
+	queues := make(map[[32]byte][]Envelope, len(snap.Queues))
+	for _, q := range snap.Queues {
+		queues[q.To] = q.Envelopes
+	}
+	fs.restoreSignals(queues)
+	return nil
+}
+
+// MirrorSignals writes the signal queues next to the registry every
+// interval in which they changed, and once more when ctx is done. A
+// replica that opens the store after taking over leadership then delivers
+// what the old leader had queued.
+func (fs *FileStore) MirrorSignals(ctx context.Context, every time.Duration) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	var gen uint64
+	for {
+		stop := false
+		select {
+		case <-ctx.Done():
+			stop = true
+		case <-tick.C:
+		}
+		if queues, g, changed := fs.signalQueues(gen); changed {
+			if err := fs.writeSignals(queues); err != nil {
+				slog.Warn("mirror signal queues", "path", fs.signalsPath(), "err", err)
+			} else {
+				gen = g
+			}
+		}
+		if stop {
+			return
+		}
+	}
+}
+
+func (fs *FileStore) writeSignals(queues map[[32]byte][]Envelope) error {
+	snap := signalSnapshot{Version: registrySnapshotVersion, Queues: make([]signalQueue, 0, len(queues))}
+	for to, q := range queues {
+		if len(q) > 0 {
+			snap.Queues = append(snap.Queues, signalQueue{To: to, Envelopes: q})
+		}
+	}
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.signalsPath(), buf, 0o600)
+}
+
+// Path returns the file backing the store.
+func (fs *FileStore) Path() string { return fs.path }
+
+func (fs *FileStore) write(snap registrySnapshot) error {
+	buf, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path, buf, 0o600)
+}
+
+// writeFileAtomic replaces path with data such that a crash at any point
+// leaves either the old or the new contents on disk, never a torn file:
+// write a sibling temp file, fsync it, rename over path, fsync the directory.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	cleanup := func() { _ = os.Remove(tmpName) }
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		cleanup()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		cleanup()
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,149 @@
+package coord
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	pool := netip.MustParsePrefix("100.64.0.0/24")
+
+	s1, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := makePeer(t, "alice"), makePeer(t, "bob")
+	ipA, err := s1.Register(context.Background(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipB, err := s1.Register(context.Background(), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	peers, _, _ := s2.Peers(context.Background())
+	if len(peers) != 2 {
+		t.Fatalf("want 2 restored peers, got %d", len(peers))
+	}
+
+	// Re-registering after restart must hand back the same address.
+	got, err := s2.Register(context.Background(), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ipB {
+		t.Errorf("bob moved from %v to %v across restart", ipB, got)
+	}
+
+	// A newcomer must not collide with a restored allocation.
+	carol, err := s2.Register(context.Background(), makePeer(t, "carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol == ipA || carol == ipB {
+		t.Errorf("newcomer got restored address %v", carol)
+	}
+}
+
+func TestFileStore_MissingFileIsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "registry.json")
+	s, err := OpenFileStore(path, netip.MustParsePrefix("100.64.0.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, _, _ := s.Peers(context.Background())
+	if len(peers) != 0 {
+		t.Errorf("want empty store, got %d peers", len(peers))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file should not exist until first registration, stat err=%v", err)
+	}
+}
+
+func TestFileStore_FileModeAndNoTempLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registry.json")
+	s, err := OpenFileStore(path, netip.MustParsePrefix("100.64.0.0/24"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Register(context.Background(), makePeer(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("registry mode = %o, want 0600", mode)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("want only registry.json in dir, got %d entries", len(entries))
+	}
+}
+
+func TestFileStore_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileStore(path, netip.MustParsePrefix("100.64.0.0/24")); err == nil {
+		t.Error("expected decode error for corrupt registry")
+	}
+}
+
+func TestFileStore_NewerVersionRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	if err := os.WriteFile(path, []byte(`{"version":99,"peers":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileStore(path, netip.MustParsePrefix("100.64.0.0/24")); err == nil {
+		t.Error("expected error for snapshot from a newer binary")
+	}
+}
+
+func TestFileStore_MirrorSignals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	pool := netip.MustParsePrefix("100.64.0.0/24")
+	s1, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var to, stale [32]byte
+	to[0], stale[0] = 1, 2
+	_ = s1.EnqueueSignal(context.Background(), to, Envelope{Sealed: []byte("hi"), Enqueue: time.Now()})
+	_ = s1.EnqueueSignal(context.Background(), stale, Envelope{Sealed: []byte("old"), Enqueue: time.Now().Add(-time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s1.MirrorSignals(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	// A replica taking over sees what was queued, minus the expired.
+	s2, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s2.PopSignals(context.Background(), to)
+	if len(got) != 1 || string(got[0].Sealed) != "hi" {
+		t.Errorf("mirrored queue = %+v", got)
+	}
+	if st, _ := s2.Stats(context.Background()); st.QueuedSignals != 0 {
+		t.Errorf("expired envelope restored: %+v", st)
+	}
+}
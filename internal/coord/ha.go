@@ -0,0 +1,123 @@
+package coord
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// leaderPoll is how often a standby replica tries to take the leader lock.
+const leaderPoll = time.Second
+
+// headerLeader on a standby's 503 carries the leader's advertised URL, so a
+// client that knows it can go straight there.
+const headerLeader = "X-Gretun-Leader"
+
+// LeaderLock is the leadership of a group of coordinator replicas sharing
+// one store directory. It is an exclusive flock on a lock file there,
+// released when the holder exits, however it exits. The file holds the
+// leader's advertised URL.
+type LeaderLock struct {
+	f    *os.File
+	path string
+}
+
+// AcquireLeadership blocks until this process holds the lock at path or
+// ctx is done. It then writes advertise into the file for standbys to
+// point clients at.
+func AcquireLeadership(ctx context.Context, path, advertise string) (*LeaderLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	tick := time.NewTicker(leaderPoll)
+	defer tick.Stop()
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-tick.C:
+		}
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(advertise+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &LeaderLock{f: f, path: path}, nil
+}
+
+// Release gives up leadership.
+func (l *LeaderLock) Release() error {
+	return l.f.Close()
+}
+
+// CurrentLeader returns the URL the leader advertised in the lock file at
+// path, or "" if there is none.
+func CurrentLeader(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// leaderHeld reports whether some process holds the lock at path. The file
+// keeps a dead leader's URL until the next one takes over, so that alone
+// doesn't say anyone is there. The probe takes the lock for a moment if
+// it's free, which at worst delays a standby's takeover by one poll.
+func leaderHeld(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	free, err := tryLock(f)
+	return err == nil && !free
+}
+
+// Standby is the handler a replica serves while it waits for leadership:
+// every request gets 503 with Retry-After and, if one holds the lock, the
+// leader's URL.
+// Promote swaps in the real handler once the replica leads.
+type Standby struct {
+	lockPath string
+	active   atomic.Pointer[http.Handler]
+}
+
+// NewStandby returns a Standby that reads the leader's URL from lockPath.
+func NewStandby(lockPath string) *Standby {
+	return &Standby{lockPath: lockPath}
+}
+
+// Promote starts passing every request to h.
+func (s *Standby) Promote(h http.Handler) {
+	s.active.Store(&h)
+}
+
+func (s *Standby) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h := s.active.Load(); h != nil {
+		(*h).ServeHTTP(w, r)
+		return
+	}
+	if leader := CurrentLeader(s.lockPath); leader != "" && leaderHeld(s.lockPath) {
+		w.Header().Set(headerLeader, leader)
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "standby coordinator", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package coord
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLock(*os.File) (bool, error) {
+	return false, errors.New("leader election needs flock, which this platform lacks")
+}
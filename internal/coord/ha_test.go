@@ -0,0 +1,81 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLeadership(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	a, err := AcquireLeadership(context.Background(), path, "http://a:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentLeader(path); got != "http://a:8443" {
+		t.Errorf("leader = %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLeadership(ctx, path, "http://b:8443"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second replica got the lock: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		b, err := AcquireLeadership(context.Background(), path, "http://b:8443")
+		if err == nil {
+			defer b.Release()
+		}
+		done <- err
+	}()
+	_ = a.Release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("standby never took over")
+	}
+	if got := CurrentLeader(path); got != "http://b:8443" {
+		t.Errorf("leader after takeover = %q", got)
+	}
+}
+
+func TestStandby(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	lock, err := AcquireLeadership(context.Background(), path, "http://a:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+
+	sb := NewStandby(path)
+	rec := httptest.NewRecorder()
+	sb.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get(headerLeader) != "http://a:8443" ||
+		rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("standby answered %d %v", rec.Code, rec.Header())
+	}
+
+	// A leader that has gone leaves its URL behind; nobody is there.
+	_ = lock.Release()
+	rec = httptest.NewRecorder()
+	sb.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get(headerLeader) != "" {
+		t.Fatalf("standby after the leader left answered %d %v", rec.Code, rec.Header())
+	}
+
+	sb.Promote(NewServer(newTestStore(t)))
+	rec = httptest.NewRecorder()
+	sb.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/peers", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("promoted standby answered %d", rec.Code)
+	}
+}
@@ -0,0 +1,191 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the coordinator's Prometheus collectors. A nil *metrics is
+// valid and records nothing, which is what a Server gets without
+// WithMetrics.
+type metrics struct {
+	registrations   *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	longPollWaiters *prometheus.GaugeVec
+	throttled       *prometheus.CounterVec
+	relayPackets    *prometheus.CounterVec
+	relayBytes      prometheus.Counter
+}
+
+// WithMetrics registers the coordinator's collectors with reg. Per-network
+// peer counts and signal queue figures are read from the stores at scrape
+// time.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(s *Server) {
+		s.metrics = &metrics{
+			registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "gretun_coord",
+				Name:      "registrations_total",
+				Help:      "Successful POST /v1/register calls, by network and whether the node was new.",
+			}, []string{"network", "kind"}),
+			authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "gretun_coord",
+				Name:      "auth_failures_total",
+				Help:      "Requests refused for authentication or authorization reasons.",
+			}, []string{"reason"}),
+			longPollWaiters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: "gretun_coord",
+				Name:      "long_poll_waiters",
+				Help:      "Requests currently parked in a long-poll or stream.",
+			}, []string{"kind"}),
+			throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "gretun_coord",
+				Name:      "rate_limited_total",
+				Help:      "Requests refused with 429, by route.",
+			}, []string{"route"}),
+			relayPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "gretun_coord",
+				Name:      "relay_packets_total",
+				Help:      "Datagrams sent to the relay: forwarded, or dropped as forbidden, offline (no relay connection) or queue_full.",
+			}, []string{"result"}),
+			relayBytes: prometheus.NewCounter(prometheus.CounterOpts{
+				Namespace: "gretun_coord",
+				Name:      "relay_bytes_total",
+				Help:      "Datagram bytes forwarded by the relay.",
+			}),
+		}
+		reg.MustRegister(s.metrics.registrations, s.metrics.authFailures, s.metrics.longPollWaiters,
+			s.metrics.throttled, s.metrics.relayPackets, s.metrics.relayBytes, &storeCollector{s: s})
+	}
+}
+
+func (m *metrics) registered(network string, isNew bool) {
+	if m == nil {
+		return
+	}
+	kind := "existing"
+	if isNew {
+		kind = "new"
+	}
+	m.registrations.WithLabelValues(network, kind).Inc()
+}
+
+func (m *metrics) authFailure(err error) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(authFailureReason(err)).Inc()
+}
+
+func (m *metrics) rateLimited(route string) {
+	if m == nil {
+		return
+	}
+	m.throttled.WithLabelValues(route).Inc()
+}
+
+func (m *metrics) relayForwarded(n int) {
+	if m == nil {
+		return
+	}
+	m.relayPackets.WithLabelValues("forwarded").Inc()
+	m.relayBytes.Add(float64(n))
+}
+
+func (m *metrics) relayDropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.relayPackets.WithLabelValues(reason).Inc()
+}
+
+// waiting marks one more request parked in a wait of the given kind and
+// returns the function that unmarks it.
+func (m *metrics) waiting(kind string) func() {
+	if m == nil {
+		return func() {}
+	}
+	g := m.longPollWaiters.WithLabelValues(kind)
+	g.Inc()
+	return g.Dec
+}
+
+var (
+	errBadSignature  = errors.New("bad request signature")
+	errBadAdminToken = errors.New("bad admin token")
+	errReplayed      = errors.New("replayed request")
+)
+
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrAuthKeyMissing):
+		return "auth_key_missing"
+	case errors.Is(err, ErrAuthKeyInvalid):
+		return "auth_key_invalid"
+	case errors.Is(err, ErrAuthKeyExpired):
+		return "auth_key_expired"
+	case errors.Is(err, ErrAuthKeyUsed):
+		return "auth_key_used"
+	case errors.Is(err, ErrAuthKeyRevoked):
+		return "auth_key_revoked"
+	case errors.Is(err, errNodeDisabled):
+		return "node_disabled"
+	case errors.Is(err, errSignalForbidden):
+		return "acl_denied"
+	case errors.Is(err, errBadAdminToken):
+		return "admin_token"
+	case errors.Is(err, errReplayed):
+		return "replay"
+	default:
+		return "signature"
+	}
+}
+
+// storeCollector reads per-network figures from the stores at scrape time,
+// so the registry itself stays the only source of truth.
+type storeCollector struct {
+	s *Server
+}
+
+var (
+	peersDesc = prometheus.NewDesc("gretun_coord_peers",
+		"Registered peers.", []string{"network"}, nil)
+	signalQueuesDesc = prometheus.NewDesc("gretun_coord_signal_queues",
+		"Recipients with envelopes waiting to be pulled.", []string{"network"}, nil)
+	queuedSignalsDesc = prometheus.NewDesc("gretun_coord_signal_queue_envelopes",
+		"Envelopes waiting to be pulled, across all recipients.", []string{"network"}, nil)
+	maxQueueDepthDesc = prometheus.NewDesc("gretun_coord_signal_queue_max_depth",
+		"Envelopes waiting for the busiest recipient.", []string{"network"}, nil)
+	signalDropsDesc = prometheus.NewDesc("gretun_coord_signal_drops_total",
+		"Envelopes dropped: overflow = evicted from a full per-recipient queue, expired = too old when pulled.",
+		[]string{"network", "reason"}, nil)
+)
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peersDesc
+	ch <- signalQueuesDesc
+	ch <- queuedSignalsDesc
+	ch <- maxQueueDepthDesc
+	ch <- signalDropsDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, nw := range c.s.sortedNetworks() {
+		st, err := nw.store.Stats(ctx)
+		if err != nil {
+			c.s.log.Warn("store stats", "network", nw.name, "err", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(peersDesc, prometheus.GaugeValue, float64(st.Peers), nw.name)
+		ch <- prometheus.MustNewConstMetric(signalQueuesDesc, prometheus.GaugeValue, float64(st.SignalQueues), nw.name)
+		ch <- prometheus.MustNewConstMetric(queuedSignalsDesc, prometheus.GaugeValue, float64(st.QueuedSignals), nw.name)
+		ch <- prometheus.MustNewConstMetric(maxQueueDepthDesc, prometheus.GaugeValue, float64(st.MaxQueueDepth), nw.name)
+		ch <- prometheus.MustNewConstMetric(signalDropsDesc, prometheus.CounterValue, float64(st.DroppedOverflow), nw.name, "overflow")
+		ch <- prometheus.MustNewConstMetric(signalDropsDesc, prometheus.CounterValue, float64(st.DroppedExpired), nw.name, "expired")
+	}
+}
@@ -0,0 +1,107 @@
+package coord
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricValue returns the value of the sample of name whose labels include
+// all of want, or -1.
+func metricValue(t *testing.T, reg *prometheus.Registry, name string, want map[string]string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range f.GetMetric() {
+			have := map[string]string{}
+			for _, l := range m.GetLabel() {
+				have[l.GetName()] = l.GetValue()
+			}
+			for k, v := range want {
+				if have[k] != v {
+					continue next
+				}
+			}
+			if m.Counter != nil {
+				return m.Counter.GetValue()
+			}
+			return m.Gauge.GetValue()
+		}
+	}
+	return -1
+}
+
+func TestServer_Metrics(t *testing.T) {
+	store := newTestStore(t)
+	store.maxQueueDepth = 1
+	_ = store.AddAuthKey(context.Background(), NewAuthKey("once", false, time.Time{}))
+	reg := prometheus.NewRegistry()
+	ts := httptest.NewServer(NewServer(store, WithRequireAuthKey(), WithMetrics(reg)))
+	defer ts.Close()
+
+	a := newTestClient(t, ts.URL)
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, AuthKey: "once"}); code != http.StatusOK {
+		t.Fatal(code)
+	}
+	a.do(t, "POST", "/v1/register", RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub}).Body.Close()
+	b := newTestClient(t, ts.URL)
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub, AuthKey: "once"}); code != http.StatusUnauthorized {
+		t.Fatal(code)
+	}
+	// Unsigned.
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/peers", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	for i := 0; i < 3; i++ {
+		r := a.do(t, "POST", "/v1/signal", SignalReq{To: a.dk.Pub, Sealed: []byte{byte(i)}})
+		r.Body.Close()
+	}
+
+	checks := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"gretun_coord_registrations_total", map[string]string{"kind": "new", "network": "default"}, 1},
+		{"gretun_coord_registrations_total", map[string]string{"kind": "existing"}, 1},
+		{"gretun_coord_auth_failures_total", map[string]string{"reason": "auth_key_used"}, 1},
+		{"gretun_coord_auth_failures_total", map[string]string{"reason": "signature"}, 1},
+		{"gretun_coord_peers", map[string]string{"network": "default"}, 1},
+		{"gretun_coord_signal_queue_envelopes", map[string]string{"network": "default"}, 1},
+		{"gretun_coord_signal_drops_total", map[string]string{"reason": "overflow"}, 2},
+	}
+	for _, c := range checks {
+		if got := metricValue(t, reg, c.name, c.labels); got != c.want {
+			t.Errorf("%s%v = %v, want %v", c.name, c.labels, got, c.want)
+		}
+	}
+}
+
+func TestStore_Stats_ExpiredDrops(t *testing.T) {
+	s := newTestStore(t)
+	var to [32]byte
+	_ = s.EnqueueSignal(context.Background(), to, Envelope{Enqueue: time.Now().Add(-time.Hour)})
+	_ = s.EnqueueSignal(context.Background(), to, Envelope{Enqueue: time.Now()})
+	if st, _ := s.Stats(context.Background()); st.QueuedSignals != 2 || st.SignalQueues != 1 || st.MaxQueueDepth != 2 {
+		t.Fatalf("before pop: %+v", st)
+	}
+	if got, _ := s.PopSignals(context.Background(), to); len(got) != 1 {
+		t.Fatalf("want 1 fresh envelope, got %d", len(got))
+	}
+	if st, _ := s.Stats(context.Background()); st.DroppedExpired != 1 || st.QueuedSignals != 0 {
+		t.Errorf("after pop: %+v", st)
+	}
+}
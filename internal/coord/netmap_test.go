@@ -0,0 +1,175 @@
+package coord
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+func TestLoadOrCreateSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coord.key")
+	k1, err := LoadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	k2, err := LoadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k1.Equal(k2) {
+		t.Error("reloaded key differs")
+	}
+	_ = os.WriteFile(path, []byte("c2hvcnQ=\n"), 0o600)
+	if _, err := LoadOrCreateSigningKey(path); err == nil {
+		t.Error("short seed should fail")
+	}
+}
+
+func newSignedClient(t *testing.T, base string, pin ed25519.PublicKey) *disco.CoordClient {
+	t.Helper()
+	nk, _ := disco.GenerateNodeKey()
+	dk, _ := disco.GenerateDiscoKey()
+	c := disco.NewCoordClient(base, nk, dk)
+	c.PinCoordKey(pin)
+	if _, _, err := c.Register(context.Background(), disco.RegisterOptions{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCoordClient_PeersVerifiesSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	signed := httptest.NewServer(NewServer(newTestStore(t), WithSigningKey(priv)))
+	defer signed.Close()
+	unsigned := httptest.NewServer(NewServer(newTestStore(t)))
+	defer unsigned.Close()
+	// tamper rewrites the tunnel IP in every response, as a MITM would.
+	inner := NewServer(newTestStore(t), WithSigningKey(priv))
+	tamper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		inner.ServeHTTP(rec, r)
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(bytes.ReplaceAll(rec.Body.Bytes(), []byte("100.64.0.1"), []byte("100.64.0.9")))
+	}))
+	defer tamper.Close()
+
+	cases := []struct {
+		name string
+		base string
+		pin  ed25519.PublicKey
+		ok   bool
+	}{
+		{"pinned", signed.URL, pub, true},
+		{"not pinned", unsigned.URL, nil, true},
+		{"wrong key", signed.URL, other, false},
+		{"unsigned", unsigned.URL, pub, false},
+		{"tampered", tamper.URL, pub, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cl := newSignedClient(t, c.base, c.pin)
+			peers, _, err := cl.Peers(context.Background(), "")
+			if c.ok && (err != nil || len(peers) != 1) {
+				t.Fatalf("peers = %v, %v", peers, err)
+			}
+			if !c.ok && !errors.Is(err, disco.ErrNetmapSignature) {
+				t.Fatalf("err = %v, want ErrNetmapSignature", err)
+			}
+		})
+	}
+}
+
+func TestCoordClient_PeersRejectsReplay(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	inner := NewServer(newTestStore(t), WithSigningKey(priv))
+	// replay records the first peer list it passes on and, once armed,
+	// serves that instead of the live one, as a MITM would.
+	var first []byte
+	var armed bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		inner.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		if r.URL.Path == "/v1/peers" {
+			if first == nil {
+				first = body
+			} else if armed {
+				body = first
+			}
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	c := newSignedClient(t, ts.URL, pub)
+	if _, _, err := c.Peers(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestClient(t, ts.URL)
+	b.register(t)
+	if peers, _, err := c.Peers(context.Background(), ""); err != nil || len(peers) != 2 {
+		t.Fatalf("peers = %v, %v", peers, err)
+	}
+	armed = true
+	if _, _, err := c.Peers(context.Background(), ""); !errors.Is(err, disco.ErrNetmapStale) {
+		t.Fatalf("replayed list: err = %v, want ErrNetmapStale", err)
+	}
+}
+
+func TestCoordClient_WatchVerifiesDeltas(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithSigningKey(priv)))
+	defer ts.Close()
+	c := newSignedClient(t, ts.URL, pub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	peers := make(chan []disco.RemotePeer, 16)
+	go func() {
+		_ = c.Watch(ctx, disco.WatchHandlers{Peers: func(p []disco.RemotePeer) { peers <- p }})
+	}()
+	waitFor := func(what string, ok func([]disco.RemotePeer) bool) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case p := <-peers:
+				if ok(p) {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("never saw a verified list with %s", what)
+			}
+		}
+	}
+	waitFor("one peer", func(p []disco.RemotePeer) bool { return len(p) == 1 })
+	b := newTestClient(t, ts.URL)
+	b.register(t)
+	waitFor("two peers", func(p []disco.RemotePeer) bool { return len(p) == 2 })
+	resp := b.do(t, "POST", "/v1/endpoints", EndpointsReq{Endpoints: []Endpoint{{Addr: netip.MustParseAddrPort("203.0.113.5:41641"), Source: SourceSTUN}}})
+	resp.Body.Close()
+	waitFor("b's endpoint", func(p []disco.RemotePeer) bool {
+		for _, rp := range p {
+			if len(rp.Endpoints) == 1 {
+				return true
+			}
+		}
+		return false
+	})
+}
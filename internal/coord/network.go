@@ -0,0 +1,165 @@
+package coord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultNetwork is the name of the network NewServer's store serves. Nodes
+// that don't ask for a network, and whose auth key doesn't name one, land
+// here.
+const DefaultNetwork = "default"
+
+// network is one isolated overlay: its own pool, registry, etag and signal
+// queues. Nodes in different networks never see or signal each other.
+type network struct {
+	name  string
+	store Store
+}
+
+var errUnknownNetwork = errors.New("unknown network")
+
+// WithNetwork adds a named network next to the default one.
+func WithNetwork(name string, store Store) Option {
+	return func(s *Server) {
+		s.networks[name] = &network{name: name, store: store}
+	}
+}
+
+// sortedNetworks returns every network, default first, then by name, so
+// lookups across networks are deterministic.
+func (s *Server) sortedNetworks() []*network {
+	out := make([]*network, 0, len(s.networks))
+	for _, nw := range s.networks {
+		out = append(out, nw)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].name == DefaultNetwork) != (out[j].name == DefaultNetwork) {
+			return out[i].name == DefaultNetwork
+		}
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+// network returns the named network; "" means the default.
+func (s *Server) network(name string) (*network, error) {
+	if name == "" {
+		name = DefaultNetwork
+	}
+	nw, ok := s.networks[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownNetwork, name)
+	}
+	return nw, nil
+}
+
+// networkOf finds the network nodeKey is registered in. Unknown nodes get
+// the default network and ErrUnknownPeer, which keeps the single-network
+// error behaviour of every handler unchanged.
+func (s *Server) networkOf(ctx context.Context, nodeKey ed25519.PublicKey) (*network, Peer, error) {
+	for _, nw := range s.sortedNetworks() {
+		p, err := nw.store.Lookup(ctx, nodeKey)
+		if err == nil {
+			return nw, p, nil
+		}
+		if !errors.Is(err, ErrUnknownPeer) {
+			return nil, Peer{}, err
+		}
+	}
+	return s.networks[DefaultNetwork], Peer{}, ErrUnknownPeer
+}
+
+// errAdmissionWithdrawn wraps the reason an expired node's admission no
+// longer stands.
+var errAdmissionWithdrawn = errors.New("admission withdrawn")
+
+// admittedIn finds the network that admitted nodeKey, for a node that is
+// no longer registered (it expired), with the tags it was admitted with
+// and the ID of the key that admitted it. The admission only stands while
+// that key does: one since revoked, expired or removed takes it back, and
+// admittedIn returns errAdmissionWithdrawn. Nodes never admitted anywhere
+// get ErrUnknownPeer.
+func (s *Server) admittedIn(ctx context.Context, nodeKey ed25519.PublicKey) (*network, []string, string, error) {
+	for _, nw := range s.sortedNetworks() {
+		tags, keyID, err := nw.store.Admitted(ctx, nodeKey)
+		if errors.Is(err, ErrUnknownPeer) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if err := s.keyStands(ctx, nw, keyID); err != nil {
+			return nil, nil, "", fmt.Errorf("%w: %w", errAdmissionWithdrawn, err)
+		}
+		return nw, tags, keyID, nil
+	}
+	return nil, nil, "", ErrUnknownPeer
+}
+
+// keyStands checks that the auth key with ID keyID could still admit a
+// node, uses aside. A node admitted without a key stands only while keys
+// aren't required.
+func (s *Server) keyStands(ctx context.Context, nw *network, keyID string) error {
+	if keyID == "" {
+		if s.requireAuthKey {
+			return ErrAuthKeyMissing
+		}
+		return nil
+	}
+	keys, err := nw.store.AuthKeys(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if k.ID != keyID {
+			continue
+		}
+		if k.Revoked {
+			return ErrAuthKeyRevoked
+		}
+		if k.Expired(now) {
+			return ErrAuthKeyExpired
+		}
+		return nil
+	}
+	return ErrAuthKeyInvalid
+}
+
+// admitNew picks the network for a node registering for the first time and
+// checks its auth key there. An explicit network wins; otherwise the
+// network holding the presented auth key does; otherwise the default.
+// A key is checked whenever one is presented, even if not required: it's
+// also how a node gets its tags.
+func (s *Server) admitNew(ctx context.Context, req RegisterReq) (*network, AuthKey, error) {
+	if req.Network != "" {
+		nw, err := s.network(req.Network)
+		if err != nil {
+			return nil, AuthKey{}, err
+		}
+		if !s.requireAuthKey && req.AuthKey == "" {
+			return nw, AuthKey{}, nil
+		}
+		k, err := nw.store.UseAuthKey(ctx, req.AuthKey)
+		return nw, k, err
+	}
+	if !s.requireAuthKey && req.AuthKey == "" {
+		return s.networks[DefaultNetwork], AuthKey{}, nil
+	}
+	if req.AuthKey == "" {
+		return nil, AuthKey{}, ErrAuthKeyMissing
+	}
+	for _, nw := range s.sortedNetworks() {
+		k, err := nw.store.UseAuthKey(ctx, req.AuthKey)
+		if errors.Is(err, ErrAuthKeyInvalid) {
+			continue
+		}
+		return nw, k, err
+	}
+	return nil, AuthKey{}, ErrAuthKeyInvalid
+}
@@ -0,0 +1,169 @@
+package coord
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// newMultiNetServer serves "default" (100.64.0.0/24) and "lab"
+// (100.65.0.0/16); lab also holds the auth key "lab-key".
+func newMultiNetServer(t *testing.T, opts ...Option) (def, lab *MemStore, ts *httptest.Server) {
+	t.Helper()
+	def = newTestStore(t)
+	lab = NewMemStore(netip.MustParsePrefix("100.65.0.0/16"))
+	_ = lab.AddAuthKey(context.Background(), NewAuthKey("lab-key", true, time.Time{}))
+	ts = httptest.NewServer(NewServer(def, append(opts, WithNetwork("lab", lab))...))
+	t.Cleanup(ts.Close)
+	return def, lab, ts
+}
+
+func registerReq(t *testing.T, base string, req RegisterReq) (int, RegisterResp) {
+	t.Helper()
+	resp := postRegister(t, base, req)
+	defer resp.Body.Close()
+	var out RegisterResp
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}
+	return resp.StatusCode, out
+}
+
+func TestServer_NetworkSelection(t *testing.T) {
+	def, lab, ts := newMultiNetServer(t)
+
+	a := newTestClient(t, ts.URL)
+	code, ra := registerReq(t, ts.URL, RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub})
+	if code != http.StatusOK || ra.Network != DefaultNetwork || ra.TunnelIP != "100.64.0.1/24" {
+		t.Fatalf("default: code=%d resp=%+v", code, ra)
+	}
+
+	// Auth key picks the network, and the CIDR reflects that pool's size.
+	b := newTestClient(t, ts.URL)
+	code, rb := registerReq(t, ts.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub, AuthKey: "lab-key"})
+	if code != http.StatusOK || rb.Network != "lab" || rb.TunnelIP != "100.65.0.1/16" {
+		t.Fatalf("by key: code=%d resp=%+v", code, rb)
+	}
+
+	// Explicit network field.
+	c := newTestClient(t, ts.URL)
+	code, rc := registerReq(t, ts.URL, RegisterReq{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub, Network: "lab"})
+	if code != http.StatusOK || rc.Network != "lab" {
+		t.Fatalf("by field: code=%d resp=%+v", code, rc)
+	}
+
+	if peers, _, _ := def.Peers(context.Background()); len(peers) != 1 {
+		t.Errorf("default network has %d peers, want 1", len(peers))
+	}
+	if peers, _, _ := lab.Peers(context.Background()); len(peers) != 2 {
+		t.Errorf("lab network has %d peers, want 2", len(peers))
+	}
+
+	d := newTestClient(t, ts.URL)
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: d.nk.Pub, Network: "nope"}); code != http.StatusBadRequest {
+		t.Errorf("unknown network: want 400, got %d", code)
+	}
+	// Switching networks under the same node key isn't allowed.
+	resp := a.do(t, "POST", "/v1/register", RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, Network: "lab"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("network switch: want 409, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_NetworksAreIsolated(t *testing.T) {
+	_, _, ts := newMultiNetServer(t)
+
+	a := newTestClient(t, ts.URL)
+	a.register(t)
+	b := newTestClient(t, ts.URL)
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub, Network: "lab"}); code != http.StatusOK {
+		t.Fatal(code)
+	}
+	b2 := newTestClient(t, ts.URL)
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: b2.nk.Pub, DiscoPubkey: b2.dk.Pub, Network: "lab"}); code != http.StatusOK {
+		t.Fatal(code)
+	}
+
+	resp := b.do(t, "GET", "/v1/peers", nil)
+	var pr PeersResp
+	err := json.NewDecoder(resp.Body).Decode(&pr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Peers) != 2 {
+		t.Errorf("lab node should see only the 2 lab nodes, got %d", len(pr.Peers))
+	}
+
+	// A signal from the default network to a lab disco key lands in the
+	// default network's queue, which the lab node never reads.
+	resp = a.do(t, "POST", "/v1/signal", SignalReq{To: b.dk.Pub, Sealed: []byte("x")})
+	resp.Body.Close()
+	resp = b2.do(t, "POST", "/v1/signal", SignalReq{To: b.dk.Pub, Sealed: []byte("y")})
+	resp.Body.Close()
+
+	resp = b.do(t, "GET", "/v1/signal", nil)
+	var sr SignalsResp
+	err = json.NewDecoder(resp.Body).Decode(&sr)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Envelopes) != 1 || string(sr.Envelopes[0].Sealed) != "y" {
+		t.Errorf("lab node should only get the lab signal, got %+v", sr.Envelopes)
+	}
+}
+
+func TestAdmin_AcrossNetworks(t *testing.T) {
+	_, lab, ts := newMultiNetServer(t, WithAdminToken(testAdminToken))
+	admin := NewAdminClient(ts.URL, testAdminToken)
+
+	a := newTestClient(t, ts.URL)
+	a.register(t)
+	b := newTestClient(t, ts.URL)
+	registerReq(t, ts.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub, NodeName: "labby", Network: "lab"})
+
+	all, err := admin.Nodes(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("want 2 nodes across networks, got %d", len(all))
+	}
+	labOnly, _ := admin.Nodes(context.Background(), "lab")
+	if len(labOnly) != 1 || labOnly[0].Network != "lab" {
+		t.Fatalf("unexpected lab listing %+v", labOnly)
+	}
+
+	disabled := true
+	if _, err := admin.UpdateNode(context.Background(), "labby", AdminNodeUpdate{Disabled: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := lab.Lookup(context.Background(), b.nk.Pub); !p.Disabled {
+		t.Error("update should reach the node's own network")
+	}
+
+	created, err := admin.CreateAuthKey(context.Background(), AdminKeyCreateReq{Network: "lab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Key.Network != "lab" {
+		t.Errorf("key network = %q", created.Key.Network)
+	}
+	if _, err := lab.UseAuthKey(context.Background(), created.Secret); err != nil {
+		t.Errorf("key should live in the lab store: %v", err)
+	}
+	if _, err := admin.CreateAuthKey(context.Background(), AdminKeyCreateReq{Network: "nope"}); err == nil {
+		t.Error("creating a key for an unknown network should fail")
+	}
+}
@@ -0,0 +1,208 @@
+package coord
+
+import (
+	"crypto/ed25519"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Rate tokens per second, holding at most
+// Burst. The zero value means unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) unlimited() bool { return l.Rate <= 0 || l.Burst <= 0 }
+
+// RouteLimits caps one endpoint per node key and per source IP. Both
+// buckets have to have a token for a request to go through. Many nodes can
+// share a source IP behind NAT, so PerIP is usually the looser of the two.
+type RouteLimits struct {
+	PerNode RateLimit
+	PerIP   RateLimit
+}
+
+// RateLimits configures WithRateLimits.
+type RateLimits struct {
+	// Register is checked per source IP before the body is read, and per
+	// node key once the request's signature proves the key.
+	Register  RouteLimits
+	Endpoints RouteLimits
+	Signal    RouteLimits
+	// NewNodes budgets first registrations per source IP. Re-registrations
+	// don't count against it; it's what stops one client draining the pool
+	// with fresh keys.
+	NewNodes RateLimit
+	// Signed budgets every signed request per source IP, on every route,
+	// before its nonce takes a place in the replay cache. It has to cover
+	// the routes' own per-IP limits together.
+	Signed RateLimit
+}
+
+// DefaultRateLimits are generous for a well-behaved daemon, which
+// registers once, posts endpoints every 25s and sends a handful of
+// envelopes per punch.
+var DefaultRateLimits = RateLimits{
+	Register: RouteLimits{
+		PerNode: RateLimit{Rate: 1, Burst: 5},
+		PerIP:   RateLimit{Rate: 0.2, Burst: 20},
+	},
+	Endpoints: RouteLimits{
+		PerNode: RateLimit{Rate: 1, Burst: 10},
+		PerIP:   RateLimit{Rate: 10, Burst: 100},
+	},
+	Signal: RouteLimits{
+		PerNode: RateLimit{Rate: 20, Burst: 100},
+		PerIP:   RateLimit{Rate: 100, Burst: 500},
+	},
+	NewNodes: RateLimit{Rate: 1.0 / 60, Burst: 10},
+	Signed:   RateLimit{Rate: 200, Burst: 1000},
+}
+
+// WithRateLimits throttles register, endpoints and signal, and signed
+// requests as a whole. Throttled requests get 429 with a Retry-After
+// header.
+func WithRateLimits(l RateLimits) Option {
+	return func(s *Server) {
+		s.limits = map[string]*routeLimiter{
+			"register":  newRouteLimiter(l.Register),
+			"endpoints": newRouteLimiter(l.Endpoints),
+			"signal":    newRouteLimiter(l.Signal),
+			"new_node":  newRouteLimiter(RouteLimits{PerIP: l.NewNodes}),
+			"signed":    newRouteLimiter(RouteLimits{PerIP: l.Signed}),
+		}
+	}
+}
+
+type routeLimiter struct {
+	perNode, perIP *limiter
+}
+
+func newRouteLimiter(l RouteLimits) *routeLimiter {
+	return &routeLimiter{perNode: newLimiter(l.PerNode), perIP: newLimiter(l.PerIP)}
+}
+
+// allowIP and allowNode check one bucket each; nil receivers allow all.
+func (rl *routeLimiter) allowIP(r *http.Request) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+	return rl.perIP.allow(sourceIP(r), time.Now())
+}
+
+func (rl *routeLimiter) allowNode(key ed25519.PublicKey) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
+	return rl.perNode.allow(string(key), time.Now())
+}
+
+// limiter keeps one token bucket per key.
+type limiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiterSweepEvery is how often idle buckets are dropped, so a stream of
+// one-off source IPs doesn't grow the map without bound.
+const limiterSweepEvery = time.Minute
+
+func newLimiter(l RateLimit) *limiter {
+	return &limiter{limit: l, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from key's bucket. If there is none it reports how
+// long until there will be.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit.unlimited() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > limiterSweepEvery {
+		l.sweepLocked(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked drops buckets that have refilled completely: forgetting them
+// changes nothing.
+func (l *limiter) sweepLocked(now time.Time) {
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// sourceIP is the client's address without the port. An IPv6 client is
+// keyed by its /64, since a single host is usually handed a whole one. The
+// coordinator doesn't trust X-Forwarded-For; behind a proxy every node
+// shares the proxy's bucket.
+func sourceIP(r *http.Request) string {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := ap.Addr().Unmap()
+	if ip.Is6() {
+		return netip.PrefixFrom(ip.WithZone(""), 64).Masked().String()
+	}
+	return ip.String()
+}
+
+// tooManyRequests writes a 429 with a Retry-After in whole seconds,
+// rounded up.
+func (s *Server) tooManyRequests(w http.ResponseWriter, route string, wait time.Duration) {
+	s.metrics.rateLimited(route)
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "rate limited", http.StatusTooManyRequests)
+}
+
+// limited applies the route's limits to an authed handler: source IP
+// first, then the verified node key.
+func (s *Server) limited(route string, h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, body []byte) {
+		rl := s.limits[route]
+		if ok, wait := rl.allowIP(r); !ok {
+			s.tooManyRequests(w, route, wait)
+			return
+		}
+		if ok, wait := rl.allowNode(pub); !ok {
+			s.tooManyRequests(w, route, wait)
+			return
+		}
+		h(w, r, nw, pub, body)
+	}
+}
@@ -0,0 +1,140 @@
+package coord
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 2, Burst: 3})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within burst refused", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("over burst: ok=%v wait=%s", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("buckets should be per key")
+	}
+	if ok, _ := l.allow("a", now.Add(wait)); !ok {
+		t.Error("token should be back after the advertised wait")
+	}
+
+	// Idle buckets are forgotten.
+	l.allow("c", now)
+	l.allow("d", now.Add(2*limiterSweepEvery))
+	if _, ok := l.buckets["c"]; ok {
+		t.Error("idle bucket survived a sweep")
+	}
+
+	if ok, _ := newLimiter(RateLimit{}).allow("x", now); !ok {
+		t.Error("zero limit should be unlimited")
+	}
+}
+
+func TestServer_RateLimits(t *testing.T) {
+	limits := RateLimits{
+		Register: RouteLimits{PerIP: RateLimit{Rate: 0.01, Burst: 2}},
+		Signal:   RouteLimits{PerNode: RateLimit{Rate: 0.01, Burst: 1}},
+	}
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithRateLimits(limits)))
+	defer ts.Close()
+
+	a, b, c := newTestClient(t, ts.URL), newTestClient(t, ts.URL), newTestClient(t, ts.URL)
+	a.register(t)
+	b.register(t)
+	resp := postRegister(t, ts.URL, RegisterReq{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third register from one IP: want 429, got %d", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra == "" || ra == "0" {
+		t.Errorf("Retry-After = %q", ra)
+	}
+
+	signal := func(from *testClient) int {
+		resp := from.do(t, "POST", "/v1/signal", SignalReq{To: b.dk.Pub, Sealed: []byte("x")})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := signal(a); code != http.StatusOK {
+		t.Fatalf("first signal: %d", code)
+	}
+	if code := signal(a); code != http.StatusTooManyRequests {
+		t.Errorf("second signal from a: want 429, got %d", code)
+	}
+	if code := signal(b); code != http.StatusOK {
+		t.Errorf("b has its own bucket: got %d", code)
+	}
+	// Endpoints are unlimited in this config.
+	for i := 0; i < 5; i++ {
+		resp := a.do(t, "POST", "/v1/endpoints", EndpointsReq{})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("endpoints %d: %d", i, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_NewNodeBudget(t *testing.T) {
+	limits := RateLimits{
+		Register: RouteLimits{PerNode: RateLimit{Rate: 0.01, Burst: 1}},
+		NewNodes: RateLimit{Rate: 0.01, Burst: 1},
+	}
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithRateLimits(limits)))
+	defer ts.Close()
+
+	a, b := newTestClient(t, ts.URL), newTestClient(t, ts.URL)
+	req := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub}
+	resp := postRegister(t, ts.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first node: %d", resp.StatusCode)
+	}
+	resp = postRegister(t, ts.URL, RegisterReq{NodePubkey: b.nk.Pub, DiscoPubkey: b.dk.Pub})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second new node from one IP: want 429, got %d", resp.StatusCode)
+	}
+
+	// Unsigned registers naming a's key are refused without touching a's
+	// bucket, so a can still re-register, once.
+	for i := 0; i < 3; i++ {
+		resp = postRegister(t, ts.URL, req)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unsigned re-register: want 401, got %d", resp.StatusCode)
+		}
+	}
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed re-register: want 200, got %d", resp.StatusCode)
+	}
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("over a's own bucket: want 429, got %d", resp.StatusCode)
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	for remote, want := range map[string]string{
+		"192.0.2.7:4242":             "192.0.2.7",
+		"[::ffff:192.0.2.7]:4242":    "192.0.2.7",
+		"[2001:db8:1:2:3:4:5:6]:443": "2001:db8:1:2::/64",
+		"[2001:db8:1:2::99]:443":     "2001:db8:1:2::/64",
+		"[fe80::1%eth0]:443":         "fe80::/64",
+		"not-an-addr":                "not-an-addr",
+	} {
+		if got := sourceIP(&http.Request{RemoteAddr: remote}); got != want {
+			t.Errorf("sourceIP(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
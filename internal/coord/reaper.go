@@ -0,0 +1,37 @@
+package coord
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// minReapInterval keeps a tiny TTL from turning the reaper into a busy loop.
+const minReapInterval = time.Second
+
+// RunReaper evicts peers not heard from (register, endpoint post, or poll)
+// within ttl, and returns their tunnel IPs to the pool grace after eviction.
+// It checks every ttl/4 and blocks until ctx is done.
+func RunReaper(ctx context.Context, store Store, ttl, grace time.Duration) {
+	interval := ttl / 4
+	if interval < minReapInterval {
+		interval = minReapInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			evicted, err := store.Expire(ctx, time.Now().Add(-ttl), grace)
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("peer expiry", "err", err)
+			}
+			for _, p := range evicted {
+				slog.Info("expired stale peer", "name", p.Name, "tunnel_ip", p.TunnelIP,
+					"last_seen", p.UpdatedAt)
+			}
+		}
+	}
+}
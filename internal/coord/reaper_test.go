@@ -0,0 +1,357 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_Touch_RefreshesWithoutEtagBump(t *testing.T) {
+	s := newTestStore(t)
+	p := makePeer(t, "alice")
+	if _, err := s.Register(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	before, etag, _ := s.Peers(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	if err := s.Touch(context.Background(), p.NodeKey); err != nil {
+		t.Fatal(err)
+	}
+	after, etag2, _ := s.Peers(context.Background())
+	if etag != etag2 {
+		t.Error("Touch should not bump the etag")
+	}
+	if !after[0].UpdatedAt.After(before[0].UpdatedAt) {
+		t.Error("Touch should advance UpdatedAt")
+	}
+}
+
+func TestStore_Touch_UnknownPeer(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Touch(context.Background(), makePeer(t, "ghost").NodeKey); err == nil {
+		t.Error("expected error touching an unregistered peer")
+	}
+}
+
+func TestStore_Expire_EvictsStaleOnly(t *testing.T) {
+	s := newTestStore(t)
+	stale, fresh := makePeer(t, "stale"), makePeer(t, "fresh")
+	if _, err := s.Register(context.Background(), stale); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Register(context.Background(), fresh); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.EnqueueSignal(context.Background(), stale.DiscoKey, Envelope{Enqueue: time.Now()})
+	_, etag, _ := s.Peers(context.Background())
+
+	evicted, err := s.Expire(context.Background(), cutoff, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted[0].Name != "stale" {
+		t.Fatalf("want only stale evicted, got %+v", evicted)
+	}
+	peers, etag2, _ := s.Peers(context.Background())
+	if len(peers) != 1 || peers[0].Name != "fresh" {
+		t.Errorf("want only fresh left, got %+v", peers)
+	}
+	if etag == etag2 {
+		t.Error("eviction should bump the etag")
+	}
+	if envs, _ := s.PopSignals(context.Background(), stale.DiscoKey); len(envs) != 0 {
+		t.Errorf("evicted peer's signal queue should be dropped, got %d", len(envs))
+	}
+}
+
+func TestStore_Expire_NothingStaleKeepsEtag(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.Register(context.Background(), makePeer(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+	_, etag, _ := s.Peers(context.Background())
+	if _, err := s.Expire(context.Background(), time.Now().Add(-time.Hour), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, etag2, _ := s.Peers(context.Background()); etag != etag2 {
+		t.Error("no-op expiry should not bump the etag")
+	}
+}
+
+func TestStore_Expire_GraceKeepsAddress(t *testing.T) {
+	s := NewMemStore(netip.MustParsePrefix("10.0.0.0/30")) // room for two
+	a := makePeer(t, "a")
+	ipA, err := s.Register(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// While a's slot is in grace, a newcomer must get the other address.
+	ipB, err := s.Register(context.Background(), makePeer(t, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipB == ipA {
+		t.Fatalf("newcomer took %v while it was reserved", ipA)
+	}
+
+	// a comes back within grace and gets its old address.
+	got, err := s.Register(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != ipA {
+		t.Errorf("returning peer got %v, want %v", got, ipA)
+	}
+}
+
+func TestStore_Expire_GraceElapsedFreesSlot(t *testing.T) {
+	s := NewMemStore(netip.MustParsePrefix("10.0.0.0/30"))
+	a, b := makePeer(t, "a"), makePeer(t, "b")
+	if _, err := s.Register(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Register(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Register(context.Background(), makePeer(t, "c")); err == nil {
+		t.Fatal("pool should be full")
+	}
+
+	// Evict everyone with zero grace: slots come straight back.
+	if _, err := s.Expire(context.Background(), time.Now().Add(time.Second), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Register(context.Background(), makePeer(t, "c")); err != nil {
+		t.Errorf("slot should be free after grace, got %v", err)
+	}
+}
+
+func TestFileStore_TombstonesSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	pool := netip.MustParsePrefix("100.64.0.0/24")
+	s1, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := makePeer(t, "a")
+	ipA, err := s1.Register(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s1.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers, _, _ := s2.Peers(context.Background()); len(peers) != 0 {
+		t.Fatalf("expired peer should stay gone, got %d", len(peers))
+	}
+	ipB, err := s2.Register(context.Background(), makePeer(t, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ipB == ipA {
+		t.Error("reserved slot was handed out after restart")
+	}
+	if got, _ := s2.Register(context.Background(), a); got != ipA {
+		t.Errorf("returning peer got %v, want %v", got, ipA)
+	}
+}
+
+func TestStore_AdmittedSurvivesExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	pool := netip.MustParsePrefix("100.64.0.0/24")
+	s1, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := makePeer(t, "a")
+	a.Tags = []string{"tag:lab"}
+	a.AuthKeyID = "k1"
+	if _, err := s1.Register(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s1.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := OpenFileStore(path, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, keyID, err := s2.Admitted(context.Background(), a.NodeKey)
+	if err != nil {
+		t.Fatalf("admission lost across expiry and reopen: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "tag:lab" || keyID != "k1" {
+		t.Errorf("admitted with %v by %q, want [tag:lab] by k1", tags, keyID)
+	}
+	if _, _, err := s2.Admitted(context.Background(), makePeer(t, "b").NodeKey); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("never-registered key: want ErrUnknownPeer, got %v", err)
+	}
+
+	if _, err := s2.Register(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.DeletePeer(context.Background(), a.NodeKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s2.Admitted(context.Background(), a.NodeKey); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("deleted peer: want ErrUnknownPeer, got %v", err)
+	}
+}
+
+func TestStore_AdmissionEndsWithTombstone(t *testing.T) {
+	s := newTestStore(t)
+	a := makePeer(t, "a")
+	if _, err := s.Register(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Admitted(context.Background(), a.NodeKey); err != nil {
+		t.Fatalf("admission gone within the grace period: %v", err)
+	}
+	if _, err := s.Expire(context.Background(), time.Now(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Admitted(context.Background(), a.NodeKey); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("after the tombstone's release: want ErrUnknownPeer, got %v", err)
+	}
+}
+
+func TestServer_ExpiredNodeRejoins(t *testing.T) {
+	store := newTestStore(t)
+	k := NewAuthKey("join-me", false, time.Time{})
+	k.Tags = []string{"tag:lab"}
+	_ = store.AddAuthKey(context.Background(), k)
+	srv := httptest.NewServer(NewServer(store, WithRequireAuthKey()))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	req := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, NodeName: "a", AuthKey: "join-me"}
+	resp := postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first register: want 200, got %d", resp.StatusCode)
+	}
+	if _, err := store.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is spent; the node comes back on its node key alone.
+	req.AuthKey = ""
+	resp = postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned rejoin: want 401, got %d", resp.StatusCode)
+	}
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed rejoin: want 200, got %d", resp.StatusCode)
+	}
+	p, err := store.Lookup(context.Background(), a.nk.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Tags) != 1 || p.Tags[0] != "tag:lab" {
+		t.Errorf("rejoined tags = %v, want [tag:lab]", p.Tags)
+	}
+}
+
+func TestServer_RevokedKeyEndsRejoin(t *testing.T) {
+	store := newTestStore(t)
+	k := NewAuthKey("join-me", false, time.Time{})
+	_ = store.AddAuthKey(context.Background(), k)
+	srv := httptest.NewServer(NewServer(store, WithRequireAuthKey()))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	req := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: a.dk.Pub, NodeName: "a", AuthKey: "join-me"}
+	resp := postRegister(t, srv.URL, req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first register: want 200, got %d", resp.StatusCode)
+	}
+	if _, err := store.Expire(context.Background(), time.Now().Add(time.Second), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAuthKey(context.Background(), k.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	req.AuthKey = ""
+	resp = a.do(t, "POST", "/v1/register", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("rejoin after the key's revocation: want 401, got %d", resp.StatusCode)
+	}
+	if _, err := store.Lookup(context.Background(), a.nk.Pub); !errors.Is(err, ErrUnknownPeer) {
+		t.Errorf("node rejoined on a revoked key: %v", err)
+	}
+}
+
+func TestRunReaper_EvictsAndStops(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.Register(context.Background(), makePeer(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunReaper(ctx, s, 10*time.Millisecond, time.Hour)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if peers, _, _ := s.Peers(context.Background()); len(peers) == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if peers, _, _ := s.Peers(context.Background()); len(peers) != 0 {
+		t.Error("reaper never evicted the stale peer")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reaper did not stop on cancel")
+	}
+}
+
+func TestServer_PollTouchesPeer(t *testing.T) {
+	store := newTestStore(t)
+	srv := httptest.NewServer(NewServer(store))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+	before, _, _ := store.Peers(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	resp := a.do(t, "GET", "/v1/peers", nil)
+	resp.Body.Close()
+	after, _, _ := store.Peers(context.Background())
+	if !after[0].UpdatedAt.After(before[0].UpdatedAt) {
+		t.Error("GET /v1/peers should refresh the caller's liveness")
+	}
+}
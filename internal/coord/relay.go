@@ -0,0 +1,247 @@
+package coord
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Relay wire format. GET /v1/relay upgrades to RelayProto, after which both
+// directions carry frames of
+//
+//	type (1 byte) || length (4 bytes, big endian) || body
+//
+// A send frame's body is the destination node key followed by one FOU
+// datagram; the relay rewrites it into a recv frame carrying the source
+// node key instead. Keepalive frames have an empty body.
+const (
+	RelayProto = "gretun-relay-v1"
+
+	relayFrameSend      = 1
+	relayFrameRecv      = 2
+	relayFrameKeepalive = 3
+
+	// relayMaxFrame bounds one frame body: a node key and a datagram.
+	relayMaxFrame = ed25519.PublicKeySize + 64<<10
+	// relayQueueLen is how many frames may wait for a slow recipient
+	// before the relay drops, as the network would.
+	relayQueueLen = 256
+	// relayKeepalive is how often each side writes a keepalive; a
+	// connection silent for three of them is dropped.
+	relayKeepalive = 20 * time.Second
+	// relayACLRecheck is how long a sender→recipient verdict is cached.
+	relayACLRecheck = 10 * time.Second
+)
+
+// WithRelay serves GET /v1/relay, which forwards FOU datagrams between
+// registered nodes that couldn't punch a direct path. The relay sees
+// tunnel payload, so the same ACL policy that gates signaling gates it.
+func WithRelay() Option {
+	return func(s *Server) { s.relay = &relayHub{conns: make(map[relayKey]*relayConn)} }
+}
+
+// relayHub tracks the one live relay connection per node.
+type relayHub struct {
+	mu    sync.Mutex
+	conns map[relayKey]*relayConn
+}
+
+type relayKey struct {
+	network string
+	node    string
+}
+
+type relayConn struct {
+	out  chan []byte // encoded frames
+	done chan struct{}
+}
+
+func (h *relayHub) add(k relayKey, c *relayConn) {
+	h.mu.Lock()
+	old := h.conns[k]
+	h.conns[k] = c
+	h.mu.Unlock()
+	if old != nil {
+		// A node reconnecting replaces its stale connection.
+		close(old.done)
+	}
+}
+
+func (h *relayHub) remove(k relayKey, c *relayConn) {
+	h.mu.Lock()
+	if h.conns[k] == c {
+		delete(h.conns, k)
+	}
+	h.mu.Unlock()
+}
+
+func (h *relayHub) get(k relayKey) *relayConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.conns[k]
+}
+
+func (s *Server) handleRelay(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, _ []byte) {
+	if r.Header.Get("Upgrade") != RelayProto {
+		w.Header().Set("Upgrade", RelayProto)
+		http.Error(w, "upgrade to "+RelayProto+" required", http.StatusUpgradeRequired)
+		return
+	}
+	self, err := nw.store.Lookup(r.Context(), pub)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if self.Disabled {
+		http.Error(w, errNodeDisabled.Error(), http.StatusForbidden)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	// The server's read and write timeouts don't apply past the upgrade.
+	_ = conn.SetDeadline(time.Time{})
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\nConnection: Upgrade\r\n\r\n", RelayProto)
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	key := relayKey{network: nw.name, node: string(pub)}
+	rc := &relayConn{out: make(chan []byte, relayQueueLen), done: make(chan struct{})}
+	s.relay.add(key, rc)
+	defer s.relay.remove(key, rc)
+	defer s.metrics.waiting("relay")()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-rc.done:
+		}
+		conn.Close()
+	}()
+	go relayWriteLoop(ctx, conn, rc)
+
+	allowed := make(map[string]time.Time) // recipient → verdict expiry
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(3 * relayKeepalive))
+		typ, body, err := readRelayFrame(brw.Reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Debug("relay read", "node", hex.EncodeToString(pub), "err", err)
+			}
+			return
+		}
+		if typ != relayFrameSend || len(body) < ed25519.PublicKeySize {
+			continue
+		}
+		dst := body[:ed25519.PublicKeySize]
+		if exp, ok := allowed[string(dst)]; !ok || time.Now().After(exp) {
+			if !s.relayAllowed(ctx, nw, pub, dst) {
+				s.metrics.relayDropped("forbidden")
+				continue
+			}
+			allowed[string(dst)] = time.Now().Add(relayACLRecheck)
+		}
+		peer := s.relay.get(relayKey{network: nw.name, node: string(dst)})
+		if peer == nil {
+			s.metrics.relayDropped("offline")
+			continue
+		}
+		frame := appendRelayFrame(nil, relayFrameRecv, pub, body[ed25519.PublicKeySize:])
+		select {
+		case peer.out <- frame:
+			s.metrics.relayForwarded(len(body) - ed25519.PublicKeySize)
+		default:
+			s.metrics.relayDropped("queue_full")
+		}
+	}
+}
+
+// relayAllowed reports whether from may send to the node dst in nw: both
+// have to be registered and enabled, and dst visible to from under the
+// policy. Disabling a node thus cuts its relayed traffic within
+// relayACLRecheck.
+func (s *Server) relayAllowed(ctx context.Context, nw *network, from, dst ed25519.PublicKey) bool {
+	sender, err := nw.store.Lookup(ctx, from)
+	if err != nil || sender.Disabled {
+		return false
+	}
+	peer, err := nw.store.Lookup(ctx, dst)
+	if err != nil || peer.Disabled {
+		return false
+	}
+	policy := s.policy.Load()
+	return policy == nil || policy.Allowed(sender, peer)
+}
+
+func relayWriteLoop(ctx context.Context, conn net.Conn, rc *relayConn) {
+	bw := bufio.NewWriter(conn)
+	ka := time.NewTicker(relayKeepalive)
+	defer ka.Stop()
+	keepalive := appendRelayFrame(nil, relayFrameKeepalive, nil, nil)
+	for {
+		var frame []byte
+		select {
+		case <-ctx.Done():
+			return
+		case <-rc.done:
+			return
+		case <-ka.C:
+			frame = keepalive
+		case frame = <-rc.out:
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(relayKeepalive))
+		if _, err := bw.Write(frame); err != nil {
+			conn.Close()
+			return
+		}
+		// Batch whatever else is already queued into one write.
+		for len(rc.out) > 0 && bw.Available() > 0 {
+			if _, err := bw.Write(<-rc.out); err != nil {
+				conn.Close()
+				return
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func appendRelayFrame(b []byte, typ byte, key, payload []byte) []byte {
+	b = append(b, typ)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(key)+len(payload)))
+	b = append(b, key...)
+	return append(b, payload...)
+}
+
+func readRelayFrame(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > relayMaxFrame {
+		return 0, nil, fmt.Errorf("relay frame of %d bytes exceeds %d", n, relayMaxFrame)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
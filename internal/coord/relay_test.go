@@ -0,0 +1,132 @@
+package coord
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newRelayClient(t *testing.T, base, name string) (*disco.CoordClient, ed25519.PublicKey) {
+	t.Helper()
+	nk, _ := disco.GenerateNodeKey()
+	dk, _ := disco.GenerateDiscoKey()
+	c := disco.NewCoordClient(base, nk, dk)
+	if _, _, err := c.Register(context.Background(), disco.RegisterOptions{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	return c, nk.Pub
+}
+
+func TestRelay_Forwards(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	policy := mustPolicy(t, `{"acls": [{"src": ["name:a"], "dst": ["name:b"]}]}`)
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithRelay(), WithACLPolicy(policy), WithMetrics(reg)))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, aPub := newRelayClient(t, ts.URL, "a")
+	b, bPub := newRelayClient(t, ts.URL, "b")
+	_, cPub := newRelayClient(t, ts.URL, "c")
+	ra, err := a.DialRelay(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := b.DialRelay(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// c is forbidden by policy and, besides, not connected; neither reaches
+	// anyone. The datagram to b follows them on the same connection, so
+	// once it arrives both drops have been counted.
+	if err := ra.Send(cPub, []byte("nope")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.Send(bPub, []byte("fou datagram")); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan []byte, 1)
+	go func() {
+		_, p, err := rb.Recv()
+		if err == nil {
+			got <- p
+		}
+	}()
+	select {
+	case p := <-got:
+		if !bytes.Equal(p, []byte("fou datagram")) {
+			t.Fatalf("payload = %q", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("datagram never relayed")
+	}
+	if v := metricValue(t, reg, "gretun_coord_relay_packets_total", map[string]string{"result": "forbidden"}); v != 1 {
+		t.Errorf("forbidden drops = %v, want 1", v)
+	}
+	if v := metricValue(t, reg, "gretun_coord_relay_packets_total", map[string]string{"result": "forwarded"}); v != 1 {
+		t.Errorf("forwarded = %v, want 1", v)
+	}
+
+	// b → a is allowed (rules are symmetric), but a has gone away.
+	ra.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for metricValue(t, reg, "gretun_coord_relay_packets_total", map[string]string{"result": "offline"}) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("datagram to a disconnected node never counted as offline")
+		}
+		if err := rb.Send(aPub, []byte("late")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRelay_Unsupported(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newTestStore(t)))
+	defer ts.Close()
+	a, _ := newRelayClient(t, ts.URL, "a")
+	if _, err := a.DialRelay(context.Background()); !errors.Is(err, disco.ErrRelayUnsupported) {
+		t.Fatalf("err = %v, want ErrRelayUnsupported", err)
+	}
+}
+
+func TestRelay_RequiresUpgrade(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newTestStore(t), WithRelay()))
+	defer ts.Close()
+	c := newTestClient(t, ts.URL)
+	c.register(t)
+	resp := c.do(t, "GET", "/v1/relay", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("status = %d, want 426", resp.StatusCode)
+	}
+}
+
+func TestRelayFrame_RoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, ed25519.PublicKeySize)
+	b := appendRelayFrame(nil, relayFrameRecv, key, []byte("x"))
+	b = appendRelayFrame(b, relayFrameKeepalive, nil, nil)
+	r := bufio.NewReader(bytes.NewReader(b))
+	typ, body, err := readRelayFrame(r)
+	if err != nil || typ != relayFrameRecv || !bytes.Equal(body, append(key, 'x')) {
+		t.Fatalf("frame = %d %x %v", typ, body, err)
+	}
+	if typ, body, err := readRelayFrame(r); err != nil || typ != relayFrameKeepalive || len(body) != 0 {
+		t.Fatalf("keepalive = %d %x %v", typ, body, err)
+	}
+
+	huge := []byte{relayFrameSend, 0xff, 0xff, 0xff, 0xff}
+	if _, _, err := readRelayFrame(bufio.NewReader(bytes.NewReader(huge))); err == nil {
+		t.Error("oversized frame should fail")
+	}
+}
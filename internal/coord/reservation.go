@@ -0,0 +1,73 @@
+package coord
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// Reservation pins a node key to a tunnel IP in one network.
+type Reservation struct {
+	NodeKey  ed25519.PublicKey
+	TunnelIP netip.Addr
+	Network  string // "" = the default network
+}
+
+// ParseReservationFile reads one reservation per line: the node key (hex,
+// as the admin API shows it, or base64, as it appears on the wire), the
+// tunnel IP, and optionally "network=NAME". Blank lines and #-comments are
+// ignored.
+func ParseReservationFile(r io.Reader) ([]Reservation, error) {
+	var out []Reservation
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want NODEKEY IP [network=NAME]", line)
+		}
+		key, err := parseNodeKey(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ip, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		res := Reservation{NodeKey: key, TunnelIP: ip}
+		for _, opt := range fields[2:] {
+			if !strings.HasPrefix(opt, "network=") {
+				return nil, fmt.Errorf("line %d: unknown option %q", line, opt)
+			}
+			res.Network = strings.TrimPrefix(opt, "network=")
+		}
+		out = append(out, res)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func parseNodeKey(s string) (ed25519.PublicKey, error) {
+	if b, err := hex.DecodeString(s); err == nil && len(b) == ed25519.PublicKeySize {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == ed25519.PublicKeySize {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%q is not a hex or base64 ed25519 node key", s)
+}
@@ -0,0 +1,155 @@
+package coord
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestStore_Register_RequestedIP(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	a := makePeer(t, "a")
+	a.TunnelIP = netip.MustParseAddr("100.64.0.10")
+	if ip, _ := s.Register(ctx, a); ip != a.TunnelIP {
+		t.Fatalf("free in-pool request: got %s", ip)
+	}
+
+	// Taken, out of pool, network and broadcast addresses all fall back to
+	// sequential allocation.
+	for _, req := range []string{"100.64.0.10", "10.0.0.1", "100.64.0.0", "100.64.0.255"} {
+		p := makePeer(t, req)
+		p.TunnelIP = netip.MustParseAddr(req)
+		ip, err := s.Register(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip == p.TunnelIP {
+			t.Errorf("request for %s should not be granted", req)
+		}
+	}
+
+	// An existing node can move to a free address.
+	a.TunnelIP = netip.MustParseAddr("100.64.0.20")
+	if ip, _ := s.Register(ctx, a); ip != a.TunnelIP {
+		t.Fatalf("move: got %s", ip)
+	}
+	b := makePeer(t, "b")
+	b.TunnelIP = netip.MustParseAddr("100.64.0.10")
+	if ip, _ := s.Register(ctx, b); ip != b.TunnelIP {
+		t.Errorf("old address should be free after a move, got %s", ip)
+	}
+}
+
+func TestStore_Reserve(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	reserved := makePeer(t, "reserved")
+	if err := s.Reserve(ctx, reserved.NodeKey, netip.MustParseAddr("100.64.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	// Sequential allocation and explicit requests skip a reserved address.
+	other := makePeer(t, "other")
+	other.TunnelIP = netip.MustParseAddr("100.64.0.1")
+	if ip, _ := s.Register(ctx, other); ip != netip.MustParseAddr("100.64.0.2") {
+		t.Errorf("other got %s, want .2", ip)
+	}
+	ip := netip.MustParseAddr("100.64.0.1")
+	if err := s.UpdatePeer(ctx, other.NodeKey, PeerUpdate{TunnelIP: &ip}); err != ErrTunnelIPTaken {
+		t.Errorf("set-ip onto a reservation: %v", err)
+	}
+
+	// The reservation beats the node's own request.
+	reserved.TunnelIP = netip.MustParseAddr("100.64.0.50")
+	if ip, _ := s.Register(ctx, reserved); ip != netip.MustParseAddr("100.64.0.1") {
+		t.Errorf("reserved node got %s", ip)
+	}
+
+	if err := s.Reserve(ctx, other.NodeKey, netip.MustParseAddr("100.64.0.1")); err == nil {
+		t.Error("double reservation should fail")
+	}
+	if err := s.Reserve(ctx, other.NodeKey, netip.MustParseAddr("10.0.0.1")); err == nil {
+		t.Error("out-of-pool reservation should fail")
+	}
+}
+
+func TestStore_Reserve_MovesExistingPeer(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	p := makePeer(t, "p")
+	if ip, _ := s.Register(ctx, p); ip != netip.MustParseAddr("100.64.0.1") {
+		t.Fatal(ip)
+	}
+	if err := s.Reserve(ctx, p.NodeKey, netip.MustParseAddr("100.64.0.9")); err != nil {
+		t.Fatal(err)
+	}
+	if ip, _ := s.Register(ctx, p); ip != netip.MustParseAddr("100.64.0.9") {
+		t.Fatalf("re-register should move onto the reservation, got %s", ip)
+	}
+	q := makePeer(t, "q")
+	if ip, _ := s.Register(ctx, q); ip != netip.MustParseAddr("100.64.0.1") {
+		t.Errorf("old address should be reusable, got %s", ip)
+	}
+}
+
+func TestParseReservationFile(t *testing.T) {
+	p := makePeer(t, "p")
+	in := "# comment\n" +
+		hex.EncodeToString(p.NodeKey) + " 100.64.0.5\n" +
+		base64.StdEncoding.EncodeToString(p.NodeKey) + " 100.65.0.5 network=lab\n"
+	rs, err := ParseReservationFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].TunnelIP != netip.MustParseAddr("100.64.0.5") || rs[1].Network != "lab" {
+		t.Fatalf("got %+v", rs)
+	}
+	if string(rs[1].NodeKey) != string(p.NodeKey) {
+		t.Error("base64 key mis-decoded")
+	}
+	for _, bad := range []string{"abcd 100.64.0.5\n", hex.EncodeToString(p.NodeKey) + "\n", hex.EncodeToString(p.NodeKey) + " 100.64.0.5 sticky\n"} {
+		if _, err := ParseReservationFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestStore_AdminMoveBeatsRequest(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	p := makePeer(t, "p")
+	p.TunnelIP = netip.MustParseAddr("100.64.0.42")
+	if _, err := s.Register(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	moved := netip.MustParseAddr("100.64.0.77")
+	if err := s.UpdatePeer(ctx, p.NodeKey, PeerUpdate{TunnelIP: &moved}); err != nil {
+		t.Fatal(err)
+	}
+	// The daemon restarts with the same --tunnel-ip; the admin move holds.
+	if ip, _ := s.Register(ctx, p); ip != moved {
+		t.Errorf("re-register got %s, want %s", ip, moved)
+	}
+}
+
+func TestServer_Register_RequestedTunnelIP(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newTestStore(t)))
+	defer ts.Close()
+
+	c := newTestClient(t, ts.URL)
+	code, resp := registerReq(t, ts.URL, RegisterReq{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub, RequestedTunnelIP: "100.64.0.42"})
+	if code != http.StatusOK || resp.TunnelIP != "100.64.0.42/24" {
+		t.Fatalf("code=%d resp=%+v", code, resp)
+	}
+	if code, _ := registerReq(t, ts.URL, RegisterReq{NodePubkey: c.nk.Pub, RequestedTunnelIP: "nope"}); code != http.StatusBadRequest {
+		t.Errorf("unparseable request: want 400, got %d", code)
+	}
+}
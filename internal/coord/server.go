@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,52 +20,195 @@ import (
 // connection open waiting for something to change.
 const longPollTimeout = 25 * time.Second
 
-// Server serves the coordinator HTTP API against a Store.
+// Server serves the coordinator HTTP API against one Store per network.
 type Server struct {
-	store Store
-	mux   *http.ServeMux
-	log   *slog.Logger
+	networks map[string]*network
+	mux      *http.ServeMux
+	log      *slog.Logger
+
+	requireAuthKey bool
+	adminTokenHash []byte // sha256 of the admin bearer token; nil = admin API off
+	policy         atomic.Pointer[ACLPolicy]
+	metrics        *metrics
+	limits         map[string]*routeLimiter // by route; nil = unlimited
+	replay         *replayCache
+	signer         ed25519.PrivateKey // signs peer lists; nil = unsigned
+	stunAddr       string             // advertised STUN server; "" = none
+	relay          *relayHub          // nil = no data-plane relay
+}
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithRequireAuthKey makes POST /v1/register refuse nodes that aren't
+// already registered unless they present a usable auth key.
+func WithRequireAuthKey() Option {
+	return func(s *Server) { s.requireAuthKey = true }
+}
+
+// WithACLPolicy restricts which nodes see and signal each other.
+func WithACLPolicy(p *ACLPolicy) Option {
+	return func(s *Server) { s.policy.Store(p) }
+}
+
+// SetACLPolicy swaps the policy on a running server (nil allows all) and
+// wakes every /v1/peers long-poll so nodes pick up their new view.
+func (s *Server) SetACLPolicy(ctx context.Context, p *ACLPolicy) error {
+	s.policy.Store(p)
+	for _, nw := range s.networks {
+		if err := nw.store.Invalidate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// NewServer wires up the HTTP handlers. register is unauthenticated (the
-// requester is by definition not yet in the store); everything else checks
-// an Ed25519 signature over the request.
-func NewServer(store Store) *Server {
+// NewServer wires up the HTTP handlers. A first registration is gated by an
+// auth key when WithRequireAuthKey is set; re-registering a known node, and
+// everything else, checks an Ed25519 signature over the request. store backs
+// DefaultNetwork; WithNetwork adds more.
+func NewServer(store Store, opts ...Option) *Server {
 	s := &Server{
-		store: store,
-		mux:   http.NewServeMux(),
-		log:   slog.Default(),
+		networks: map[string]*network{DefaultNetwork: {name: DefaultNetwork, store: store}},
+		mux:      http.NewServeMux(),
+		log:      slog.Default(),
+		replay:   newReplayCache(),
+	}
+	for _, o := range opts {
+		o(s)
 	}
 	s.mux.HandleFunc("POST /v1/register", s.handleRegister)
-	s.mux.HandleFunc("POST /v1/endpoints", s.authed(s.handleEndpoints))
+	s.mux.HandleFunc("POST /v1/endpoints", s.authed(s.limited("endpoints", s.handleEndpoints)))
 	s.mux.HandleFunc("GET /v1/peers", s.authed(s.handlePeers))
-	s.mux.HandleFunc("POST /v1/signal", s.authed(s.handleSignal))
+	s.mux.HandleFunc("POST /v1/signal", s.authed(s.limited("signal", s.handleSignal)))
 	s.mux.HandleFunc("GET /v1/signal", s.authed(s.handleSignalPull))
+	s.mux.HandleFunc("GET /v1/stream", s.authed(s.handleStream))
 	s.mux.HandleFunc("GET /debug/peers", s.handleDebugPeers)
+	if s.relay != nil {
+		s.mux.HandleFunc("GET /v1/relay", s.authed(s.handleRelay))
+	}
+	if s.adminTokenHash != nil {
+		s.registerAdmin()
+	}
 	return s
 }
 
-// ServeHTTP makes Server an http.Handler.
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+// ServeHTTP makes Server an http.Handler. Every request gets one access
+// log line carrying the status and, once known, the caller's node key.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	info := &accessInfo{}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.mux.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)))
+	s.log.Info("request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+		"node", info.node, "remote", r.RemoteAddr, "dur", time.Since(start).Round(time.Millisecond))
+}
+
+// accessInfo collects what handlers learn about a request for its access
+// log line.
+type accessInfo struct {
+	node string // hex node key
+}
+
+type accessInfoKey struct{}
+
+// noteNode records the caller's node key for the access log.
+func noteNode(r *http.Request, key []byte) {
+	if info, ok := r.Context().Value(accessInfoKey{}).(*accessInfo); ok {
+		info.node = hex.EncodeToString(key)
+	}
+}
+
+// statusRecorder remembers the status code written through it. Unwrap lets
+// http.ResponseController reach the real writer for flushes and deadlines.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
 
-// handlerFunc is the shape of a handler that already has the authed peer.
-type handlerFunc func(w http.ResponseWriter, r *http.Request, pub ed25519.PublicKey, body []byte)
+// handlerFunc is the shape of a handler that already has the authed peer
+// and the network it belongs to.
+type handlerFunc func(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, body []byte)
 
 func (s *Server) authed(h handlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pub, body, err := VerifyRequest(r)
 		if err != nil {
+			s.metrics.authFailure(errBadSignature)
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		h(w, r, pub, body)
+		noteNode(r, pub)
+		// Anyone can mint a key that verifies, so the source pays before
+		// anything is remembered for it.
+		if ok, wait := s.limits["signed"].allowIP(r); !ok {
+			s.tooManyRequests(w, "signed", wait)
+			return
+		}
+		nw, p, err := s.networkOf(r.Context(), pub)
+		switch {
+		case err == nil && p.Disabled:
+			s.metrics.authFailure(errNodeDisabled)
+			http.Error(w, errNodeDisabled.Error(), http.StatusForbidden)
+			return
+		case err != nil && !errors.Is(err, ErrUnknownPeer):
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Every handler refuses an unknown key, so there is nothing of
+		// its to replay and its nonce isn't worth a slot.
+		if err == nil && !s.checkFresh(w, pub, r) {
+			return
+		}
+		h(w, r, nw, pub, body)
 	}
 }
 
+// checkFresh runs the replay check and writes the error response if the
+// request fails it: 401 for a replay, 429 while the cache is full.
+func (s *Server) checkFresh(w http.ResponseWriter, pub ed25519.PublicKey, r *http.Request) bool {
+	switch err := s.replay.fresh(pub, r, time.Now()); {
+	case errors.Is(err, errReplayCacheFull):
+		s.tooManyRequests(w, "signed", maxClockSkew/4)
+		return false
+	case err != nil:
+		s.metrics.authFailure(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
+	limits := s.limits["register"]
+	if ok, wait := limits.allowIP(r); !ok {
+		s.tooManyRequests(w, "register", wait)
+		return
+	}
+	body, signed, err := s.registerBody(r)
+	if errors.Is(err, errBadSignature) || errors.Is(err, errReplayed) {
+		s.metrics.authFailure(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, errReplayCacheFull) {
+		s.tooManyRequests(w, "register", maxClockSkew/4)
+		return
+	}
+	if err != nil {
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
 	var req RegisterReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
@@ -69,80 +216,289 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad node_pubkey length", http.StatusBadRequest)
 		return
 	}
-	tunnelIP, err := s.store.Register(r.Context(), Peer{
-		NodeKey:  req.NodePubkey,
-		DiscoKey: req.DiscoPubkey,
-		Name:     req.NodeName,
+	if signed != nil && !signed.Equal(ed25519.PublicKey(req.NodePubkey)) {
+		s.metrics.authFailure(errBadSignature)
+		http.Error(w, "request not signed by node_pubkey", http.StatusUnauthorized)
+		return
+	}
+	noteNode(r, req.NodePubkey)
+	// Only a proven key gets a bucket: keying on an unsigned node_pubkey
+	// would let anyone drain someone else's.
+	if signed != nil {
+		if ok, wait := limits.allowNode(signed); !ok {
+			s.tooManyRequests(w, "register", wait)
+			return
+		}
+	}
+	requested, err := parseRequestedIP(req.RequestedTunnelIP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var (
+		tags  []string
+		keyID string
+		isNew bool
+	)
+	nw, existing, err := s.networkOf(r.Context(), req.NodePubkey)
+	if errors.Is(err, ErrUnknownPeer) {
+		// A node the reaper expired (a laptop asleep past --peer-ttl) was
+		// admitted once already. Until its tombstone is released it
+		// rejoins with the tags it was admitted with, not as a stranger
+		// whose single-use key is long spent, unless that key has been
+		// revoked or has expired since. Then it is a stranger again.
+		admitted, t, id, aerr := s.admittedIn(r.Context(), req.NodePubkey)
+		switch {
+		case aerr == nil:
+			nw, tags, keyID, err = admitted, t, id, nil
+		case errors.Is(aerr, errAdmissionWithdrawn):
+			s.log.Info("rejoin refused", "name", req.NodeName, "err", aerr)
+		case !errors.Is(aerr, ErrUnknownPeer):
+			err = aerr
+		}
+	}
+	switch {
+	case err == nil:
+		// Admitted nodes re-register on every daemon restart; making them
+		// burn a fresh key each time would defeat single-use keys, so they
+		// skip the auth key check. Node keys are public, though, so they
+		// have to prove they hold theirs.
+		if signed == nil {
+			s.metrics.authFailure(errUnsignedReregister)
+			http.Error(w, errUnsignedReregister.Error(), http.StatusUnauthorized)
+			return
+		}
+		if existing.Disabled {
+			s.metrics.authFailure(errNodeDisabled)
+			http.Error(w, errNodeDisabled.Error(), http.StatusForbidden)
+			return
+		}
+		if req.Network != "" && req.Network != nw.name {
+			http.Error(w, "node already registered in network "+nw.name, http.StatusConflict)
+			return
+		}
+	case errors.Is(err, ErrUnknownPeer):
+		if ok, wait := s.limits["new_node"].allowIP(r); !ok {
+			s.tooManyRequests(w, "register", wait)
+			return
+		}
+		var k AuthKey
+		nw, k, err = s.admitNew(r.Context(), req)
+		if errors.Is(err, errUnknownNetwork) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.log.Info("register rejected", "name", req.NodeName, "network", req.Network, "err", err)
+			s.metrics.authFailure(err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		tags, keyID = k.Tags, k.ID
+		isNew = true
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tunnelIP, err := nw.store.Register(r.Context(), Peer{
+		NodeKey:   req.NodePubkey,
+		DiscoKey:  req.DiscoPubkey,
+		Name:      req.NodeName,
+		Tags:      tags,
+		AuthKeyID: keyID,
+		TunnelIP:  requested,
 	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	_, etag, _ := s.store.Peers(r.Context())
-	writeJSON(w, http.StatusOK, RegisterResp{TunnelIP: tunnelIP.String() + "/24", Etag: etag})
+	s.metrics.registered(nw.name, isNew)
+	_, etag, _ := nw.store.Peers(r.Context())
+	writeJSON(w, http.StatusOK, RegisterResp{
+		TunnelIP:    netip.PrefixFrom(tunnelIP, nw.store.Pool().Bits()).String(),
+		Etag:        etag,
+		Network:     nw.name,
+		STUNServers: s.stunServersFor(r),
+	})
+}
+
+// registerBody reads a register request's body. The signature headers are
+// optional, as a node's first registration is vouched for by its auth key;
+// if present they are checked, and the key that signed is returned.
+func (s *Server) registerBody(r *http.Request) ([]byte, ed25519.PublicKey, error) {
+	if r.Header.Get(headerAuth) == "" {
+		body, err := io.ReadAll(r.Body)
+		return body, nil, err
+	}
+	pub, body, err := VerifyRequest(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errBadSignature, err)
+	}
+	if err := s.replay.fresh(pub, r, time.Now()); err != nil {
+		return nil, nil, err
+	}
+	return body, pub, nil
 }
 
-func (s *Server) handleEndpoints(w http.ResponseWriter, r *http.Request, pub ed25519.PublicKey, body []byte) {
+// parseRequestedIP accepts a bare address or the CIDR form the coordinator
+// hands out; "" means no preference.
+func parseRequestedIP(s string) (netip.Addr, error) {
+	if s == "" {
+		return netip.Addr{}, nil
+	}
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Addr(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("bad requested_tunnel_ip %q", s)
+	}
+	return ip, nil
+}
+
+func (s *Server) handleEndpoints(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, body []byte) {
 	var req EndpointsReq
 	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	if err := s.store.SetEndpoints(r.Context(), pub, req.Endpoints); err != nil {
+	if !req.NAT.valid() {
+		http.Error(w, "unknown nat type", http.StatusBadRequest)
+		return
+	}
+	if req.NAT != "" {
+		if err := nw.store.SetNAT(r.Context(), pub, req.NAT); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	if err := nw.store.SetEndpoints(r.Context(), pub, req.Endpoints); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
 }
 
-func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request, _ ed25519.PublicKey, _ []byte) {
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, _ []byte) {
+	_ = nw.store.Touch(r.Context(), pub)
 	since := r.URL.Query().Get("since")
 	if since != "" {
 		ctx, cancel := context.WithTimeout(r.Context(), longPollTimeout)
 		defer cancel()
-		_ = s.store.WaitForPeersChange(ctx, since)
+		done := s.metrics.waiting("peers")
+		_ = nw.store.WaitForPeersChange(ctx, since)
+		done()
+	}
+	peers, etag, err := s.viewOf(r.Context(), nw, pub)
+	if errors.Is(err, ErrUnknownPeer) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
-	peers, etag, err := s.store.Peers(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, PeersResp{Etag: etag, Peers: peers})
+	issued := time.Now()
+	writeJSON(w, http.StatusOK, PeersResp{Etag: etag, Peers: peers, IssuedAt: issued,
+		Signature: s.signNetmap(pub, etag, issued, peers)})
+}
+
+// enabledPeers drops disabled peers so that everyone else tears down their
+// tunnels to them.
+func enabledPeers(peers []Peer) []Peer {
+	out := peers[:0]
+	for _, p := range peers {
+		if !p.Disabled {
+			out = append(out, p)
+		}
+	}
+	return out
 }
 
-func (s *Server) handleSignal(w http.ResponseWriter, r *http.Request, pub ed25519.PublicKey, body []byte) {
+func (s *Server) handleSignal(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, body []byte) {
 	var req SignalReq
 	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
+	// authed skips the replay check for unknown keys, so they mustn't get
+	// anything queued.
+	if _, err := nw.store.Lookup(r.Context(), pub); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !s.signalAllowed(r.Context(), nw, pub, req.To) {
+		s.metrics.authFailure(errSignalForbidden)
+		http.Error(w, errSignalForbidden.Error(), http.StatusForbidden)
+		return
+	}
 	env := Envelope{Sealed: req.Sealed, Enqueue: time.Now()}
-	if err := s.store.EnqueueSignal(r.Context(), req.To, env); err != nil {
+	if err := nw.store.EnqueueSignal(r.Context(), req.To, env); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]bool{"queued": true})
 }
 
-func (s *Server) handleSignalPull(w http.ResponseWriter, r *http.Request, pub ed25519.PublicKey, _ []byte) {
-	to, err := discoKeyForNodeKey(r.Context(), s.store, pub)
+func (s *Server) handleSignalPull(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, _ []byte) {
+	to, err := discoKeyForNodeKey(r.Context(), nw.store, pub)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	envs, _ := s.store.PopSignals(r.Context(), to)
+	_ = nw.store.Touch(r.Context(), pub)
+	envs, _ := nw.store.PopSignals(r.Context(), to)
 	if len(envs) == 0 {
 		ctx, cancel := context.WithTimeout(r.Context(), longPollTimeout)
 		defer cancel()
-		if werr := s.store.WaitForSignal(ctx, to); werr == nil {
-			envs, _ = s.store.PopSignals(r.Context(), to)
+		done := s.metrics.waiting("signal")
+		werr := nw.store.WaitForSignal(ctx, to)
+		done()
+		if werr == nil {
+			envs, _ = nw.store.PopSignals(r.Context(), to)
 		}
 	}
 	writeJSON(w, http.StatusOK, SignalsResp{Envelopes: envs})
 }
 
+// signalAllowed applies the ACL policy to a signal from the node with key
+// from to the node with disco key to. Under a policy, unknown recipients
+// are refused too, so probing can't reveal who's registered. Without one,
+// envelopes are queued in the sender's network, which is all the isolation
+// networks need: a recipient elsewhere never pulls from that queue.
+func (s *Server) signalAllowed(ctx context.Context, nw *network, from ed25519.PublicKey, to [32]byte) bool {
+	policy := s.policy.Load()
+	if policy == nil {
+		return true
+	}
+	sender, err := nw.store.Lookup(ctx, from)
+	if err != nil {
+		return false
+	}
+	peers, _, err := nw.store.Peers(ctx)
+	if err != nil {
+		return false
+	}
+	for _, p := range peers {
+		if p.DiscoKey == to {
+			return !p.Disabled && policy.Allowed(sender, p)
+		}
+	}
+	return false
+}
+
 func (s *Server) handleDebugPeers(w http.ResponseWriter, r *http.Request) {
-	peers, etag, err := s.store.Peers(r.Context())
+	// An unauthenticated dump of everyone would defeat the policy.
+	if s.policy.Load() != nil {
+		http.Error(w, "disabled while an ACL policy is loaded; use the admin API", http.StatusForbidden)
+		return
+	}
+	nw, err := s.network(r.URL.Query().Get("network"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	peers, etag, err := nw.store.Peers(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -150,19 +506,26 @@ func (s *Server) handleDebugPeers(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, PeersResp{Etag: etag, Peers: peers})
 }
 
+var (
+	errNodeDisabled    = errors.New("node disabled by admin")
+	errSignalForbidden = errors.New("recipient not allowed by ACL policy")
+
+	// errUnsignedReregister refuses a register for a known node key that
+	// isn't signed with it.
+	errUnsignedReregister = errors.New("re-registration must be signed with the node key")
+)
+
 // discoKeyForNodeKey looks up the caller's disco pubkey — we need it to find
 // the caller's signal queue, but the HTTP auth uses the node pubkey.
 func discoKeyForNodeKey(ctx context.Context, store Store, nodeKey ed25519.PublicKey) ([32]byte, error) {
-	peers, _, err := store.Peers(ctx)
+	p, err := store.Lookup(ctx, nodeKey)
+	if errors.Is(err, ErrUnknownPeer) {
+		return [32]byte{}, errors.New("unregistered node")
+	}
 	if err != nil {
 		return [32]byte{}, err
 	}
-	for _, p := range peers {
-		if bytes.Equal(p.NodeKey, nodeKey) {
-			return p.DiscoKey, nil
-		}
-	}
-	return [32]byte{}, errors.New("unregistered node")
+	return p.DiscoKey, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, body any) {
@@ -54,8 +54,9 @@ func TestServer_Endpoints_BadJSON(t *testing.T) {
 	// Manually craft a signed request with invalid JSON body.
 	bad := []byte("not json")
 	req, _ := http.NewRequest("POST", srv.URL+"/v1/endpoints", bytes.NewReader(bad))
-	ts, nodeB64, auth := SignRequest(a.nk.Priv, a.nk.Pub, "POST", "/v1/endpoints", bad)
+	ts, nonce, nodeB64, auth := SignRequest(a.nk.Priv, a.nk.Pub, "POST", "/v1/endpoints", bad)
 	req.Header.Set("X-Gretun-Timestamp", ts)
+	req.Header.Set("X-Gretun-Nonce", nonce)
 	req.Header.Set("X-Gretun-Node", nodeB64)
 	req.Header.Set("Authorization", auth)
 	resp, err := http.DefaultClient.Do(req)
@@ -84,6 +85,46 @@ func TestServer_Endpoints_UnregisteredPeer(t *testing.T) {
 	}
 }
 
+func TestServer_Endpoints_NAT(t *testing.T) {
+	srv := httptest.NewServer(NewServer(newTestStore(t)))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+	b := newTestClient(t, srv.URL)
+	b.register(t)
+
+	resp := a.do(t, "POST", "/v1/endpoints", EndpointsReq{NAT: "full_cone"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown nat type: want 400, got %d", resp.StatusCode)
+	}
+	resp = a.do(t, "POST", "/v1/endpoints", EndpointsReq{NAT: NATSymmetric})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	// Omitting it leaves the last report alone.
+	resp = a.do(t, "POST", "/v1/endpoints", EndpointsReq{})
+	resp.Body.Close()
+
+	resp = b.do(t, "GET", "/v1/peers", nil)
+	defer resp.Body.Close()
+	var out PeersResp
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	var nat NATType = "missing"
+	for _, p := range out.Peers {
+		if bytes.Equal(p.NodeKey, a.nk.Pub) {
+			nat = p.NAT
+		}
+	}
+	if nat != NATSymmetric {
+		t.Errorf("a's nat = %q, want symmetric", nat)
+	}
+}
+
 func TestServer_Signal_BadJSON(t *testing.T) {
 	srv := httptest.NewServer(NewServer(newTestStore(t)))
 	defer srv.Close()
@@ -93,8 +134,9 @@ func TestServer_Signal_BadJSON(t *testing.T) {
 
 	bad := []byte("not json")
 	req, _ := http.NewRequest("POST", srv.URL+"/v1/signal", bytes.NewReader(bad))
-	ts, nodeB64, auth := SignRequest(a.nk.Priv, a.nk.Pub, "POST", "/v1/signal", bad)
+	ts, nonce, nodeB64, auth := SignRequest(a.nk.Priv, a.nk.Pub, "POST", "/v1/signal", bad)
 	req.Header.Set("X-Gretun-Timestamp", ts)
+	req.Header.Set("X-Gretun-Nonce", nonce)
 	req.Header.Set("X-Gretun-Node", nodeB64)
 	req.Header.Set("Authorization", auth)
 	resp, err := http.DefaultClient.Do(req)
@@ -48,12 +48,11 @@ func (c *testClient) do(t *testing.T, method, path string, body any) *http.Respo
 		}
 	}
 	req, _ := http.NewRequest(method, c.base+path, bytes.NewReader(buf))
-	if path != "/v1/register" {
-		ts, nodeB64, auth := SignRequest(c.nk.Priv, c.nk.Pub, method, path, buf)
-		req.Header.Set("X-Gretun-Timestamp", ts)
-		req.Header.Set("X-Gretun-Node", nodeB64)
-		req.Header.Set("Authorization", auth)
-	}
+	ts, nonce, nodeB64, auth := SignRequest(c.nk.Priv, c.nk.Pub, method, path, buf)
+	req.Header.Set("X-Gretun-Timestamp", ts)
+	req.Header.Set("X-Gretun-Nonce", nonce)
+	req.Header.Set("X-Gretun-Node", nodeB64)
+	req.Header.Set("Authorization", auth)
 	resp, err := c.http.Do(req)
 	if err != nil {
 		t.Fatalf("%s %s: %v", method, path, err)
@@ -165,8 +164,9 @@ func TestServer_RejectsBadSignature(t *testing.T) {
 	forged, _ := disco.GenerateNodeKey()
 	buf, _ := json.Marshal(EndpointsReq{Endpoints: []Endpoint{{Addr: netip.MustParseAddrPort("1.2.3.4:5"), Source: SourceSTUN}}})
 	req, _ := http.NewRequest("POST", srv.URL+"/v1/endpoints", bytes.NewReader(buf))
-	ts, _, auth := SignRequest(forged.Priv, forged.Pub, "POST", "/v1/endpoints", buf)
+	ts, nonce, _, auth := SignRequest(forged.Priv, forged.Pub, "POST", "/v1/endpoints", buf)
 	req.Header.Set("X-Gretun-Timestamp", ts)
+	req.Header.Set("X-Gretun-Nonce", nonce)
 	// Claim to be `a` by using a's pub key but the signature is by `forged`.
 	req.Header.Set("X-Gretun-Node", a.nk.B64())
 	req.Header.Set("Authorization", auth)
@@ -193,9 +193,10 @@ func TestServer_RejectsStaleTimestamp(t *testing.T) {
 	req, _ := http.NewRequest("GET", srv.URL+"/v1/peers", nil)
 	// Stamp the request 10 minutes in the past.
 	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
-	digest := signingMaterial(stale, "GET", "/v1/peers", buf)
+	digest := signingMaterial(stale, "n", "GET", "/v1/peers", buf)
 	sig := ed25519.Sign(a.nk.Priv, digest)
 	req.Header.Set("X-Gretun-Timestamp", stale)
+	req.Header.Set("X-Gretun-Nonce", "n")
 	req.Header.Set("X-Gretun-Node", a.nk.B64())
 	req.Header.Set("Authorization", authScheme+" "+base64.StdEncoding.EncodeToString(sig))
 
@@ -241,3 +242,29 @@ func TestServer_PeersLongPollReturnsOnChange(t *testing.T) {
 	}
 }
 
+
+func TestServer_ReregisterNeedsNodeKey(t *testing.T) {
+	store := newTestStore(t)
+	srv := httptest.NewServer(NewServer(store))
+	defer srv.Close()
+
+	a := newTestClient(t, srv.URL)
+	a.register(t)
+
+	// Node keys are public; knowing one mustn't be enough to take it over.
+	mallory := newTestClient(t, srv.URL)
+	hijack := RegisterReq{NodePubkey: a.nk.Pub, DiscoPubkey: mallory.dk.Pub, NodeName: "mallory"}
+	resp := postRegister(t, srv.URL, hijack)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned re-register: want 401, got %d", resp.StatusCode)
+	}
+	resp = mallory.do(t, "POST", "/v1/register", hijack)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("re-register signed by another key: want 401, got %d", resp.StatusCode)
+	}
+	if p, _ := store.Lookup(context.Background(), a.nk.Pub); p.DiscoKey != a.dk.Pub || p.Name != "test" {
+		t.Errorf("peer changed by a failed takeover: %+v", p)
+	}
+}
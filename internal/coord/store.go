@@ -7,8 +7,10 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/netip"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,16 +18,86 @@ import (
 // Store is the coordinator's state interface. Implementations must be safe
 // for concurrent use.
 type Store interface {
+	// Pool is the CIDR tunnel IPs are allocated from.
+	Pool() netip.Prefix
 	Register(ctx context.Context, p Peer) (netip.Addr, error)
 	SetEndpoints(ctx context.Context, nodeKey ed25519.PublicKey, eps []Endpoint) error
+	// SetNAT records a peer's reported NAT type. It bumps the etag only if
+	// the type changed.
+	SetNAT(ctx context.Context, nodeKey ed25519.PublicKey, nat NATType) error
 	Peers(ctx context.Context) ([]Peer, string, error)
 	WaitForPeersChange(ctx context.Context, since string) error
+	// Invalidate bumps the etag without changing any peer, so long-polls
+	// return and callers re-fetch a view that depends on something outside
+	// the store (e.g. a reloaded ACL policy).
+	Invalidate(ctx context.Context) error
+
+	// Lookup returns the registered peer for nodeKey, or ErrUnknownPeer.
+	Lookup(ctx context.Context, nodeKey ed25519.PublicKey) (Peer, error)
+	// Admitted returns the tags nodeKey was admitted with and the ID of
+	// the auth key that admitted it, even after it expired, or
+	// ErrUnknownPeer if it never registered, was deleted, or expired longer
+	// ago than the reaper's grace period.
+	Admitted(ctx context.Context, nodeKey ed25519.PublicKey) (tags []string, keyID string, err error)
+	// Departed lists the expired nodes that are still admitted, with the
+	// name and tags they had and the tunnel IP held for them.
+	Departed(ctx context.Context) ([]Peer, error)
+	// Touch marks a peer as alive without changing the etag.
+	Touch(ctx context.Context, nodeKey ed25519.PublicKey) error
+	// Expire evicts peers whose UpdatedAt is before cutoff and releases
+	// tunnel IPs that have been held for evicted peers longer than grace.
+	Expire(ctx context.Context, cutoff time.Time, grace time.Duration) ([]Peer, error)
 
 	EnqueueSignal(ctx context.Context, to [32]byte, env Envelope) error
 	PopSignals(ctx context.Context, to [32]byte) ([]Envelope, error)
 	WaitForSignal(ctx context.Context, to [32]byte) error
+
+	AddAuthKey(ctx context.Context, k AuthKey) error
+	// UseAuthKey validates secret and, if it is still usable, records one
+	// use of it. Errors are the ErrAuthKey* sentinels.
+	UseAuthKey(ctx context.Context, secret string) (AuthKey, error)
+	AuthKeys(ctx context.Context) ([]AuthKey, error)
+	// RevokeAuthKey permanently disables the key with the given ID.
+	RevokeAuthKey(ctx context.Context, id string) error
+
+	// UpdatePeer applies an admin change to a registered peer: every field
+	// is checked first, then all of them land together or none do. It
+	// bumps the etag.
+	UpdatePeer(ctx context.Context, nodeKey ed25519.PublicKey, u PeerUpdate) error
+	// Reserve pins a node key to a tunnel IP ahead of (or regardless of)
+	// its registration.
+	Reserve(ctx context.Context, nodeKey ed25519.PublicKey, ip netip.Addr) error
+	// DeletePeer forgets a peer outright: its tunnel IP goes straight back
+	// to the pool, with no grace period. For an expired node it withdraws
+	// the admission and frees the address still held for it.
+	DeletePeer(ctx context.Context, nodeKey ed25519.PublicKey) error
+
+	// Stats summarises the store for metrics.
+	Stats(ctx context.Context) (StoreStats, error)
 }
 
+// StoreStats is a point-in-time summary of a store. The Dropped counters
+// are cumulative since the store was opened.
+type StoreStats struct {
+	Peers         int
+	SignalQueues  int // recipients with envelopes waiting
+	QueuedSignals int // envelopes waiting, across all recipients
+	MaxQueueDepth int // envelopes waiting for the busiest recipient
+	// DroppedOverflow counts envelopes evicted because their recipient's
+	// queue was already at maxQueueDepth.
+	DroppedOverflow uint64
+	// DroppedExpired counts envelopes PopSignals threw away as too old.
+	DroppedExpired uint64
+}
+
+var (
+	// ErrUnknownPeer is returned for node keys that aren't registered.
+	ErrUnknownPeer = errors.New("unknown peer")
+	// ErrTunnelIPTaken is returned when assigning an address that another
+	// peer holds (or is being held for an expired peer).
+	ErrTunnelIPTaken = errors.New("tunnel IP already assigned")
+)
+
 // MemStore is the in-memory default implementation.
 type MemStore struct {
 	pool netip.Prefix
@@ -35,6 +107,18 @@ type MemStore struct {
 	byTunnel    map[string]string    // tunnel_ip → base64(NodeKey)
 	peersEtag   string
 	peersBroad  chan struct{}
+	// tombstones holds the tunnel IP of recently expired peers so a node
+	// that comes back within the grace period gets its old address.
+	tombstones map[string]tombstone // key: base64(NodeKey)
+	// admitted remembers every node key that registered, its tags and its
+	// auth key, until an admin deletes it or its tombstone is released:
+	// expiry alone doesn't undo admission, but it doesn't last forever.
+	admitted map[string]admission // key: base64(NodeKey)
+	authKeys map[string]*AuthKey // key: AuthKey.Hash
+	// reservations pin a node to an address; loaded from config at
+	// startup rather than persisted.
+	reservations map[string]netip.Addr // key: base64(NodeKey)
+	reservedIPs  map[netip.Addr]string // reverse of reservations
 
 	signalMu    sync.Mutex
 	signals     map[[32]byte][]Envelope
@@ -44,15 +128,31 @@ type MemStore struct {
 	maxQueueDepth int
 	// maxAge drops envelopes older than this on pop.
 	maxAge time.Duration
+	// droppedOverflow and droppedExpired count envelopes lost to the two
+	// limits above; guarded by signalMu.
+	droppedOverflow uint64
+	droppedExpired  uint64
+	// signalGen changes whenever the queues do, so FileStore's mirror
+	// can skip writes when nothing happened; guarded by signalMu.
+	signalGen uint64
+
+	// persist, if set, is called with the registry snapshot after every
+	// change to the nodeKey → tunnel_ip mapping. FileStore installs it.
+	persist func(registrySnapshot) error
 }
 
 // NewMemStore constructs an empty in-memory store; pool is the CIDR from
-// which tunnel IPs are allocated (must be IPv4).
+// which tunnel IPs are allocated, IPv4 or IPv6.
 func NewMemStore(pool netip.Prefix) *MemStore {
 	return &MemStore{
 		pool:          pool,
 		peers:         make(map[string]*Peer),
 		byTunnel:      make(map[string]string),
+		tombstones:    make(map[string]tombstone),
+		admitted:      make(map[string]admission),
+		authKeys:      make(map[string]*AuthKey),
+		reservations:  make(map[string]netip.Addr),
+		reservedIPs:   make(map[netip.Addr]string),
 		peersEtag:     newEtag(),
 		peersBroad:    make(chan struct{}),
 		signals:       make(map[[32]byte][]Envelope),
@@ -62,13 +162,19 @@ func NewMemStore(pool netip.Prefix) *MemStore {
 	}
 }
 
+// Pool returns the allocation CIDR.
+func (s *MemStore) Pool() netip.Prefix { return s.pool }
+
 func newEtag() string {
 	h := sha256.Sum256([]byte(time.Now().UTC().Format(time.RFC3339Nano)))
 	return hex.EncodeToString(h[:8])
 }
 
 // Register inserts or updates a peer and assigns a tunnel IP. The mapping
-// (nodePubkey → tunnel_ip) is stable across reconnects.
+// (nodePubkey → tunnel_ip) is stable across reconnects. A valid p.TunnelIP
+// is taken as a request: it is granted if it is a free host address of the
+// pool, otherwise ignored, and always ignored once an admin has moved the
+// peer. A reservation for the node beats both.
 func (s *MemStore) Register(ctx context.Context, p Peer) (netip.Addr, error) {
 	if err := ctx.Err(); err != nil {
 		return netip.Addr{}, err
@@ -82,25 +188,57 @@ func (s *MemStore) Register(ctx context.Context, p Peer) (netip.Addr, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if existing, ok := s.peers[keyB64]; ok {
+	requested := p.TunnelIP
+	existing, ok := s.peers[keyB64]
+	if ok && existing.TunnelIPLocked {
+		requested = netip.Addr{}
+	}
+	want := s.preferredIPLocked(keyB64, requested)
+
+	if ok {
+		// Tags are fixed by the auth key used on first registration.
 		existing.DiscoKey = p.DiscoKey
-		existing.Name = p.Name
+		if !existing.NameLocked {
+			existing.Name = p.Name
+		}
+		if want.IsValid() && want != existing.TunnelIP {
+			s.moveLocked(keyB64, existing, want)
+		}
 		existing.UpdatedAt = time.Now().UTC()
 		s.bumpEtagLocked()
+		if err := s.persistLocked(); err != nil {
+			return netip.Addr{}, err
+		}
 		return existing.TunnelIP, nil
 	}
 
-	ip, err := s.allocateIPLocked()
-	if err != nil {
-		return netip.Addr{}, err
+	ip := want
+	if tomb, ok := s.tombstones[keyB64]; ok {
+		if !ip.IsValid() {
+			ip = tomb.TunnelIP
+		} else if s.byTunnel[tomb.TunnelIP.String()] == keyB64 {
+			delete(s.byTunnel, tomb.TunnelIP.String())
+		}
+		delete(s.tombstones, keyB64)
+	}
+	if !ip.IsValid() {
+		var err error
+		if ip, err = s.allocateIPLocked(); err != nil {
+			return netip.Addr{}, err
+		}
 	}
+	s.releaseTombstoneAtLocked(ip, keyB64)
 
 	p.TunnelIP = ip
 	p.UpdatedAt = time.Now().UTC()
 	peer := p
 	s.peers[keyB64] = &peer
 	s.byTunnel[ip.String()] = keyB64
+	s.admitted[keyB64] = admission{NodeKey: keyB64, Name: p.Name, Tags: p.Tags, AuthKeyID: p.AuthKeyID}
 	s.bumpEtagLocked()
+	if err := s.persistLocked(); err != nil {
+		return netip.Addr{}, err
+	}
 	return ip, nil
 }
 
@@ -109,7 +247,8 @@ func (s *MemStore) allocateIPLocked() (netip.Addr, error) {
 	// Skip network address (.0); assign sequentially.
 	next := a.Next()
 	for s.pool.Contains(next) {
-		if _, taken := s.byTunnel[next.String()]; !taken {
+		_, taken := s.byTunnel[next.String()]
+		if _, reserved := s.reservedIPs[next]; !taken && !reserved {
 			// Also skip what looks like a broadcast address: last address
 			// in the prefix. Naive check: addr+1 must stay in prefix.
 			if !s.pool.Contains(next.Next()) {
@@ -122,6 +261,92 @@ func (s *MemStore) allocateIPLocked() (netip.Addr, error) {
 	return netip.Addr{}, errors.New("tunnel IP pool exhausted")
 }
 
+// preferredIPLocked returns the address key should end up on if it differs
+// from the default (keep current / tombstone / next free): its reservation,
+// else requested if that is free. The zero Addr means "no preference".
+func (s *MemStore) preferredIPLocked(key string, requested netip.Addr) netip.Addr {
+	if r, ok := s.reservations[key]; ok {
+		if s.freeForLocked(r, key) {
+			return r
+		}
+		slog.Warn("reserved tunnel IP held by another peer", "tunnel_ip", r, "holder", s.byTunnel[r.String()])
+	}
+	if requested.IsValid() {
+		if s.assignable(requested) && s.freeForLocked(requested, key) {
+			return requested
+		}
+		slog.Info("requested tunnel IP not available", "tunnel_ip", requested, "pool", s.pool)
+	}
+	return netip.Addr{}
+}
+
+// freeForLocked reports whether key may take ip: no live peer other than
+// key holds it, and it isn't reserved for anyone else. A tombstone loses to
+// a reservation.
+func (s *MemStore) freeForLocked(ip netip.Addr, key string) bool {
+	if owner, ok := s.reservedIPs[ip]; ok && owner != key {
+		return false
+	}
+	holder, taken := s.byTunnel[ip.String()]
+	if !taken || holder == key {
+		return true
+	}
+	if _, live := s.peers[holder]; live {
+		return false
+	}
+	return s.reservedIPs[ip] == key
+}
+
+// releaseTombstoneAtLocked drops a tombstone of some other node that sat on
+// ip; only reachable when a reservation overrode it.
+func (s *MemStore) releaseTombstoneAtLocked(ip netip.Addr, key string) {
+	holder, ok := s.byTunnel[ip.String()]
+	if !ok || holder == key {
+		return
+	}
+	if _, live := s.peers[holder]; !live {
+		delete(s.tombstones, holder)
+	}
+}
+
+// moveLocked re-homes a live peer onto ip, which must be free for it.
+func (s *MemStore) moveLocked(key string, p *Peer, ip netip.Addr) {
+	s.releaseTombstoneAtLocked(ip, key)
+	if s.byTunnel[p.TunnelIP.String()] == key {
+		delete(s.byTunnel, p.TunnelIP.String())
+	}
+	s.byTunnel[ip.String()] = key
+	p.TunnelIP = ip
+}
+
+// Reserve pins nodeKey to ip. The address is never handed to anyone else,
+// and the node is moved onto it at its next registration. If another live
+// peer holds ip right now, it keeps it until it is deleted or expires; a
+// warning is logged on every register of the reserved node meanwhile.
+func (s *MemStore) Reserve(ctx context.Context, nodeKey ed25519.PublicKey, ip netip.Addr) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(nodeKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid node key length %d", len(nodeKey))
+	}
+	if !s.assignable(ip) {
+		return fmt.Errorf("%s is not an assignable address in %s", ip, s.pool)
+	}
+	key := base64Encode(nodeKey)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, ok := s.reservedIPs[ip]; ok && owner != key {
+		return fmt.Errorf("%s: %w", ip, ErrTunnelIPTaken)
+	}
+	if old, ok := s.reservations[key]; ok {
+		delete(s.reservedIPs, old)
+	}
+	s.reservations[key] = ip
+	s.reservedIPs[ip] = key
+	return nil
+}
+
 // SetEndpoints replaces the endpoints for a registered peer.
 func (s *MemStore) SetEndpoints(ctx context.Context, nodeKey ed25519.PublicKey, eps []Endpoint) error {
 	if err := ctx.Err(); err != nil {
@@ -134,7 +359,7 @@ func (s *MemStore) SetEndpoints(ctx context.Context, nodeKey ed25519.PublicKey,
 
 	peer, ok := s.peers[keyB64]
 	if !ok {
-		return errors.New("unknown peer")
+		return ErrUnknownPeer
 	}
 	peer.Endpoints = append(peer.Endpoints[:0], eps...)
 	peer.UpdatedAt = time.Now().UTC()
@@ -142,6 +367,27 @@ func (s *MemStore) SetEndpoints(ctx context.Context, nodeKey ed25519.PublicKey,
 	return nil
 }
 
+// SetNAT records the NAT type a registered peer reported.
+func (s *MemStore) SetNAT(ctx context.Context, nodeKey ed25519.PublicKey, nat NATType) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keyB64 := base64Encode(nodeKey)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, ok := s.peers[keyB64]
+	if !ok {
+		return ErrUnknownPeer
+	}
+	if peer.NAT != nat {
+		peer.NAT = nat
+		s.bumpEtagLocked()
+	}
+	return nil
+}
+
 // Peers returns all registered peers and the current etag.
 func (s *MemStore) Peers(ctx context.Context) ([]Peer, string, error) {
 	if err := ctx.Err(); err != nil {
@@ -159,6 +405,131 @@ func (s *MemStore) Peers(ctx context.Context) ([]Peer, string, error) {
 	return out, s.peersEtag, nil
 }
 
+// Touch refreshes a peer's UpdatedAt. It deliberately leaves the etag (and
+// the registry file) alone: liveness alone is not a change peers care about.
+func (s *MemStore) Touch(ctx context.Context, nodeKey ed25519.PublicKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peer, ok := s.peers[base64Encode(nodeKey)]
+	if !ok {
+		return ErrUnknownPeer
+	}
+	peer.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// Lookup returns a copy of the peer registered under nodeKey.
+func (s *MemStore) Lookup(ctx context.Context, nodeKey ed25519.PublicKey) (Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return Peer{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peer, ok := s.peers[base64Encode(nodeKey)]
+	if !ok {
+		return Peer{}, ErrUnknownPeer
+	}
+	return *peer, nil
+}
+
+// Admitted returns the tags and auth key ID of a node key that registered
+// and is still live or tombstoned.
+func (s *MemStore) Admitted(ctx context.Context, nodeKey ed25519.PublicKey) ([]string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.admitted[base64Encode(nodeKey)]
+	if !ok {
+		return nil, "", ErrUnknownPeer
+	}
+	return append([]string(nil), a.Tags...), a.AuthKeyID, nil
+}
+
+// Departed lists admitted nodes that are no longer registered, by name.
+func (s *MemStore) Departed(ctx context.Context) ([]Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Peer
+	for key, a := range s.admitted {
+		if _, live := s.peers[key]; live {
+			continue
+		}
+		nodeKey, err := hex.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		out = append(out, Peer{
+			NodeKey:   nodeKey,
+			Name:      a.Name,
+			Tags:      append([]string(nil), a.Tags...),
+			AuthKeyID: a.AuthKeyID,
+			TunnelIP:  s.tombstones[key].TunnelIP,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Expire removes every peer last seen before cutoff. Their tunnel IP stays
+// reserved for grace, then goes back to the pool. Evicting anyone bumps the
+// etag so daemons drop the matching peer state.
+func (s *MemStore) Expire(ctx context.Context, cutoff time.Time, grace time.Duration) ([]Peer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evicted []Peer
+	for key, p := range s.peers {
+		// Disabled peers can't touch, so expiring them would quietly let
+		// them re-register as new nodes. They stay until an admin acts.
+		if p.Disabled || !p.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		evicted = append(evicted, *p)
+		delete(s.peers, key)
+		// Keep the name it expired under, renames included, so admins
+		// can still find it.
+		s.admitted[key] = admission{NodeKey: key, Name: p.Name, Tags: p.Tags, AuthKeyID: p.AuthKeyID}
+		s.tombstones[key] = tombstone{NodeKey: key, TunnelIP: p.TunnelIP, ExpiredAt: now}
+		s.dropSignals(p.DiscoKey)
+	}
+
+	released := false
+	for key, tomb := range s.tombstones {
+		if now.Sub(tomb.ExpiredAt) < grace {
+			continue
+		}
+		if s.byTunnel[tomb.TunnelIP.String()] == key {
+			delete(s.byTunnel, tomb.TunnelIP.String())
+		}
+		delete(s.tombstones, key)
+		delete(s.admitted, key)
+		released = true
+	}
+
+	if len(evicted) > 0 {
+		s.bumpEtagLocked()
+	}
+	if len(evicted) > 0 || released {
+		if err := s.persistLocked(); err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
 // WaitForPeersChange blocks until the etag differs from `since`, or ctx
 // fires. Returns nil on change, ctx.Err() on cancellation.
 func (s *MemStore) WaitForPeersChange(ctx context.Context, since string) error {
@@ -178,6 +549,105 @@ func (s *MemStore) WaitForPeersChange(ctx context.Context, since string) error {
 	}
 }
 
+// Invalidate bumps the etag.
+func (s *MemStore) Invalidate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.bumpEtagLocked()
+	s.mu.Unlock()
+	return nil
+}
+
+// persistLocked hands the current registry to s.persist. Endpoints ride
+// along but are not the point: they're re-posted every refresh anyway, so
+// SetEndpoints deliberately doesn't trigger a write.
+func (s *MemStore) persistLocked() error {
+	if s.persist == nil {
+		return nil
+	}
+	if err := s.persist(s.snapshotLocked()); err != nil {
+		return fmt.Errorf("persist registry: %w", err)
+	}
+	return nil
+}
+
+func (s *MemStore) snapshotLocked() registrySnapshot {
+	snap := registrySnapshot{Version: registrySnapshotVersion, Peers: make([]Peer, 0, len(s.peers))}
+	for _, p := range s.peers {
+		cp := *p
+		cp.Endpoints = append([]Endpoint(nil), p.Endpoints...)
+		snap.Peers = append(snap.Peers, cp)
+	}
+	sort.Slice(snap.Peers, func(i, j int) bool {
+		return snap.Peers[i].TunnelIP.Less(snap.Peers[j].TunnelIP)
+	})
+	for _, t := range s.tombstones {
+		snap.Tombstones = append(snap.Tombstones, t)
+	}
+	sort.Slice(snap.Tombstones, func(i, j int) bool {
+		return snap.Tombstones[i].TunnelIP.Less(snap.Tombstones[j].TunnelIP)
+	})
+	// Live peers carry their own tags; only expired ones need a record.
+	for key, a := range s.admitted {
+		if _, live := s.peers[key]; !live {
+			snap.Admitted = append(snap.Admitted, a)
+		}
+	}
+	sort.Slice(snap.Admitted, func(i, j int) bool {
+		return snap.Admitted[i].NodeKey < snap.Admitted[j].NodeKey
+	})
+	snap.AuthKeys = s.authKeysLocked()
+	return snap
+}
+
+// restore loads a snapshot into an empty store. Peers whose tunnel IP no
+// longer fits the pool are kept (their address is still theirs) but logged.
+// Restored peers start a fresh TTL: the coordinator being down is not
+// evidence that they are.
+func (s *MemStore) restore(snap registrySnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	for i := range snap.Peers {
+		p := snap.Peers[i]
+		if len(p.NodeKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("snapshot peer %d: invalid node key length %d", i, len(p.NodeKey))
+		}
+		keyB64 := base64Encode(p.NodeKey)
+		if other, taken := s.byTunnel[p.TunnelIP.String()]; taken && other != keyB64 {
+			return fmt.Errorf("snapshot peer %d: tunnel IP %s assigned twice", i, p.TunnelIP)
+		}
+		if !s.pool.Contains(p.TunnelIP) {
+			slog.Warn("restored peer outside pool", "name", p.Name, "tunnel_ip", p.TunnelIP, "pool", s.pool)
+		}
+		p.UpdatedAt = now
+		s.peers[keyB64] = &p
+		s.byTunnel[p.TunnelIP.String()] = keyB64
+		s.admitted[keyB64] = admission{NodeKey: keyB64, Name: p.Name, Tags: p.Tags, AuthKeyID: p.AuthKeyID}
+	}
+	for _, t := range snap.Tombstones {
+		if _, taken := s.byTunnel[t.TunnelIP.String()]; taken {
+			continue
+		}
+		s.tombstones[t.NodeKey] = t
+		s.byTunnel[t.TunnelIP.String()] = t.NodeKey
+	}
+	// An admission outlives its peer only as long as the tombstone does.
+	for _, a := range snap.Admitted {
+		if _, held := s.tombstones[a.NodeKey]; held {
+			s.admitted[a.NodeKey] = a
+		}
+	}
+	for i := range snap.AuthKeys {
+		k := snap.AuthKeys[i]
+		s.authKeys[k.Hash] = &k
+	}
+	s.bumpEtagLocked()
+	return nil
+}
+
 func (s *MemStore) bumpEtagLocked() {
 	s.peersEtag = newEtag()
 	old := s.peersBroad
@@ -185,6 +655,207 @@ func (s *MemStore) bumpEtagLocked() {
 	close(old)
 }
 
+// AddAuthKey stores a new auth key. Re-adding a known key updates its
+// options but keeps its use count, so reloading a key file at startup
+// doesn't resurrect spent single-use keys.
+func (s *MemStore) AddAuthKey(ctx context.Context, k AuthKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.authKeys[k.Hash]; ok {
+		existing.Reusable = k.Reusable
+		existing.ExpiresAt = k.ExpiresAt
+		existing.Tags = k.Tags
+	} else {
+		s.authKeys[k.Hash] = &k
+	}
+	return s.persistLocked()
+}
+
+// UseAuthKey consumes one use of the key whose secret is given.
+func (s *MemStore) UseAuthKey(ctx context.Context, secret string) (AuthKey, error) {
+	if err := ctx.Err(); err != nil {
+		return AuthKey{}, err
+	}
+	if secret == "" {
+		return AuthKey{}, ErrAuthKeyMissing
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.authKeys[hashAuthKey(secret)]
+	if !ok {
+		return AuthKey{}, ErrAuthKeyInvalid
+	}
+	if err := k.usable(time.Now()); err != nil {
+		return AuthKey{}, err
+	}
+	k.Uses++
+	if err := s.persistLocked(); err != nil {
+		return AuthKey{}, err
+	}
+	return *k, nil
+}
+
+// AuthKeys lists every known auth key, oldest first.
+func (s *MemStore) AuthKeys(ctx context.Context) ([]AuthKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authKeysLocked(), nil
+}
+
+func (s *MemStore) authKeysLocked() []AuthKey {
+	out := make([]AuthKey, 0, len(s.authKeys))
+	for _, k := range s.authKeys {
+		out = append(out, *k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// RevokeAuthKey marks a key revoked. The record is kept so that reloading
+// the key file can't bring it back.
+func (s *MemStore) RevokeAuthKey(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.authKeys {
+		if k.ID == id {
+			k.Revoked = true
+			return s.persistLocked()
+		}
+	}
+	return ErrAuthKeyInvalid
+}
+
+// PeerUpdate is an admin change to a peer. Nil fields are left alone.
+type PeerUpdate struct {
+	Name     *string
+	Disabled *bool
+	TunnelIP *netip.Addr
+}
+
+// UpdatePeer applies u under one lock and one persist. A rename or move is
+// pinned against later re-registrations; disabling drops the peer's queued
+// signals; a new tunnel IP must be a free pool address.
+func (s *MemStore) UpdatePeer(ctx context.Context, nodeKey ed25519.PublicKey, u PeerUpdate) error {
+	if u.Name != nil && strings.TrimSpace(*u.Name) == "" {
+		return errors.New("name must not be empty")
+	}
+	if u.TunnelIP != nil && !s.assignable(*u.TunnelIP) {
+		return fmt.Errorf("%s is not an assignable address in %s", *u.TunnelIP, s.pool)
+	}
+	return s.updatePeer(ctx, nodeKey, func(p *Peer) error {
+		key := base64Encode(p.NodeKey)
+		if u.TunnelIP != nil {
+			ip := *u.TunnelIP
+			if holder, taken := s.byTunnel[ip.String()]; taken && holder != key {
+				return ErrTunnelIPTaken
+			}
+			if owner, ok := s.reservedIPs[ip]; ok && owner != key {
+				return ErrTunnelIPTaken
+			}
+		}
+		// Everything is checked; nothing below can fail.
+		if u.Name != nil {
+			p.Name = *u.Name
+			p.NameLocked = true
+		}
+		if u.Disabled != nil {
+			p.Disabled = *u.Disabled
+			if p.Disabled {
+				s.dropSignals(p.DiscoKey)
+			}
+		}
+		if u.TunnelIP != nil {
+			s.moveLocked(key, p, *u.TunnelIP)
+			p.TunnelIPLocked = true
+		}
+		return nil
+	})
+}
+
+// DeletePeer removes a peer and releases its tunnel IP immediately.
+func (s *MemStore) DeletePeer(ctx context.Context, nodeKey ed25519.PublicKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key := base64Encode(nodeKey)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.peers[key]
+	if !ok {
+		return s.deleteDepartedLocked(key)
+	}
+	delete(s.peers, key)
+	delete(s.tombstones, key)
+	delete(s.admitted, key)
+	if s.byTunnel[p.TunnelIP.String()] == key {
+		delete(s.byTunnel, p.TunnelIP.String())
+	}
+	s.dropSignals(p.DiscoKey)
+	s.bumpEtagLocked()
+	return s.persistLocked()
+}
+
+// deleteDepartedLocked withdraws an expired node's admission and releases
+// the tunnel IP its tombstone holds. There is no peer to drop and so no
+// etag to bump.
+func (s *MemStore) deleteDepartedLocked(key string) error {
+	_, admitted := s.admitted[key]
+	tomb, held := s.tombstones[key]
+	if !admitted && !held {
+		return ErrUnknownPeer
+	}
+	delete(s.admitted, key)
+	if held {
+		delete(s.tombstones, key)
+		if s.byTunnel[tomb.TunnelIP.String()] == key {
+			delete(s.byTunnel, tomb.TunnelIP.String())
+		}
+	}
+	return s.persistLocked()
+}
+
+// updatePeer applies fn to a registered peer under the write lock, then
+// bumps the etag and persists. fn returning an error aborts both.
+func (s *MemStore) updatePeer(ctx context.Context, nodeKey ed25519.PublicKey, fn func(*Peer) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.peers[base64Encode(nodeKey)]
+	if !ok {
+		return ErrUnknownPeer
+	}
+	if err := fn(p); err != nil {
+		return err
+	}
+	s.bumpEtagLocked()
+	return s.persistLocked()
+}
+
+// assignable reports whether ip is a host address of the pool: not the
+// network or broadcast address, and the same family.
+func (s *MemStore) assignable(ip netip.Addr) bool {
+	if !ip.IsValid() || !s.pool.Contains(ip) || ip == s.pool.Masked().Addr() {
+		return false
+	}
+	return s.pool.Contains(ip.Next())
+}
+
 // EnqueueSignal adds a sealed envelope to the recipient's queue.
 func (s *MemStore) EnqueueSignal(ctx context.Context, to [32]byte, env Envelope) error {
 	if err := ctx.Err(); err != nil {
@@ -194,9 +865,11 @@ func (s *MemStore) EnqueueSignal(ctx context.Context, to [32]byte, env Envelope)
 	q := s.signals[to]
 	if len(q) >= s.maxQueueDepth {
 		q = q[1:]
+		s.droppedOverflow++
 	}
 	q = append(q, env)
 	s.signals[to] = q
+	s.signalGen++
 	wake := s.signalWakes[to]
 	delete(s.signalWakes, to)
 	s.signalMu.Unlock()
@@ -215,8 +888,11 @@ func (s *MemStore) PopSignals(ctx context.Context, to [32]byte) ([]Envelope, err
 	}
 	s.signalMu.Lock()
 	defer s.signalMu.Unlock()
-	queue := s.signals[to]
-	delete(s.signals, to)
+	queue, ok := s.signals[to]
+	if ok {
+		delete(s.signals, to)
+		s.signalGen++
+	}
 
 	cutoff := time.Now().Add(-s.maxAge)
 	fresh := queue[:0]
@@ -225,9 +901,74 @@ func (s *MemStore) PopSignals(ctx context.Context, to [32]byte) ([]Envelope, err
 			fresh = append(fresh, e)
 		}
 	}
+	s.droppedExpired += uint64(len(queue) - len(fresh))
 	return fresh, nil
 }
 
+// Stats implements Store.
+func (s *MemStore) Stats(ctx context.Context) (StoreStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StoreStats{}, err
+	}
+	var st StoreStats
+	s.mu.Lock()
+	st.Peers = len(s.peers)
+	s.mu.Unlock()
+
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	for _, q := range s.signals {
+		if len(q) == 0 {
+			continue
+		}
+		st.SignalQueues++
+		st.QueuedSignals += len(q)
+		st.MaxQueueDepth = max(st.MaxQueueDepth, len(q))
+	}
+	st.DroppedOverflow = s.droppedOverflow
+	st.DroppedExpired = s.droppedExpired
+	return st, nil
+}
+
+// dropSignals discards anything queued for an evicted peer's disco key.
+func (s *MemStore) dropSignals(to [32]byte) {
+	s.signalMu.Lock()
+	delete(s.signals, to)
+	s.signalGen++
+	s.signalMu.Unlock()
+}
+
+// signalQueues copies the queues if they changed since generation gen, and
+// returns the generation copied.
+func (s *MemStore) signalQueues(gen uint64) (map[[32]byte][]Envelope, uint64, bool) {
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	if s.signalGen == gen {
+		return nil, gen, false
+	}
+	out := make(map[[32]byte][]Envelope, len(s.signals))
+	for to, q := range s.signals {
+		out[to] = append([]Envelope(nil), q...)
+	}
+	return out, s.signalGen, true
+}
+
+// restoreSignals loads queues saved by another replica, dropping envelopes
+// already too old to deliver.
+func (s *MemStore) restoreSignals(queues map[[32]byte][]Envelope) {
+	cutoff := time.Now().Add(-s.maxAge)
+	s.signalMu.Lock()
+	defer s.signalMu.Unlock()
+	for to, q := range queues {
+		for _, e := range q {
+			if e.Enqueue.After(cutoff) {
+				s.signals[to] = append(s.signals[to], e)
+			}
+		}
+	}
+	s.signalGen++
+}
+
 // WaitForSignal blocks until an envelope is enqueued for `to`, or ctx fires.
 func (s *MemStore) WaitForSignal(ctx context.Context, to [32]byte) error {
 	s.signalMu.Lock()
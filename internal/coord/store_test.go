@@ -75,6 +75,22 @@ func TestStore_Register_SequentialIPs(t *testing.T) {
 	}
 }
 
+func TestStore_Register_IPv6Pool(t *testing.T) {
+	s := NewMemStore(netip.MustParsePrefix("fd7a:115c:a1e0::/64"))
+	ip, err := s.Register(context.Background(), makePeer(t, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != netip.MustParseAddr("fd7a:115c:a1e0::1") {
+		t.Errorf("first IP = %v, want fd7a:115c:a1e0::1", ip)
+	}
+	p := makePeer(t, "b")
+	p.TunnelIP = netip.MustParseAddr("fd7a:115c:a1e0::42")
+	if ip, err = s.Register(context.Background(), p); err != nil || ip != p.TunnelIP {
+		t.Errorf("requested IP: got %v, %v; want %v", ip, err, p.TunnelIP)
+	}
+}
+
 func TestStore_AllocateIP_Exhaustion(t *testing.T) {
 	// /30 has 4 addresses: .0 (network), .1, .2, .3 (broadcast).
 	// allocateIPLocked skips network (.0) and broadcast (.3), leaving .1 and .2.
@@ -0,0 +1,216 @@
+package coord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// streamKeepalive is how often GET /v1/stream writes a comment line when
+// nothing else happened, so that proxies and the client's idle watchdog
+// see a live connection. It also refreshes the caller's updated_at.
+const streamKeepalive = 15 * time.Second
+
+// handleStream pushes the caller's view of its network and its envelopes
+// over one server-sent-events response, replacing the /v1/peers and
+// /v1/signal long-polls:
+//
+//	event: peers    PeersResp, the full view; always the first event
+//	event: delta    PeersDelta, peers added, changed or gone since the last event
+//	event: signal   SignalsResp, envelopes as they arrive
+//
+// The stream ends when the caller is disabled or deleted; reconnecting then
+// gets the usual 403 or 404.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request, nw *network, pub ed25519.PublicKey, _ []byte) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	self, err := nw.store.Lookup(ctx, pub)
+	if err != nil {
+		// Clients tell this 404 from a coordinator without the stream
+		// route by its body, so it has to stay ErrUnknownPeer's text.
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUnknownPeer) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	view, etag, err := s.viewOf(ctx, nw, pub)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout is sized for long-polls; a stream has
+	// to outlive it.
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]Peer, len(view))
+	for _, p := range view {
+		sent[string(p.NodeKey)] = p
+	}
+	issued := time.Now()
+	if err := writeEvent(w, rc, "peers", PeersResp{Etag: etag, Peers: view, IssuedAt: issued,
+		Signature: s.signNetmap(pub, etag, issued, view)}); err != nil {
+		return
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		since := etag
+		for {
+			if err := nw.store.WaitForPeersChange(ctx, since); err != nil {
+				return
+			}
+			if _, since, err = nw.store.Peers(ctx); err != nil {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	// The watcher only says envelopes are waiting; the loop below pops
+	// them when it is about to write, so a stream that ends in between
+	// leaves them queued for the next connection.
+	signalled := make(chan struct{})
+	go func() {
+		for {
+			if err := nw.store.WaitForSignal(ctx, self.DiscoKey); err != nil {
+				return
+			}
+			select {
+			case signalled <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	defer s.metrics.waiting("stream")()
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	_ = nw.store.Touch(ctx, pub)
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepalive.C:
+			_ = nw.store.Touch(ctx, pub)
+			if _, err = fmt.Fprint(w, ": keepalive\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		case <-signalled:
+			envs, perr := nw.store.PopSignals(ctx, self.DiscoKey)
+			if perr != nil {
+				return
+			}
+			if len(envs) == 0 {
+				continue
+			}
+			if err = writeEvent(w, rc, "signal", SignalsResp{Envelopes: envs}); err != nil {
+				s.requeue(nw, self.DiscoKey, envs)
+			}
+		case <-changed:
+			view, etag, err = s.viewOf(ctx, nw, pub)
+			if err != nil {
+				s.log.Debug("ending stream", "err", err)
+				return
+			}
+			delta := diffPeers(sent, view)
+			if len(delta.Upsert) == 0 && len(delta.Remove) == 0 {
+				continue
+			}
+			delta.Etag = etag
+			delta.IssuedAt = time.Now()
+			delta.Signature = s.signNetmap(pub, etag, delta.IssuedAt, view)
+			err = writeEvent(w, rc, "delta", delta)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// requeue puts envelopes that couldn't be written back in to's queue, in
+// order and with their original enqueue times, so they still age out.
+func (s *Server) requeue(nw *network, to [32]byte, envs []Envelope) {
+	for _, env := range envs {
+		if err := nw.store.EnqueueSignal(context.Background(), to, env); err != nil {
+			s.log.Debug("requeueing signal", "err", err)
+			return
+		}
+	}
+}
+
+// viewOf is the peer list pub gets to see: enabled peers the policy lets it
+// see, itself included. A disabled or unknown caller gets an error.
+func (s *Server) viewOf(ctx context.Context, nw *network, pub ed25519.PublicKey) ([]Peer, string, error) {
+	self, err := nw.store.Lookup(ctx, pub)
+	if err != nil {
+		return nil, "", err
+	}
+	if self.Disabled {
+		return nil, "", errNodeDisabled
+	}
+	peers, etag, err := nw.store.Peers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.policy.Load().Visible(self, enabledPeers(peers)), etag, nil
+}
+
+// diffPeers works out what changed between what the client was last sent
+// and view, and updates sent to match view. Bumps to UpdatedAt alone don't
+// count as a change.
+func diffPeers(sent map[string]Peer, view []Peer) PeersDelta {
+	var d PeersDelta
+	seen := make(map[string]bool, len(view))
+	for _, p := range view {
+		k := string(p.NodeKey)
+		seen[k] = true
+		if old, ok := sent[k]; ok && samePeer(old, p) {
+			continue
+		}
+		sent[k] = p
+		d.Upsert = append(d.Upsert, p)
+	}
+	for k, p := range sent {
+		if !seen[k] {
+			delete(sent, k)
+			d.Remove = append(d.Remove, p.NodeKey)
+		}
+	}
+	return d
+}
+
+func samePeer(a, b Peer) bool {
+	a.UpdatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, event string, body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf); err != nil {
+		return err
+	}
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
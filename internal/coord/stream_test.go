@@ -0,0 +1,195 @@
+package coord
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+// readEvent returns the next non-keepalive SSE event.
+func readEvent(t *testing.T, sc *bufio.Scanner) (string, string) {
+	t.Helper()
+	var event, data string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("stream ended: %v", sc.Err())
+	return "", ""
+}
+
+func TestServer_Stream(t *testing.T) {
+	store := newTestStore(t)
+	ts := httptest.NewServer(NewServer(store))
+	defer ts.Close()
+
+	a := newTestClient(t, ts.URL)
+	a.register(t)
+	a.http.Timeout = 0
+	resp := a.do(t, "GET", "/v1/stream", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content-type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	sc := bufio.NewScanner(resp.Body)
+
+	ev, data := readEvent(t, sc)
+	var full PeersResp
+	if err := json.Unmarshal([]byte(data), &full); ev != "peers" || err != nil || len(full.Peers) != 1 {
+		t.Fatalf("first event %s %s", ev, data)
+	}
+
+	b := newTestClient(t, ts.URL)
+	b.register(t)
+	ev, data = readEvent(t, sc)
+	var delta PeersDelta
+	if err := json.Unmarshal([]byte(data), &delta); ev != "delta" || err != nil || len(delta.Upsert) != 1 ||
+		string(delta.Upsert[0].NodeKey) != string(b.nk.Pub) {
+		t.Fatalf("want delta adding b, got %s %s", ev, data)
+	}
+
+	sig := b.do(t, "POST", "/v1/signal", SignalReq{To: a.dk.Pub, Sealed: []byte("hi")})
+	sig.Body.Close()
+	ev, data = readEvent(t, sc)
+	var sr SignalsResp
+	if err := json.Unmarshal([]byte(data), &sr); ev != "signal" || err != nil || string(sr.Envelopes[0].Sealed) != "hi" {
+		t.Fatalf("want signal, got %s %s", ev, data)
+	}
+
+	if err := store.DeletePeer(context.Background(), b.nk.Pub); err != nil {
+		t.Fatal(err)
+	}
+	ev, data = readEvent(t, sc)
+	delta = PeersDelta{}
+	if err := json.Unmarshal([]byte(data), &delta); ev != "delta" || err != nil || len(delta.Upsert) != 0 ||
+		len(delta.Remove) != 1 || string(delta.Remove[0]) != string(b.nk.Pub) {
+		t.Fatalf("want delta removing b, got %s %s", ev, data)
+	}
+}
+
+// failingWriter takes the stream's first event and fails every write
+// after it, like a connection that drops mid-stream.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if f.writes++; f.writes > 1 {
+		return 0, errors.New("connection reset")
+	}
+	return f.ResponseRecorder.Write(b)
+}
+
+func TestServer_StreamKeepsUnwrittenSignals(t *testing.T) {
+	store := newTestStore(t)
+	srv := NewServer(store)
+	a := makePeer(t, "a")
+	if _, err := store.Register(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	env := Envelope{Sealed: []byte("hi"), Enqueue: time.Now()}
+	if err := store.EnqueueSignal(context.Background(), a.DiscoKey, env); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder()}
+	srv.handleStream(w, httptest.NewRequest("GET", "/v1/stream", nil), srv.networks[DefaultNetwork], a.NodeKey, nil)
+	if w.writes < 2 {
+		t.Fatalf("stream ended after %d writes, before trying the signal", w.writes)
+	}
+	envs, err := store.PopSignals(context.Background(), a.DiscoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs) != 1 || string(envs[0].Sealed) != "hi" {
+		t.Errorf("queue after a failed write = %v, want the envelope back", envs)
+	}
+}
+
+func TestServer_StreamUnregistered(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newTestStore(t)))
+	defer ts.Close()
+
+	// The client tells this 404 from a missing route by its body.
+	a := newTestClient(t, ts.URL)
+	resp := a.do(t, "GET", "/v1/stream", nil)
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNotFound || strings.TrimSpace(string(b)) != ErrUnknownPeer.Error() {
+		t.Errorf("got %d %q, want 404 %q", resp.StatusCode, b, ErrUnknownPeer)
+	}
+}
+
+func TestCoordClient_WatchStream(t *testing.T) {
+	ts := httptest.NewServer(NewServer(newTestStore(t)))
+	defer ts.Close()
+
+	nk, _ := disco.GenerateNodeKey()
+	dk, _ := disco.GenerateDiscoKey()
+	c := disco.NewCoordClient(ts.URL, nk, dk)
+	if _, _, err := c.Register(context.Background(), disco.RegisterOptions{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	peers := make(chan int, 16)
+	signals := make(chan string, 16)
+	go func() {
+		_ = c.Watch(ctx, disco.WatchHandlers{
+			Peers: func(p []disco.RemotePeer) { peers <- len(p) },
+			Signals: func(s [][]byte) {
+				for _, b := range s {
+					signals <- string(b)
+				}
+			},
+		})
+	}()
+	waitFor := func(want int) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case n := <-peers:
+				if n == want {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("never saw %d peers", want)
+			}
+		}
+	}
+	waitFor(1)
+
+	b := newTestClient(t, ts.URL)
+	b.register(t)
+	waitFor(2)
+
+	resp := b.do(t, "POST", "/v1/signal", SignalReq{To: dk.Pub, Sealed: []byte("hello")})
+	resp.Body.Close()
+	select {
+	case s := <-signals:
+		if s != "hello" {
+			t.Errorf("signal = %q", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not delivered")
+	}
+}
@@ -0,0 +1,89 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/pion/stun"
+)
+
+// WithSTUNAddr advertises a STUN server in every RegisterResp, so daemons
+// given no --stun-server use it instead of the public defaults. addr is
+// host:port; an empty or unspecified host means "the host the node used to
+// reach the coordinator".
+func WithSTUNAddr(addr string) Option {
+	return func(s *Server) { s.stunAddr = addr }
+}
+
+// stunServersFor is what a RegisterResp advertises to r's sender.
+func (s *Server) stunServersFor(r *http.Request) []string {
+	if s.stunAddr == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(s.stunAddr)
+	if err != nil {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); host == "" || (err == nil && ip.IsUnspecified()) {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+	}
+	return []string{net.JoinHostPort(host, port)}
+}
+
+// ServeSTUN answers RFC 5389 binding requests on conn with the sender's
+// address as XOR-MAPPED-ADDRESS until ctx is done or conn fails. Anything
+// else is ignored. It closes conn on return.
+func ServeSTUN(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return ctx.Err()
+			}
+			return err
+		}
+		resp, ok := stunReply(buf[:n], from)
+		if !ok {
+			continue
+		}
+		_, _ = conn.WriteTo(resp, from)
+	}
+}
+
+// stunReply builds the binding success response for the request in b, or
+// reports false if b isn't a binding request.
+func stunReply(b []byte, from net.Addr) ([]byte, bool) {
+	if !stun.IsMessage(b) {
+		return nil, false
+	}
+	req := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := req.Decode(); err != nil || req.Type != stun.BindingRequest {
+		return nil, false
+	}
+	ua, ok := from.(*net.UDPAddr)
+	if !ok {
+		return nil, false
+	}
+	resp, err := stun.Build(
+		stun.NewTransactionIDSetter(req.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: ua.IP, Port: ua.Port},
+		stun.NewSoftware("gretun-coord"),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return nil, false
+	}
+	return resp.Raw, true
+}
@@ -0,0 +1,75 @@
+package coord
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+func TestServeSTUN(t *testing.T) {
+	srv, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeSTUN(ctx, srv) }()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// Junk first: the server must keep going.
+	_, _ = client.WriteTo([]byte("not stun"), srv.LocalAddr())
+
+	dctx, dcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dcancel()
+	ep, err := disco.DiscoverPublic(dctx, client, []string{srv.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.Addr.String() != client.LocalAddr().String() {
+		t.Errorf("mapped %s, want %s", ep.Addr, client.LocalAddr())
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("ServeSTUN returned %v", err)
+	}
+}
+
+func TestRegister_AdvertisesSTUN(t *testing.T) {
+	cases := []struct {
+		addr string
+		want string
+	}{
+		{":3478", "127.0.0.1:3478"},
+		{"0.0.0.0:3478", "127.0.0.1:3478"},
+		{"stun.example.com:3478", "stun.example.com:3478"},
+	}
+	for _, c := range cases {
+		ts := httptest.NewServer(NewServer(newTestStore(t), WithSTUNAddr(c.addr)))
+		nk, _ := disco.GenerateNodeKey()
+		dk, _ := disco.GenerateDiscoKey()
+		cl := disco.NewCoordClient(ts.URL, nk, dk)
+		if _, _, err := cl.Register(context.Background(), disco.RegisterOptions{Name: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := cl.STUNServers(); len(got) != 1 || got[0] != c.want {
+			t.Errorf("%s: advertised %v, want %s", c.addr, got, c.want)
+		}
+		ts.Close()
+	}
+
+	ts := httptest.NewServer(NewServer(newTestStore(t)))
+	defer ts.Close()
+	a := newTestClient(t, ts.URL)
+	if resp := a.register(t); resp.STUNServers != nil {
+		t.Errorf("advertised %v without WithSTUNAddr", resp.STUNServers)
+	}
+}
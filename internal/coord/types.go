@@ -11,14 +11,38 @@ import (
 
 // EndpointSource tags where an endpoint was learned. "local" means a NIC
 // address the peer saw on itself; "stun" means a public mapping reported
-// by a STUN server. Punching prefers stun but tries all.
+// by a STUN server; "portmap" means a forward the peer's gateway granted
+// over PCP, NAT-PMP or UPnP. Punching prefers stun but tries all.
 type EndpointSource string
 
 const (
-	SourceLocal EndpointSource = "local"
-	SourceSTUN  EndpointSource = "stun"
+	SourceLocal   EndpointSource = "local"
+	SourceSTUN    EndpointSource = "stun"
+	SourcePortmap EndpointSource = "portmap"
 )
 
+// NATType is a node's own classification of the NAT in front of its disco
+// socket (see disco.Netcheck). The coordinator only stores and forwards
+// it; peers use it to pick a punch strategy.
+type NATType string
+
+const (
+	NATUnknown             NATType = "unknown"
+	NATNone                NATType = "none"
+	NATEndpointIndependent NATType = "endpoint_independent"
+	NATAddressDependent    NATType = "address_dependent"
+	NATSymmetric           NATType = "symmetric"
+)
+
+// valid reports whether t is one of the known types, or empty.
+func (t NATType) valid() bool {
+	switch t {
+	case "", NATUnknown, NATNone, NATEndpointIndependent, NATAddressDependent, NATSymmetric:
+		return true
+	}
+	return false
+}
+
 // Endpoint is one ip:port candidate for reaching a peer.
 type Endpoint struct {
 	Addr   netip.AddrPort `json:"addr"`
@@ -33,6 +57,23 @@ type Peer struct {
 	TunnelIP    netip.Addr     `json:"tunnel_ip"`
 	Endpoints   []Endpoint     `json:"endpoints"`
 	UpdatedAt   time.Time      `json:"updated_at"`
+	// Disabled peers are hidden from everyone else's peer list and refused
+	// on every authenticated endpoint until an admin re-enables them.
+	Disabled bool `json:"disabled,omitempty"`
+	// NameLocked is set when an admin renamed the peer; re-registering
+	// with a different --node-name then doesn't undo the rename.
+	NameLocked bool `json:"name_locked,omitempty"`
+	// TunnelIPLocked is set when an admin moved the peer; re-registering
+	// with a --tunnel-ip request then doesn't move it back.
+	TunnelIPLocked bool `json:"tunnel_ip_locked,omitempty"`
+	// Tags come from the auth key the node first registered with. ACL
+	// policies select on them.
+	Tags []string `json:"tags,omitempty"`
+	// AuthKeyID is the ID of that key; empty if it joined without one.
+	AuthKeyID string `json:"auth_key_id,omitempty"`
+	// NAT is the node's last reported NAT type; empty until it reports
+	// one. It is a hint and not covered by the netmap signature.
+	NAT NATType `json:"nat,omitempty"`
 }
 
 // Envelope is the opaque relay payload. The coordinator never peeks inside
@@ -49,23 +90,51 @@ type RegisterReq struct {
 	DiscoPubkey       [32]byte `json:"disco_pubkey"`
 	NodeName          string   `json:"node_name"`
 	RequestedTunnelIP string   `json:"requested_tunnel_ip,omitempty"`
+	AuthKey           string   `json:"auth_key,omitempty"`
+	// Network picks the overlay to join. Empty means the network of the
+	// auth key, or the coordinator's default network.
+	Network string `json:"network,omitempty"`
 }
 
 // RegisterResp is the response for POST /v1/register.
 type RegisterResp struct {
 	TunnelIP string `json:"tunnel_ip"`
 	Etag     string `json:"peers_etag"`
+	Network  string `json:"network"`
+	// STUNServers are host:port STUN servers the coordinator runs or
+	// recommends; see WithSTUNAddr.
+	STUNServers []string `json:"stun_servers,omitempty"`
 }
 
 // EndpointsReq is the body of POST /v1/endpoints.
 type EndpointsReq struct {
 	Endpoints []Endpoint `json:"endpoints"`
+	// NAT, if set, replaces the node's reported NAT type.
+	NAT NATType `json:"nat,omitempty"`
 }
 
 // PeersResp is the body returned by GET /v1/peers.
 type PeersResp struct {
 	Etag  string `json:"etag"`
 	Peers []Peer `json:"peers"`
+	// IssuedAt is when the coordinator produced the list. It is signed,
+	// so clients can refuse a list older than one they already have.
+	IssuedAt time.Time `json:"issued_at"`
+	// Signature is the coordinator's Ed25519 signature over the list; see
+	// WithSigningKey. Absent when the coordinator has no signing key.
+	Signature []byte `json:"sig,omitempty"`
+}
+
+// PeersDelta is a "delta" event on GET /v1/stream: peers that appeared or
+// changed since the previous event, and node keys of peers that are gone.
+// Signature covers the full list once the delta is applied, so the client
+// checks its own view rather than the delta.
+type PeersDelta struct {
+	Etag      string    `json:"etag"`
+	Upsert    []Peer    `json:"upsert,omitempty"`
+	Remove    [][]byte  `json:"remove,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	Signature []byte    `json:"sig,omitempty"`
 }
 
 // SignalReq is the body of POST /v1/signal.
@@ -0,0 +1,145 @@
+//go:build linux
+
+package daemon
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+// Birthday-paradox probing for peers behind symmetric NAT (--aggressive-punch).
+// Such a NAT gives every destination its own public port, so the one the
+// peer learned from STUN is useless to us. Instead each side opens many
+// extra sockets and pings the other's known endpoints from all of them,
+// creating as many mappings on its own NAT, while spraying pings at
+// guessed ports on the other's public IPs. With 256 mappings on one side
+// and ~1000 guesses from the other, a guess lands on a mapping ~98% of the
+// time. A hit only proves the mapping of the socket it landed on, not the
+// FOU port's, so the tunnel still waits for the usual FOU-port proof.
+const (
+	// aggressiveSockets caps the extra sockets one run opens.
+	aggressiveSockets = 256
+	// aggressiveDur is how long a run lasts before the peer falls back to
+	// the relay.
+	aggressiveDur = 10 * time.Second
+	// aggressivePerTick caps the pings one run sends per punchInterval,
+	// 256/s, split between the extra sockets and the spray.
+	aggressivePerTick = 64
+	// aggressiveNear is how far around a known port a "near" guess falls;
+	// many symmetric NATs allocate sequentially.
+	aggressiveNear = 512
+)
+
+// aggressivePunch is one probing run for one peer.
+type aggressivePunch struct {
+	conns []net.PacketConn
+	next  int // round-robin cursor over conns
+}
+
+// startAggressive opens the run's sockets and starts reading them. It
+// opens fewer than aggressiveSockets if the process runs out of them.
+func (p *peerFSM) startAggressive() *aggressivePunch {
+	a := &aggressivePunch{}
+	for len(a.conns) < aggressiveSockets {
+		c, err := listenBound("udp4", net.JoinHostPort(bindHost(p.deps.localIP, false), "0"), p.deps.bindIface)
+		if err != nil {
+			slog.Warn("aggressive punch: opening sockets", "opened", len(a.conns), "err", err)
+			break
+		}
+		a.conns = append(a.conns, c)
+		go p.readAggressive(c)
+	}
+	if p.deps.metrics != nil {
+		p.deps.metrics.AggressivePunchAttempts.Inc()
+	}
+	slog.Info("hole punch failed; probing for a symmetric NAT", "peer", p.peer.Name, "sockets", len(a.conns))
+	return a
+}
+
+// readAggressive hands the peer's disco messages arriving on c to the FSM
+// until c is closed.
+func (p *peerFSM) readAggressive(c net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		sender, body, err := disco.OpenEnvelope(buf[:n], p.deps.self)
+		if err != nil || sender != p.peer.DiscoKey {
+			continue
+		}
+		ua, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		p.onUDPVia(c, ua.AddrPort(), body)
+	}
+}
+
+// tick sends one tick's worth of pings: from the next extra sockets to
+// each of the peer's endpoints, then guesses at its public IPs from the
+// disco socket.
+func (a *aggressivePunch) tick(p *peerFSM, eps []disco.RemoteEndpoint) {
+	if len(eps) == 0 {
+		return
+	}
+	budget := aggressivePerTick
+	if len(a.conns) > 0 {
+		per := max(1, aggressivePerTick/2/len(eps))
+		for i := 0; i < per && budget > 0; i++ {
+			c := a.conns[a.next%len(a.conns)]
+			a.next++
+			for _, e := range eps {
+				p.sendPing(c, e.Addr)
+				budget--
+			}
+		}
+	}
+	for i := 0; budget > 0; i++ {
+		budget--
+		e := eps[i%len(eps)]
+		if ip := e.Addr.Addr(); !ip.Is4() || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			continue
+		}
+		near := (i/len(eps))%2 == 0
+		p.sendPing(nil, netip.AddrPortFrom(e.Addr.Addr(), guessPort(e.Addr.Port(), near)))
+	}
+}
+
+// guessPort picks a remote port to try: near the known one, or anywhere
+// above the well-known range.
+func guessPort(known uint16, near bool) uint16 {
+	if near {
+		p := int(known) + rand.IntN(2*aggressiveNear+1) - aggressiveNear
+		if p >= 1024 && p <= 65535 {
+			return uint16(p)
+		}
+	}
+	return uint16(1024 + rand.IntN(65536-1024))
+}
+
+// owns reports whether c is one of the run's sockets.
+func (a *aggressivePunch) owns(c net.PacketConn) bool {
+	for _, ac := range a.conns {
+		if ac == c {
+			return true
+		}
+	}
+	return false
+}
+
+// stop closes every socket but keep, which carries the winning path.
+func (a *aggressivePunch) stop(keep net.PacketConn) {
+	for _, c := range a.conns {
+		if c != keep {
+			c.Close()
+		}
+	}
+	a.conns = nil
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package daemon
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vishvananda/netlink"
+)
+
+func TestGuessPort(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if p := guessPort(40000, true); p < 40000-aggressiveNear || p > 40000+aggressiveNear {
+			t.Fatalf("near guess %d too far from 40000", p)
+		}
+		if p := guessPort(1100, true); p < 1024 {
+			t.Fatalf("near guess %d below 1024", p)
+		}
+		if p := guessPort(40000, false); p < 1024 {
+			t.Fatalf("far guess %d below 1024", p)
+		}
+	}
+}
+
+// TestAggressivePunch_AdoptsWinningSocket plays the peer: it answers the
+// first probe it sees, and the FSM must keep the socket that probe came
+// from for the disco path. With no FOU-port socket to prove the data path
+// it must not go direct.
+func TestAggressivePunch_AdoptsWinningSocket(t *testing.T) {
+	if !firstGlobalV4().IsValid() {
+		t.Skip("no global v4 on this host; skipping")
+	}
+	self, _ := disco.GenerateDiscoKey()
+	peerKey, _ := disco.GenerateDiscoKey()
+	discoCn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discoCn.Close()
+	peerCn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerCn.Close()
+
+	reg := prometheus.NewRegistry()
+	deps := peerDeps{
+		self: self, ifaceName: "gretun0", fouPort: 7777, discoCn: discoCn, aggressive: true,
+		nl: &fakeNL{links: make(map[string]netlink.Link)}, metrics: NewMetrics(reg),
+	}
+	fsm := newPeerFSM(deps, disco.RemotePeer{
+		Name:      "b",
+		DiscoKey:  peerKey.Pub,
+		Endpoints: []disco.RemoteEndpoint{{Addr: peerCn.LocalAddr().(*net.UDPAddr).AddrPort(), Source: "stun"}},
+	})
+	defer fsm.teardown()
+	fsm.state = statePunching
+	deadline := time.Now().Add(-time.Second)
+	fsm.tickPunch(&deadline)
+	if fsm.aggr == nil || len(fsm.aggr.conns) == 0 {
+		t.Fatal("no probe run after the plain punch timed out")
+	}
+	if got := testutil.ToFloat64(deps.metrics.AggressivePunchAttempts); got != 1 {
+		t.Fatalf("attempts = %v, want 1", got)
+	}
+
+	// Answer the first ping that came from an extra socket.
+	buf := make([]byte, 2048)
+	_ = peerCn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, from, err := peerCn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from == discoCn.LocalAddr().(*net.UDPAddr).AddrPort() {
+			continue
+		}
+		if _, body, err := disco.OpenEnvelope(buf[:n], peerKey); err != nil || body.Type != disco.MsgPing {
+			t.Fatalf("probe = %+v, %v", body, err)
+		}
+		pong, _ := disco.BuildEnvelope(peerKey, self.Pub, disco.Body{Type: disco.MsgPong})
+		if _, err := peerCn.WriteToUDPAddrPort(pong, from); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+
+	var ev fsmEvent
+	select {
+	case ev = <-fsm.incoming:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pong never reached the FSM")
+	}
+	if ev.conn == nil {
+		t.Fatal("pong should have arrived on an extra socket")
+	}
+	fsm.handle(ev, &deadline)
+	if fsm.state == stateDirect || fsm.winConn != ev.conn || fsm.aggr != nil {
+		t.Fatalf("state = %v, adopted socket = %v, probe still running = %v", fsm.state, fsm.winConn == ev.conn, fsm.aggr != nil)
+	}
+	if got := testutil.ToFloat64(deps.metrics.AggressivePunchSuccesses); got != 1 {
+		t.Errorf("successes = %v, want 1", got)
+	}
+
+	// A pong on the disco socket during the next run is a plain punch
+	// winning, not the probe.
+	fsm.aggr = fsm.startAggressive()
+	fsm.handle(fsmEvent{kind: evUDP, addr: ev.addr, body: disco.Body{Type: disco.MsgPong}}, &deadline)
+	if fsm.aggr != nil {
+		t.Error("probe still running after a plain pong")
+	}
+	if got := testutil.ToFloat64(deps.metrics.AggressivePunchSuccesses); got != 1 {
+		t.Errorf("successes = %v after a disco-socket pong, want 1", got)
+	}
+}
+
+func TestTickPunch_BothHardProbesAtOnce(t *testing.T) {
+	discoCn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discoCn.Close()
+	self, _ := disco.GenerateDiscoKey()
+	ep := disco.RemoteEndpoint{Addr: discoCn.LocalAddr().(*net.UDPAddr).AddrPort(), Source: "stun"}
+
+	for _, peerNAT := range []disco.NATType{disco.NATEndpointIndependent, disco.NATSymmetric} {
+		deps := peerDeps{self: self, discoCn: discoCn, aggressive: true, selfNAT: disco.NATAddressDependent}
+		fsm := newPeerFSM(deps, disco.RemotePeer{Name: "b", NAT: peerNAT, Endpoints: []disco.RemoteEndpoint{ep}})
+		fsm.state = statePunching
+		deadline := time.Now().Add(time.Minute)
+		fsm.tickPunch(&deadline)
+		if started := fsm.aggr != nil; started != peerNAT.Hard() {
+			t.Errorf("peer %s: probing started = %v on the first tick", peerNAT, started)
+		}
+		fsm.teardown()
+	}
+}
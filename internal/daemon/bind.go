@@ -0,0 +1,70 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// --bind-iface and --local-ip pin the outer path, and that takes more than
+// the kernel tunnel: the disco socket that pings and STUNs, the raw FOU
+// sockets that prove the data path, and the --aggressive-punch sockets all
+// have to leave the same way, or they punch and measure a path the tunnel
+// never uses.
+
+// listenBound opens a packet socket on network and address, bound to dev
+// with SO_BINDTODEVICE if dev is set. The device is bound before the
+// address, so nothing from another interface is queued in between.
+func listenBound(network, address, dev string) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: bindDevice(dev)}
+	return lc.ListenPacket(context.Background(), network, address)
+}
+
+// bindHost is the host part to bind a socket of the given family to:
+// local if it is of that family, else any address.
+func bindHost(local netip.Addr, v6 bool) string {
+	if local.IsValid() && local.Is6() == v6 {
+		return local.String()
+	}
+	return ""
+}
+
+// bindDevice is a net.ListenConfig Control that binds the socket to dev,
+// or nil when dev is empty.
+func bindDevice(dev string) func(network, address string, c syscall.RawConn) error {
+	if dev == "" {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) { serr = unix.BindToDevice(int(fd), dev) }); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// boundAddrs is localAddrs narrowed to what a bound socket can be reached
+// on: --local-ip alone, or the addresses of --bind-iface.
+func boundAddrs(dev string, local netip.Addr) []netip.Addr {
+	if local.IsValid() {
+		return []netip.Addr{local}
+	}
+	if dev == "" {
+		return localAddrs()
+	}
+	ifi, err := net.InterfaceByName(dev)
+	if err != nil {
+		return nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	return globalAddrs(addrs)
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package daemon
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// boundDevice reads SO_BINDTODEVICE back off c.
+func boundDevice(t *testing.T, c syscall.Conn) string {
+	t.Helper()
+	sc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dev string
+	var gerr error
+	if err := sc.Control(func(fd uintptr) {
+		dev, gerr = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	return dev
+}
+
+func TestListenBound_DeviceAndAddress(t *testing.T) {
+	local := netip.MustParseAddr("127.0.0.1")
+	c, err := listenBound("udp", net.JoinHostPort(bindHost(local, false), "0"), "lo")
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("no CAP_NET_RAW; skipping")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if dev := boundDevice(t, c.(*net.UDPConn)); dev != "lo" {
+		t.Errorf("bound to device %q, want lo", dev)
+	}
+	if got := c.LocalAddr().(*net.UDPAddr).AddrPort().Addr(); got != local {
+		t.Errorf("bound to %v, want %v", got, local)
+	}
+
+	free, err := listenBound("udp4", ":0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer free.Close()
+	if dev := boundDevice(t, free.(*net.UDPConn)); dev != "" {
+		t.Errorf("unpinned socket bound to device %q", dev)
+	}
+}
+
+func TestListenFOU_Bound(t *testing.T) {
+	local := netip.MustParseAddr("127.0.0.1")
+	fc, err := listenFOU(7777, false, "lo", local)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("no CAP_NET_RAW; skipping")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fc.Close()
+	if dev := boundDevice(t, fc.IPConn); dev != "lo" {
+		t.Errorf("bound to device %q, want lo", dev)
+	}
+	if got := fc.LocalAddr().(*net.UDPAddr).AddrPort(); got != netip.AddrPortFrom(local, 7777) {
+		t.Errorf("LocalAddr = %v, want %v", got, netip.AddrPortFrom(local, 7777))
+	}
+
+	// An IPv4 --local-ip doesn't pin the IPv6 socket's address.
+	fc6, err := listenFOU(7777, true, "lo", local)
+	if err != nil {
+		t.Skipf("no IPv6: %v", err)
+	}
+	defer fc6.Close()
+	if got := fc6.LocalAddr().(*net.UDPAddr).IP; !got.Equal(net.IPv6unspecified) {
+		t.Errorf("IPv6 socket bound to %v, want ::", got)
+	}
+}
+
+func TestBoundAddrs(t *testing.T) {
+	local := netip.MustParseAddr("192.0.2.7")
+	if got := boundAddrs("lo", local); len(got) != 1 || got[0] != local {
+		t.Errorf("with --local-ip: %v, want just %v", got, local)
+	}
+	if got := boundAddrs("lo", netip.Addr{}); len(got) != 0 {
+		t.Errorf("on lo: %v, want no global addresses", got)
+	}
+	if got := boundAddrs("no-such-iface0", netip.Addr{}); len(got) != 0 {
+		t.Errorf("on a missing interface: %v, want none", got)
+	}
+}
@@ -0,0 +1,339 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultControlSocket is where `gretun up` serves its control API.
+const DefaultControlSocket = "/run/gretun.sock"
+
+// controlPingTimeout is how long a control-socket ping waits for a pong
+// unless the request says otherwise.
+const controlPingTimeout = 5 * time.Second
+
+// Status is the body returned by GET /v1/status.
+type Status struct {
+	NodeName    string                 `json:"node_name"`
+	NodeKey     string                 `json:"node_key"`
+	TunnelIP    netip.Addr             `json:"tunnel_ip"`
+	Coordinator string                 `json:"coordinator"`
+	DiscoAddr   string                 `json:"disco_addr"`
+	FOUPort     uint16                 `json:"fou_port"`
+	NAT         disco.NATType          `json:"nat,omitempty"`
+	Endpoints   []disco.RemoteEndpoint `json:"endpoints"` // as last posted
+	Peers       int                    `json:"peers"`
+	Started     time.Time              `json:"started"`
+}
+
+// PeerStatus is one peer in the body returned by GET /v1/peers.
+type PeerStatus struct {
+	Name     string     `json:"name"`
+	NodeKey  string     `json:"node_key"`
+	TunnelIP netip.Addr `json:"tunnel_ip"`
+	State    string     `json:"state"`
+	Since    time.Time  `json:"since"`
+	// Iface and Remote describe the tunnel, once there is one; Remote is
+	// the relay shim while Relayed.
+	Iface     string        `json:"iface,omitempty"`
+	Remote    string        `json:"remote,omitempty"`
+	Relayed   bool          `json:"relayed,omitempty"`
+	FOUProven bool          `json:"fou_proven,omitempty"`
+	Endpoints []string      `json:"endpoints"` // candidates pinged while punching
+	NAT       disco.NATType `json:"nat,omitempty"`
+	LastPong  time.Time     `json:"last_pong,omitempty"`
+	RTTMillis float64       `json:"rtt_ms,omitempty"` // of the last answered ping
+}
+
+// PeersResp is the body returned by GET /v1/peers.
+type PeersResp struct {
+	Peers []PeerStatus `json:"peers"`
+}
+
+// PingResp is the body returned by POST /v1/peers/{peer}/ping.
+type PingResp struct {
+	Peer      string  `json:"peer"`
+	From      string  `json:"from"` // where the pong came from
+	RTTMillis float64 `json:"rtt_ms"`
+}
+
+// serveControl serves the control API on a Unix socket at path until ctx
+// is done. The socket is mode 0600 and connections from anyone but root
+// or the daemon's own user are dropped.
+func (d *Daemon) serveControl(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// A socket file left by a killed daemon blocks the bind. Only remove it
+	// if nothing answers there.
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("%s: another daemon is listening", path)
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", d.handleStatus)
+	mux.HandleFunc("GET /v1/peers", d.handlePeers)
+	mux.HandleFunc("POST /v1/peers/{peer}/ping", d.handlePing)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		_ = os.Remove(path)
+	}()
+	go func() {
+		if err := srv.Serve(credListener{ln}); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("control socket", "err", err)
+		}
+	}()
+	slog.Info("control socket listening", "path", path)
+	return nil
+}
+
+// credListener drops connections from users other than root and our own.
+type credListener struct{ net.Listener }
+
+func (l credListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(c)
+		if err == nil && (uid == 0 || uid == uint32(os.Geteuid())) {
+			return c, nil
+		}
+		slog.Warn("control socket: refused connection", "uid", uid, "err", err)
+		c.Close()
+	}
+}
+
+// peerUID is the uid of the process at the other end of a Unix socket.
+func peerUID(c net.Conn) (uint32, error) {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
+
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	st := Status{
+		NodeName:    d.cfg.NodeName,
+		NodeKey:     d.node.B64(),
+		TunnelIP:    d.self,
+		Coordinator: d.client.Coordinator(),
+		FOUPort:     d.cfg.FOUPort,
+		NAT:         d.nat,
+		Endpoints:   append([]disco.RemoteEndpoint{}, d.endpoints...),
+		Peers:       len(d.peers),
+		Started:     d.started,
+	}
+	d.mu.Unlock()
+	if d.discoCn != nil {
+		st.DiscoAddr = d.discoCn.LocalAddr().String()
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (d *Daemon) handlePeers(w http.ResponseWriter, r *http.Request) {
+	out := PeersResp{Peers: []PeerStatus{}}
+	for _, p := range d.peerList() {
+		out.Peers = append(out.Peers, p.status())
+	}
+	sort.Slice(out.Peers, func(i, j int) bool { return out.Peers[i].Name < out.Peers[j].Name })
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (d *Daemon) handlePing(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("peer")
+	p, err := d.findPeer(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	timeout := controlPingTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			http.Error(w, "bad timeout", http.StatusBadRequest)
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	seen, err := p.ping(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+	p.mu.Lock()
+	name := p.peer.Name
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, PingResp{Peer: name, From: seen.from.String(), RTTMillis: millis(seen.rtt)})
+}
+
+func (d *Daemon) peerList() []*peerFSM {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]*peerFSM, 0, len(d.peers))
+	for _, p := range d.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+// findPeer resolves id, a peer name, tunnel IP or base64 node key prefix,
+// to exactly one peer.
+func (d *Daemon) findPeer(id string) (*peerFSM, error) {
+	var found []*peerFSM
+	for _, p := range d.peerList() {
+		p.mu.Lock()
+		name, ip := p.peer.Name, p.peer.TunnelIP
+		key := base64.StdEncoding.EncodeToString(p.peer.NodeKey)
+		p.mu.Unlock()
+		if id == name || id == ip.String() || strings.HasPrefix(key, id) {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no peer %q", id)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%q matches %d peers", id, len(found))
+}
+
+// status snapshots the peer for the control API.
+func (p *peerFSM) status() PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := PeerStatus{
+		Name:      p.peer.Name,
+		NodeKey:   base64.StdEncoding.EncodeToString(p.peer.NodeKey),
+		TunnelIP:  p.peer.TunnelIP,
+		State:     p.state.String(),
+		Since:     p.since,
+		Relayed:   p.relayed,
+		FOUProven: p.fouProven,
+		Endpoints: []string{},
+		NAT:       p.peer.NAT,
+		LastPong:  p.lastPong,
+		RTTMillis: millis(p.rtt),
+	}
+	for _, e := range p.peer.Endpoints {
+		st.Endpoints = append(st.Endpoints, e.Addr.String())
+	}
+	if p.tunnelUp {
+		st.Iface = p.deps.ifaceName
+		switch {
+		case p.relayed:
+			st.Remote = "relay"
+		case p.fouProven:
+			st.Remote = p.fouPeer.String()
+		case p.winning.IsValid():
+			st.Remote = p.winning.String()
+		}
+	}
+	return st
+}
+
+// ping sends a disco ping along the peer's current path, or to all of its
+// endpoints if it has none, and waits for the first pong.
+func (p *peerFSM) ping(ctx context.Context) (pongSeen, error) {
+	w := &pongWaiter{since: time.Now(), ch: make(chan pongSeen, 1)}
+	p.mu.Lock()
+	p.waiters = append(p.waiters, w)
+	state, winning, via := p.state, p.winning, p.winConn
+	fouPeer, proven := p.fouPeer, p.fouProven
+	eps := append([]disco.RemoteEndpoint(nil), p.peer.Endpoints...)
+	name := p.peer.Name
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		for i, o := range p.waiters {
+			if o == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+	}()
+
+	switch {
+	case state == stateDirect && proven:
+		p.sendFOUPing(fouPeer)
+	case state == stateDirect:
+		p.sendPing(via, winning)
+	case len(eps) == 0:
+		return pongSeen{}, errors.New("peer has no endpoints to ping")
+	default:
+		for _, e := range eps {
+			p.sendPing(nil, e.Addr)
+		}
+	}
+	select {
+	case seen := <-w.ch:
+		return seen, nil
+	case <-ctx.Done():
+		return pongSeen{}, fmt.Errorf("no pong from %s: %w", name, ctx.Err())
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, "marshal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf)
+}
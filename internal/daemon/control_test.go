@@ -0,0 +1,99 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+// chanConn hands the FSM's writes to the test as they happen.
+type chanConn struct {
+	net.PacketConn
+	sent chan sentPacket
+}
+
+func (c *chanConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.sent <- sentPacket{addr.(*net.UDPAddr).AddrPort(), append([]byte(nil), b...)}
+	return len(b), nil
+}
+
+func TestControlSocket(t *testing.T) {
+	nk, _ := disco.GenerateNodeKey()
+	self, _ := disco.GenerateDiscoKey()
+	peerKey, _ := disco.GenerateDiscoKey()
+	d := New(Config{Coordinators: []string{"http://coord.test"}, NodeName: "a", FOUPort: 7777}, nil, nk, self)
+	d.self = netip.MustParseAddr("100.64.0.1")
+	d.endpoints = []disco.RemoteEndpoint{{Addr: netip.MustParseAddrPort("203.0.113.1:41641"), Source: "stun"}}
+
+	winning := netip.MustParseAddrPort("203.0.113.5:41641")
+	conn := &chanConn{sent: make(chan sentPacket, 4)}
+	fsm := newPeerFSM(peerDeps{self: self, selfNode: nk, discoCn: conn}, disco.RemotePeer{
+		Name:      "b",
+		DiscoKey:  peerKey.Pub,
+		TunnelIP:  netip.MustParseAddr("100.64.0.2"),
+		Endpoints: []disco.RemoteEndpoint{{Addr: winning, Source: "stun"}},
+	})
+	fsm.setState(stateDirect)
+	fsm.winning = winning
+	d.peers[peerKey.Pub] = fsm
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sock := filepath.Join(t.TempDir(), "gretun.sock")
+	if err := d.serveControl(ctx, sock); err != nil {
+		t.Fatal(err)
+	}
+	c := NewControlClient(sock)
+
+	st, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NodeName != "a" || st.TunnelIP != d.self || st.Peers != 1 || len(st.Endpoints) != 1 || st.Coordinator != "http://coord.test" {
+		t.Errorf("status = %+v", st)
+	}
+
+	peers, err := c.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].Name != "b" || peers[0].State != "direct" || peers[0].Since.IsZero() {
+		t.Fatalf("peers = %+v", peers)
+	}
+
+	// Answer the ping the daemon sends down the direct path.
+	go func() {
+		pkt := <-conn.sent
+		_, body, err := disco.OpenEnvelope(pkt.env, peerKey)
+		if err != nil || body.Type != disco.MsgPing || pkt.to != winning {
+			return
+		}
+		deadline := time.Now()
+		fsm.onDiscoUDP(conn, winning, disco.Body{Type: disco.MsgPong, Tx: body.Tx}, &deadline)
+	}()
+	res, err := c.Ping(ctx, "100.64.0.2", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Peer != "b" || res.From != winning.String() {
+		t.Errorf("ping = %+v", res)
+	}
+	if peers, _ := c.Peers(ctx); peers[0].RTTMillis == 0 {
+		t.Error("rtt not recorded from the answered ping")
+	}
+
+	if _, err := c.Ping(ctx, "c", 0); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("ping of an unknown peer: err = %v, want a 404", err)
+	}
+	if _, err := c.Ping(ctx, "b", 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "504") {
+		t.Errorf("unanswered ping: err = %v, want a 504", err)
+	}
+}
@@ -0,0 +1,80 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ControlClient talks to a running daemon over its control socket.
+type ControlClient struct {
+	http *http.Client
+}
+
+// NewControlClient constructs a client for the daemon serving path.
+func NewControlClient(path string) *ControlClient {
+	var d net.Dialer
+	return &ControlClient{http: &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// Status describes the daemon itself.
+func (c *ControlClient) Status(ctx context.Context) (Status, error) {
+	var out Status
+	err := c.do(ctx, "GET", "/v1/status", &out)
+	return out, err
+}
+
+// Peers lists the daemon's peers by name.
+func (c *ControlClient) Peers(ctx context.Context) ([]PeerStatus, error) {
+	var out PeersResp
+	if err := c.do(ctx, "GET", "/v1/peers", &out); err != nil {
+		return nil, err
+	}
+	return out.Peers, nil
+}
+
+// Ping sends a disco ping to the peer identified by id (name, tunnel IP,
+// or node key prefix) and has the daemon wait up to timeout for its pong.
+// Zero means the daemon's default.
+func (c *ControlClient) Ping(ctx context.Context, id string, timeout time.Duration) (PingResp, error) {
+	path := "/v1/peers/" + url.PathEscape(id) + "/ping"
+	if timeout > 0 {
+		path += "?timeout=" + timeout.String()
+	}
+	var out PingResp
+	err := c.do(ctx, "POST", path, &out)
+	return out, err
+}
+
+func (c *ControlClient) do(ctx context.Context, method, path string, out any) error {
+	// The host is ignored; every request goes to the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://gretun"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
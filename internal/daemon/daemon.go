@@ -7,6 +7,7 @@ package daemon
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
@@ -26,15 +27,29 @@ import (
 
 // Config configures a daemon instance.
 type Config struct {
-	Coordinator string
-	NodeName    string
-	StateDir    string
-	Iface       string // printf pattern with a single %d, e.g. "gretun%d"
-	FOUPort     uint16
-	DiscoAddr   string // UDP address to bind the disco socket on (e.g. ":0")
-	STUNServers []string
-	Aggressive  bool
-	MetricsAddr string // if non-empty, expose Prometheus /metrics here
+	Coordinators []string // coordinator replica URLs; failed over in order
+	NodeName     string
+	AuthKey      string // pre-auth key for first registration, if the coordinator requires one
+	Network      string // coordinator network to join; empty = decided by auth key or coordinator
+	TunnelIP     string // tunnel address to ask the coordinator for; empty = any
+	StateDir     string
+	Iface        string // printf pattern with a single %d, e.g. "gretun%d"
+	FOUPort      uint16
+	DiscoAddr    string // UDP address to bind the disco socket on (e.g. ":0")
+	STUNServers  []string
+	Aggressive   bool
+	PortMap      bool   // ask the gateway to forward the disco and FOU ports
+	MetricsAddr  string // if non-empty, expose Prometheus /metrics here
+	// BindIface and LocalIP override the route lookup that picks each
+	// tunnel's outer device and local address.
+	BindIface string
+	LocalIP   netip.Addr
+	// ControlSocket, if set, is the Unix socket `gretun peers` and
+	// `gretun daemon` talk to.
+	ControlSocket string
+	// CoordPubkey, if set, is the coordinator's netmap signing key; peer
+	// lists it didn't sign are refused.
+	CoordPubkey ed25519.PublicKey
 }
 
 // Daemon is the top-level runtime. One per process.
@@ -44,14 +59,30 @@ type Daemon struct {
 	node    disco.NodeKey
 	disco   disco.DiscoKey
 	client  *disco.CoordClient
+	relay   *relayManager
 	discoCn net.PacketConn
+	fouCn   net.PacketConn // raw socket on the FOU port; nil = unavailable
+	fouCn6  net.PacketConn // the same over IPv6
 	metrics *Metrics
+	nat     disco.NATType // what netcheck last found; guarded by mu
+	portmap *portMapper   // nil = no port mapping; guarded by mu
+	// discoRead is held by whoever reads discoCn: discoReadLoop for each
+	// read, or a STUN round for the whole of it. Otherwise each would eat
+	// the other's packets.
+	discoRead sync.Mutex
+	// netChanged wakes refreshLoop early when our addresses or routes
+	// change.
+	netChanged chan struct{}
 
-	mu       sync.Mutex
-	peers    map[[32]byte]*peerFSM // keyed by remote disco pubkey
-	self     netip.Addr
-	ifaceSeq int
-	fouOwned bool
+	mu        sync.Mutex
+	peers     map[[32]byte]*peerFSM // keyed by remote disco pubkey
+	self      netip.Addr
+	fouOwned  bool
+	dp        dataplaneState           // persisted in StateDir
+	leftover  map[string]tunnel.Status // links from an earlier run, by name; nil once claimed
+	foreign   map[string]bool          // links named by --iface that the state file doesn't record
+	started   time.Time
+	endpoints []disco.RemoteEndpoint // as last posted to the coordinator
 }
 
 // New constructs a daemon. The caller still has to call Run.
@@ -59,24 +90,38 @@ func New(cfg Config, nl tunnel.Netlinker, nk disco.NodeKey, dk disco.DiscoKey) *
 	if cfg.Iface == "" {
 		cfg.Iface = "gretun%d"
 	}
+	client := disco.NewFailoverCoordClient(cfg.Coordinators, nk, dk)
+	if cfg.CoordPubkey != nil {
+		client.PinCoordKey(cfg.CoordPubkey)
+	}
 	return &Daemon{
-		cfg:    cfg,
-		nl:     nl,
-		node:   nk,
-		disco:  dk,
-		client: disco.NewCoordClient(cfg.Coordinator, nk, dk),
-		peers:  make(map[[32]byte]*peerFSM),
+		cfg:        cfg,
+		nl:         nl,
+		node:       nk,
+		disco:      dk,
+		client:     client,
+		peers:      make(map[[32]byte]*peerFSM),
+		netChanged: make(chan struct{}, 1),
 	}
 }
 
 // Run blocks until ctx fires. It brings up the disco socket, registers with
 // the coordinator, and orchestrates per-peer state machines.
 func (d *Daemon) Run(ctx context.Context) error {
+	d.mu.Lock()
+	d.started = time.Now()
+	d.mu.Unlock()
 	addr := d.cfg.DiscoAddr
 	if addr == "" {
 		addr = ":0"
 	}
-	conn, err := net.ListenPacket("udp4", addr)
+	// "udp" with no address binds both families on one port, so IPv4 and
+	// IPv6 candidates share the disco socket. --local-ip fills in an
+	// address left unset.
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" && d.cfg.LocalIP.IsValid() {
+		addr = net.JoinHostPort(d.cfg.LocalIP.String(), port)
+	}
+	conn, err := listenBound("udp", addr, d.cfg.BindIface)
 	if err != nil {
 		return fmt.Errorf("disco listen: %w", err)
 	}
@@ -86,33 +131,87 @@ func (d *Daemon) Run(ctx context.Context) error {
 	local := conn.LocalAddr().(*net.UDPAddr)
 	slog.Info("disco socket bound", "addr", local.String())
 
+	// A FOU port that a killed run created is still ours to remove; one
+	// on a port we no longer use is only in the way.
+	d.loadDataplane()
+	prev, prev6 := d.dp.FOUPort, d.dp.FOU6
+	if prev != 0 && prev != d.cfg.FOUPort {
+		slog.Info("removing FOU port left by an earlier run", "port", prev)
+		tunnel.RemoveFOU(d.nl, prev)
+		if prev6 {
+			tunnel.RemoveFOU6(d.nl, prev)
+		}
+		prev, prev6 = 0, false
+	}
+
 	created, err := tunnel.EnsureFOU(d.nl, d.cfg.FOUPort, tunnel.EncapFOU)
 	if err != nil {
 		return fmt.Errorf("FOU setup: %w", err)
 	}
-	d.fouOwned = created
+	d.fouOwned = created || prev != 0
+	// IPv6 data arrives on a FOU port of its own. Without one, peers are
+	// only reached over IPv4.
+	owned6, fou6 := false, false
+	if created6, err := tunnel.EnsureFOU6(d.nl, d.cfg.FOUPort, tunnel.EncapFOU); err != nil {
+		slog.Warn("IPv6 FOU setup failed; IPv6 paths won't carry data", "err", err)
+	} else {
+		owned6, fou6 = created6 || prev6, true
+	}
+	if d.fouOwned {
+		d.dp.FOUPort, d.dp.FOU6 = d.cfg.FOUPort, owned6
+	} else {
+		d.dp.FOUPort, d.dp.FOU6 = 0, false
+	}
+	d.saveDataplane()
 	defer func() {
+		if owned6 {
+			tunnel.RemoveFOU6(d.nl, d.cfg.FOUPort)
+		}
 		if d.fouOwned {
 			tunnel.RemoveFOU(d.nl, d.cfg.FOUPort)
 		}
+		d.mu.Lock()
+		d.dp.FOUPort, d.dp.FOU6 = 0, false
+		d.saveDataplane()
+		d.mu.Unlock()
 	}()
 
+	if fc, err := listenFOU(d.cfg.FOUPort, false, d.cfg.BindIface, d.cfg.LocalIP); err != nil {
+		slog.Warn("cannot ping from the FOU port; trusting the disco path", "err", err)
+	} else {
+		d.fouCn = fc
+		defer fc.Close()
+	}
+	if fou6 {
+		if fc, err := listenFOU(d.cfg.FOUPort, true, d.cfg.BindIface, d.cfg.LocalIP); err != nil {
+			slog.Warn("cannot ping from the IPv6 FOU port; trusting the disco path", "err", err)
+		} else {
+			d.fouCn6 = fc
+			defer fc.Close()
+		}
+	}
+
+	if d.cfg.PortMap {
+		d.swapPortMapper(newPortMapper())
+		defer d.swapPortMapper(nil)
+	}
+
 	endpoints, err := d.collectEndpoints(ctx, local.Port)
 	if err != nil {
 		slog.Warn("endpoint collection partially failed", "err", err)
 	}
 
-	cidr, _, err := d.client.Register(ctx, d.cfg.NodeName)
-	if err != nil {
-		return fmt.Errorf("register: %w", err)
-	}
-	slog.Info("registered", "coord", d.cfg.Coordinator, "tunnel_cidr", cidr)
-	if prefix, err := netip.ParsePrefix(cidr); err == nil {
-		d.self = prefix.Addr()
+	if err := d.register(ctx); err != nil {
+		return err
 	}
+	// Our tunnel address is known now, which adopting a link needs.
+	d.recoverLinks(ctx)
+	nat := d.measureNAT(ctx)
 
-	if err := d.client.PostEndpoints(ctx, endpoints); err != nil {
+	if err := d.client.PostEndpoints(ctx, endpoints, nat); err != nil {
 		slog.Warn("post endpoints failed", "err", err)
+	} else {
+		d.setEndpoints(endpoints)
 	}
 
 	if d.cfg.MetricsAddr != "" {
@@ -140,11 +239,25 @@ func (d *Daemon) Run(ctx context.Context) error {
 		slog.Info("metrics listening", "addr", d.cfg.MetricsAddr)
 	}
 
+	if d.cfg.ControlSocket != "" {
+		if err := d.serveControl(ctx, d.cfg.ControlSocket); err != nil {
+			slog.Warn("control socket unavailable", "path", d.cfg.ControlSocket, "err", err)
+		}
+	}
+
+	d.relay = newRelayManager(d.client, d.cfg.FOUPort)
+	go d.relay.run(ctx)
+
 	errs := make(chan error, 4)
 	go d.discoReadLoop(ctx, errs)
-	go d.signalPullLoop(ctx, errs)
-	go d.peersPollLoop(ctx, errs)
+	for _, fc := range []net.PacketConn{d.fouCn, d.fouCn6} {
+		if fc != nil {
+			go d.fouReadLoop(ctx, fc)
+		}
+	}
+	go d.coordLoop(ctx)
 	go d.refreshLoop(ctx, local.Port, errs)
+	go d.netmonLoop(ctx)
 	go d.metricsUpdateLoop(ctx)
 
 	select {
@@ -159,45 +272,147 @@ func (d *Daemon) Run(ctx context.Context) error {
 	}
 }
 
+// register (re-)registers with the coordinator and records our tunnel IP.
+func (d *Daemon) register(ctx context.Context) error {
+	cidr, _, err := d.client.Register(ctx, disco.RegisterOptions{
+		Name:     d.cfg.NodeName,
+		AuthKey:  d.cfg.AuthKey,
+		Network:  d.cfg.Network,
+		TunnelIP: d.cfg.TunnelIP,
+	})
+	if err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	slog.Info("registered", "coord", d.client.Coordinator(), "tunnel_cidr", cidr)
+	if prefix, err := netip.ParsePrefix(cidr); err == nil {
+		if want, err := netip.ParseAddr(d.cfg.TunnelIP); err == nil && want != prefix.Addr() {
+			slog.Warn("coordinator did not grant requested tunnel IP", "requested", want, "got", prefix.Addr())
+		}
+		d.mu.Lock()
+		d.self = prefix.Addr()
+		d.mu.Unlock()
+	}
+	return nil
+}
+
 func (d *Daemon) collectEndpoints(ctx context.Context, port int) ([]disco.RemoteEndpoint, error) {
 	eps := make([]disco.RemoteEndpoint, 0, 8)
+	dualStack := d.discoCn.LocalAddr().(*net.UDPAddr).IP.To4() == nil
 
-	ifAddrs, _ := net.InterfaceAddrs()
-	for _, a := range ifAddrs {
-		ipn, ok := a.(*net.IPNet)
-		if !ok {
+	for _, ip := range boundAddrs(d.cfg.BindIface, d.cfg.LocalIP) {
+		if ip.Is6() && !dualStack {
 			continue
 		}
-		v4 := ipn.IP.To4()
-		if v4 == nil || v4.IsLoopback() || !v4.IsGlobalUnicast() {
-			continue
-		}
-		if ap, ok := netip.AddrFromSlice(v4); ok {
-			eps = append(eps, disco.RemoteEndpoint{
-				Addr:   netip.AddrPortFrom(ap, uint16(port)),
-				Source: "local",
-			})
+		eps = append(eps, disco.RemoteEndpoint{
+			Addr:   netip.AddrPortFrom(ip, uint16(port)),
+			Source: "local",
+		})
+	}
+
+	// A gateway forward makes punching unnecessary for whoever reaches it.
+	// The FOU port's mapping keeps the data path open but isn't advertised:
+	// peers only ever ping disco endpoints.
+	d.mu.Lock()
+	pm := d.portmap
+	d.mu.Unlock()
+	if pm != nil {
+		pm.refresh(ctx, uint16(port), d.cfg.FOUPort)
+		if ext, ok := pm.external(uint16(port)); ok {
+			eps = append(eps, disco.RemoteEndpoint{Addr: ext, Source: "portmap"})
 		}
 	}
 
+	d.discoRead.Lock()
+	defer d.discoRead.Unlock()
 	stunCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	if pub, err := disco.DiscoverPublic(stunCtx, d.discoCn, d.cfg.STUNServers); err == nil {
+	pub, stunErr := disco.DiscoverPublic(stunCtx, d.discoCn, d.stunServers())
+	if stunErr == nil {
 		eps = append(eps, disco.RemoteEndpoint{Addr: pub.Addr, Source: "stun"})
-	} else {
-		return eps, err
 	}
-	return eps, nil
+	if dualStack && firstGlobalV6().IsValid() {
+		// An IPv6 host usually gets its own address back, already listed;
+		// anything else is a translator worth advertising.
+		stun6Ctx, cancel6 := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel6()
+		if pub, err := disco.DiscoverPublic6(stun6Ctx, d.discoCn, d.stunServers()); err == nil && !hasEndpoint(eps, pub.Addr) {
+			eps = append(eps, disco.RemoteEndpoint{Addr: pub.Addr, Source: "stun"})
+		}
+	}
+	return eps, stunErr
+}
+
+func hasEndpoint(eps []disco.RemoteEndpoint, ap netip.AddrPort) bool {
+	for _, e := range eps {
+		if e.Addr == ap {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Daemon) setEndpoints(eps []disco.RemoteEndpoint) {
+	d.mu.Lock()
+	d.endpoints = eps
+	d.mu.Unlock()
+}
+
+// stunServers is --stun-server or, without it, the coordinator's own STUN
+// server; the public defaults may be unreachable from here.
+func (d *Daemon) stunServers() []string {
+	if len(d.cfg.STUNServers) > 0 {
+		return d.cfg.STUNServers
+	}
+	return d.client.STUNServers()
+}
+
+// measureNAT runs netcheck and hands the result to d.nat and to every
+// peer's state machine. Run calls it at startup, and refreshLoop again
+// after each network change, which may well put us behind another NAT.
+func (d *Daemon) measureNAT(ctx context.Context) disco.NATType {
+	nat := d.netcheck(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nat = nat
+	for _, p := range d.peers {
+		p.setSelfNAT(nat)
+	}
+	return nat
+}
+
+// netcheck classifies our NAT from the disco socket, which it keeps from
+// discoReadLoop meanwhile. The result goes out with every endpoint post
+// so peers can pick a punch strategy. Telling NAT types apart takes two
+// server IPs, so the public defaults make up the numbers.
+func (d *Daemon) netcheck(ctx context.Context) disco.NATType {
+	servers := d.stunServers()
+	if len(servers) < 2 {
+		servers = append(append([]string(nil), servers...), disco.DefaultSTUNServers...)
+	}
+	d.discoRead.Lock()
+	defer d.discoRead.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rep, err := disco.Netcheck(ctx, d.discoCn, servers)
+	if err != nil {
+		slog.Warn("netcheck failed", "err", err)
+		return disco.NATUnknown
+	}
+	slog.Info("netcheck", "nat", rep.NAT, "port_preserved", rep.PortPreserved, "hairpin", rep.Hairpin)
+	return rep.NAT
 }
 
 func (d *Daemon) discoReadLoop(ctx context.Context, errs chan<- error) {
 	buf := make([]byte, 2048)
 	for {
+		d.discoRead.Lock()
 		if err := d.discoCn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			d.discoRead.Unlock()
 			errs <- err
 			return
 		}
 		n, from, err := d.discoCn.ReadFrom(buf)
+		d.discoRead.Unlock()
 		if ctx.Err() != nil {
 			return
 		}
@@ -224,47 +439,67 @@ func (d *Daemon) discoReadLoop(ctx context.Context, errs chan<- error) {
 	}
 }
 
-func (d *Daemon) signalPullLoop(ctx context.Context, errs chan<- error) {
-	for ctx.Err() == nil {
-		sealedEnvs, err := d.client.PullSignals(ctx)
+// fouReadLoop hands the peers disco messages that arrive on fc, one of the
+// FOU-port sockets. Only FOU-port pings and pongs are expected there. A
+// read error just ends the loop: peers then stay unproven and fall back
+// to the relay.
+func (d *Daemon) fouReadLoop(ctx context.Context, fc net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		if err := fc.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			slog.Warn("fou read", "err", err)
+			return
+		}
+		n, from, err := fc.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			if ctx.Err() != nil {
-				return
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
 			}
-			slog.Warn("pull signals", "err", err)
-			time.Sleep(2 * time.Second)
+			slog.Warn("fou read", "err", err)
+			return
+		}
+		sender, body, err := disco.OpenEnvelope(buf[:n], d.disco)
+		if err != nil || !body.FOU {
 			continue
 		}
-		for _, s := range sealedEnvs {
-			sender, body, err := disco.OpenEnvelope(s, d.disco)
-			if err != nil {
-				continue
-			}
-			d.mu.Lock()
-			p := d.peers[sender]
-			d.mu.Unlock()
-			if p == nil {
-				continue
-			}
-			p.onSignal(body)
+		fromAddr := from.(*net.UDPAddr)
+		fromAP := netip.AddrPortFrom(mustAddrFromIP(fromAddr.IP), uint16(fromAddr.Port))
+		d.mu.Lock()
+		p := d.peers[sender]
+		d.mu.Unlock()
+		if p == nil {
+			continue
 		}
+		p.onUDPVia(fc, fromAP, body)
 	}
 }
 
-func (d *Daemon) peersPollLoop(ctx context.Context, errs chan<- error) {
-	var etag string
-	for ctx.Err() == nil {
-		peers, newEtag, err := d.client.Peers(ctx, etag)
+// coordLoop follows the coordinator's view of our peers and relays
+// envelopes to their state machines, over a stream when the coordinator
+// has one and long-polls otherwise.
+func (d *Daemon) coordLoop(ctx context.Context) {
+	_ = d.client.Watch(ctx, disco.WatchHandlers{
+		Peers:   func(peers []disco.RemotePeer) { d.reconcilePeers(ctx, peers) },
+		Signals: d.deliverSignals,
+	})
+}
+
+func (d *Daemon) deliverSignals(sealedEnvs [][]byte) {
+	for _, s := range sealedEnvs {
+		sender, body, err := disco.OpenEnvelope(s, d.disco)
 		if err != nil {
-			if ctx.Err() != nil {
-				return
-			}
-			slog.Warn("peers poll", "err", err)
-			time.Sleep(2 * time.Second)
 			continue
 		}
-		etag = newEtag
-		d.reconcilePeers(ctx, peers)
+		d.mu.Lock()
+		p := d.peers[sender]
+		d.mu.Unlock()
+		if p == nil {
+			continue
+		}
+		p.onSignal(body)
 	}
 }
 
@@ -275,23 +510,75 @@ func (d *Daemon) refreshLoop(ctx context.Context, discoPort int, errs chan<- err
 		select {
 		case <-ctx.Done():
 			return
-		case <-tick.C:
-			eps, err := d.collectEndpoints(ctx, discoPort)
-			if err != nil {
-				slog.Warn("endpoint refresh", "err", err)
-				continue
-			}
-			if err := d.client.PostEndpoints(ctx, eps); err != nil {
-				slog.Warn("endpoint repost", "err", err)
+		case <-d.netChanged:
+			tick.Reset(25 * time.Second)
+			if d.cfg.PortMap {
+				d.swapPortMapper(newPortMapper())
 			}
+			d.measureNAT(ctx)
+		case <-tick.C:
 		}
+		d.repostEndpoints(ctx, discoPort)
 	}
 }
 
+// swapPortMapper installs m, which may be nil, and releases the mappings
+// the one it replaces held. After a network change the old gateway may be
+// gone or forward to an address we no longer have, so its mappings are
+// dropped rather than renewed, and the gateway is looked up again.
+func (d *Daemon) swapPortMapper(m *portMapper) {
+	d.mu.Lock()
+	old := d.portmap
+	d.portmap = m
+	d.mu.Unlock()
+	if old != nil {
+		old.release()
+	}
+}
+
+// repostEndpoints collects our endpoints afresh and posts them to the
+// coordinator, registering again if it has forgotten us.
+func (d *Daemon) repostEndpoints(ctx context.Context, discoPort int) {
+	eps, err := d.collectEndpoints(ctx, discoPort)
+	if err != nil {
+		slog.Warn("endpoint refresh", "err", err)
+		return
+	}
+	d.mu.Lock()
+	nat := d.nat
+	d.mu.Unlock()
+	err = d.client.PostEndpoints(ctx, eps, nat)
+	if errors.Is(err, disco.ErrNotRegistered) {
+		slog.Warn("coordinator forgot us; re-registering")
+		if err = d.register(ctx); err == nil {
+			err = d.client.PostEndpoints(ctx, eps, nat)
+		}
+	}
+	if err != nil {
+		slog.Warn("endpoint repost", "err", err)
+		return
+	}
+	d.setEndpoints(eps)
+}
+
 func (d *Daemon) reconcilePeers(ctx context.Context, peers []disco.RemotePeer) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	// An admin can move us to a different tunnel IP. Every tunnel carries
+	// the old address, so rebuild them all.
+	for _, p := range peers {
+		if p.DiscoKey == d.disco.Pub && p.TunnelIP.IsValid() && p.TunnelIP != d.self {
+			slog.Info("coordinator reassigned our tunnel IP", "from", d.self, "to", p.TunnelIP)
+			d.self = p.TunnelIP
+			for k, fsm := range d.peers {
+				fsm.stop()
+				fsm.wait()
+				delete(d.peers, k)
+			}
+		}
+	}
+
 	seen := make(map[[32]byte]bool, len(peers))
 	for _, p := range peers {
 		if p.DiscoKey == d.disco.Pub {
@@ -300,20 +587,25 @@ func (d *Daemon) reconcilePeers(ctx context.Context, peers []disco.RemotePeer) {
 		seen[p.DiscoKey] = true
 		fsm, ok := d.peers[p.DiscoKey]
 		if !ok {
-			ifname := fmt.Sprintf(d.cfg.Iface, d.ifaceSeq)
-			d.ifaceSeq++
 			fsm = newPeerFSM(peerDeps{
 				self:       d.disco,
 				selfNode:   d.node,
-				ifaceName:  ifname,
+				ifaceName:  d.ifaceFor(p.DiscoKey),
 				fouPort:    d.cfg.FOUPort,
 				selfTunnel: d.self,
 				nl:         d.nl,
 				discoCn:    d.discoCn,
+				fouCn:      d.fouCn,
+				fouCn6:     d.fouCn6,
 				coord:      d.client,
+				relay:      d.relay,
 				aggressive: d.cfg.Aggressive,
+				selfNAT:    d.nat,
+				bindIface:  d.cfg.BindIface,
+				localIP:    d.cfg.LocalIP,
 				metrics:    d.metrics,
 			}, p)
+			d.adopt(fsm, p)
 			d.peers[p.DiscoKey] = fsm
 			go fsm.run(ctx)
 		} else {
@@ -323,10 +615,16 @@ func (d *Daemon) reconcilePeers(ctx context.Context, peers []disco.RemotePeer) {
 
 	for k, fsm := range d.peers {
 		if !seen[k] {
+			// Its interface name is free once the link is gone.
 			fsm.stop()
+			fsm.wait()
 			delete(d.peers, k)
+			d.forgetIface(k)
 		}
 	}
+	if d.leftover != nil {
+		d.dropUnclaimed(seen)
+	}
 }
 
 // metricsUpdateLoop keeps the peer-state gauge current without plumbing state
@@ -359,11 +657,15 @@ func (d *Daemon) metricsUpdateLoop(ctx context.Context) {
 	}
 }
 
+// shutdown stops every peer and waits for their tunnels to come down.
 func (d *Daemon) shutdown() {
 	d.mu.Lock()
 	for _, p := range d.peers {
 		p.stop()
 	}
+	for _, p := range d.peers {
+		p.wait()
+	}
 	d.peers = nil
 	d.mu.Unlock()
 }
@@ -0,0 +1,238 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/HueCodes/gretun/internal/tunnel"
+)
+
+// dataplaneFile, in StateDir, records what the daemon has put in the
+// kernel, so that a daemon that was killed before it could clean up can
+// pick up where it left off.
+const dataplaneFile = "dataplane.json"
+
+type dataplaneState struct {
+	// Ifaces maps each peer's disco key (base64) to its interface, so a
+	// peer keeps its interface name across restarts.
+	Ifaces map[string]string `json:"ifaces"`
+	// FOUPort is the FOU port the daemon created and still owns, if any;
+	// FOU6 says it created the IPv6 one too.
+	FOUPort uint16 `json:"fou_port,omitempty"`
+	FOU6    bool   `json:"fou6,omitempty"`
+}
+
+// loadDataplane reads the state file. A missing or unreadable file leaves
+// an empty state: there is nothing to recover, or nothing we can trust.
+func (d *Daemon) loadDataplane() {
+	d.dp = dataplaneState{Ifaces: make(map[string]string)}
+	if d.cfg.StateDir == "" {
+		return
+	}
+	path := filepath.Join(d.cfg.StateDir, dataplaneFile)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &d.dp)
+	}
+	if err != nil {
+		slog.Warn("ignoring data plane state", "path", path, "err", err)
+		d.dp = dataplaneState{}
+	}
+	if d.dp.Ifaces == nil {
+		d.dp.Ifaces = make(map[string]string)
+	}
+}
+
+// saveDataplane writes the state file. Callers hold d.mu, or own d.dp
+// before the loops start.
+func (d *Daemon) saveDataplane() {
+	if d.cfg.StateDir == "" {
+		return
+	}
+	path := filepath.Join(d.cfg.StateDir, dataplaneFile)
+	if err := writeFileAtomic(path, d.dp); err != nil {
+		slog.Warn("saving data plane state", "path", path, "err", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to path by way of a synced temp file
+// and a synced directory, so a crash leaves either the old file or the
+// new one, never a torn or empty one.
+func writeFileAtomic(path string, v any) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(buf); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// recoverLinks looks for interfaces an earlier run left behind. Ones the
+// state file records wait in d.leftover for their peer to claim them.
+// Anything else, even if named by our pattern, may belong to another
+// daemon or an operator, so it is left alone and its name kept out of
+// ifaceFor's way. Without a state file nothing is recorded, and so
+// nothing is touched.
+func (d *Daemon) recoverLinks(ctx context.Context) {
+	d.leftover = make(map[string]tunnel.Status)
+	d.foreign = make(map[string]bool)
+	links, err := tunnel.List(ctx, d.nl)
+	if err != nil {
+		slog.Warn("listing tunnels left by an earlier run", "err", err)
+		return
+	}
+	owned := make(map[string]bool, len(d.dp.Ifaces))
+	for _, name := range d.dp.Ifaces {
+		owned[name] = true
+	}
+	for _, l := range links {
+		if !d.ourIface(l.Name) {
+			continue
+		}
+		if owned[l.Name] {
+			d.leftover[l.Name] = l
+			continue
+		}
+		slog.Info("leaving unrecorded tunnel alone", "iface", l.Name, "remote", l.RemoteIP)
+		d.foreign[l.Name] = true
+	}
+}
+
+// ourIface reports whether name is one --iface generates.
+func (d *Daemon) ourIface(name string) bool {
+	var n int
+	if _, err := fmt.Sscanf(name, d.cfg.Iface, &n); err != nil {
+		return false
+	}
+	return fmt.Sprintf(d.cfg.Iface, n) == name
+}
+
+// ifaceFor returns the interface for the peer with disco key key: the one
+// it had before, in this run or an earlier one, or else the lowest name
+// nobody holds, skipping links recoverLinks found but doesn't own. Callers hold d.mu.
+func (d *Daemon) ifaceFor(key [32]byte) string {
+	k := base64.StdEncoding.EncodeToString(key[:])
+	if name, ok := d.dp.Ifaces[k]; ok {
+		return name
+	}
+	taken := make(map[string]bool, len(d.dp.Ifaces)+len(d.foreign))
+	for name := range d.foreign {
+		taken[name] = true
+	}
+	for _, name := range d.dp.Ifaces {
+		taken[name] = true
+	}
+	name := ""
+	for n := 0; ; n++ {
+		if name = fmt.Sprintf(d.cfg.Iface, n); !taken[name] {
+			break
+		}
+	}
+	d.dp.Ifaces[k] = name
+	d.saveDataplane()
+	return name
+}
+
+// forgetIface releases the peer's interface name. Callers hold d.mu.
+func (d *Daemon) forgetIface(key [32]byte) {
+	delete(d.dp.Ifaces, base64.StdEncoding.EncodeToString(key[:]))
+	d.saveDataplane()
+}
+
+// adopt hands fsm the link an earlier run left under its interface name,
+// if the link still carries our tunnel address and points at one of the
+// peer's endpoints. Traffic then keeps flowing while the peer is punched
+// again, and the first path found retargets the link in place. A link
+// that doesn't match is deleted. Callers hold d.mu.
+func (d *Daemon) adopt(fsm *peerFSM, peer disco.RemotePeer) {
+	name := fsm.deps.ifaceName
+	l, ok := d.leftover[name]
+	if !ok {
+		return
+	}
+	delete(d.leftover, name)
+	remote, _ := netip.ParseAddr(l.RemoteIP)
+	if adoptable(l, remote, peer, d.self) {
+		fsm.tunnelUp = true
+		fsm.outer6 = remote.Is6()
+		fsm.remote = netip.AddrPortFrom(remote, l.EncapDport)
+		slog.Info("adopted tunnel from an earlier run", "iface", name, "peer", peer.Name, "remote", remote)
+		return
+	}
+	slog.Info("deleting stale tunnel from an earlier run", "iface", name, "peer", peer.Name, "remote", l.RemoteIP)
+	if err := tunnel.Delete(context.Background(), d.nl, name); err != nil {
+		slog.Warn("stale tunnel delete", "iface", name, "err", err)
+	}
+}
+
+func adoptable(l tunnel.Status, remote netip.Addr, peer disco.RemotePeer, self netip.Addr) bool {
+	if inner, err := netip.ParsePrefix(l.TunnelIP); err != nil || inner.Addr() != self {
+		return false
+	}
+	for _, e := range peer.Endpoints {
+		if e.Addr.Addr().Unmap() == remote {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnclaimed deletes the links left by an earlier run whose peers are
+// gone, and forgets the names of peers not in seen. It runs once, after
+// the first peer list, when every live peer has had the chance to claim
+// its link. Callers hold d.mu.
+func (d *Daemon) dropUnclaimed(seen map[[32]byte]bool) {
+	for name, l := range d.leftover {
+		slog.Info("deleting tunnel of a departed peer", "iface", name, "remote", l.RemoteIP)
+		if err := tunnel.Delete(context.Background(), d.nl, name); err != nil {
+			slog.Warn("departed peer tunnel delete", "iface", name, "err", err)
+		}
+	}
+	d.leftover = nil
+	for k := range d.dp.Ifaces {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != 32 || !seen[[32]byte(key)] {
+			delete(d.dp.Ifaces, k)
+		}
+	}
+	d.saveDataplane()
+}
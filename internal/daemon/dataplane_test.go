@@ -0,0 +1,153 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/vishvananda/netlink"
+)
+
+func greLink(name, remote string) *netlink.Gretun {
+	return &netlink.Gretun{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		Local:     net.ParseIP("192.0.2.1"),
+		Remote:    net.ParseIP(remote),
+	}
+}
+
+func TestRecoverLinks_AdoptsMatchingSparesUnrecorded(t *testing.T) {
+	keyB, _ := disco.GenerateDiscoKey()
+	keyC, _ := disco.GenerateDiscoKey()
+	keyD, _ := disco.GenerateDiscoKey()
+	b64 := func(k disco.DiscoKey) string { return base64.StdEncoding.EncodeToString(k.Pub[:]) }
+
+	dir := t.TempDir()
+	prev, _ := json.Marshal(dataplaneState{Ifaces: map[string]string{b64(keyB): "gretun3", b64(keyC): "gretun5"}})
+	if err := os.WriteFile(filepath.Join(dir, dataplaneFile), prev, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	inner := func(s string) []netlink.Addr {
+		p := netip.MustParsePrefix(s)
+		return []netlink.Addr{{IPNet: &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), 32)}}}
+	}
+	nl := &fakeNL{
+		links: map[string]netlink.Link{
+			"gretun3": greLink("gretun3", "203.0.113.5"), // b's, still valid
+			"gretun5": greLink("gretun5", "203.0.113.9"), // c's; c is gone
+			"gretun7": greLink("gretun7", "203.0.113.7"), // nobody's
+			"tun0":    greLink("tun0", "198.51.100.1"),   // not ours
+		},
+		addrs: map[string][]netlink.Addr{
+			"gretun3": inner("100.64.0.1/30"),
+			"gretun5": inner("100.64.0.1/30"),
+		},
+	}
+
+	d := New(Config{Coordinators: []string{"http://coord.test"}, StateDir: dir, Iface: "gretun%d"}, nl, disco.NodeKey{}, disco.DiscoKey{})
+	d.self = netip.MustParseAddr("100.64.0.1")
+	d.loadDataplane()
+	d.recoverLinks(context.Background())
+	if _, ok := nl.links["gretun7"]; !ok {
+		t.Error("deleted gretun7, which the state file doesn't record")
+	}
+	if _, ok := nl.links["tun0"]; !ok {
+		t.Error("deleted tun0, which --iface doesn't name")
+	}
+
+	peerB := disco.RemotePeer{Name: "b", DiscoKey: keyB.Pub, Endpoints: []disco.RemoteEndpoint{
+		{Addr: netip.MustParseAddrPort("203.0.113.5:41641"), Source: "stun"},
+	}}
+	peerD := disco.RemotePeer{Name: "d", DiscoKey: keyD.Pub}
+	seen := map[[32]byte]bool{}
+	fsms := map[string]*peerFSM{}
+	for _, p := range []disco.RemotePeer{peerB, peerD} {
+		fsm := newPeerFSM(peerDeps{ifaceName: d.ifaceFor(p.DiscoKey), nl: nl}, p)
+		d.adopt(fsm, p)
+		seen[p.DiscoKey] = true
+		fsms[p.Name] = fsm
+	}
+	d.dropUnclaimed(seen)
+
+	if _, ok := nl.links["gretun7"]; !ok {
+		t.Error("deleted unrecorded gretun7 with the departed peers")
+	}
+	if fsms["b"].deps.ifaceName != "gretun3" || !fsms["b"].tunnelUp {
+		t.Errorf("b got %s (up = %v), want its old gretun3 adopted", fsms["b"].deps.ifaceName, fsms["b"].tunnelUp)
+	}
+	if fsms["d"].deps.ifaceName != "gretun0" || fsms["d"].tunnelUp {
+		t.Errorf("d got %s (up = %v), want the lowest free name", fsms["d"].deps.ifaceName, fsms["d"].tunnelUp)
+	}
+	if _, ok := nl.links["gretun5"]; ok {
+		t.Error("departed peer c's gretun5 survived the first peer list")
+	}
+
+	d.loadDataplane()
+	want := map[string]string{b64(keyB): "gretun3", b64(keyD): "gretun0"}
+	if len(d.dp.Ifaces) != len(want) {
+		t.Fatalf("saved ifaces = %v, want %v", d.dp.Ifaces, want)
+	}
+	for k, v := range want {
+		if d.dp.Ifaces[k] != v {
+			t.Errorf("saved ifaces = %v, want %v", d.dp.Ifaces, want)
+		}
+	}
+}
+
+func TestRecoverLinks_NoStateTouchesNothing(t *testing.T) {
+	for name, dir := range map[string]string{"no state dir": "", "no state file": t.TempDir()} {
+		t.Run(name, func(t *testing.T) {
+			nl := &fakeNL{links: map[string]netlink.Link{
+				"gretun0": greLink("gretun0", "203.0.113.5"),
+				"gretun1": greLink("gretun1", "203.0.113.6"),
+			}}
+			d := New(Config{Coordinators: []string{"http://coord.test"}, StateDir: dir, Iface: "gretun%d"}, nl, disco.NodeKey{}, disco.DiscoKey{})
+			d.loadDataplane()
+			d.recoverLinks(context.Background())
+			key, _ := disco.GenerateDiscoKey()
+			d.mu.Lock()
+			name := d.ifaceFor(key.Pub)
+			d.dropUnclaimed(map[[32]byte]bool{key.Pub: true})
+			d.mu.Unlock()
+			if len(nl.links) != 2 {
+				t.Errorf("links = %v, want both left alone", nl.links)
+			}
+			if name != "gretun2" {
+				t.Errorf("new peer got %s, want gretun2, past the links already there", name)
+			}
+		})
+	}
+}
+
+func TestAdopt_StaleRemoteDeleted(t *testing.T) {
+	key, _ := disco.GenerateDiscoKey()
+	nl := &fakeNL{
+		links: map[string]netlink.Link{"gretun0": greLink("gretun0", "127.0.0.1")}, // a relay shim
+		addrs: map[string][]netlink.Addr{"gretun0": {{IPNet: &net.IPNet{IP: net.ParseIP("100.64.0.1"), Mask: net.CIDRMask(30, 32)}}}},
+	}
+	d := New(Config{Coordinators: []string{"http://coord.test"}}, nl, disco.NodeKey{}, disco.DiscoKey{})
+	d.self = netip.MustParseAddr("100.64.0.1")
+	d.loadDataplane()
+	d.dp.Ifaces[base64.StdEncoding.EncodeToString(key.Pub[:])] = "gretun0"
+	d.recoverLinks(context.Background())
+
+	p := disco.RemotePeer{Name: "b", DiscoKey: key.Pub, Endpoints: []disco.RemoteEndpoint{
+		{Addr: netip.MustParseAddrPort("203.0.113.5:41641"), Source: "stun"},
+	}}
+	fsm := newPeerFSM(peerDeps{ifaceName: d.ifaceFor(key.Pub), nl: nl}, p)
+	d.adopt(fsm, p)
+	if fsm.tunnelUp {
+		t.Error("adopted a link pointing at a dead relay shim")
+	}
+	if _, ok := nl.links["gretun0"]; ok {
+		t.Error("stale link not deleted")
+	}
+}
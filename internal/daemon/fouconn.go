@@ -0,0 +1,167 @@
+//go:build linux
+
+package daemon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// udpHeaderLen is the size of a UDP header.
+const udpHeaderLen = 8
+
+// fouConn sends and receives disco messages on the kernel FOU port. The
+// kernel owns that port, so a userspace bind is impossible while the
+// tunnel exists; instead fouConn is a raw IPPROTO_UDP socket that writes
+// UDP datagrams with the FOU port as their source and reads copies of the
+// datagrams addressed to it. Pings sent this way ride the exact NAT
+// mapping the tunnel's data uses, so a pong proves the data path. The
+// kernel FOU receive path drops them as malformed GRE.
+//
+// It satisfies net.PacketConn with UDP addresses, so the FSM can treat it
+// like any other disco socket. One fouConn serves one address family.
+type fouConn struct {
+	*net.IPConn
+	port uint16
+	v6   bool
+}
+
+// listenFOU opens a fouConn for port over IPv4, or IPv6 if v6 is set,
+// bound to dev and to local if they are set. It needs CAP_NET_RAW.
+func listenFOU(port uint16, v6 bool, dev string, local netip.Addr) (*fouConn, error) {
+	network := "ip4:udp"
+	if v6 {
+		network = "ip6:udp"
+	}
+	pc, err := listenBound(network, bindHost(local, v6), dev)
+	if err != nil {
+		return nil, fmt.Errorf("raw udp socket: %w", err)
+	}
+	c := pc.(*net.IPConn)
+	if err := attachFilter(c, fouFilter(port, v6)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("raw udp socket: %w", err)
+	}
+	if v6 {
+		// IPv6 has no zero checksum for UDP; have the kernel fill it in,
+		// and check it on the way in.
+		err := sockControl(c, func(fd int) error {
+			return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_CHECKSUM, 6)
+		})
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("raw udp6 socket: %w", err)
+		}
+	}
+	return &fouConn{IPConn: c, port: port, v6: v6}, nil
+}
+
+// fouFilter passes only disco messages sent to port. Without it the raw
+// socket would be handed a copy of every inbound UDP datagram on the host,
+// the tunnel's own data included. An IPv4 raw socket sees the IP header
+// first, so the UDP header is found through its length; an IPv6 one starts
+// at the UDP header.
+func fouFilter(port uint16, v6 bool) []bpf.Instruction {
+	m := disco.Magic
+	var udp bpf.Instruction = bpf.LoadMemShift{Off: 0} // X = IPv4 header length
+	if v6 {
+		udp = bpf.LoadConstant{Dst: bpf.RegX, Val: 0}
+	}
+	return []bpf.Instruction{
+		udp,
+		bpf.LoadIndirect{Off: 2, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(port), SkipTrue: 5},
+		bpf.LoadIndirect{Off: udpHeaderLen, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: binary.BigEndian.Uint32(m[0:4]), SkipTrue: 3},
+		bpf.LoadIndirect{Off: udpHeaderLen + 4, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(binary.BigEndian.Uint16(m[4:6])), SkipTrue: 1},
+		bpf.RetConstant{Val: 0xffff},
+		bpf.RetConstant{Val: 0},
+	}
+}
+
+// attachFilter installs prog on c with SO_ATTACH_FILTER.
+func attachFilter(c *net.IPConn, prog []bpf.Instruction) error {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return sockControl(c, func(fd int) error {
+		return unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog)
+	})
+}
+
+// sockControl runs fn on c's file descriptor.
+func sockControl(c *net.IPConn, fn func(fd int) error) error {
+	sc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := sc.Control(func(fd uintptr) { ferr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// ReadFrom returns the payload of the next disco message sent to the FOU
+// port. The socket filter drops everything else; what slipped in before
+// it was attached is skipped here.
+func (c *fouConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.IPConn.ReadFromIP(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < udpHeaderLen || binary.BigEndian.Uint16(b[2:]) != c.port {
+			continue
+		}
+		end := int(binary.BigEndian.Uint16(b[4:]))
+		if end < udpHeaderLen || end > n {
+			continue
+		}
+		src := &net.UDPAddr{IP: from.IP, Port: int(binary.BigEndian.Uint16(b[0:]))}
+		return copy(b, b[udpHeaderLen:end]), src, nil
+	}
+}
+
+// WriteTo sends b to addr, a *net.UDPAddr, from the FOU port. The checksum
+// is left at zero, which IPv4 allows and which the kernel fills in over
+// IPv6; the kernel also writes the IP header.
+func (c *fouConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ua, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("fou conn: not a UDP address")
+	}
+	if len(b)+udpHeaderLen > 0xffff {
+		return 0, errors.New("fou conn: datagram too large")
+	}
+	pkt := make([]byte, udpHeaderLen, udpHeaderLen+len(b))
+	binary.BigEndian.PutUint16(pkt[0:], c.port)
+	binary.BigEndian.PutUint16(pkt[2:], uint16(ua.Port))
+	binary.BigEndian.PutUint16(pkt[4:], uint16(udpHeaderLen+len(b)))
+	pkt = append(pkt, b...)
+	if _, err := c.IPConn.WriteToIP(pkt, &net.IPAddr{IP: ua.IP}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr is the FOU port on the address the socket is bound to, every
+// address of its family unless --local-ip is set.
+func (c *fouConn) LocalAddr() net.Addr {
+	ip := c.IPConn.LocalAddr().(*net.IPAddr).IP
+	return &net.UDPAddr{IP: ip, Port: int(c.port)}
+}
@@ -0,0 +1,239 @@
+//go:build linux
+
+package daemon
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/bpf"
+)
+
+func TestFOUConn_RoundTrip(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		network, lo := "udp4", net.IPv4(127, 0, 0, 1)
+		if v6 {
+			network, lo = "udp6", net.IPv6loopback
+		}
+		t.Run(network, func(t *testing.T) {
+			// Any free port stands in for the FOU port: the raw socket
+			// sees datagrams to it whether or not something is bound there.
+			probe, err := net.ListenUDP(network, &net.UDPAddr{IP: lo})
+			if err != nil {
+				t.Skipf("no %s loopback: %v", network, err)
+			}
+			port := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+			probe.Close()
+
+			fc, err := listenFOU(port, v6, "", netip.Addr{})
+			if errors.Is(err, os.ErrPermission) {
+				t.Skip("no CAP_NET_RAW; skipping")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fc.Close()
+			peer, err := net.ListenUDP(network, &net.UDPAddr{IP: lo})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peer.Close()
+
+			if _, err := fc.WriteTo([]byte("ping"), peer.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, from, err := peer.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "ping" || from.Port != int(port) {
+				t.Fatalf("got %q from %v, want \"ping\" from port %d", buf[:n], from, port)
+			}
+
+			// Only disco messages get through the socket filter: not the
+			// tunnel's data, and not datagrams to other ports.
+			fouAddr := &net.UDPAddr{IP: lo, Port: int(port)}
+			pong := append(disco.Magic[:], "pong"...)
+			if _, err := peer.WriteToUDP([]byte("gre data"), fouAddr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := peer.WriteToUDP(pong, &net.UDPAddr{IP: lo, Port: int(port) ^ 1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := peer.WriteToUDP(pong, fouAddr); err != nil {
+				t.Fatal(err)
+			}
+			_ = fc.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, addr, err := fc.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != string(pong) || addr.(*net.UDPAddr).Port != peer.LocalAddr().(*net.UDPAddr).Port {
+				t.Fatalf("got %q from %v, want %q from %v", buf[:n], addr, pong, peer.LocalAddr())
+			}
+		})
+	}
+}
+
+// TestFOUFilter runs the socket filter over hand-built packets as each
+// family's raw socket sees them: IPv4 with its IP header, IPv6 without.
+func TestFOUFilter(t *testing.T) {
+	disc := append(disco.Magic[:], make([]byte, 32)...)
+	for _, v6 := range []bool{false, true} {
+		vm, err := bpf.NewVM(fouFilter(5555, v6))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt := func(ihl int, dport uint16, payload []byte) []byte {
+			if v6 {
+				ihl = 0
+			}
+			b := make([]byte, ihl+udpHeaderLen)
+			if ihl > 0 {
+				b[0] = 0x40 | byte(ihl/4)
+			}
+			binary.BigEndian.PutUint16(b[ihl:], 41641)
+			binary.BigEndian.PutUint16(b[ihl+2:], dport)
+			return append(b, payload...)
+		}
+		for _, c := range []struct {
+			name string
+			pkt  []byte
+			pass bool
+		}{
+			{"disco to the FOU port", pkt(20, 5555, disc), true},
+			{"with IP options", pkt(24, 5555, disc), true},
+			{"FOU data", pkt(20, 5555, []byte{0x00, 0x00, 0x08, 0x00, 0x45}), false},
+			{"disco to another port", pkt(20, 5556, disc), false},
+			{"truncated", pkt(20, 5555, disco.Magic[:3]), false},
+		} {
+			n, err := vm.Run(c.pkt)
+			if err != nil {
+				t.Fatalf("v6=%v %s: %v", v6, c.name, err)
+			}
+			if (n > 0) != c.pass {
+				t.Errorf("v6=%v %s: kept %d bytes, want pass=%v", v6, c.name, n, c.pass)
+			}
+		}
+	}
+}
+
+// sentConn records what the FSM writes to it. Other methods panic
+// through the nil embedded interface.
+type sentConn struct {
+	net.PacketConn
+	sent []sentPacket
+}
+
+type sentPacket struct {
+	to  netip.AddrPort
+	env []byte
+}
+
+func (c *sentConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.sent = append(c.sent, sentPacket{addr.(*net.UDPAddr).AddrPort(), append([]byte(nil), b...)})
+	return len(b), nil
+}
+
+// lastFOUPing checks that the FSM's last write was a FOU-port ping to to,
+// and returns its tx.
+func lastFOUPing(t *testing.T, c *sentConn, to netip.AddrPort, key disco.DiscoKey) string {
+	t.Helper()
+	if len(c.sent) == 0 {
+		t.Fatal("nothing sent from the FOU port")
+	}
+	last := c.sent[len(c.sent)-1]
+	_, body, err := disco.OpenEnvelope(last.env, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != disco.MsgPing || !body.FOU || last.to != to {
+		t.Fatalf("sent %+v to %v, want a FOU-port ping to %v", body, last.to, to)
+	}
+	return body.Tx
+}
+
+func TestOnDiscoUDP_ProvesFOUPathBeforeDirect(t *testing.T) {
+	if !firstGlobalV4().IsValid() {
+		t.Skip("no global v4 on this host; skipping")
+	}
+	peerDisco := netip.MustParseAddrPort("203.0.113.5:41641")
+	peerFOU := netip.MustParseAddrPort("203.0.113.5:7777")
+
+	self, _ := disco.GenerateDiscoKey()
+	peerKey, _ := disco.GenerateDiscoKey()
+	nl := &fakeNL{links: make(map[string]netlink.Link)}
+	fouCn := &sentConn{}
+	deps := peerDeps{self: self, ifaceName: "gretun0", fouPort: 7777, nl: nl, fouCn: fouCn}
+	fsm := newPeerFSM(deps, disco.RemotePeer{Name: "b", DiscoKey: peerKey.Pub})
+	fsm.state = statePunching
+	deadline := time.Now().Add(time.Second)
+
+	fsm.onDiscoUDP(nil, peerDisco, disco.Body{Type: disco.MsgPong}, &deadline)
+	if fsm.state != statePunching || fsm.tunnelUp {
+		t.Fatalf("state = %v up = %v after a disco pong; the FOU path is unproven", fsm.state, fsm.tunnelUp)
+	}
+	if time.Until(deadline) < punchAttemptDur-time.Second {
+		t.Error("punch deadline not extended for the FOU proof")
+	}
+	tx := lastFOUPing(t, fouCn, peerDisco, peerKey)
+
+	// The peer answers from its FOU port, which isn't where we pinged:
+	// that only teaches us where the port is.
+	fsm.onDiscoUDP(fouCn, peerFOU, disco.Body{Type: disco.MsgPong, Tx: tx, FOU: true}, &deadline)
+	if fsm.state != statePunching || fsm.fouPeer != peerFOU {
+		t.Fatalf("state = %v fouPeer = %v; want still punching with the FOU port learned", fsm.state, fsm.fouPeer)
+	}
+	tx = lastFOUPing(t, fouCn, peerFOU, peerKey)
+
+	fsm.onDiscoUDP(fouCn, peerFOU, disco.Body{Type: disco.MsgPong, Tx: tx, FOU: true}, &deadline)
+	if fsm.state != stateDirect {
+		t.Fatalf("state = %v after the FOU pong, want direct", fsm.state)
+	}
+	gre := nl.links["gretun0"].(*netlink.Gretun)
+	if gre.Remote.String() != "203.0.113.5" || gre.EncapDport != peerFOU.Port() {
+		t.Errorf("tunnel points at %v:%d, want the peer's FOU port %v", gre.Remote, gre.EncapDport, peerFOU)
+	}
+}
+
+// TestOnDiscoUDP_ProvesIPv6FOUPath: an IPv6 pong gets the same proof, over
+// the IPv6 FOU socket, and the tunnel points at the peer's own FOU port
+// rather than assuming ours.
+func TestOnDiscoUDP_ProvesIPv6FOUPath(t *testing.T) {
+	peerDisco := netip.MustParseAddrPort("[2001:db8::5]:41641")
+	peerFOU := netip.MustParseAddrPort("[2001:db8::5]:9999")
+
+	self, _ := disco.GenerateDiscoKey()
+	peerKey, _ := disco.GenerateDiscoKey()
+	fouCn, fouCn6 := &sentConn{}, &sentConn{}
+	deps := peerDeps{self: self, ifaceName: "gretun0", fouPort: 7777,
+		nl: &fakeNL{links: make(map[string]netlink.Link)}, fouCn: fouCn, fouCn6: fouCn6}
+	fsm := newPeerFSM(deps, disco.RemotePeer{Name: "b", DiscoKey: peerKey.Pub})
+	defer fsm.teardown()
+	fsm.state = statePunching
+	deadline := time.Now().Add(time.Second)
+
+	fsm.onDiscoUDP(nil, peerDisco, disco.Body{Type: disco.MsgPong}, &deadline)
+	if fsm.state != statePunching {
+		t.Fatalf("state = %v after an IPv6 disco pong; the FOU path is unproven", fsm.state)
+	}
+	if len(fouCn.sent) != 0 {
+		t.Fatal("IPv6 FOU ping went out of the IPv4 socket")
+	}
+	tx := lastFOUPing(t, fouCn6, peerDisco, peerKey)
+	fsm.onDiscoUDP(fouCn6, peerFOU, disco.Body{Type: disco.MsgPong, Tx: tx, FOU: true}, &deadline)
+	tx = lastFOUPing(t, fouCn6, peerFOU, peerKey)
+	fsm.onDiscoUDP(fouCn6, peerFOU, disco.Body{Type: disco.MsgPong, Tx: tx, FOU: true}, &deadline)
+	if fsm.state != stateDirect || fsm.fouPeer != peerFOU {
+		t.Fatalf("state = %v fouPeer = %v, want direct to %v", fsm.state, fsm.fouPeer, peerFOU)
+	}
+}
@@ -14,6 +14,9 @@ type Metrics struct {
 	DiscoPingsSent    prometheus.Counter
 	DiscoPongsRecv    prometheus.Counter
 	HolePunchDuration prometheus.Histogram
+
+	AggressivePunchAttempts  prometheus.Counter
+	AggressivePunchSuccesses prometheus.Counter
 }
 
 // NewMetrics registers the collectors with reg and returns handles.
@@ -40,9 +43,20 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 			Help:      "Elapsed wall time from entering `punching` to reaching `direct`.",
 			Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 30},
 		}),
+		AggressivePunchAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gretun",
+			Name:      "aggressive_punch_attempts_total",
+			Help:      "Birthday-paradox probe runs started after a plain punch failed (--aggressive-punch).",
+		}),
+		AggressivePunchSuccesses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gretun",
+			Name:      "aggressive_punch_successes_total",
+			Help:      "Birthday-paradox probe runs that got a pong.",
+		}),
 	}
 	if reg != nil {
-		reg.MustRegister(m.PeersByState, m.DiscoPingsSent, m.DiscoPongsRecv, m.HolePunchDuration)
+		reg.MustRegister(m.PeersByState, m.DiscoPingsSent, m.DiscoPongsRecv, m.HolePunchDuration,
+			m.AggressivePunchAttempts, m.AggressivePunchSuccesses)
 	}
 	return m
 }
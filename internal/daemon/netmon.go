@@ -0,0 +1,140 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// netSettle is how long the network has to stay quiet before a change is
+// acted on. A DHCP renewal or an interface switch arrives as a burst of
+// address, link and route messages.
+var netSettle = time.Second
+
+// netmonLoop watches the kernel for address, link and route changes and
+// hands them to watchNetwork. If the subscriptions fail, refreshLoop's
+// timer is the only thing that notices a new network.
+func (d *Daemon) netmonLoop(ctx context.Context) {
+	events := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case events <- struct{}{}:
+		default:
+		}
+	}
+	onErr := func(kind string) func(error) {
+		return func(err error) { slog.Debug("netlink subscription", "kind", kind, "err", err) }
+	}
+	addrs := make(chan netlink.AddrUpdate, 16)
+	links := make(chan netlink.LinkUpdate, 16)
+	routes := make(chan netlink.RouteUpdate, 16)
+	if err := netlink.AddrSubscribeWithOptions(addrs, ctx.Done(), netlink.AddrSubscribeOptions{ErrorCallback: onErr("addr")}); err != nil {
+		slog.Warn("cannot watch addresses; endpoint changes wait for the next refresh", "err", err)
+		return
+	}
+	if err := netlink.LinkSubscribeWithOptions(links, ctx.Done(), netlink.LinkSubscribeOptions{ErrorCallback: onErr("link")}); err != nil {
+		slog.Warn("cannot watch links", "err", err)
+	}
+	if err := netlink.RouteSubscribeWithOptions(routes, ctx.Done(), netlink.RouteSubscribeOptions{ErrorCallback: onErr("route")}); err != nil {
+		slog.Warn("cannot watch routes", "err", err)
+	}
+	go func() {
+		// A subscription's channel closes when its socket fails; a nil
+		// channel then just never fires.
+		for addrs != nil || links != nil || routes != nil {
+			select {
+			case _, ok := <-addrs:
+				if !ok {
+					addrs = nil
+				}
+			case _, ok := <-links:
+				if !ok {
+					links = nil
+				}
+			case _, ok := <-routes:
+				if !ok {
+					routes = nil
+				}
+			}
+			notify()
+		}
+	}()
+	d.watchNetwork(ctx, events, d.netSnapshot)
+}
+
+// watchNetwork takes a snapshot of the network netSettle after each burst
+// of events and, if it differs from the last one, tells refreshLoop to
+// re-collect endpoints and every peer to move its tunnel and re-punch.
+// Our own tunnels come and go without changing the snapshot.
+func (d *Daemon) watchNetwork(ctx context.Context, events <-chan struct{}, snapshot func() string) {
+	last := snapshot()
+	settle := time.NewTimer(netSettle)
+	settle.Stop()
+	defer settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+			settle.Reset(netSettle)
+		case <-settle.C:
+			cur := snapshot()
+			if cur == last {
+				continue
+			}
+			slog.Info("network changed; re-collecting endpoints and re-punching peers", "was", last, "now", cur)
+			last = cur
+			select {
+			case d.netChanged <- struct{}{}:
+			default:
+			}
+			for _, p := range d.peerList() {
+				p.netChange()
+			}
+		}
+	}
+}
+
+// netSnapshot describes the addresses and default routes peers could
+// reach us by: every global unicast address outside our own tunnels, and
+// each default route's gateway and device.
+func (d *Daemon) netSnapshot() string {
+	var parts []string
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 || d.ourIface(ifi.Name) {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipn.IP)
+			if ok && ip.Unmap().IsGlobalUnicast() {
+				parts = append(parts, fmt.Sprintf("%s@%s", ip.Unmap(), ifi.Name))
+			}
+		}
+	}
+	routes, _ := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	for _, r := range routes {
+		if r.Dst != nil {
+			if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		parts = append(parts, fmt.Sprintf("default via %s dev %d", r.Gw, r.LinkIndex))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,148 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/coord"
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/vishvananda/netlink"
+)
+
+func TestWatchNetwork_ActsOnRealChangesOnly(t *testing.T) {
+	defer func(d time.Duration) { netSettle = d }(netSettle)
+	netSettle = 20 * time.Millisecond
+
+	key, _ := disco.GenerateDiscoKey()
+	d := New(Config{Coordinators: []string{"http://coord.test"}}, nil, disco.NodeKey{}, disco.DiscoKey{})
+	fsm := newPeerFSM(peerDeps{}, disco.RemotePeer{Name: "b", DiscoKey: key.Pub})
+	d.peers[key.Pub] = fsm
+
+	var mu sync.Mutex
+	snap := "192.0.2.1@eth0 default via 192.0.2.254 dev 2"
+	snapshot := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return snap
+	}
+	events := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.watchNetwork(ctx, events, snapshot)
+
+	// A tunnel coming up makes noise but changes nothing we advertise.
+	events <- struct{}{}
+	select {
+	case <-d.netChanged:
+		t.Fatal("acted on an event that changed nothing")
+	case <-time.After(10 * netSettle):
+	}
+
+	mu.Lock()
+	snap = "198.51.100.3@wwan0 default via 198.51.100.1 dev 3"
+	mu.Unlock()
+	for range 3 {
+		events <- struct{}{}
+	}
+	select {
+	case <-d.netChanged:
+	case <-time.After(time.Second):
+		t.Fatal("refresh not woken by a new address")
+	}
+	if ev := <-fsm.incoming; ev.kind != evNetChange {
+		t.Errorf("peer got event %v, want evNetChange", ev.kind)
+	}
+	if len(fsm.incoming) != 0 {
+		t.Error("a burst of events told the peer more than once")
+	}
+}
+
+func TestOnNetChange_MovesTunnelAndRepunches(t *testing.T) {
+	local := netip.MustParseAddr("192.0.2.7")
+	discoCn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discoCn.Close()
+	self, _ := disco.GenerateDiscoKey()
+	nk, _ := disco.GenerateNodeKey()
+	nl := &fakeNL{links: make(map[string]netlink.Link)}
+	nl.routes = []netlink.Route{{LinkIndex: 2, Src: local.AsSlice()}}
+	nl.links["eth0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	fsm := newPeerFSM(peerDeps{
+		self: self, selfNode: nk, ifaceName: "gretun0", fouPort: 7777, nl: nl, discoCn: discoCn,
+		coord: disco.NewFailoverCoordClient([]string{"http://127.0.0.1:1"}, nk, self),
+	}, disco.RemotePeer{Name: "b"})
+
+	// The tunnel was brought up from an address we no longer have.
+	remote := netip.MustParseAddrPort("203.0.113.5:7777")
+	if !fsm.pointTunnel(netip.MustParseAddr("192.0.2.99"), "", remote, false) {
+		t.Fatal("tunnel not created")
+	}
+	fsm.state = stateDirect
+	fsm.fouProven = true
+	deadline := time.Time{}
+
+	fsm.onNetChange(&deadline)
+	gre := nl.links["gretun0"].(*netlink.Gretun)
+	if nl.modified != 1 || !gre.Local.Equal(local.AsSlice()) || gre.EncapDport != remote.Port() {
+		t.Errorf("tunnel %v -> %v:%d after %d modifies, want it moved in place to local %v", gre.Local, gre.Remote, gre.EncapDport, nl.modified, local)
+	}
+	if fsm.state != statePunching || fsm.fouProven {
+		t.Errorf("state = %v proven = %v, want punching for a fresh proof", fsm.state, fsm.fouProven)
+	}
+	if time.Until(deadline) < punchAttemptDur-time.Second {
+		t.Error("no punch deadline set")
+	}
+}
+
+func TestMeasureNAT_SharesSocketWithReadLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var servers []string
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		sc, err := net.ListenPacket("udp4", ip+":0")
+		if err != nil {
+			t.Skipf("no %s: %v", ip, err)
+		}
+		defer sc.Close()
+		go func() { _ = coord.ServeSTUN(ctx, sc) }()
+		servers = append(servers, sc.LocalAddr().String())
+	}
+	discoCn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discoCn.Close()
+
+	d := New(Config{Coordinators: []string{"http://coord.test"}, STUNServers: servers}, nil, disco.NodeKey{}, disco.DiscoKey{})
+	d.discoCn = discoCn
+	d.nat = disco.NATSymmetric
+	key, _ := disco.GenerateDiscoKey()
+	fsm := newPeerFSM(peerDeps{selfNAT: disco.NATSymmetric}, disco.RemotePeer{Name: "b", DiscoKey: key.Pub, NAT: disco.NATSymmetric})
+	d.peers[key.Pub] = fsm
+	errs := make(chan error, 1)
+	go d.discoReadLoop(ctx, errs)
+	time.Sleep(50 * time.Millisecond)
+
+	// The read loop is waiting on the socket, yet every STUN answer
+	// reaches netcheck.
+	if nat := d.measureNAT(ctx); nat != disco.NATNone {
+		t.Fatalf("nat = %v, want %v", nat, disco.NATNone)
+	}
+	d.mu.Lock()
+	nat := d.nat
+	d.mu.Unlock()
+	if nat != disco.NATNone {
+		t.Errorf("d.nat = %v, want %v", nat, disco.NATNone)
+	}
+	if fsm.bothHard() {
+		t.Error("peer still thinks we're behind a symmetric NAT")
+	}
+}
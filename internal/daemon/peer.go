@@ -47,7 +47,8 @@ const (
 	punchInterval    = 250 * time.Millisecond
 	punchAttemptDur  = 5 * time.Second
 	keepaliveEvery   = 25 * time.Second
-	relayRetryEvery  = 30 * time.Second
+	relayRetryEvery  = 30 * time.Second // first re-punch while relayed; doubles
+	relayRetryMax    = 5 * time.Minute
 	pongMissDeadline = 75 * time.Second
 )
 
@@ -61,8 +62,14 @@ type peerDeps struct {
 	selfTunnel netip.Addr
 	nl         tunnel.Netlinker
 	discoCn    net.PacketConn
+	fouCn      net.PacketConn // nil = can't ping from the FOU port; trust the disco path
+	fouCn6     net.PacketConn // the same for IPv6 paths
 	coord      *disco.CoordClient
+	relay      *relayManager // nil = no relay; peers that can't punch stay down
 	aggressive bool
+	selfNAT    disco.NATType // what our netcheck found; guarded by mu, see setSelfNAT
+	bindIface  string        // if set, outer paths leave through this device only
+	localIP    netip.Addr    // if valid, the local end of every outer path
 	metrics    *Metrics
 }
 
@@ -75,18 +82,62 @@ type peerFSM struct {
 	state      peerState
 	winning    netip.AddrPort
 	tunnelUp   bool
+	outer6     bool           // the tunnel's outer path is IPv6 (an ip6gre link)
+	remote     netip.AddrPort // where the tunnel points
+	relayed    bool           // tunnel runs through a relay shim
 	lastPong   time.Time
 	punchStart time.Time
-	done       chan struct{}
-	incoming   chan fsmEvent
-	stopOnce   sync.Once
+	// While relayed, a re-punch burst pings until repunchUntil; the next
+	// one starts at retryAt, retryEvery after the last.
+	repunchUntil time.Time
+	retryAt      time.Time
+	retryEvery   time.Duration
+	// winConn is the socket winning was reached from; nil = the disco
+	// socket. With --aggressive-punch it can be one of aggr's.
+	winConn net.PacketConn
+	// aggr is the running --aggressive-punch probe, if any. Only the run
+	// goroutine touches it.
+	aggr      *aggressivePunch
+	aggrTried bool // once per punching phase
+	// fouPeer is the peer's FOU port as it appears to us, learned from
+	// its FOU-port pings and pongs; fouTx maps our outstanding FOU-port
+	// pings to where they went. The tunnel only goes direct once a pong
+	// comes back from the address its ping was sent to.
+	fouPeer   netip.AddrPort
+	fouTx     map[string]netip.AddrPort
+	fouProven bool
+	// since is when state was entered. pingAt holds the send time of
+	// outstanding pings, rtt the last one answered, and waiters the
+	// control socket's pings waiting for an answer.
+	since   time.Time
+	pingAt  map[string]time.Time
+	rtt     time.Duration
+	waiters []*pongWaiter
+
+	done     chan struct{}
+	exited   chan struct{} // closed once run has torn down
+	incoming chan fsmEvent
+	stopOnce sync.Once
+}
+
+// pongWaiter is a control-socket ping waiting for a pong to any of our
+// pings sent after since.
+type pongWaiter struct {
+	since time.Time
+	ch    chan pongSeen
+}
+
+type pongSeen struct {
+	from netip.AddrPort
+	rtt  time.Duration
 }
 
 type fsmEvent struct {
-	kind    fsmEventKind
-	addr    netip.AddrPort
-	body    disco.Body
-	signal  bool // true if this came via coord relay, false if direct UDP
+	kind   fsmEventKind
+	addr   netip.AddrPort
+	body   disco.Body
+	signal bool           // true if this came via coord relay, false if direct UDP
+	conn   net.PacketConn // socket a UDP message arrived on; nil = the disco socket
 }
 
 type fsmEventKind int
@@ -95,6 +146,7 @@ const (
 	evUpdate fsmEventKind = iota
 	evUDP
 	evSignal
+	evNetChange
 )
 
 func newPeerFSM(deps peerDeps, peer disco.RemotePeer) *peerFSM {
@@ -103,6 +155,7 @@ func newPeerFSM(deps peerDeps, peer disco.RemotePeer) *peerFSM {
 		peer:     peer,
 		state:    stateUnknown,
 		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
 		incoming: make(chan fsmEvent, 32),
 	}
 }
@@ -120,8 +173,15 @@ func (p *peerFSM) update(peer disco.RemotePeer) {
 
 // onUDP handles a disco message arriving on the disco socket.
 func (p *peerFSM) onUDP(from netip.AddrPort, body disco.Body) {
+	p.onUDPVia(nil, from, body)
+}
+
+// onUDPVia handles a disco message arriving on conn, one of the
+// --aggressive-punch sockets or the FOU-port socket, or the disco socket
+// if conn is nil.
+func (p *peerFSM) onUDPVia(conn net.PacketConn, from netip.AddrPort, body disco.Body) {
 	select {
-	case p.incoming <- fsmEvent{kind: evUDP, addr: from, body: body}:
+	case p.incoming <- fsmEvent{kind: evUDP, addr: from, body: body, conn: conn}:
 	default:
 	}
 }
@@ -134,12 +194,26 @@ func (p *peerFSM) onSignal(body disco.Body) {
 	}
 }
 
+// netChange tells the peer our addresses or routes have changed.
+func (p *peerFSM) netChange() {
+	select {
+	case p.incoming <- fsmEvent{kind: evNetChange}:
+	default:
+	}
+}
+
 func (p *peerFSM) stop() {
 	p.stopOnce.Do(func() { close(p.done) })
 }
 
+// wait blocks until run, stopped, has deleted the peer's tunnel.
+func (p *peerFSM) wait() {
+	<-p.exited
+}
+
 // run is the main driver goroutine.
 func (p *peerFSM) run(ctx context.Context) {
+	defer close(p.exited)
 	defer p.teardown()
 	tick := time.NewTicker(punchInterval)
 	defer tick.Stop()
@@ -167,15 +241,24 @@ func (p *peerFSM) run(ctx context.Context) {
 }
 
 func (p *peerFSM) teardown() {
+	if p.aggr != nil {
+		p.aggr.stop(nil)
+	}
 	p.mu.Lock()
 	up := p.tunnelUp
+	relayed := p.relayed
 	iface := p.deps.ifaceName
+	if p.winConn != nil {
+		p.winConn.Close()
+	}
 	p.mu.Unlock()
-	if !up {
-		return
+	if up {
+		if err := tunnel.Delete(context.Background(), p.deps.nl, iface); err != nil {
+			slog.Warn("peer teardown: tunnel delete", "iface", iface, "err", err)
+		}
 	}
-	if err := tunnel.Delete(context.Background(), p.deps.nl, iface); err != nil {
-		slog.Warn("peer teardown: tunnel delete", "iface", iface, "err", err)
+	if relayed {
+		p.deps.relay.close(p.peer.NodeKey)
 	}
 }
 
@@ -183,6 +266,9 @@ func (p *peerFSM) setState(s peerState) {
 	p.mu.Lock()
 	prev := p.state
 	p.state = s
+	if prev != s || p.since.IsZero() {
+		p.since = time.Now()
+	}
 	p.mu.Unlock()
 	if prev != s {
 		slog.Info("peer state change", "peer", p.peer.Name, "from", prev, "to", s)
@@ -203,9 +289,42 @@ func (p *peerFSM) handle(ev fsmEvent, punchDeadline *time.Time) {
 	case evUpdate:
 		p.onPeerUpdate(punchDeadline)
 	case evUDP:
-		p.onDiscoUDP(ev.addr, ev.body, punchDeadline)
+		p.onDiscoUDP(ev.conn, ev.addr, ev.body, punchDeadline)
 	case evSignal:
 		p.onDiscoSignal(ev.body, punchDeadline)
+	case evNetChange:
+		p.onNetChange(punchDeadline)
+	}
+}
+
+// onNetChange moves the tunnel to our current local address at once, so
+// the kernel stops sending from one we may have lost, and punches the
+// path again: the NAT mappings it used belong to the old address. A
+// relayed peer starts a re-punch burst now rather than at the end of its
+// backoff.
+func (p *peerFSM) onNetChange(punchDeadline *time.Time) {
+	p.mu.Lock()
+	state, up, relayed, remote := p.state, p.tunnelUp, p.relayed, p.remote
+	p.mu.Unlock()
+	if up && !relayed && remote.IsValid() {
+		p.bringUpTunnel(remote)
+	}
+	switch state {
+	case stateDirect:
+		p.mu.Lock()
+		p.fouProven = false
+		p.fouTx = nil
+		p.punchStart = time.Now()
+		p.mu.Unlock()
+		p.setState(statePunching)
+		*punchDeadline = time.Now().Add(punchAttemptDur)
+		go p.sendCallMeMaybe()
+	case stateRelay:
+		p.mu.Lock()
+		p.retryEvery = relayRetryEvery
+		p.retryAt = time.Time{}
+		p.repunchUntil = time.Time{}
+		p.mu.Unlock()
 	}
 }
 
@@ -225,26 +344,74 @@ func (p *peerFSM) onPeerUpdate(punchDeadline *time.Time) {
 	}
 }
 
-func (p *peerFSM) onDiscoUDP(from netip.AddrPort, body disco.Body, punchDeadline *time.Time) {
+func (p *peerFSM) onDiscoUDP(via net.PacketConn, from netip.AddrPort, body disco.Body, punchDeadline *time.Time) {
 	switch body.Type {
 	case disco.MsgPing:
+		if body.FOU {
+			// Sent from the peer's FOU port: that's where its data will come
+			// from. Answer from ours, so the pong walks the data path back.
+			p.learnFOUPeer(from)
+			p.sendFOUPong(from, body.Tx)
+			return
+		}
 		// Reply with pong on same socket to punch in reverse.
-		p.sendPong(from, body.Tx)
+		p.sendPong(via, from, body.Tx)
 	case disco.MsgPong:
 		if p.deps.metrics != nil {
 			p.deps.metrics.DiscoPongsRecv.Inc()
 		}
+		p.notePong(from, body.Tx)
+		if body.FOU {
+			p.onFOUPong(from, body.Tx)
+			return
+		}
 		p.mu.Lock()
 		if p.state != stateDirect {
+			fresh := p.winning != from
 			p.winning = from
+			if p.winConn != nil && p.winConn != via {
+				p.winConn.Close()
+			}
+			p.winConn = via
 			p.lastPong = time.Now()
-			since := time.Since(p.punchStart)
+			p.aggrTried = false
+			state := p.state
 			p.mu.Unlock()
-			p.setState(stateDirect)
-			if p.deps.metrics != nil && !p.punchStart.IsZero() {
-				p.deps.metrics.HolePunchDuration.Observe(since.Seconds())
+			aggrWin := false
+			if p.aggr != nil {
+				aggrWin = p.aggr.owns(via)
+				p.aggr.stop(via)
+				p.aggr = nil
+				if aggrWin && p.deps.metrics != nil {
+					p.deps.metrics.AggressivePunchSuccesses.Inc()
+				}
+			}
+			if p.fouConnFor(from) == nil {
+				if from.Addr().Is6() {
+					// IPv6 is rarely translated, so without a way to
+					// prove it the peer's FOU port is taken to be on the
+					// address that answered, at the port we use too.
+					p.goDirect(netip.AddrPortFrom(from.Addr(), p.deps.fouPort))
+					return
+				}
+				if aggrWin {
+					// The mapping that answered belongs to one of the
+					// probe's sockets, not to the FOU port the kernel
+					// sends from, and without the FOU-port socket there's
+					// no proving that one. Leave the peer to the relay.
+					slog.Info("probe reached the peer, but its data path can't be proven", "peer", p.peer.Name)
+					return
+				}
+				p.goDirect(from)
+				return
 			}
-			p.bringUpTunnel(from)
+			// The disco path works. Data leaves from the FOU port, whose
+			// mapping is its own, so prove that path before going direct;
+			// a new path buys the proof one more punch attempt.
+			if d := time.Now().Add(punchAttemptDur); state == statePunching && fresh && d.After(*punchDeadline) {
+				*punchDeadline = d
+			}
+			p.sendFOUPings()
 		} else {
 			p.lastPong = time.Now()
 			p.mu.Unlock()
@@ -252,6 +419,67 @@ func (p *peerFSM) onDiscoUDP(from netip.AddrPort, body disco.Body, punchDeadline
 	case disco.MsgCallMeMaybe:
 		p.absorbEndpoints(body.Endpoints)
 		p.onPeerUpdate(punchDeadline)
+		// A relayed peer asking us to call is re-punching from its side;
+		// join in, without moving our own schedule.
+		p.mu.Lock()
+		if p.state == stateRelay && time.Now().After(p.repunchUntil) {
+			p.repunchUntil = time.Now().Add(punchAttemptDur)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// onFOUPong handles a pong sent from the peer's FOU port to ours. If it
+// answers a ping we sent to the address it came from, the data 5-tuple
+// works both ways and the tunnel goes direct to that address.
+func (p *peerFSM) onFOUPong(from netip.AddrPort, tx string) {
+	p.mu.Lock()
+	to, ours := p.fouTx[tx]
+	if p.fouProven {
+		if from == p.fouPeer {
+			p.lastPong = time.Now()
+		}
+		p.mu.Unlock()
+		return
+	}
+	if !ours || to != from {
+		// An answer to a ping that went to the peer's disco endpoint: it
+		// tells us where the peer's FOU port is. Ping that next.
+		p.mu.Unlock()
+		p.learnFOUPeer(from)
+		p.sendFOUPing(from)
+		return
+	}
+	p.fouPeer = from
+	p.fouProven = true
+	p.fouTx = nil
+	p.lastPong = time.Now()
+	p.mu.Unlock()
+	p.goDirect(from)
+}
+
+// goDirect moves the peer to direct with its tunnel pointed at remote.
+func (p *peerFSM) goDirect(remote netip.AddrPort) {
+	p.mu.Lock()
+	since := time.Since(p.punchStart)
+	relayed := p.relayed
+	p.mu.Unlock()
+	p.setState(stateDirect)
+	if p.deps.metrics != nil && !p.punchStart.IsZero() {
+		p.deps.metrics.HolePunchDuration.Observe(since.Seconds())
+	}
+	if p.bringUpTunnel(remote) && relayed {
+		p.dropRelay()
+		slog.Info("relayed peer reachable directly; tunnel moved off the relay", "peer", p.peer.Name, "remote", remote.String())
+	}
+}
+
+// learnFOUPeer records where the peer's FOU port appears to us.
+func (p *peerFSM) learnFOUPeer(at netip.AddrPort) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.fouProven {
+		p.fouPeer = at
 	}
 }
 
@@ -259,7 +487,7 @@ func (p *peerFSM) onDiscoSignal(body disco.Body, punchDeadline *time.Time) {
 	// Relayed messages are typically call_me_maybe — the peer telling us
 	// "here are my endpoints, try them." ping/pong over signal is rare but
 	// harmless to accept.
-	p.onDiscoUDP(netip.AddrPort{}, body, punchDeadline)
+	p.onDiscoUDP(nil, netip.AddrPort{}, body, punchDeadline)
 }
 
 func (p *peerFSM) absorbEndpoints(addrs []string) {
@@ -285,24 +513,91 @@ func (p *peerFSM) tickPunch(punchDeadline *time.Time) {
 	eps := append([]disco.RemoteEndpoint(nil), p.peer.Endpoints...)
 	p.mu.Unlock()
 
-	if state != statePunching {
-		return
-	}
-	if time.Now().After(*punchDeadline) {
-		p.setState(stateRelay)
-		slog.Warn("hole punch failed; falling back to relay (data-plane relay not yet implemented)",
-			"peer", p.peer.Name)
+	switch state {
+	case statePunching:
+		if (time.Now().After(*punchDeadline) || p.bothHard()) && p.deps.aggressive && !p.aggrTried {
+			p.aggrTried = true
+			p.aggr = p.startAggressive()
+			*punchDeadline = time.Now().Add(aggressiveDur)
+		}
+		if time.Now().After(*punchDeadline) {
+			if p.aggr != nil {
+				p.aggr.stop(nil)
+				p.aggr = nil
+			}
+			p.setState(stateRelay)
+			p.mu.Lock()
+			p.retryEvery = relayRetryEvery
+			p.retryAt = time.Now().Add(p.retryEvery)
+			p.mu.Unlock()
+			p.bringUpRelay()
+			return
+		}
+	case stateRelay:
+		ping, started := p.repunchDue()
+		if !ping {
+			return
+		}
+		if started {
+			// Endpoints may have moved since the last try; ask the peer
+			// to ping back as well.
+			go p.sendCallMeMaybe()
+		}
+	default:
 		return
 	}
 	for _, e := range eps {
-		p.sendPing(e.Addr)
+		p.sendPing(nil, e.Addr)
+	}
+	if p.aggr != nil {
+		p.aggr.tick(p, eps)
 	}
+	p.sendFOUPings()
+}
+
+// bothHard reports whether both sides said they are behind NATs that map
+// per destination. Then neither STUN endpoint is any use and plain pings
+// can't meet, so --aggressive-punch starts probing straight away instead
+// of after punchAttemptDur. With one easy side, plain punching usually
+// works and gets its chance first.
+func (p *peerFSM) bothHard() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.deps.selfNAT.Hard() && p.peer.NAT.Hard()
+}
+
+// setSelfNAT records what a fresh netcheck found, for bothHard.
+func (p *peerFSM) setSelfNAT(nat disco.NATType) {
+	p.mu.Lock()
+	p.deps.selfNAT = nat
+	p.mu.Unlock()
+}
+
+// repunchDue reports whether a relayed peer should be pinged this tick,
+// and whether that starts a new re-punch burst because the backoff has
+// run out.
+func (p *peerFSM) repunchDue() (ping, started bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Before(p.repunchUntil) {
+		return true, false
+	}
+	if now.Before(p.retryAt) {
+		return false, false
+	}
+	p.repunchUntil = now.Add(punchAttemptDur)
+	p.retryEvery = min(2*p.retryEvery, relayRetryMax)
+	p.retryAt = now.Add(p.retryEvery)
+	slog.Debug("re-punching relayed peer", "peer", p.peer.Name, "next_in", p.retryEvery)
+	return true, true
 }
 
 func (p *peerFSM) keepalive() {
 	p.mu.Lock()
 	state := p.state
 	peerAddr := p.winning
+	via := p.winConn
 	last := p.lastPong
 	p.mu.Unlock()
 	if state != stateDirect {
@@ -310,31 +605,148 @@ func (p *peerFSM) keepalive() {
 	}
 	if time.Since(last) > pongMissDeadline {
 		slog.Warn("peer keepalive lost; re-punching", "peer", p.peer.Name)
+		p.mu.Lock()
+		p.fouProven = false
+		p.mu.Unlock()
 		p.setState(statePunching)
 		return
 	}
-	p.sendPing(peerAddr)
+	p.mu.Lock()
+	fouPeer, proven := p.fouPeer, p.fouProven
+	p.mu.Unlock()
+	if proven {
+		// The data path is what has to stay up; ping along it.
+		p.sendFOUPing(fouPeer)
+		return
+	}
+	p.sendPing(via, peerAddr)
+}
+
+func (p *peerFSM) sendPing(via net.PacketConn, to netip.AddrPort) {
+	body := disco.Body{
+		Type:    disco.MsgPing,
+		Tx:      newTxID(),
+		NodeKey: p.deps.selfNode.B64(),
+	}
+	p.mu.Lock()
+	p.notePing(body.Tx)
+	p.mu.Unlock()
+	p.sendDisco(via, to, body)
+	if p.deps.metrics != nil {
+		p.deps.metrics.DiscoPingsSent.Inc()
+	}
+}
+
+// notePing remembers when ping tx went out. Callers hold p.mu.
+func (p *peerFSM) notePing(tx string) {
+	if p.pingAt == nil || len(p.pingAt) >= maxPendingTx {
+		p.pingAt = make(map[string]time.Time)
+	}
+	p.pingAt[tx] = time.Now()
+}
+
+// notePong times the ping pong tx answers and hands the answer to anyone
+// in ping waiting for it. Pongs to pings we don't remember are ignored.
+func (p *peerFSM) notePong(from netip.AddrPort, tx string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at, ok := p.pingAt[tx]
+	if !ok {
+		return
+	}
+	delete(p.pingAt, tx)
+	p.rtt = time.Since(at)
+	for _, w := range p.waiters {
+		if !at.Before(w.since) {
+			select {
+			case w.ch <- pongSeen{from: from, rtt: p.rtt}:
+			default:
+			}
+		}
+	}
 }
 
-func (p *peerFSM) sendPing(to netip.AddrPort) {
+func (p *peerFSM) sendPong(via net.PacketConn, to netip.AddrPort, tx string) {
+	body := disco.Body{
+		Type: disco.MsgPong,
+		Tx:   tx,
+		Src:  to.String(),
+	}
+	p.sendDisco(via, to, body)
+}
+
+// maxPendingTx bounds the outstanding pings remembered per peer, for RTTs
+// and for the FOU-port proof.
+const maxPendingTx = 64
+
+// fouConnFor is the FOU-port socket for to's address family, or nil if
+// there is none.
+func (p *peerFSM) fouConnFor(to netip.AddrPort) net.PacketConn {
+	if to.Addr().Unmap().Is4() {
+		return p.deps.fouCn
+	}
+	return p.deps.fouCn6
+}
+
+// sendFOUPings pings, from the FOU port, the disco endpoint that answered
+// and the peer's FOU port if we know it, until one of them proves the data
+// path. It does nothing without a FOU-port socket.
+func (p *peerFSM) sendFOUPings() {
+	p.mu.Lock()
+	winning, fouPeer, proven := p.winning, p.fouPeer, p.fouProven
+	p.mu.Unlock()
+	if proven {
+		return
+	}
+	if winning.IsValid() {
+		p.sendFOUPing(winning)
+	}
+	if fouPeer.IsValid() && fouPeer != winning {
+		p.sendFOUPing(fouPeer)
+	}
+}
+
+// sendFOUPing pings to from the FOU port, remembering where the ping went
+// so its pong can be checked against it.
+func (p *peerFSM) sendFOUPing(to netip.AddrPort) {
+	via := p.fouConnFor(to)
+	if via == nil {
+		return
+	}
 	body := disco.Body{
 		Type:    disco.MsgPing,
 		Tx:      newTxID(),
 		NodeKey: p.deps.selfNode.B64(),
+		FOU:     true,
+	}
+	p.mu.Lock()
+	if !p.fouProven {
+		if p.fouTx == nil || len(p.fouTx) >= maxPendingTx {
+			p.fouTx = make(map[string]netip.AddrPort)
+		}
+		p.fouTx[body.Tx] = to
 	}
-	p.sendDisco(to, body)
+	p.notePing(body.Tx)
+	p.mu.Unlock()
+	p.sendDisco(via, to, body)
 	if p.deps.metrics != nil {
 		p.deps.metrics.DiscoPingsSent.Inc()
 	}
 }
 
-func (p *peerFSM) sendPong(to netip.AddrPort, tx string) {
+// sendFOUPong answers a FOU-port ping from our own FOU port.
+func (p *peerFSM) sendFOUPong(to netip.AddrPort, tx string) {
+	via := p.fouConnFor(to)
+	if via == nil {
+		return
+	}
 	body := disco.Body{
 		Type: disco.MsgPong,
 		Tx:   tx,
 		Src:  to.String(),
+		FOU:  true,
 	}
-	p.sendDisco(to, body)
+	p.sendDisco(via, to, body)
 }
 
 func (p *peerFSM) sendCallMeMaybe() {
@@ -352,61 +764,164 @@ func (p *peerFSM) sendCallMeMaybe() {
 	}
 }
 
-func (p *peerFSM) sendDisco(to netip.AddrPort, body disco.Body) {
+// sendDisco seals body for the peer and sends it to to from via, or from
+// the disco socket if via is nil.
+func (p *peerFSM) sendDisco(via net.PacketConn, to netip.AddrPort, body disco.Body) {
 	env, err := disco.BuildEnvelope(p.deps.self, p.peer.DiscoKey, body)
 	if err != nil {
 		return
 	}
+	if via == nil {
+		via = p.deps.discoCn
+	}
 	udp := net.UDPAddrFromAddrPort(to)
-	_, _ = p.deps.discoCn.WriteTo(env, udp)
+	_, _ = via.WriteTo(env, udp)
 }
 
-func (p *peerFSM) bringUpTunnel(to netip.AddrPort) {
-	p.mu.Lock()
-	if p.tunnelUp {
-		p.mu.Unlock()
-		return
+func (p *peerFSM) bringUpTunnel(to netip.AddrPort) bool {
+	src, err := p.localFor(to.Addr())
+	if err != nil {
+		slog.Warn("no local address to reach the peer from; cannot create tunnel", "peer", p.peer.Name, "remote", to.String(), "err", err)
+		return false
 	}
-	p.mu.Unlock()
+	return p.pointTunnel(src.IP, src.Dev, to, false)
+}
 
-	// Pick local IP: first global-unicast IPv4 on any interface. This is
-	// good enough for the portfolio scope; a real impl would bind to the
-	// interface that carries the disco socket traffic to the peer.
-	local := firstGlobalV4()
+// localFor picks the local end of the outer path to remote: --local-ip if
+// given, else the source the kernel would use on its route to remote,
+// through --bind-iface if given. Without either override, a failed route
+// lookup falls back to the first global address of remote's family. The
+// device is only returned, and the link bound to it, for --bind-iface.
+func (p *peerFSM) localFor(remote netip.Addr) (tunnel.Source, error) {
+	remote = remote.Unmap()
+	if ip := p.deps.localIP; ip.IsValid() {
+		if ip.Is6() != remote.Is6() {
+			return tunnel.Source{}, fmt.Errorf("--local-ip %s can't reach %s", ip, remote)
+		}
+		return tunnel.Source{IP: ip, Dev: p.deps.bindIface}, nil
+	}
+	src, err := tunnel.LocalFor(p.deps.nl, remote, p.deps.bindIface)
+	if err == nil {
+		slog.Debug("outer path", "peer", p.peer.Name, "remote", remote, "local", src.IP, "dev", src.Dev)
+		if p.deps.bindIface == "" {
+			src.Dev = ""
+		}
+		return src, nil
+	}
+	if p.deps.bindIface != "" {
+		return tunnel.Source{}, err
+	}
+	local, family := firstGlobalV4(), "IPv4"
+	if remote.Is6() {
+		local, family = firstGlobalV6(), "IPv6"
+	}
 	if !local.IsValid() {
-		slog.Warn("no local IPv4; cannot create tunnel")
+		return tunnel.Source{}, fmt.Errorf("%w, and no global %s address to fall back on", err, family)
+	}
+	slog.Debug("route lookup failed; using the first global address", "peer", p.peer.Name, "remote", remote, "local", local, "err", err)
+	return tunnel.Source{IP: local}, nil
+}
+
+// bringUpRelay points the tunnel at a relay shim for peers that couldn't
+// punch.
+func (p *peerFSM) bringUpRelay() {
+	if p.deps.relay == nil {
+		slog.Warn("hole punch failed and there is no relay", "peer", p.peer.Name)
+		return
+	}
+	shim, err := p.deps.relay.open(p.peer.NodeKey)
+	if err != nil {
+		slog.Warn("hole punch failed; relay unavailable", "peer", p.peer.Name, "err", err)
 		return
 	}
+	p.mu.Lock()
+	p.relayed = true
+	p.mu.Unlock()
+	slog.Info("hole punch failed; relaying through coordinator", "peer", p.peer.Name, "shim", shim.String())
+	if !p.pointTunnel(netip.AddrFrom4([4]byte{127, 0, 0, 1}), "", shim, true) {
+		p.dropRelay()
+	}
+}
 
+// dropRelay removes the peer's relay shim once the tunnel no longer uses it.
+func (p *peerFSM) dropRelay() {
+	p.deps.relay.close(p.peer.NodeKey)
+	p.mu.Lock()
+	p.relayed = false
+	p.mu.Unlock()
+}
+
+// pointTunnel brings up the FOU/GRE link from local, bound to dev if set,
+// to the peer's FOU endpoint at to or, if it is up already, retargets it
+// there in place so its addresses and routes survive. loopback is set for
+// a relay shim.
+func (p *peerFSM) pointTunnel(local netip.Addr, dev string, to netip.AddrPort, loopback bool) bool {
 	cfg := tunnel.Config{
 		Name:          p.deps.ifaceName,
 		LocalIP:       local.AsSlice(),
 		RemoteIP:      to.Addr().AsSlice(),
+		Dev:           dev,
 		Encap:         tunnel.EncapFOU,
 		EncapDport:    to.Port(),
 		EncapSport:    p.deps.fouPort,
 		EncapChecksum: true,
+		Loopback:      loopback,
+	}
+	p.mu.Lock()
+	up := p.tunnelUp
+	outer6 := p.outer6
+	p.mu.Unlock()
+	if up && outer6 != local.Is6() {
+		// gre and ip6gre are different link kinds, so moving between IPv4
+		// and IPv6 paths takes a new link.
+		if err := tunnel.Delete(context.Background(), p.deps.nl, p.deps.ifaceName); err != nil {
+			slog.Warn("tunnel delete for family change", "iface", p.deps.ifaceName, "err", err)
+			return false
+		}
+		p.mu.Lock()
+		p.tunnelUp = false
+		p.mu.Unlock()
+		up = false
+	}
+	if up {
+		if err := tunnel.Retarget(context.Background(), p.deps.nl, cfg); err != nil {
+			slog.Warn("tunnel retarget", "iface", p.deps.ifaceName, "err", err)
+			return false
+		}
+		p.mu.Lock()
+		p.remote = to
+		p.mu.Unlock()
+		return true
 	}
 	if err := tunnel.Create(context.Background(), p.deps.nl, cfg); err != nil {
 		slog.Warn("tunnel create", "iface", p.deps.ifaceName, "err", err)
-		return
+		return false
 	}
 	if p.deps.selfTunnel.IsValid() && p.peer.TunnelIP.IsValid() {
-		cidr := fmt.Sprintf("%s/30", p.deps.selfTunnel.String())
+		bits := 30
+		if p.deps.selfTunnel.Is6() {
+			bits = 126
+		}
+		cidr := netip.PrefixFrom(p.deps.selfTunnel, bits).String()
 		_ = tunnel.AssignIP(context.Background(), p.deps.nl, p.deps.ifaceName, cidr)
 	}
 
 	p.mu.Lock()
 	p.tunnelUp = true
+	p.outer6 = local.Is6()
+	p.remote = to
 	p.mu.Unlock()
-	slog.Info("tunnel up", "iface", p.deps.ifaceName, "peer", p.peer.Name, "remote", to.String())
+	slog.Info("tunnel up", "iface", p.deps.ifaceName, "peer", p.peer.Name, "local", local, "remote", to.String())
+	return true
 }
 
 func gatherLocalAddrPorts(conn net.PacketConn) []string {
 	localUDP, _ := conn.LocalAddr().(*net.UDPAddr)
 	port := 0
+	dualStack := false
 	if localUDP != nil {
 		port = localUDP.Port
+		dualStack = localUDP.IP.To4() == nil
 	}
 	var out []string
 	if addrs, err := net.InterfaceAddrs(); err == nil {
@@ -415,32 +930,68 @@ func gatherLocalAddrPorts(conn net.PacketConn) []string {
 			if !ok {
 				continue
 			}
-			v4 := ipn.IP.To4()
-			if v4 == nil || v4.IsLoopback() {
+			ip, ok := netip.AddrFromSlice(ipn.IP)
+			if !ok {
 				continue
 			}
-			out = append(out, fmt.Sprintf("%s:%d", v4.String(), port))
+			ip = ip.Unmap()
+			if ip.IsLoopback() || (ip.Is6() && (!dualStack || !ip.IsGlobalUnicast())) {
+				continue
+			}
+			out = append(out, netip.AddrPortFrom(ip, uint16(port)).String())
 		}
 	}
 	return out
 }
 
-func firstGlobalV4() netip.Addr {
+// localAddrs is every global-unicast address on the host, IPv4 first.
+// IPv6 link-local addresses are left out: they'd need a zone.
+func localAddrs() []netip.Addr {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return netip.Addr{}
+		return nil
 	}
+	return globalAddrs(addrs)
+}
+
+// globalAddrs picks the global unicast addresses out of addrs, IPv4 first.
+func globalAddrs(addrs []net.Addr) []netip.Addr {
+	var v4, v6 []netip.Addr
 	for _, a := range addrs {
 		ipn, ok := a.(*net.IPNet)
 		if !ok {
 			continue
 		}
-		v4 := ipn.IP.To4()
-		if v4 == nil || v4.IsLoopback() || !v4.IsGlobalUnicast() {
+		ip, ok := netip.AddrFromSlice(ipn.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.IsLoopback() || !ip.IsGlobalUnicast() {
 			continue
 		}
-		if ap, ok := netip.AddrFromSlice(v4); ok {
-			return ap
+		if ip.Is4() {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	return append(v4, v6...)
+}
+
+func firstGlobalV4() netip.Addr {
+	for _, ip := range localAddrs() {
+		if ip.Is4() {
+			return ip
+		}
+	}
+	return netip.Addr{}
+}
+
+func firstGlobalV6() netip.Addr {
+	for _, ip := range localAddrs() {
+		if ip.Is6() {
+			return ip
 		}
 	}
 	return netip.Addr{}
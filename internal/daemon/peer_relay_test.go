@@ -0,0 +1,198 @@
+//go:build linux
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+	"github.com/HueCodes/gretun/internal/tunnel"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeNL keeps links in a map. Methods the FSM doesn't use panic through
+// the nil embedded interface.
+type fakeNL struct {
+	tunnel.Netlinker
+	links    map[string]netlink.Link
+	addrs    map[string][]netlink.Addr // what AddrList reports, by link name
+	routes   []netlink.Route           // what RouteGet reports; none = unreachable
+	modified int
+	deleted  int
+}
+
+func (f *fakeNL) LinkAdd(l netlink.Link) error {
+	f.links[l.Attrs().Name] = l
+	return nil
+}
+
+func (f *fakeNL) LinkModify(l netlink.Link) error {
+	f.modified++
+	f.links[l.Attrs().Name] = l
+	return nil
+}
+
+func (f *fakeNL) LinkDel(l netlink.Link) error {
+	f.deleted++
+	delete(f.links, l.Attrs().Name)
+	return nil
+}
+
+func (f *fakeNL) LinkByName(name string) (netlink.Link, error) {
+	if l, ok := f.links[name]; ok {
+		return l, nil
+	}
+	return nil, net.UnknownNetworkError(name)
+}
+
+func (f *fakeNL) LinkList() ([]netlink.Link, error) {
+	var out []netlink.Link
+	for _, l := range f.links {
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func (f *fakeNL) AddrList(l netlink.Link, _ int) ([]netlink.Addr, error) {
+	return f.addrs[l.Attrs().Name], nil
+}
+
+func (f *fakeNL) LinkByIndex(index int) (netlink.Link, error) {
+	for _, l := range f.links {
+		if l.Attrs().Index == index {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("no link %d", index)
+}
+
+func (f *fakeNL) RouteGet(dst net.IP) ([]netlink.Route, error) {
+	if len(f.routes) == 0 {
+		return nil, syscall.ENETUNREACH
+	}
+	return f.routes, nil
+}
+
+func (f *fakeNL) LinkSetUp(netlink.Link) error              { return nil }
+func (f *fakeNL) LinkSetMTU(netlink.Link, int) error        { return nil }
+func (f *fakeNL) AddrAdd(netlink.Link, *netlink.Addr) error { return nil }
+func (f *fakeNL) FouAdd(netlink.Fou) error                  { return nil }
+
+func TestRepunchDue_BacksOff(t *testing.T) {
+	fsm := newPeerFSM(peerDeps{}, disco.RemotePeer{Name: "b"})
+	fsm.state = stateRelay
+	fsm.retryEvery = relayRetryEvery
+	fsm.retryAt = time.Now().Add(time.Hour)
+	if ping, _ := fsm.repunchDue(); ping {
+		t.Fatal("re-punched before the backoff ran out")
+	}
+
+	want := relayRetryEvery
+	for i := 0; i < 6; i++ {
+		fsm.retryAt = time.Time{}
+		fsm.repunchUntil = time.Time{}
+		if ping, started := fsm.repunchDue(); !ping || !started {
+			t.Fatalf("round %d: no new burst once due", i)
+		}
+		want = min(2*want, relayRetryMax)
+		if fsm.retryEvery != want {
+			t.Fatalf("round %d: retryEvery = %v, want %v", i, fsm.retryEvery, want)
+		}
+		if ping, started := fsm.repunchDue(); !ping || started {
+			t.Fatalf("round %d: a burst should keep pinging without restarting", i)
+		}
+	}
+}
+
+func TestOnDiscoUDP_PongMovesRelayedTunnelInPlace(t *testing.T) {
+	local := firstGlobalV4()
+	if !local.IsValid() {
+		t.Skip("no global v4 on this host; skipping")
+	}
+	nl := &fakeNL{links: make(map[string]netlink.Link)}
+	deps := peerDeps{ifaceName: "gretun0", fouPort: 7777, nl: nl, relay: newRelayManager(nil, 7777)}
+	nk, _ := disco.GenerateNodeKey()
+	fsm := newPeerFSM(deps, disco.RemotePeer{Name: "b", NodeKey: nk.Pub})
+	fsm.state = statePunching
+
+	var deadline time.Time
+	fsm.tickPunch(&deadline)
+	if fsm.state != stateRelay || !fsm.relayed || !fsm.tunnelUp {
+		t.Fatalf("state = %v relayed = %v up = %v; want a relayed tunnel", fsm.state, fsm.relayed, fsm.tunnelUp)
+	}
+	if gre := nl.links["gretun0"].(*netlink.Gretun); !gre.Remote.IsLoopback() {
+		t.Fatalf("relayed tunnel remote = %v, want the loopback shim", gre.Remote)
+	}
+
+	from := netip.MustParseAddrPort("203.0.113.5:41641")
+	fsm.onDiscoUDP(nil, from, disco.Body{Type: disco.MsgPong}, &deadline)
+	if fsm.state != stateDirect || fsm.relayed {
+		t.Fatalf("state = %v relayed = %v after a pong", fsm.state, fsm.relayed)
+	}
+	if nl.modified != 1 || nl.deleted != 0 {
+		t.Fatalf("modified = %d deleted = %d; want the link changed in place", nl.modified, nl.deleted)
+	}
+	gre := nl.links["gretun0"].(*netlink.Gretun)
+	if gre.Remote.String() != "203.0.113.5" || gre.EncapDport != 41641 {
+		t.Errorf("tunnel now points at %v:%d", gre.Remote, gre.EncapDport)
+	}
+	if len(deps.relay.shims) != 0 {
+		t.Error("relay shim not closed after the upgrade")
+	}
+}
+
+func TestPointTunnel_FamilyChangeRecreatesLink(t *testing.T) {
+	nl := &fakeNL{links: make(map[string]netlink.Link)}
+	fsm := newPeerFSM(peerDeps{ifaceName: "gretun0", fouPort: 7777, nl: nl}, disco.RemotePeer{Name: "b"})
+
+	if !fsm.pointTunnel(netip.MustParseAddr("192.0.2.1"), "", netip.MustParseAddrPort("203.0.113.5:7777"), false) {
+		t.Fatal("IPv4 tunnel not created")
+	}
+	if !fsm.pointTunnel(netip.MustParseAddr("2001:db8::1"), "", netip.MustParseAddrPort("[2001:db8::2]:7777"), false) {
+		t.Fatal("tunnel not moved to the IPv6 path")
+	}
+	if nl.deleted != 1 || nl.modified != 0 {
+		t.Fatalf("deleted = %d modified = %d; a gre link can't be modified into ip6gre", nl.deleted, nl.modified)
+	}
+	if typ := nl.links["gretun0"].Type(); typ != "ip6gre" || !fsm.outer6 {
+		t.Errorf("link type = %q, outer6 = %v after the move", typ, fsm.outer6)
+	}
+}
+
+func TestLocalFor_RouteAndOverrides(t *testing.T) {
+	nl := &fakeNL{links: make(map[string]netlink.Link), addrs: make(map[string][]netlink.Addr)}
+	nl.links["eth0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	nl.links["wlan0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wlan0", Index: 3}}
+	nl.addrs["eth0"] = []netlink.Addr{{IPNet: &net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(24, 32)}}}
+	nl.routes = []netlink.Route{{LinkIndex: 3, Src: net.ParseIP("192.168.1.7")}}
+	remote := netip.MustParseAddr("203.0.113.5")
+
+	fsm := newPeerFSM(peerDeps{nl: nl}, disco.RemotePeer{Name: "b"})
+	src, err := fsm.localFor(remote)
+	if err != nil || src != (tunnel.Source{IP: netip.MustParseAddr("192.168.1.7")}) {
+		t.Errorf("route: %+v, %v; want the route's source, link left unbound", src, err)
+	}
+
+	fsm.deps.bindIface = "eth0"
+	src, err = fsm.localFor(remote)
+	if err != nil || src != (tunnel.Source{IP: netip.MustParseAddr("10.0.0.5"), Dev: "eth0"}) {
+		t.Errorf("--bind-iface: %+v, %v; want eth0's address, bound to eth0", src, err)
+	}
+	if _, err := fsm.localFor(netip.MustParseAddr("2001:db8::2")); err == nil {
+		t.Error("--bind-iface without an IPv6 address reached an IPv6 peer")
+	}
+
+	fsm.deps.localIP = netip.MustParseAddr("10.0.0.9")
+	src, err = fsm.localFor(remote)
+	if err != nil || src != (tunnel.Source{IP: fsm.deps.localIP, Dev: "eth0"}) {
+		t.Errorf("--local-ip: %+v, %v; want it as given", src, err)
+	}
+	if _, err := fsm.localFor(netip.MustParseAddr("2001:db8::2")); err == nil {
+		t.Error("IPv4 --local-ip used for an IPv6 peer")
+	}
+}
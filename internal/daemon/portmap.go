@@ -0,0 +1,86 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/portmap"
+)
+
+// portmapRetry is how long the daemon waits before asking a gateway that
+// granted nothing again. Each attempt can block a refresh for seconds.
+const portmapRetry = 5 * time.Minute
+
+// portMapper holds gateway port mappings for the disco socket and the FOU
+// port.
+type portMapper struct {
+	client *portmap.Client
+
+	mu      sync.Mutex
+	held    map[uint16]portmap.Mapping // by internal port
+	retryAt time.Time
+}
+
+// newPortMapper finds the default gateway, or returns nil if there is none.
+func newPortMapper() *portMapper {
+	gw, local, err := portmap.DefaultGateway()
+	if err != nil {
+		slog.Debug("port mapping disabled: no gateway", "err", err)
+		return nil
+	}
+	return &portMapper{client: portmap.NewClient(gw, local), held: make(map[uint16]portmap.Mapping)}
+}
+
+// refresh maps each port that isn't mapped yet and renews mappings that
+// are due.
+func (m *portMapper) refresh(ctx context.Context, ports ...uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Before(m.retryAt) {
+		return
+	}
+	for _, port := range ports {
+		if cur, ok := m.held[port]; ok && now.Before(cur.RenewAt()) {
+			continue
+		}
+		got, err := m.client.Map(ctx, port)
+		if err != nil {
+			slog.Info("gateway port mapping failed", "gateway", m.client.Gateway(), "port", port, "err", err)
+			delete(m.held, port)
+			m.retryAt = now.Add(portmapRetry)
+			return
+		}
+		if cur, ok := m.held[port]; !ok || cur.External != got.External {
+			slog.Info("gateway mapped port", "via", got.Protocol, "port", port, "external", got.External, "lifetime", got.Lifetime)
+		}
+		m.held[port] = got
+	}
+}
+
+// external is the public address the gateway forwards to port, if any.
+func (m *portMapper) external(port uint16) (netip.AddrPort, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	got, ok := m.held[port]
+	return got.External, ok
+}
+
+// release removes every mapping from the gateway.
+func (m *portMapper) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for port, pm := range m.held {
+		if err := m.client.Unmap(ctx, pm); err != nil {
+			slog.Debug("gateway port unmap", "port", port, "err", err)
+		}
+		delete(m.held, port)
+	}
+}
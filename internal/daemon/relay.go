@@ -0,0 +1,191 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+// shimBase is the loopback range relay shims bind in, one address per
+// relayed peer. The kernel identifies a GRE tunnel by its (local, remote)
+// pair, so every relayed tunnel needs a distinct remote address.
+var shimBase = netip.MustParseAddr("127.77.0.0")
+
+// relayManager carries the FOU traffic of peers that couldn't punch through
+// the coordinator's relay. The kernel tunnel to a relayed peer points at a
+// shim: a UDP socket on its own loopback address standing in for the
+// peer's FOU endpoint. Datagrams the kernel sends the shim go up the relay;
+// datagrams the relay delivers from the peer are written from the shim to
+// the local FOU port, so the kernel sees them arrive from "the peer".
+type relayManager struct {
+	client  *disco.CoordClient
+	fouPort uint16
+
+	mu          sync.Mutex
+	conn        *disco.RelayConn // nil while disconnected
+	unsupported bool
+	shims       map[string]*relayShim // by peer node key
+	nextShim    uint32
+	freeShims   []uint32 // slots of closed shims, oldest first
+}
+
+// relayShim is one relayed peer's stand-in FOU endpoint.
+type relayShim struct {
+	peer ed25519.PublicKey
+	conn *net.UDPConn
+	slot uint32 // index of its address in the shim range
+}
+
+func newRelayManager(client *disco.CoordClient, fouPort uint16) *relayManager {
+	return &relayManager{client: client, fouPort: fouPort, shims: make(map[string]*relayShim)}
+}
+
+// run keeps the relay connection up until ctx is done, or gives up for
+// good if the coordinator has no relay.
+func (m *relayManager) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		rc, err := m.client.DialRelay(ctx)
+		if errors.Is(err, disco.ErrRelayUnsupported) {
+			slog.Info("coordinator has no data-plane relay; peers that can't punch stay down")
+			m.mu.Lock()
+			m.unsupported = true
+			m.mu.Unlock()
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("relay connect", "err", err, "retry_in", backoff)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, relayRetryEvery)
+			continue
+		}
+		backoff = time.Second
+		slog.Info("relay connected", "coord", m.client.Coordinator())
+		m.mu.Lock()
+		m.conn = rc
+		m.mu.Unlock()
+		m.serve(ctx, rc)
+		m.mu.Lock()
+		m.conn = nil
+		m.mu.Unlock()
+		rc.Close()
+	}
+}
+
+// serve delivers relayed datagrams to their shims until rc fails.
+func (m *relayManager) serve(ctx context.Context, rc *disco.RelayConn) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		tick := time.NewTicker(disco.RelayKeepalive)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				_ = rc.Keepalive()
+			}
+		}
+	}()
+	fou := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(m.fouPort)}
+	for {
+		src, payload, err := rc.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("relay connection lost", "err", err)
+			}
+			return
+		}
+		m.mu.Lock()
+		shim := m.shims[string(src)]
+		m.mu.Unlock()
+		if shim == nil {
+			continue
+		}
+		_, _ = shim.conn.WriteToUDP(payload, fou)
+	}
+}
+
+// open creates the shim for peer and returns the address the kernel
+// tunnel should use as the peer's FOU endpoint.
+func (m *relayManager) open(peer ed25519.PublicKey) (netip.AddrPort, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unsupported {
+		return netip.AddrPort{}, disco.ErrRelayUnsupported
+	}
+	if s := m.shims[string(peer)]; s != nil {
+		return s.conn.LocalAddr().(*net.UDPAddr).AddrPort(), nil
+	}
+	// Reuse the address that has been free longest, so a tunnel still
+	// pointed at a just-closed shim isn't handed to another peer at once.
+	var slot uint32
+	if len(m.freeShims) > 0 {
+		slot, m.freeShims = m.freeShims[0], m.freeShims[1:]
+	} else {
+		if m.nextShim+1 >= 1<<16 {
+			return netip.AddrPort{}, errors.New("out of relay shim addresses")
+		}
+		m.nextShim++
+		slot = m.nextShim
+	}
+	ip := shimBase.As4()
+	ip[2], ip[3] = byte(slot>>8), byte(slot)
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4(ip), 0)))
+	if err != nil {
+		m.freeShims = append(m.freeShims, slot)
+		return netip.AddrPort{}, fmt.Errorf("relay shim: %w", err)
+	}
+	s := &relayShim{peer: peer, conn: conn, slot: slot}
+	m.shims[string(peer)] = s
+	go m.forward(s)
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort(), nil
+}
+
+// forward sends what the kernel writes to s up the relay, until s closes.
+func (m *relayManager) forward(s *relayShim) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		rc := m.conn
+		m.mu.Unlock()
+		if rc == nil {
+			continue // like a lossy link: dropped until the relay is back
+		}
+		_ = rc.Send(s.peer, buf[:n])
+	}
+}
+
+// close removes peer's shim and frees its address.
+func (m *relayManager) close(peer ed25519.PublicKey) {
+	m.mu.Lock()
+	s := m.shims[string(peer)]
+	delete(m.shims, string(peer))
+	if s != nil {
+		m.freeShims = append(m.freeShims, s.slot)
+	}
+	m.mu.Unlock()
+	if s != nil {
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/HueCodes/gretun/internal/coord"
+	"github.com/HueCodes/gretun/internal/disco"
+)
+
+// TestRelayManager_Bridges stands in for two kernels: each "FOU port" is a
+// plain UDP socket, and a datagram written to A's shim for B must come out
+// of B's shim for A, addressed to B's FOU port.
+func TestRelayManager_Bridges(t *testing.T) {
+	ts := httptest.NewServer(coord.NewServer(coord.NewMemStore(netip.MustParsePrefix("100.64.0.0/24")), coord.WithRelay()))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type node struct {
+		key disco.NodeKey
+		fou *net.UDPConn
+		m   *relayManager
+	}
+	mk := func(name string) node {
+		nk, _ := disco.GenerateNodeKey()
+		dk, _ := disco.GenerateDiscoKey()
+		c := disco.NewCoordClient(ts.URL, nk, dk)
+		if _, _, err := c.Register(ctx, disco.RegisterOptions{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		fou, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { fou.Close() })
+		m := newRelayManager(c, uint16(fou.LocalAddr().(*net.UDPAddr).Port))
+		go m.run(ctx)
+		return node{key: nk, fou: fou, m: m}
+	}
+	a, b := mk("a"), mk("b")
+
+	shimAB, err := a.m.open(b.key.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.m.close(b.key.Pub)
+	shimBA, err := b.m.open(a.key.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.m.close(a.key.Pub)
+	if !shimAB.Addr().IsLoopback() {
+		t.Fatalf("shim %v is not on loopback", shimAB)
+	}
+	// Tunnels are told apart by remote address, so each relayed peer of
+	// one node needs its own.
+	other, _ := disco.GenerateNodeKey()
+	shimAC, err := a.m.open(other.Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.m.close(other.Pub)
+	if shimAC.Addr() == shimAB.Addr() {
+		t.Fatalf("two relayed peers share shim address %v", shimAB.Addr())
+	}
+
+	// The relay connections come up asynchronously, and until they do the
+	// shim drops, so keep sending.
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatal("datagram never crossed the relay")
+		}
+		if _, err := a.fou.WriteToUDPAddrPort([]byte("gre"), shimAB); err != nil {
+			t.Fatal(err)
+		}
+		_ = b.fou.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, from, err := b.fou.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			continue
+		}
+		if string(buf[:n]) != "gre" || from != shimBA {
+			t.Fatalf("got %q from %v, want \"gre\" from %v", buf[:n], from, shimBA)
+		}
+		return
+	}
+}
+
+func TestRelayManager_ReusesShimAddresses(t *testing.T) {
+	m := newRelayManager(nil, 0)
+	keys := make([]disco.NodeKey, 3)
+	for i := range keys {
+		keys[i], _ = disco.GenerateNodeKey()
+	}
+	first, err := m.open(keys[0].Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.open(keys[1].Pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close(keys[1].Pub)
+	m.close(keys[0].Pub)
+
+	// Peers come and go for the life of the daemon; the range mustn't
+	// run out just because many have.
+	for i := 0; i < 3; i++ {
+		got, err := m.open(keys[2].Pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Addr() != first.Addr() {
+			t.Errorf("round %d: got %v, want the freed %v", i, got.Addr(), first.Addr())
+		}
+		if got.Addr() == second.Addr() {
+			t.Fatalf("reused %v while its peer still holds it", got.Addr())
+		}
+		m.close(keys[2].Pub)
+	}
+	if m.nextShim != 2 {
+		t.Errorf("nextShim = %d, want 2", m.nextShim)
+	}
+}
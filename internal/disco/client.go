@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/netip"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,39 +33,105 @@ type envelopeForWire struct {
 	Enqueue time.Time `json:"enqueue"`
 }
 
+// peerForWire is the wire shape of a peer in /v1/peers and /v1/stream.
+type peerForWire struct {
+	NodeKey   []byte            `json:"node_pubkey"`
+	DiscoKey  [32]byte          `json:"disco_pubkey"`
+	Name      string            `json:"node_name"`
+	TunnelIP  netip.Addr        `json:"tunnel_ip"`
+	Endpoints []endpointForWire `json:"endpoints"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	NAT       NATType           `json:"nat,omitempty"`
+}
+
+func (p peerForWire) remote() RemotePeer {
+	eps := make([]RemoteEndpoint, 0, len(p.Endpoints))
+	for _, e := range p.Endpoints {
+		eps = append(eps, RemoteEndpoint(e))
+	}
+	return RemotePeer{
+		NodeKey: p.NodeKey, DiscoKey: p.DiscoKey, Name: p.Name,
+		TunnelIP: p.TunnelIP, Endpoints: eps, UpdatedAt: p.UpdatedAt,
+		NAT: p.NAT,
+	}
+}
+
 // CoordClient is a thin client for the coordinator HTTP API. It signs every
-// non-register request with the caller's Ed25519 node key.
+// request with the caller's Ed25519 node key.
 type CoordClient struct {
-	base string
-	nk   NodeKey
-	dk   DiscoKey
-	http *http.Client
+	// bases are the coordinator replicas; cur indexes the one in use.
+	bases []string
+	cur   atomic.Int32
+	nk    NodeKey
+	dk    DiscoKey
+	http  *http.Client
+	// streamHTTP has no overall timeout; Watch uses an idle watchdog.
+	streamHTTP *http.Client
+	// coordPub, if set, must have signed every peer list; see PinCoordKey.
+	coordPub ed25519.PublicKey
+
+	mu           sync.Mutex
+	stunServers  []string  // advertised by the last successful Register
+	netmapIssued time.Time // issue time of the newest verified peer list
+	// netmapIssuedBy is the same per replica, each by its own clock.
+	netmapIssuedBy map[string]time.Time
 }
 
 // NewCoordClient constructs a client against base URL (e.g. http://coord:8443).
 func NewCoordClient(base string, nk NodeKey, dk DiscoKey) *CoordClient {
+	return NewFailoverCoordClient([]string{base}, nk, dk)
+}
+
+// NewFailoverCoordClient constructs a client against a group of coordinator
+// replicas. It sticks with one until it can't be reached or answers 503, as
+// a standby does, and then moves on to the next (or to the leader the
+// standby named).
+func NewFailoverCoordClient(bases []string, nk NodeKey, dk DiscoKey) *CoordClient {
+	trimmed := make([]string, len(bases))
+	for i, b := range bases {
+		trimmed[i] = strings.TrimRight(b, "/")
+	}
 	return &CoordClient{
-		base: base,
-		nk:   nk,
-		dk:   dk,
-		http: &http.Client{Timeout: 30 * time.Second},
+		bases:      trimmed,
+		nk:         nk,
+		dk:         dk,
+		http:       &http.Client{Timeout: 30 * time.Second},
+		streamHTTP: &http.Client{},
 	}
 }
 
+// RegisterOptions is what a node tells the coordinator about itself when it
+// registers.
+type RegisterOptions struct {
+	Name string
+	// AuthKey is only checked the first time a node key registers; later
+	// registrations of the same key don't need it.
+	AuthKey string
+	// Network names the overlay to join on a multi-network coordinator.
+	// Empty lets the auth key (or the coordinator's default) decide.
+	Network string
+	// TunnelIP asks for a specific address (e.g. "100.64.0.10"). The
+	// coordinator grants it only if it's free and in the pool; otherwise it
+	// allocates as usual, so callers must use the returned address.
+	TunnelIP string
+}
+
 // Register registers this node with the coordinator. The returned tunnel IP
 // is in CIDR notation (e.g. "100.64.0.5/24").
-func (c *CoordClient) Register(ctx context.Context, name string) (tunnelCIDR, etag string, err error) {
+func (c *CoordClient) Register(ctx context.Context, opts RegisterOptions) (tunnelCIDR, etag string, err error) {
 	body, _ := json.Marshal(struct {
-		NodePubkey  []byte   `json:"node_pubkey"`
-		DiscoPubkey [32]byte `json:"disco_pubkey"`
-		NodeName    string   `json:"node_name"`
-	}{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub, NodeName: name})
+		NodePubkey        []byte   `json:"node_pubkey"`
+		DiscoPubkey       [32]byte `json:"disco_pubkey"`
+		NodeName          string   `json:"node_name"`
+		RequestedTunnelIP string   `json:"requested_tunnel_ip,omitempty"`
+		AuthKey           string   `json:"auth_key,omitempty"`
+		Network           string   `json:"network,omitempty"`
+	}{NodePubkey: c.nk.Pub, DiscoPubkey: c.dk.Pub, NodeName: opts.Name, AuthKey: opts.AuthKey,
+		Network: opts.Network, RequestedTunnelIP: opts.TunnelIP})
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.base+"/v1/register", bytes.NewReader(body))
-	if err != nil {
-		return "", "", err
-	}
-	resp, err := c.http.Do(req)
+	// Signed, since the coordinator only lets a known node key re-register
+	// if the request proves we hold it.
+	resp, err := c.signedDo(ctx, "POST", "/v1/register", body)
 	if err != nil {
 		return "", "", err
 	}
@@ -70,29 +141,46 @@ func (c *CoordClient) Register(ctx context.Context, name string) (tunnelCIDR, et
 		return "", "", fmt.Errorf("register: %d: %s", resp.StatusCode, string(b))
 	}
 	var out struct {
-		TunnelIP string `json:"tunnel_ip"`
-		Etag     string `json:"peers_etag"`
+		TunnelIP    string   `json:"tunnel_ip"`
+		Etag        string   `json:"peers_etag"`
+		STUNServers []string `json:"stun_servers"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return "", "", err
 	}
+	c.mu.Lock()
+	c.stunServers = out.STUNServers
+	c.mu.Unlock()
 	return out.TunnelIP, out.Etag, nil
 }
 
-// PostEndpoints publishes the node's current endpoint candidates.
-func (c *CoordClient) PostEndpoints(ctx context.Context, eps []RemoteEndpoint) error {
+// STUNServers returns the STUN servers the coordinator advertised on the
+// last successful Register; nil if it advertised none.
+func (c *CoordClient) STUNServers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stunServers
+}
+
+// PostEndpoints publishes the node's current endpoint candidates and, if
+// nat is non-empty, the type of NAT they sit behind.
+func (c *CoordClient) PostEndpoints(ctx context.Context, eps []RemoteEndpoint, nat NATType) error {
 	wire := make([]endpointForWire, len(eps))
 	for i, e := range eps {
 		wire[i] = endpointForWire{Addr: e.Addr, Source: string(e.Source)}
 	}
 	body, _ := json.Marshal(struct {
 		Endpoints []endpointForWire `json:"endpoints"`
-	}{Endpoints: wire})
+		NAT       NATType           `json:"nat,omitempty"`
+	}{Endpoints: wire, NAT: nat})
 	resp, err := c.signedDo(ctx, "POST", "/v1/endpoints", body)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotRegistered
+	}
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("endpoints: %d: %s", resp.StatusCode, string(b))
@@ -114,9 +202,14 @@ type RemotePeer struct {
 	TunnelIP  netip.Addr
 	Endpoints []RemoteEndpoint
 	UpdatedAt time.Time
+	// NAT is the type of NAT the peer reported being behind; empty if it
+	// hasn't. Unsigned, so only a hint.
+	NAT NATType
 }
 
 // Peers fetches the peer list; if since != "" the coordinator long-polls.
+// With a pinned coordinator key, a list without a valid signature is an
+// ErrNetmapSignature, and one older than the last accepted ErrNetmapStale.
 func (c *CoordClient) Peers(ctx context.Context, since string) ([]RemotePeer, string, error) {
 	path := "/v1/peers"
 	if since != "" {
@@ -132,29 +225,20 @@ func (c *CoordClient) Peers(ctx context.Context, since string) ([]RemotePeer, st
 	}
 
 	var wire struct {
-		Etag  string `json:"etag"`
-		Peers []struct {
-			NodeKey   []byte            `json:"node_pubkey"`
-			DiscoKey  [32]byte          `json:"disco_pubkey"`
-			Name      string            `json:"node_name"`
-			TunnelIP  netip.Addr        `json:"tunnel_ip"`
-			Endpoints []endpointForWire `json:"endpoints"`
-			UpdatedAt time.Time         `json:"updated_at"`
-		} `json:"peers"`
+		Etag      string        `json:"etag"`
+		Peers     []peerForWire `json:"peers"`
+		IssuedAt  time.Time     `json:"issued_at"`
+		Signature []byte        `json:"sig"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&wire); err != nil {
 		return nil, "", err
 	}
 	out := make([]RemotePeer, 0, len(wire.Peers))
 	for _, p := range wire.Peers {
-		eps := make([]RemoteEndpoint, 0, len(p.Endpoints))
-		for _, e := range p.Endpoints {
-			eps = append(eps, RemoteEndpoint(e))
-		}
-		out = append(out, RemotePeer{
-			NodeKey: p.NodeKey, DiscoKey: p.DiscoKey, Name: p.Name,
-			TunnelIP: p.TunnelIP, Endpoints: eps, UpdatedAt: p.UpdatedAt,
-		})
+		out = append(out, p.remote())
+	}
+	if err := c.verifyNetmap(wire.Etag, wire.IssuedAt, out, wire.Signature); err != nil {
+		return nil, "", err
 	}
 	return out, wire.Etag, nil
 }
@@ -192,51 +276,139 @@ func (c *CoordClient) PullSignals(ctx context.Context) ([][]byte, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return nil, err
 	}
-	sealed := make([][]byte, 0, len(out.Envelopes))
-	for _, e := range out.Envelopes {
+	return sealedOf(out.Envelopes), nil
+}
+
+func sealedOf(envs []envelopeForWire) [][]byte {
+	sealed := make([][]byte, 0, len(envs))
+	for _, e := range envs {
 		sealed = append(sealed, e.Sealed)
 	}
-	return sealed, nil
+	return sealed
 }
 
 // signedDo issues a request with the gretun signature headers attached.
 func (c *CoordClient) signedDo(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	ts := strconv.FormatInt(time.Now().UTC().Unix(), 10)
-	digest := signingMaterial(ts, method, normalisePath(path), body)
-	sig := ed25519.Sign(c.nk.Priv, digest)
-	req.Header.Set("X-Gretun-Timestamp", ts)
-	req.Header.Set("X-Gretun-Node", base64.StdEncoding.EncodeToString(c.nk.Pub))
-	req.Header.Set("Authorization", "Gretun "+base64.StdEncoding.EncodeToString(sig))
-	return c.http.Do(req)
+	return c.do(ctx, c.http, func(base string) (*http.Request, error) {
+		return c.signedRequest(ctx, base, method, path, body)
+	})
+}
+
+// Coordinator returns the URL of the coordinator currently in use.
+func (c *CoordClient) Coordinator() string {
+	return c.bases[c.cur.Load()]
 }
 
-// normalisePath strips the query string from a request path so that the
-// signature covers the route only, not the query. This matches the server
-// which uses r.URL.Path for the signing material.
-func normalisePath(p string) string {
-	if i := indexQuery(p); i >= 0 {
-		return p[:i]
+// do sends the request build makes to the current coordinator, moving on to
+// the next one when it can't be reached or answers 503. Each coordinator is
+// tried at most once per call; the last failure is returned as is.
+func (c *CoordClient) do(ctx context.Context, hc *http.Client, build func(base string) (*http.Request, error)) (*http.Response, error) {
+	for tried := 1; ; tried++ {
+		i := c.cur.Load()
+		base := c.bases[i]
+		resp, err := c.doThrottled(ctx, hc, func() (*http.Request, error) { return build(base) })
+		failed := (err != nil && !errors.Is(err, ErrThrottled)) ||
+			(err == nil && resp.StatusCode == http.StatusServiceUnavailable)
+		if !failed || ctx.Err() != nil || tried >= len(c.bases) {
+			return resp, err
+		}
+		next := (i + 1) % int32(len(c.bases))
+		if resp != nil {
+			if j := c.indexOf(resp.Header.Get("X-Gretun-Leader")); j >= 0 && j != i {
+				next = j
+			}
+			resp.Body.Close()
+		}
+		if c.cur.CompareAndSwap(i, next) {
+			slog.Info("coordinator failover", "from", base, "to", c.bases[next], "err", err)
+		}
 	}
-	return p
 }
 
-func indexQuery(p string) int {
-	for i, c := range p {
-		if c == '?' {
-			return i
+func (c *CoordClient) indexOf(base string) int32 {
+	base = strings.TrimRight(base, "/")
+	for i, b := range c.bases {
+		if b == base && base != "" {
+			return int32(i)
 		}
 	}
 	return -1
 }
 
-func signingMaterial(ts, method, path string, body []byte) []byte {
+const (
+	// maxThrottleRetries is how many 429s in a row a request waits out
+	// before the 429 is handed to the caller.
+	maxThrottleRetries = 3
+	// maxRetryAfter is the longest Retry-After the client will sleep
+	// through; a longer one fails the request with ErrThrottled.
+	maxRetryAfter = time.Minute
+)
+
+// doThrottled sends the request build makes, waiting as long as the
+// coordinator's Retry-After asks whenever it answers 429. build is called
+// per attempt so signed requests get a fresh timestamp.
+func (c *CoordClient) doThrottled(ctx context.Context, hc *http.Client, build func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := hc.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == maxThrottleRetries {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		resp.Body.Close()
+		if wait > maxRetryAfter {
+			return nil, fmt.Errorf("%w: retry after %s", ErrThrottled, wait)
+		}
+		sleepCtx(ctx, wait)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header: delay-seconds or an HTTP date.
+// Missing or unparseable values mean one second.
+func retryAfter(v string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return time.Second
+}
+
+// signedRequest builds a request carrying the gretun signature headers.
+func (c *CoordClient) signedRequest(ctx context.Context, base, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	ts := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	var nb [16]byte
+	if _, err := rand.Read(nb[:]); err != nil {
+		return nil, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(nb[:])
+	// The signature covers the query too, so ?since= can't be swapped.
+	digest := signingMaterial(ts, nonce, method, path, body)
+	sig := ed25519.Sign(c.nk.Priv, digest)
+	req.Header.Set("X-Gretun-Timestamp", ts)
+	req.Header.Set("X-Gretun-Nonce", nonce)
+	req.Header.Set("X-Gretun-Node", base64.StdEncoding.EncodeToString(c.nk.Pub))
+	req.Header.Set("Authorization", "Gretun "+base64.StdEncoding.EncodeToString(sig))
+	return req, nil
+}
+
+func signingMaterial(ts, nonce, method, path string, body []byte) []byte {
 	var b bytes.Buffer
 	b.WriteString(ts)
 	b.WriteByte('\n')
+	b.WriteString(nonce)
+	b.WriteByte('\n')
 	b.WriteString(method)
 	b.WriteByte('\n')
 	b.WriteString(path)
@@ -248,3 +420,11 @@ func signingMaterial(ts, method, path string, body []byte) []byte {
 
 // ErrNoPeer is returned when a signalling target isn't registered yet.
 var ErrNoPeer = errors.New("no such peer")
+
+// ErrThrottled is returned when the coordinator rate-limits us for longer
+// than the client is willing to wait.
+var ErrThrottled = errors.New("throttled by coordinator")
+
+// ErrNotRegistered is returned when the coordinator no longer knows this
+// node, e.g. because it expired us after a long outage. Register again.
+var ErrNotRegistered = errors.New("node not registered with coordinator")
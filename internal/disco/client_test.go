@@ -17,6 +17,7 @@ import (
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSha256Sum(t *testing.T) {
@@ -32,70 +33,47 @@ func TestSha256Sum(t *testing.T) {
 	}
 }
 
-func TestNormalisePath(t *testing.T) {
-	cases := map[string]string{
-		"/v1/peers":                  "/v1/peers",
-		"/v1/peers?since=abc":        "/v1/peers",
-		"/v1/peers?":                 "/v1/peers",
-		"":                           "",
-		"?only-query":                "",
-		"/v1/peers?a=1&b=2":          "/v1/peers",
-	}
-	for in, want := range cases {
-		if got := normalisePath(in); got != want {
-			t.Errorf("normalisePath(%q) = %q, want %q", in, got, want)
-		}
-	}
-}
-
-func TestIndexQuery(t *testing.T) {
-	cases := map[string]int{
-		"":              -1,
-		"/no/query":     -1,
-		"?":             0,
-		"/p?q":          2,
-		"/p/q/r?x=1":    6,
-	}
-	for in, want := range cases {
-		if got := indexQuery(in); got != want {
-			t.Errorf("indexQuery(%q) = %d, want %d", in, got, want)
-		}
-	}
-}
-
 func TestSigningMaterial_Deterministic(t *testing.T) {
 	body := []byte(`{"foo":"bar"}`)
-	a := signingMaterial("100", "POST", "/v1/x", body)
-	b := signingMaterial("100", "POST", "/v1/x", body)
+	a := signingMaterial("100", "n", "POST", "/v1/x", body)
+	b := signingMaterial("100", "n", "POST", "/v1/x", body)
 	if !bytes.Equal(a, b) {
 		t.Errorf("signingMaterial should be deterministic")
 	}
 
 	// Different timestamp → different digest.
-	c := signingMaterial("101", "POST", "/v1/x", body)
+	c := signingMaterial("101", "n", "POST", "/v1/x", body)
 	if bytes.Equal(a, c) {
 		t.Errorf("different timestamps should produce different digests")
 	}
 
 	// Different body → different digest.
-	d := signingMaterial("100", "POST", "/v1/x", []byte(`{"foo":"baz"}`))
+	d := signingMaterial("100", "n", "POST", "/v1/x", []byte(`{"foo":"baz"}`))
 	if bytes.Equal(a, d) {
 		t.Errorf("different bodies should produce different digests")
 	}
+
+	// Different nonce or query → different digest.
+	if bytes.Equal(a, signingMaterial("100", "m", "POST", "/v1/x", body)) {
+		t.Errorf("different nonces should produce different digests")
+	}
+	if bytes.Equal(a, signingMaterial("100", "n", "POST", "/v1/x?since=a", body)) {
+		t.Errorf("the query string should be covered")
+	}
 }
 
 func TestSigningMaterial_Layout(t *testing.T) {
-	// Layout: ts \n method \n path \n sha256(body)
-	out := signingMaterial("42", "GET", "/x", []byte("body"))
-	parts := bytes.SplitN(out, []byte("\n"), 4)
-	if len(parts) != 4 {
-		t.Fatalf("expected 4 newline-separated fields, got %d", len(parts))
+	// Layout: ts \n nonce \n method \n path \n sha256(body)
+	out := signingMaterial("42", "abc", "GET", "/x?y=1", []byte("body"))
+	parts := bytes.SplitN(out, []byte("\n"), 5)
+	if len(parts) != 5 {
+		t.Fatalf("expected 5 newline-separated fields, got %d", len(parts))
 	}
-	if string(parts[0]) != "42" || string(parts[1]) != "GET" || string(parts[2]) != "/x" {
-		t.Errorf("bad header fields: %q %q %q", parts[0], parts[1], parts[2])
+	if string(parts[0]) != "42" || string(parts[1]) != "abc" || string(parts[2]) != "GET" || string(parts[3]) != "/x?y=1" {
+		t.Errorf("bad header fields: %q %q %q %q", parts[0], parts[1], parts[2], parts[3])
 	}
 	want := sha256.Sum256([]byte("body"))
-	if !bytes.Equal(parts[3], want[:]) {
+	if !bytes.Equal(parts[4], want[:]) {
 		t.Errorf("body digest wrong")
 	}
 }
@@ -284,7 +262,7 @@ func TestCoordClient_Register(t *testing.T) {
 	defer srv.Close()
 
 	c := NewCoordClient(srv.URL, nk, dk)
-	ip, etag, err := c.Register(context.Background(), "alice")
+	ip, etag, err := c.Register(context.Background(), RegisterOptions{Name: "alice"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -304,7 +282,7 @@ func TestCoordClient_Register_HTTPError(t *testing.T) {
 	}))
 	defer srv.Close()
 	c := NewCoordClient(srv.URL, nk, dk)
-	if _, _, err := c.Register(context.Background(), "x"); err == nil {
+	if _, _, err := c.Register(context.Background(), RegisterOptions{Name: "x"}); err == nil {
 		t.Error("expected error on 409")
 	}
 }
@@ -335,7 +313,7 @@ func TestCoordClient_PostEndpoints_SignsRequest(t *testing.T) {
 			http.Error(w, "bad auth header", http.StatusBadRequest)
 			return
 		}
-		digest := signingMaterial(ts, r.Method, r.URL.Path, body)
+		digest := signingMaterial(ts, r.Header.Get("X-Gretun-Nonce"), r.Method, r.URL.RequestURI(), body)
 		if ed25519.Verify(pub, digest, sig) {
 			sigOK.Store(true)
 		}
@@ -347,7 +325,7 @@ func TestCoordClient_PostEndpoints_SignsRequest(t *testing.T) {
 	ap, _ := netip.ParseAddrPort("1.2.3.4:5555")
 	if err := c.PostEndpoints(context.Background(), []RemoteEndpoint{
 		{Addr: ap, Source: "stun"},
-	}); err != nil {
+	}, NATSymmetric); err != nil {
 		t.Fatal(err)
 	}
 	if !sigOK.Load() {
@@ -467,7 +445,7 @@ func TestCoordClient_NetworkError(t *testing.T) {
 	srv.Close()
 
 	c := NewCoordClient(srv.URL, nk, dk)
-	if _, _, err := c.Register(context.Background(), "x"); err == nil {
+	if _, _, err := c.Register(context.Background(), RegisterOptions{Name: "x"}); err == nil {
 		t.Error("expected network error")
 	}
 }
@@ -483,7 +461,7 @@ func TestCoordClient_ContextCancelled(t *testing.T) {
 	c := NewCoordClient(srv.URL, nk, dk)
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
-	if _, _, err := c.Register(ctx, "x"); !errors.Is(err, context.Canceled) {
+	if _, _, err := c.Register(ctx, RegisterOptions{Name: "x"}); !errors.Is(err, context.Canceled) {
 		// Connection attempt may fail with a wrapped error — the key is we don't hang.
 		if err == nil {
 			t.Error("expected error with cancelled context")
@@ -491,3 +469,170 @@ func TestCoordClient_ContextCancelled(t *testing.T) {
 	}
 }
 
+
+func TestCoordClient_PostEndpoints_NotRegistered(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown peer", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := NewCoordClient(srv.URL, nk, dk)
+	if err := c.PostEndpoints(context.Background(), nil, ""); !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("want ErrNotRegistered on 404, got %v", err)
+	}
+}
+
+func TestCoordClient_HonorsRetryAfter(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	c := NewCoordClient(srv.URL, nk, dk)
+	if err := c.SendSignal(context.Background(), [32]byte{}, []byte("x")); err != nil {
+		t.Fatalf("throttled request should be retried: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("want 2 attempts, got %d", calls.Load())
+	}
+}
+
+func TestCoordClient_RetryAfterTooLong(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := NewCoordClient(srv.URL, nk, dk)
+	if err := c.PostEndpoints(context.Background(), nil, ""); !errors.Is(err, ErrThrottled) {
+		t.Errorf("want ErrThrottled, got %v", err)
+	}
+}
+
+func TestCoordClient_FailsOver(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"tunnel_ip":"100.64.0.5/24","peers_etag":"e"}`))
+	}))
+	defer leader.Close()
+	var standbyCalls atomic.Int32
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standbyCalls.Add(1)
+		w.Header().Set("X-Gretun-Leader", leader.URL)
+		http.Error(w, "standby coordinator", http.StatusServiceUnavailable)
+	}))
+	defer standby.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	// The standby names the leader, so the client skips past the dead one.
+	c := NewFailoverCoordClient([]string{standby.URL, dead.URL, leader.URL + "/"}, nk, dk)
+	if _, _, err := c.Register(context.Background(), RegisterOptions{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Coordinator() != leader.URL {
+		t.Errorf("coordinator = %s, want %s", c.Coordinator(), leader.URL)
+	}
+	if _, _, err := c.Register(context.Background(), RegisterOptions{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if standbyCalls.Load() != 1 {
+		t.Errorf("standby asked %d times; the client should stay on the leader", standbyCalls.Load())
+	}
+
+	// Unreachable and standby only: the last answer comes back.
+	lone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "standby coordinator", http.StatusServiceUnavailable)
+	}))
+	defer lone.Close()
+	c = NewFailoverCoordClient([]string{dead.URL, lone.URL}, nk, dk)
+	if _, _, err := c.Register(context.Background(), RegisterOptions{Name: "a"}); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("want the standby's 503, got %v", err)
+	}
+}
+
+func TestCoordClient_PeersAcrossSkewedReplicas(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	now := time.Now().UTC().Truncate(time.Second)
+	// replica serves an empty peer list stamped by its own clock, or a
+	// 503 once it's down.
+	type replica struct {
+		clock atomic.Int64
+		down  atomic.Bool
+	}
+	serve := func(rp *replica) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rp.down.Load() {
+				http.Error(w, "standby coordinator", http.StatusServiceUnavailable)
+				return
+			}
+			issued := time.Unix(rp.clock.Load(), 0).UTC()
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"etag":      "e",
+				"peers":     []any{},
+				"issued_at": issued,
+				"sig":       ed25519.Sign(priv, netmapMaterial(nk.Pub, "e", issued, nil)),
+			})
+		}))
+	}
+	var a, b replica
+	a.clock.Store(now.Unix())
+	b.clock.Store(now.Add(-20 * time.Second).Unix())
+	ta, tb := serve(&a), serve(&b)
+	defer ta.Close()
+	defer tb.Close()
+
+	c := NewFailoverCoordClient([]string{ta.URL, tb.URL}, nk, dk)
+	c.PinCoordKey(pub)
+	ctx := context.Background()
+	if _, _, err := c.Peers(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	// B's clock is 20s behind A's: its lists still count after failover.
+	a.down.Store(true)
+	if _, _, err := c.Peers(ctx, ""); err != nil {
+		t.Fatalf("after failover: %v", err)
+	}
+	if c.Coordinator() != tb.URL {
+		t.Fatalf("coordinator = %s, want %s", c.Coordinator(), tb.URL)
+	}
+	// But not one older than B's own last.
+	b.clock.Add(-1)
+	if _, _, err := c.Peers(ctx, ""); !errors.Is(err, ErrNetmapStale) {
+		t.Errorf("replayed list from B: err = %v, want ErrNetmapStale", err)
+	}
+	// Nor one further behind the newest than any replica's clock may be.
+	b.clock.Store(now.Add(-2 * replicaSkew).Unix())
+	if _, _, err := c.Peers(ctx, ""); !errors.Is(err, ErrNetmapStale) {
+		t.Errorf("list %v behind: err = %v, want ErrNetmapStale", 2*replicaSkew, err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"7":                             7 * time.Second,
+		"":                              time.Second,
+		"soon":                          time.Second,
+		"Mon, 01 Jan 2024 00:00:30 GMT": 30 * time.Second,
+		"Sun, 31 Dec 2023 23:00:00 GMT": 0,
+	}
+	for in, want := range cases {
+		if got := retryAfter(in, now); got != want {
+			t.Errorf("retryAfter(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
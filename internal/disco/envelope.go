@@ -45,6 +45,7 @@ type Body struct {
 	NodeKey   string      `json:"node_key,omitempty"`   // ping: b64 Ed25519 pubkey
 	Src       string      `json:"src,omitempty"`        // pong: ip:port the ping was seen from
 	Endpoints []string    `json:"endpoints,omitempty"`  // call_me_maybe
+	FOU       bool        `json:"fou,omitempty"`        // ping/pong: sent from the sender's kernel FOU port
 }
 
 // Marshal serialises a Body to JSON.
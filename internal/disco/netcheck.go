@@ -0,0 +1,326 @@
+package disco
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+// NATType is how the NAT in front of a socket maps it to public addresses,
+// in RFC 5780 terms. Peers read it off the coordinator to pick a punch
+// strategy.
+type NATType string
+
+const (
+	// NATUnknown means too few STUN servers answered to tell.
+	NATUnknown NATType = "unknown"
+	// NATNone means the mapped address is the socket's own: no NAT.
+	NATNone NATType = "none"
+	// NATEndpointIndependent NATs reuse one mapping for every destination,
+	// so the STUN-reported endpoint is reachable by anyone we ping.
+	NATEndpointIndependent NATType = "endpoint_independent"
+	// NATAddressDependent NATs map per destination IP but not per port.
+	NATAddressDependent NATType = "address_dependent"
+	// NATSymmetric NATs map per destination IP and port. Also reported when
+	// the mapping varies by IP and no server offered an alternate port to
+	// test the port dependence with.
+	NATSymmetric NATType = "symmetric"
+)
+
+// Hard reports whether t gives each destination its own mapping, so the
+// endpoint STUN reported is useless to a peer.
+func (t NATType) Hard() bool {
+	return t == NATAddressDependent || t == NATSymmetric
+}
+
+// hairpinTimeout caps the wait for a probe sent to our own public address.
+const hairpinTimeout = 500 * time.Millisecond
+
+// Mapping is one STUN server's view of our address.
+type Mapping struct {
+	Server string
+	Addr   netip.AddrPort
+}
+
+// NetcheckReport is the result of Netcheck.
+type NetcheckReport struct {
+	// Mappings are every answer, including the alternate-port probe.
+	Mappings []Mapping
+	NAT      NATType
+	// PortPreserved is set when every mapping kept the socket's local port.
+	PortPreserved bool
+	// Hairpin is set when a datagram sent to our own public address from
+	// another local socket came back in: the NAT loops traffic between two
+	// hosts behind it.
+	Hairpin bool
+}
+
+// Netcheck classifies the NAT in front of conn. It asks every server for
+// our mapped address from conn, and, when a server names an alternate
+// port in OTHER-ADDRESS, asks again on that port. Mappings that agree
+// across server IPs mean an endpoint-independent NAT; the alternate port
+// tells address-dependent from symmetric. Servers should be at least two
+// distinct IPs or the answer is NATUnknown.
+//
+// Like DiscoverPublic, it reads conn and doesn't close it: run it before
+// anything else reads the socket.
+func Netcheck(ctx context.Context, conn net.PacketConn, servers []string) (NetcheckReport, error) {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+	var (
+		probes []stunProbe
+		errs   []error
+		seen   = make(map[netip.AddrPort]bool)
+	)
+	for _, s := range servers {
+		raddr, err := net.ResolveUDPAddr("udp4", s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolve %s: %w", s, err))
+			continue
+		}
+		if ap := raddr.AddrPort(); !seen[ap] {
+			seen[ap] = true
+			probes = append(probes, stunProbe{server: s, addr: raddr})
+		}
+	}
+	if len(probes) == 0 {
+		return NetcheckReport{}, fmt.Errorf("all STUN servers unreachable: %w", errors.Join(errs...))
+	}
+
+	answers, err := stunRound(ctx, conn, probes)
+	if err != nil {
+		return NetcheckReport{}, err
+	}
+	if len(answers) == 0 {
+		return NetcheckReport{}, errors.New("no STUN server answered")
+	}
+
+	var rep NetcheckReport
+	var byIP []netip.AddrPort
+	ipSeen := make(map[netip.Addr]bool)
+	for _, a := range answers {
+		rep.Mappings = append(rep.Mappings, Mapping{Server: a.probe.server, Addr: a.mapped})
+		if ip := a.probe.addr.AddrPort().Addr(); !ipSeen[ip] {
+			ipSeen[ip] = true
+			byIP = append(byIP, a.mapped)
+		}
+	}
+
+	// Port dependence: ask the first server's IP again, on its other port.
+	var alt netip.AddrPort
+	first := answers[0]
+	if o := first.other; o.IsValid() && o.Port() != first.probe.addr.AddrPort().Port() {
+		ap := netip.AddrPortFrom(first.probe.addr.AddrPort().Addr(), o.Port())
+		altProbe := stunProbe{server: ap.String(), addr: net.UDPAddrFromAddrPort(ap)}
+		if more, err := stunRound(ctx, conn, []stunProbe{altProbe}); err == nil && len(more) == 1 {
+			alt = more[0].mapped
+			rep.Mappings = append(rep.Mappings, Mapping{Server: altProbe.server, Addr: alt})
+		}
+	}
+
+	localPort := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+	rep.NAT = classifyNAT(byIP, alt, func(m netip.AddrPort) bool {
+		return m.Port() == localPort && isLocalIP(m.Addr())
+	})
+	rep.PortPreserved = true
+	for _, m := range rep.Mappings {
+		if m.Addr.Port() != localPort {
+			rep.PortPreserved = false
+		}
+	}
+	rep.Hairpin = hairpin(ctx, conn, byIP[0])
+	return rep, nil
+}
+
+// classifyNAT applies RFC 5780's mapping tests. byIP holds one mapping per
+// distinct server IP, first server first; alt, if valid, is the mapping
+// the first server's IP reported on its other port. local says whether a
+// mapping is one of our own addresses.
+func classifyNAT(byIP []netip.AddrPort, alt netip.AddrPort, local func(netip.AddrPort) bool) NATType {
+	if len(byIP) == 0 {
+		return NATUnknown
+	}
+	allLocal := true
+	for _, m := range byIP {
+		if !local(m) {
+			allLocal = false
+		}
+	}
+	if allLocal {
+		return NATNone
+	}
+	if len(byIP) < 2 {
+		return NATUnknown
+	}
+	same := true
+	for _, m := range byIP[1:] {
+		if m != byIP[0] {
+			same = false
+		}
+	}
+	switch {
+	case same && (!alt.IsValid() || alt == byIP[0]):
+		return NATEndpointIndependent
+	case !same && alt.IsValid() && alt == byIP[0]:
+		return NATAddressDependent
+	default:
+		return NATSymmetric
+	}
+}
+
+// isLocalIP reports whether ip is assigned to one of this host's
+// interfaces.
+func isLocalIP(ip netip.Addr) bool {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if own, ok := netip.AddrFromSlice(ipn.IP); ok && own.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// hairpin sends a random token to our own public address from a second
+// socket and reports whether it comes in on conn.
+func hairpin(ctx context.Context, conn net.PacketConn, public netip.AddrPort) bool {
+	probe, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return false
+	}
+	defer probe.Close()
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	if _, err := probe.WriteTo(token, net.UDPAddrFromAddrPort(public)); err != nil {
+		return false
+	}
+
+	deadline := time.Now().Add(hairpinTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return false
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(buf[:n], token) {
+			return true
+		}
+	}
+}
+
+// stunProbe is one binding request destination.
+type stunProbe struct {
+	server string // as given, for reports
+	addr   *net.UDPAddr
+}
+
+// stunAnswer is a probe's response: our mapped address, and the server's
+// OTHER-ADDRESS if it sent one.
+type stunAnswer struct {
+	probe  stunProbe
+	mapped netip.AddrPort
+	other  netip.AddrPort
+}
+
+// stunRound sends a binding request to every probe from conn and collects
+// the answers, in probe order, until all are in, stunReadTimeout passes,
+// or ctx is done. Servers that don't answer are left out; it only fails
+// if no request could be sent or ctx was cancelled.
+func stunRound(ctx context.Context, conn net.PacketConn, probes []stunProbe) ([]stunAnswer, error) {
+	pending := make(map[[stun.TransactionIDSize]byte]int, len(probes))
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	var errs []error
+	for i, p := range probes {
+		if err := req.NewTransactionID(); err != nil {
+			errs = append(errs, fmt.Errorf("new txid: %w", err))
+			continue
+		}
+		req.Encode()
+		if _, err := conn.WriteTo(req.Raw, p.addr); err != nil {
+			errs = append(errs, fmt.Errorf("write %s: %w", p.server, err))
+			continue
+		}
+		pending[req.TransactionID] = i
+	}
+	if len(pending) == 0 {
+		return nil, fmt.Errorf("all STUN servers unreachable: %w", errors.Join(errs...))
+	}
+
+	deadline := time.Now().Add(stunReadTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("set read deadline: %w", err)
+	}
+	// Cancelling ctx cuts the read short.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	got := make([]*stunAnswer, len(probes))
+	buf := make([]byte, 1500)
+	for left := len(pending); left > 0; {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if !stun.IsMessage(buf[:n]) {
+			continue
+		}
+		msg := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+		if err := msg.Decode(); err != nil {
+			continue
+		}
+		i, ok := pending[msg.TransactionID]
+		if !ok || got[i] != nil {
+			continue
+		}
+		var xor stun.XORMappedAddress
+		if err := xor.GetFrom(msg); err != nil {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(xor.IP.To4())
+		if !ok {
+			continue
+		}
+		a := &stunAnswer{probe: probes[i], mapped: netip.AddrPortFrom(ip, uint16(xor.Port))}
+		var other stun.OtherAddress
+		if other.GetFrom(msg) == nil {
+			if oip, ok := netip.AddrFromSlice(other.IP.To4()); ok {
+				a.other = netip.AddrPortFrom(oip, uint16(other.Port))
+			}
+		}
+		got[i] = a
+		left--
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+
+	var out []stunAnswer
+	for _, a := range got {
+		if a != nil {
+			out = append(out, *a)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,88 @@
+package disco
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClassifyNAT(t *testing.T) {
+	a := netip.MustParseAddrPort("198.51.100.7:40000")
+	b := netip.MustParseAddrPort("198.51.100.7:40001")
+	notLocal := func(netip.AddrPort) bool { return false }
+	cases := []struct {
+		name string
+		byIP []netip.AddrPort
+		alt  netip.AddrPort
+		want NATType
+	}{
+		{"no answers", nil, netip.AddrPort{}, NATUnknown},
+		{"one server", []netip.AddrPort{a}, netip.AddrPort{}, NATUnknown},
+		{"same mapping", []netip.AddrPort{a, a}, netip.AddrPort{}, NATEndpointIndependent},
+		{"same mapping, alt port too", []netip.AddrPort{a, a}, a, NATEndpointIndependent},
+		{"varies by IP, not port", []netip.AddrPort{a, b}, a, NATAddressDependent},
+		{"varies by IP and port", []netip.AddrPort{a, b}, netip.MustParseAddrPort("198.51.100.7:40002"), NATSymmetric},
+		{"varies by IP, port untested", []netip.AddrPort{a, b}, netip.AddrPort{}, NATSymmetric},
+	}
+	for _, c := range cases {
+		if got := classifyNAT(c.byIP, c.alt, notLocal); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if got := classifyNAT([]netip.AddrPort{a}, netip.AddrPort{}, func(netip.AddrPort) bool { return true }); got != NATNone {
+		t.Errorf("own address: got %q, want none", got)
+	}
+	if !NATSymmetric.Hard() || NATEndpointIndependent.Hard() || NATUnknown.Hard() {
+		t.Error("Hard() misclassifies")
+	}
+}
+
+func TestNetcheck_NoNAT(t *testing.T) {
+	s1 := newMiniSTUN(t, 0)
+	defer s1.Close()
+	s2 := newMiniSTUN(t, 0)
+	defer s2.Close()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	rep, err := Netcheck(ctx, client, []string{s1.addr(), s2.addr(), s1.addr()})
+	if err != nil {
+		t.Fatalf("Netcheck: %v", err)
+	}
+	if len(rep.Mappings) != 2 {
+		t.Fatalf("mappings = %v, want one per distinct server", rep.Mappings)
+	}
+	if rep.NAT != NATNone || !rep.PortPreserved {
+		t.Errorf("nat = %q port preserved = %v; a loopback socket has no NAT", rep.NAT, rep.PortPreserved)
+	}
+	if !rep.Hairpin {
+		t.Error("a datagram to our own address should come back")
+	}
+}
+
+func TestNetcheck_NoAnswer(t *testing.T) {
+	dead, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	addr := dead.LocalAddr().String()
+	_ = dead.Close()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := Netcheck(ctx, client, []string{addr}); err == nil {
+		t.Fatal("expected an error when no server answers")
+	}
+}
@@ -0,0 +1,105 @@
+package disco
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// netmapContext must match coord's.
+const netmapContext = "gretun-netmap-v2"
+
+// ErrNetmapSignature means a peer list didn't carry a valid signature from
+// the pinned coordinator key. The list is discarded.
+var ErrNetmapSignature = errors.New("peer list not signed by the pinned coordinator key")
+
+// ErrNetmapStale means a correctly signed peer list was issued before one
+// this client already accepted: a replay. The list is discarded.
+var ErrNetmapStale = errors.New("peer list older than one already accepted")
+
+// replicaSkew is how far apart coordinator replicas' clocks may be, the
+// same window the coordinator allows request timestamps. Each replica
+// stamps lists by its own clock, so after a failover the new one's may
+// look older than the last one's.
+const replicaSkew = 60 * time.Second
+
+// PinCoordKey makes Peers and Watch reject any peer list that isn't signed
+// by pub. Call it before the first request.
+func (c *CoordClient) PinCoordKey(pub ed25519.PublicKey) {
+	c.coordPub = pub
+}
+
+// ParseCoordKey decodes a coordinator public key given as base64 (as
+// gretun-coord logs it) or hex.
+func ParseCoordKey(s string) (ed25519.PublicKey, error) {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == ed25519.PublicKeySize {
+		return b, nil
+	}
+	if b, err := hex.DecodeString(s); err == nil && len(b) == ed25519.PublicKeySize {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%q is not a base64 or hex ed25519 public key", s)
+}
+
+// verifyNetmap checks sig over peers as served to this node under etag at
+// issued, and that no list issued later was accepted before it: none from
+// the replica in use, and none from another by more than replicaSkew.
+// Without a pinned key everything passes.
+func (c *CoordClient) verifyNetmap(etag string, issued time.Time, peers []RemotePeer, sig []byte) error {
+	if c.coordPub == nil {
+		return nil
+	}
+	if len(sig) == 0 || !ed25519.Verify(c.coordPub, netmapMaterial(c.nk.Pub, etag, issued, peers), sig) {
+		return ErrNetmapSignature
+	}
+	base := c.Coordinator()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, seen := c.netmapIssuedBy[base]
+	if seen && issued.Before(last) || issued.Before(c.netmapIssued.Add(-replicaSkew)) {
+		return ErrNetmapStale
+	}
+	if c.netmapIssuedBy == nil {
+		c.netmapIssuedBy = make(map[string]time.Time)
+	}
+	c.netmapIssuedBy[base] = issued
+	if issued.After(c.netmapIssued) {
+		c.netmapIssued = issued
+	}
+	return nil
+}
+
+// netmapMaterial mirrors coord.netmapMaterial byte for byte.
+func netmapMaterial(node ed25519.PublicKey, etag string, issued time.Time, peers []RemotePeer) []byte {
+	sorted := append([]RemotePeer(nil), peers...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].NodeKey, sorted[j].NodeKey) < 0 })
+
+	b := []byte(netmapContext)
+	b = appendField(b, node)
+	b = appendField(b, []byte(etag))
+	b = binary.BigEndian.AppendUint64(b, uint64(issued.UnixNano()))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(sorted)))
+	for _, p := range sorted {
+		b = appendField(b, p.NodeKey)
+		b = append(b, p.DiscoKey[:]...)
+		b = appendField(b, []byte(p.Name))
+		b = appendField(b, []byte(p.TunnelIP.String()))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(p.Endpoints)))
+		for _, e := range p.Endpoints {
+			b = appendField(b, []byte(e.Addr.String()))
+			b = appendField(b, []byte(e.Source))
+		}
+	}
+	return b
+}
+
+func appendField(b, field []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+	return append(b, field...)
+}
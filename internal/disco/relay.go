@@ -0,0 +1,138 @@
+package disco
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Relay framing; must match coord's.
+const (
+	relayProto = "gretun-relay-v1"
+
+	relayFrameSend      = 1
+	relayFrameRecv      = 2
+	relayFrameKeepalive = 3
+
+	relayMaxFrame = ed25519.PublicKeySize + 64<<10
+	// RelayKeepalive is how often a RelayConn user should call Keepalive
+	// when it has nothing else to send.
+	RelayKeepalive = 20 * time.Second
+)
+
+// ErrRelayUnsupported means the coordinator doesn't run a data-plane relay.
+var ErrRelayUnsupported = errors.New("coordinator has no relay")
+
+// relayHTTP speaks only HTTP/1.1: the relay is reached by an Upgrade,
+// which HTTP/2 doesn't have. Every dial takes over its own connection.
+var relayHTTP = &http.Client{Transport: &http.Transport{
+	Proxy:        http.ProxyFromEnvironment,
+	TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+}}
+
+// RelayConn is a connection to the coordinator's data-plane relay. It
+// carries opaque datagrams to and from other nodes, addressed by node key.
+// Send and Recv may be called from different goroutines.
+type RelayConn struct {
+	rwc io.ReadWriteCloser
+	br  *bufio.Reader
+
+	wmu sync.Mutex
+	buf []byte
+}
+
+// DialRelay opens the relay connection. It lasts until ctx is done or
+// Close is called.
+func (c *CoordClient) DialRelay(ctx context.Context) (*RelayConn, error) {
+	resp, err := c.do(ctx, relayHTTP, func(base string) (*http.Request, error) {
+		req, err := c.signedRequest(ctx, base, "GET", "/v1/relay", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", relayProto)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		return nil, ErrRelayUnsupported
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("relay: %d: %s", resp.StatusCode, b)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("relay: upgraded body is not writable")
+	}
+	rc := &RelayConn{rwc: rwc, br: bufio.NewReader(rwc)}
+	go func() {
+		<-ctx.Done()
+		rc.Close()
+	}()
+	return rc, nil
+}
+
+// Send relays one datagram to the node with key dst. Like UDP, delivery
+// isn't guaranteed: the relay drops when dst isn't connected or is slow.
+func (r *RelayConn) Send(dst ed25519.PublicKey, payload []byte) error {
+	return r.writeFrame(relayFrameSend, dst, payload)
+}
+
+// Keepalive tells the relay the connection is alive.
+func (r *RelayConn) Keepalive() error {
+	return r.writeFrame(relayFrameKeepalive, nil, nil)
+}
+
+func (r *RelayConn) writeFrame(typ byte, key, payload []byte) error {
+	r.wmu.Lock()
+	defer r.wmu.Unlock()
+	b := append(r.buf[:0], typ)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(key)+len(payload)))
+	b = append(b, key...)
+	b = append(b, payload...)
+	r.buf = b
+	_, err := r.rwc.Write(b)
+	return err
+}
+
+// Recv returns the next datagram relayed to this node and the node key of
+// its sender. Keepalives are skipped.
+func (r *RelayConn) Recv() (ed25519.PublicKey, []byte, error) {
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+			return nil, nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[1:])
+		if n > relayMaxFrame {
+			return nil, nil, fmt.Errorf("relay frame of %d bytes exceeds %d", n, relayMaxFrame)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r.br, body); err != nil {
+			return nil, nil, err
+		}
+		if hdr[0] != relayFrameRecv || len(body) < ed25519.PublicKeySize {
+			continue
+		}
+		return ed25519.PublicKey(body[:ed25519.PublicKeySize]), body[ed25519.PublicKeySize:], nil
+	}
+}
+
+// Close closes the connection; a blocked Recv returns an error.
+func (r *RelayConn) Close() error {
+	return r.rwc.Close()
+}
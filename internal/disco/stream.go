@@ -0,0 +1,247 @@
+package disco
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// streamIdleTimeout drops a stream that has been silent for this long.
+	// The coordinator writes a keepalive every 15s.
+	streamIdleTimeout = 45 * time.Second
+	// streamRetry is how long Watch long-polls before trying the stream
+	// again on a coordinator that didn't offer one.
+	streamRetry = 5 * time.Minute
+	// watchBackoff is the pause after a failed stream or poll.
+	watchBackoff = 2 * time.Second
+)
+
+// errStreamUnsupported means the coordinator has no GET /v1/stream.
+var errStreamUnsupported = errors.New("coordinator does not support streaming")
+
+// unknownPeerBody is what the coordinator's handlers answer a 404 with
+// when they don't know the caller, as opposed to the mux's 404 for a
+// route it doesn't have.
+const unknownPeerBody = "unknown peer"
+
+// WatchHandlers receives what Watch learns. Peers always gets the full
+// current peer list, sorted by tunnel IP, however it was learned.
+type WatchHandlers struct {
+	Peers   func([]RemotePeer)
+	Signals func([][]byte)
+}
+
+// Watch delivers this node's peer list and incoming envelopes until ctx is
+// done. It holds one GET /v1/stream open when the coordinator offers it,
+// and otherwise falls back to the /v1/peers and /v1/signal long-polls,
+// trying the stream again every few minutes. Handlers are called from one
+// goroutine at a time per kind.
+func (c *CoordClient) Watch(ctx context.Context, h WatchHandlers) error {
+	for ctx.Err() == nil {
+		err := c.stream(ctx, h)
+		switch {
+		case ctx.Err() != nil:
+		case errors.Is(err, ErrNotRegistered):
+			// The endpoint refresh re-registers us; keep retrying the
+			// stream until it has.
+			slog.Warn("coordinator stream: not registered; waiting to re-register")
+			sleepCtx(ctx, watchBackoff)
+		case errors.Is(err, errStreamUnsupported):
+			slog.Info("coordinator stream unavailable; long-polling", "retry_in", streamRetry)
+			pollCtx, cancel := context.WithTimeout(ctx, streamRetry)
+			c.longPoll(pollCtx, h)
+			cancel()
+		default:
+			slog.Warn("coordinator stream", "err", err)
+			sleepCtx(ctx, watchBackoff)
+		}
+	}
+	return ctx.Err()
+}
+
+// stream consumes GET /v1/stream until it breaks.
+func (c *CoordClient) stream(ctx context.Context, h WatchHandlers) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	resp, err := c.do(ctx, c.streamHTTP, func(base string) (*http.Request, error) {
+		return c.signedRequest(ctx, base, "GET", "/v1/stream", nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if strings.TrimSpace(string(b)) == unknownPeerBody {
+			return ErrNotRegistered
+		}
+		return errStreamUnsupported
+	case resp.StatusCode == http.StatusMethodNotAllowed:
+		return errStreamUnsupported
+	case resp.StatusCode != http.StatusOK:
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("stream: %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	view := make(map[string]RemotePeer)
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 8<<20)
+	var event, data string
+	for sc.Scan() {
+		idle.Reset(streamIdleTimeout)
+		line := sc.Text()
+		switch {
+		case line == "":
+			if event != "" {
+				if err := c.applyStreamEvent(view, event, data, h); err != nil {
+					return err
+				}
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// applyStreamEvent folds one event into view and hands the result to h.
+// With a pinned coordinator key, a peers or delta event whose signature
+// doesn't cover the resulting view, or that is older than the last list
+// accepted, is an error, which drops the stream.
+func (c *CoordClient) applyStreamEvent(view map[string]RemotePeer, event, data string, h WatchHandlers) error {
+	var etag string
+	var issued time.Time
+	var sig []byte
+	switch event {
+	case "peers":
+		var ev struct {
+			Etag      string        `json:"etag"`
+			Peers     []peerForWire `json:"peers"`
+			IssuedAt  time.Time     `json:"issued_at"`
+			Signature []byte        `json:"sig"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("stream peers: %w", err)
+		}
+		clear(view)
+		for _, p := range ev.Peers {
+			view[string(p.NodeKey)] = p.remote()
+		}
+		etag, issued, sig = ev.Etag, ev.IssuedAt, ev.Signature
+	case "delta":
+		var ev struct {
+			Etag      string        `json:"etag"`
+			Upsert    []peerForWire `json:"upsert"`
+			Remove    [][]byte      `json:"remove"`
+			IssuedAt  time.Time     `json:"issued_at"`
+			Signature []byte        `json:"sig"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("stream delta: %w", err)
+		}
+		for _, p := range ev.Upsert {
+			view[string(p.NodeKey)] = p.remote()
+		}
+		for _, k := range ev.Remove {
+			delete(view, string(k))
+		}
+		etag, issued, sig = ev.Etag, ev.IssuedAt, ev.Signature
+	case "signal":
+		var ev struct {
+			Envelopes []envelopeForWire `json:"envelopes"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("stream signal: %w", err)
+		}
+		if h.Signals != nil {
+			h.Signals(sealedOf(ev.Envelopes))
+		}
+		return nil
+	default:
+		// Unknown events are for newer clients.
+		return nil
+	}
+	peers := make([]RemotePeer, 0, len(view))
+	for _, p := range view {
+		peers = append(peers, p)
+	}
+	if err := c.verifyNetmap(etag, issued, peers, sig); err != nil {
+		return err
+	}
+	if h.Peers != nil {
+		sort.Slice(peers, func(i, j int) bool { return peers[i].TunnelIP.Less(peers[j].TunnelIP) })
+		h.Peers(peers)
+	}
+	return nil
+}
+
+// longPoll runs the /v1/peers and /v1/signal long-polls until ctx is done.
+func (c *CoordClient) longPoll(ctx context.Context, h WatchHandlers) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var etag string
+		for ctx.Err() == nil {
+			peers, newEtag, err := c.Peers(ctx, etag)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("peers poll", "err", err)
+					sleepCtx(ctx, watchBackoff)
+				}
+				continue
+			}
+			etag = newEtag
+			if h.Peers != nil {
+				h.Peers(peers)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			sealed, err := c.PullSignals(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("pull signals", "err", err)
+					sleepCtx(ctx, watchBackoff)
+				}
+				continue
+			}
+			if h.Signals != nil && len(sealed) > 0 {
+				h.Signals(sealed)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,103 @@
+package disco
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCoordClient_Watch_FallsBackToLongPoll(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/peers":
+			if r.URL.Query().Get("since") != "" {
+				// Hold the long-poll like the real coordinator would.
+				<-r.Context().Done()
+				return
+			}
+			_, _ = w.Write([]byte(`{"etag":"e1","peers":[{"node_pubkey":"AQID","tunnel_ip":"100.64.0.2"}]}`))
+		case "/v1/signal":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan []RemotePeer, 1)
+	c := NewCoordClient(srv.URL, nk, dk)
+	go func() {
+		_ = c.Watch(ctx, WatchHandlers{Peers: func(p []RemotePeer) { got <- p }})
+	}()
+	select {
+	case peers := <-got:
+		if len(peers) != 1 || peers[0].TunnelIP.String() != "100.64.0.2" {
+			t.Errorf("unexpected peers %+v", peers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no peers delivered via long-poll")
+	}
+}
+
+func TestCoordClient_Stream_UnknownPeerIsNotUnsupported(t *testing.T) {
+	nk, _ := GenerateNodeKey()
+	dk, _ := GenerateDiscoKey()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/stream" {
+			http.Error(w, "unknown peer", http.StatusNotFound)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewCoordClient(srv.URL, nk, dk)
+	if err := c.stream(context.Background(), WatchHandlers{}); !errors.Is(err, ErrNotRegistered) {
+		t.Errorf("unknown peer: want ErrNotRegistered, got %v", err)
+	}
+
+	srv.Config.Handler = http.NotFoundHandler()
+	if err := c.stream(context.Background(), WatchHandlers{}); !errors.Is(err, errStreamUnsupported) {
+		t.Errorf("missing route: want errStreamUnsupported, got %v", err)
+	}
+}
+
+func TestApplyStreamEvent(t *testing.T) {
+	c := &CoordClient{}
+	view := map[string]RemotePeer{}
+	var last []RemotePeer
+	h := WatchHandlers{Peers: func(p []RemotePeer) { last = p }}
+
+	steps := []struct {
+		event, data string
+		want        []string
+	}{
+		{"peers", `{"peers":[{"node_pubkey":"AQ==","tunnel_ip":"100.64.0.2"},{"node_pubkey":"Ag==","tunnel_ip":"100.64.0.1"}]}`, []string{"100.64.0.1", "100.64.0.2"}},
+		{"delta", `{"upsert":[{"node_pubkey":"AQ==","tunnel_ip":"100.64.0.9"}],"remove":["Ag=="]}`, []string{"100.64.0.9"}},
+		{"peers", `{"peers":[]}`, nil},
+	}
+	for _, s := range steps {
+		if err := c.applyStreamEvent(view, s.event, s.data, h); err != nil {
+			t.Fatal(err)
+		}
+		if len(last) != len(s.want) {
+			t.Fatalf("after %s: got %d peers, want %d", s.event, len(last), len(s.want))
+		}
+		for i, ip := range s.want {
+			if last[i].TunnelIP.String() != ip {
+				t.Errorf("after %s: peer %d = %s, want %s", s.event, i, last[i].TunnelIP, ip)
+			}
+		}
+	}
+	if err := c.applyStreamEvent(view, "delta", "{", h); err == nil {
+		t.Error("bad JSON should fail")
+	}
+}
@@ -43,6 +43,20 @@ type PublicEndpoint struct {
 // subsequent traffic rides the same NAT mapping — that's the whole point of
 // STUN for hole punching.
 func DiscoverPublic(ctx context.Context, conn net.PacketConn, servers []string) (PublicEndpoint, error) {
+	return discoverPublic(ctx, conn, "udp4", servers)
+}
+
+// DiscoverPublic6 is DiscoverPublic over IPv6: it asks the servers' IPv6
+// addresses, so conn must be an IPv6 or dual-stack socket. Most IPv6 hosts
+// aren't behind a NAT and get their own address back, but the answer still
+// shows what a firewall or NPTv6 prefix translation in between does.
+func DiscoverPublic6(ctx context.Context, conn net.PacketConn, servers []string) (PublicEndpoint, error) {
+	return discoverPublic(ctx, conn, "udp6", servers)
+}
+
+// discoverPublic resolves servers for network, "udp4" or "udp6", and
+// races them on conn.
+func discoverPublic(ctx context.Context, conn net.PacketConn, network string, servers []string) (PublicEndpoint, error) {
 	if len(servers) == 0 {
 		servers = DefaultSTUNServers
 	}
@@ -57,7 +71,7 @@ func DiscoverPublic(ctx context.Context, conn net.PacketConn, servers []string)
 
 	var sendErrs []error
 	for _, s := range servers {
-		raddr, err := net.ResolveUDPAddr("udp4", s)
+		raddr, err := net.ResolveUDPAddr(network, s)
 		if err != nil {
 			sendErrs = append(sendErrs, fmt.Errorf("resolve %s: %w", s, err))
 			continue
@@ -118,13 +132,13 @@ func DiscoverPublic(ctx context.Context, conn net.PacketConn, servers []string)
 			if err := xor.GetFrom(msg); err != nil {
 				continue
 			}
-			ip, ok := netip.AddrFromSlice(xor.IP.To4())
+			ip, ok := netip.AddrFromSlice(xor.IP)
 			if !ok {
 				continue
 			}
 			resCh <- result{
 				ep: PublicEndpoint{
-					Addr: netip.AddrPortFrom(ip, uint16(xor.Port)),
+					Addr: netip.AddrPortFrom(ip.Unmap(), uint16(xor.Port)),
 					Via:  via,
 				},
 			}
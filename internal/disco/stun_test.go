@@ -3,6 +3,7 @@ package disco
 import (
 	"context"
 	"net"
+	"net/netip"
 	"strings"
 	"sync"
 	"testing"
@@ -23,7 +24,13 @@ type miniSTUN struct {
 
 func newMiniSTUN(t *testing.T, delay time.Duration) *miniSTUN {
 	t.Helper()
-	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	return newMiniSTUNOn(t, "udp4", "127.0.0.1:0", delay)
+}
+
+// newMiniSTUNOn is newMiniSTUN listening on addr instead of IPv4 loopback.
+func newMiniSTUNOn(t *testing.T, network, addr string, delay time.Duration) *miniSTUN {
+	t.Helper()
+	conn, err := net.ListenPacket(network, addr)
 	if err != nil {
 		t.Fatalf("listen: %v", err)
 	}
@@ -111,6 +118,34 @@ func TestDiscoverPublic_Succeeds(t *testing.T) {
 	}
 }
 
+func TestDiscoverPublic6_DualStack(t *testing.T) {
+	if ln, err := net.ListenPacket("udp6", "[::1]:0"); err != nil {
+		t.Skip("no IPv6 loopback; skipping")
+	} else {
+		ln.Close()
+	}
+	srv := newMiniSTUNOn(t, "udp6", "[::1]:0", 0)
+	defer srv.Close()
+
+	// The daemon's disco socket: one port, both families.
+	client, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ep, err := DiscoverPublic6(ctx, client, []string{srv.addr()})
+	if err != nil {
+		t.Fatalf("DiscoverPublic6: %v", err)
+	}
+	if ep.Addr.Addr() != netip.IPv6Loopback() || ep.Addr.Port() != uint16(client.LocalAddr().(*net.UDPAddr).Port) {
+		t.Errorf("mapped = %v, want [::1] on the client's port", ep.Addr)
+	}
+}
+
 func TestDiscoverPublic_Race(t *testing.T) {
 	slow := newMiniSTUN(t, 500*time.Millisecond)
 	defer slow.Close()
@@ -0,0 +1,64 @@
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rtfGateway is RTF_GATEWAY from <linux/route.h>.
+const rtfGateway = 0x2
+
+// DefaultGateway returns the IPv4 default gateway from /proc/net/route and
+// the local address this host reaches it from.
+func DefaultGateway() (gw, local netip.Addr, err error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	defer f.Close()
+	if gw, err = parseProcRoute(f); err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+	// Connecting a UDP socket sends nothing; it only picks the source.
+	c, err := net.Dial("udp4", netip.AddrPortFrom(gw, 5351).String())
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("route to gateway %s: %w", gw, err)
+	}
+	defer c.Close()
+	return gw, c.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
+
+// parseProcRoute finds the default route's gateway in r, which is laid
+// out like /proc/net/route: a header line, then Iface, Destination,
+// Gateway, Flags, ... with addresses as little-endian hex.
+func parseProcRoute(r io.Reader) (netip.Addr, error) {
+	s := bufio.NewScanner(r)
+	s.Scan() // header
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 4 || f[1] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(f[3], 16, 32)
+		if err != nil || flags&rtfGateway == 0 {
+			continue
+		}
+		b, err := hex.DecodeString(f[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		var ip [4]byte
+		binary.LittleEndian.PutUint32(ip[:], binary.BigEndian.Uint32(b))
+		return netip.AddrFrom4(ip), nil
+	}
+	return netip.Addr{}, errors.New("no IPv4 default route")
+}
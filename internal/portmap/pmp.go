@@ -0,0 +1,164 @@
+package portmap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// PCP and NAT-PMP share the gateway's UDP port 5351 and the retransmit
+// schedule. A NAT-PMP-only gateway answers a PCP request with a NAT-PMP
+// "unsupported version" response, which sends Map on to NAT-PMP.
+
+const (
+	pcpVersion  = 2
+	pcpOpMap    = 1
+	pcpReply    = 0x80 // R bit, set on responses
+	pcpMapLen   = 60   // MAP request and response, header included
+	pmpVersion  = 0
+	pmpOpAddr   = 0
+	pmpOpMapUDP = 1
+	pmpReply    = 128
+	pmpTries    = 3
+	protoUDP    = 17
+)
+
+var errUnsupportedVersion = errors.New("unsupported version")
+
+// pcpMap sends a PCP MAP request for internal (RFC 6887 §11). A lifetime
+// of zero deletes the mapping.
+func (c *Client) pcpMap(ctx context.Context, internal, external uint16, lifetime time.Duration) (Mapping, error) {
+	c.mu.Lock()
+	nonce, ok := c.nonces[internal]
+	if !ok {
+		_, _ = rand.Read(nonce[:])
+		c.nonces[internal] = nonce
+	}
+	c.mu.Unlock()
+
+	req := make([]byte, pcpMapLen)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:], uint32(lifetime/time.Second))
+	client := c.local.As16()
+	copy(req[8:24], client[:])
+	copy(req[24:36], nonce[:])
+	req[36] = protoUDP
+	binary.BigEndian.PutUint16(req[40:], internal)
+	binary.BigEndian.PutUint16(req[42:], external)
+	any4 := netip.IPv4Unspecified().As16()
+	copy(req[44:60], any4[:])
+
+	resp, err := c.roundTrip(ctx, req, func(b []byte) bool {
+		if len(b) >= 4 && b[0] == pmpVersion {
+			return true // a NAT-PMP gateway turning PCP down
+		}
+		return len(b) >= pcpMapLen && b[0] == pcpVersion && b[1] == pcpReply|pcpOpMap &&
+			[12]byte(b[24:36]) == nonce
+	})
+	if err != nil {
+		return Mapping{}, err
+	}
+	if resp[0] == pmpVersion {
+		return Mapping{}, errUnsupportedVersion
+	}
+	if code := resp[3]; code != 0 {
+		return Mapping{}, fmt.Errorf("%w: PCP result %d", ErrRefused, code)
+	}
+	ip := netip.AddrFrom16([16]byte(resp[44:60])).Unmap()
+	return Mapping{
+		Protocol: ProtoPCP,
+		Internal: internal,
+		External: netip.AddrPortFrom(ip, binary.BigEndian.Uint16(resp[42:])),
+		Lifetime: time.Duration(binary.BigEndian.Uint32(resp[4:])) * time.Second,
+		Granted:  time.Now(),
+	}, nil
+}
+
+// pmpMap sends a NAT-PMP UDP mapping request for internal (RFC 6886
+// §3.3). A lifetime of zero deletes the mapping.
+func (c *Client) pmpMap(ctx context.Context, internal, external uint16, lifetime time.Duration) (Mapping, error) {
+	req := make([]byte, 12)
+	req[0] = pmpVersion
+	req[1] = pmpOpMapUDP
+	binary.BigEndian.PutUint16(req[4:], internal)
+	binary.BigEndian.PutUint16(req[6:], external)
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	resp, err := c.roundTrip(ctx, req, func(b []byte) bool {
+		return len(b) >= 16 && b[0] == pmpVersion && b[1] == pmpReply+pmpOpMapUDP &&
+			binary.BigEndian.Uint16(b[8:]) == internal
+	})
+	if err != nil {
+		return Mapping{}, err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+		return Mapping{}, fmt.Errorf("%w: NAT-PMP result %d", ErrRefused, code)
+	}
+	m := Mapping{
+		Protocol: ProtoNATPMP,
+		Internal: internal,
+		Lifetime: time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second,
+		Granted:  time.Now(),
+	}
+	if lifetime == 0 {
+		return m, nil
+	}
+	// The mapping response doesn't carry the public address; ask for it.
+	addr, err := c.roundTrip(ctx, []byte{pmpVersion, pmpOpAddr}, func(b []byte) bool {
+		return len(b) >= 12 && b[0] == pmpVersion && b[1] == pmpReply+pmpOpAddr
+	})
+	if err != nil {
+		return Mapping{}, fmt.Errorf("public address: %w", err)
+	}
+	if code := binary.BigEndian.Uint16(addr[2:]); code != 0 {
+		return Mapping{}, fmt.Errorf("%w: NAT-PMP public address result %d", ErrRefused, code)
+	}
+	m.External = netip.AddrPortFrom(netip.AddrFrom4([4]byte(addr[8:12])), binary.BigEndian.Uint16(resp[10:]))
+	return m, nil
+}
+
+// roundTrip sends req to the gateway from a socket bound to our LAN
+// address and returns the first reply accept takes, retransmitting with
+// RFC 6886's doubling interval.
+func (c *Client) roundTrip(ctx context.Context, req []byte, accept func([]byte) bool) ([]byte, error) {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(c.local, 0)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	to := net.UDPAddrFromAddrPort(netip.AddrPortFrom(c.gateway, c.pmpPort))
+	buf := make([]byte, 1100)
+	wait := c.retry
+	for try := 0; try < pmpTries; try++ {
+		if _, err := conn.WriteToUDP(req, to); err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(wait))
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					return nil, err
+				}
+				break
+			}
+			if from.Addr().Unmap() == c.gateway && accept(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+		wait *= 2
+	}
+	return nil, errors.New("no answer from gateway")
+}
@@ -0,0 +1,142 @@
+// Package portmap asks the LAN gateway to forward a UDP port to this host,
+// which lets peers reach the port without hole punching. It speaks PCP
+// (RFC 6887), NAT-PMP (RFC 6886) and UPnP IGD, trying them in that order
+// and sticking with whichever one worked.
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultLifetime is the lease asked for. Gateways may grant less.
+const DefaultLifetime = 2 * time.Hour
+
+// Protocol names, as found in Mapping.Protocol.
+const (
+	ProtoPCP    = "pcp"
+	ProtoNATPMP = "nat-pmp"
+	ProtoUPnP   = "upnp"
+)
+
+// ErrRefused is wrapped by errors from a gateway that answered but
+// wouldn't map the port.
+var ErrRefused = errors.New("gateway refused mapping")
+
+// Mapping is a port forward the gateway granted.
+type Mapping struct {
+	Protocol string
+	Internal uint16
+	External netip.AddrPort
+	// Lifetime is the granted lease; zero means the gateway only does
+	// permanent mappings.
+	Lifetime time.Duration
+	Granted  time.Time
+}
+
+// RenewAt is when m should be renewed: halfway through its lease.
+func (m Mapping) RenewAt() time.Time {
+	if m.Lifetime == 0 {
+		return m.Granted.Add(DefaultLifetime / 2)
+	}
+	return m.Granted.Add(m.Lifetime / 2)
+}
+
+// Client maps ports on one gateway. It is safe for concurrent use.
+type Client struct {
+	gateway netip.Addr
+	local   netip.Addr // our address on the gateway's LAN
+
+	// Tests point these at a fake gateway.
+	pmpPort  uint16        // PCP and NAT-PMP server port
+	ssdpAddr string        // where UPnP discovery is sent
+	retry    time.Duration // first PCP/NAT-PMP retransmit interval
+	http     *http.Client
+
+	mu     sync.Mutex
+	upnp   *upnpService        // nil until discovered
+	nonces map[uint16][12]byte // PCP mapping nonce per internal port
+	last   map[uint16]Mapping  // latest grant per internal port
+}
+
+// NewClient returns a client for gateway, reached from local.
+func NewClient(gateway, local netip.Addr) *Client {
+	return &Client{
+		gateway:  gateway,
+		local:    local,
+		pmpPort:  5351,
+		ssdpAddr: "239.255.255.250:1900",
+		retry:    250 * time.Millisecond,
+		http:     &http.Client{Timeout: 5 * time.Second},
+		nonces:   make(map[uint16][12]byte),
+		last:     make(map[uint16]Mapping),
+	}
+}
+
+// Gateway is the gateway c talks to.
+func (c *Client) Gateway() netip.Addr { return c.gateway }
+
+// Map asks the gateway to forward UDP to internal on this host, or
+// renews an earlier mapping of it, asking for the same external port
+// again. The protocol that worked last time goes first.
+func (c *Client) Map(ctx context.Context, internal uint16) (Mapping, error) {
+	c.mu.Lock()
+	prev, had := c.last[internal]
+	c.mu.Unlock()
+
+	want := internal
+	protos := []string{ProtoPCP, ProtoNATPMP, ProtoUPnP}
+	if had {
+		want = prev.External.Port()
+		protos = append([]string{prev.Protocol}, protos...)
+	}
+	var errs []error
+	tried := make(map[string]bool)
+	for _, proto := range protos {
+		if tried[proto] {
+			continue
+		}
+		tried[proto] = true
+		m, err := c.mapVia(ctx, proto, internal, want, DefaultLifetime)
+		if err == nil {
+			c.mu.Lock()
+			c.last[internal] = m
+			c.mu.Unlock()
+			return m, nil
+		}
+		if ctx.Err() != nil {
+			return Mapping{}, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", proto, err))
+	}
+	return Mapping{}, fmt.Errorf("portmap %d: %w", internal, errors.Join(errs...))
+}
+
+// Unmap removes m from the gateway.
+func (c *Client) Unmap(ctx context.Context, m Mapping) error {
+	c.mu.Lock()
+	delete(c.last, m.Internal)
+	c.mu.Unlock()
+	if m.Protocol == ProtoUPnP {
+		return c.upnpDelete(ctx, m)
+	}
+	_, err := c.mapVia(ctx, m.Protocol, m.Internal, m.External.Port(), 0)
+	return err
+}
+
+func (c *Client) mapVia(ctx context.Context, proto string, internal, external uint16, lifetime time.Duration) (Mapping, error) {
+	switch proto {
+	case ProtoPCP:
+		return c.pcpMap(ctx, internal, external, lifetime)
+	case ProtoNATPMP:
+		return c.pmpMap(ctx, internal, external, lifetime)
+	case ProtoUPnP:
+		return c.upnpMap(ctx, internal, external, lifetime)
+	}
+	return Mapping{}, fmt.Errorf("unknown protocol %q", proto)
+}
@@ -0,0 +1,290 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	loopback = netip.MustParseAddr("127.0.0.1")
+	publicIP = netip.MustParseAddr("203.0.113.9")
+)
+
+// fakePMP is a gateway's port 5351. In "pcp" mode it grants PCP MAP
+// requests; in "natpmp" mode it turns PCP down and grants NAT-PMP.
+type fakePMP struct {
+	mode string
+	conn *net.UDPConn
+
+	mu        sync.Mutex
+	lifetimes []uint32   // requested, in order
+	suggested []uint16   // requested external ports, in order
+	nonces    [][12]byte // PCP only
+}
+
+func newFakePMP(t *testing.T, mode string) *fakePMP {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakePMP{mode: mode, conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakePMP) port() uint16 { return uint16(f.conn.LocalAddr().(*net.UDPAddr).Port) }
+
+func (f *fakePMP) serve() {
+	buf := make([]byte, 1100)
+	for {
+		n, from, err := f.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		var resp []byte
+		switch {
+		case req[0] == pcpVersion && f.mode == "natpmp":
+			resp = []byte{pmpVersion, pmpReply + req[1], 0, 1} // unsupported version
+		case req[0] == pcpVersion && n == pcpMapLen:
+			f.mu.Lock()
+			f.lifetimes = append(f.lifetimes, binary.BigEndian.Uint32(req[4:]))
+			f.suggested = append(f.suggested, binary.BigEndian.Uint16(req[42:]))
+			f.nonces = append(f.nonces, [12]byte(req[24:36]))
+			f.mu.Unlock()
+			resp = append([]byte(nil), req...)
+			resp[1] = pcpReply | pcpOpMap
+			resp[3] = 0
+			ext := publicIP.As16()
+			copy(resp[44:], ext[:])
+		case req[0] == pmpVersion && req[1] == pmpOpAddr:
+			resp = make([]byte, 12)
+			resp[1] = pmpReply + pmpOpAddr
+			ext := publicIP.As4()
+			copy(resp[8:], ext[:])
+		case req[0] == pmpVersion && req[1] == pmpOpMapUDP && n == 12:
+			f.mu.Lock()
+			f.lifetimes = append(f.lifetimes, binary.BigEndian.Uint32(req[8:]))
+			f.suggested = append(f.suggested, binary.BigEndian.Uint16(req[6:]))
+			f.mu.Unlock()
+			resp = make([]byte, 16)
+			resp[1] = pmpReply + pmpOpMapUDP
+			copy(resp[8:10], req[4:6])
+			copy(resp[10:12], req[6:8])
+			copy(resp[12:16], req[8:12])
+		default:
+			continue
+		}
+		_, _ = f.conn.WriteToUDPAddrPort(resp, from)
+	}
+}
+
+func testClient(pmpPort uint16) *Client {
+	c := NewClient(loopback, loopback)
+	c.pmpPort = pmpPort
+	c.retry = 20 * time.Millisecond
+	return c
+}
+
+func TestMap_PCP(t *testing.T) {
+	gw := newFakePMP(t, "pcp")
+	c := testClient(gw.port())
+	ctx := context.Background()
+
+	m, err := c.Map(ctx, 7777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Protocol != ProtoPCP || m.External != netip.AddrPortFrom(publicIP, 7777) || m.Lifetime != DefaultLifetime {
+		t.Fatalf("mapping = %+v", m)
+	}
+	if _, err := c.Map(ctx, 7777); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unmap(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if len(gw.nonces) != 3 || gw.nonces[0] != gw.nonces[1] || gw.nonces[1] != gw.nonces[2] {
+		t.Error("a renewal or delete must reuse the mapping's nonce")
+	}
+	if gw.suggested[1] != m.External.Port() {
+		t.Errorf("renewal suggested port %d, want the granted %d", gw.suggested[1], m.External.Port())
+	}
+	if gw.lifetimes[2] != 0 {
+		t.Errorf("unmap asked for lifetime %d, want 0", gw.lifetimes[2])
+	}
+}
+
+func TestMap_NATPMPFallback(t *testing.T) {
+	gw := newFakePMP(t, "natpmp")
+	c := testClient(gw.port())
+
+	m, err := c.Map(context.Background(), 41641)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Protocol != ProtoNATPMP || m.External != netip.AddrPortFrom(publicIP, 41641) {
+		t.Fatalf("mapping = %+v", m)
+	}
+	if m.RenewAt().Sub(m.Granted) != DefaultLifetime/2 {
+		t.Errorf("renew at %v after the grant, want half the lease", m.RenewAt().Sub(m.Granted))
+	}
+}
+
+// fakeIGD is a UPnP gateway: an SSDP responder plus the HTTP side.
+type fakeIGD struct {
+	ssdp *net.UDPConn
+	http *httptest.Server
+
+	mu      sync.Mutex
+	actions []string
+}
+
+func newFakeIGD(t *testing.T) *fakeIGD {
+	t.Helper()
+	f := &fakeIGD{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+ <device>
+  <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+  <deviceList><device>
+   <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+   <deviceList><device>
+    <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+    <serviceList><service>
+     <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+     <controlURL>/ctl/IPConn</controlURL>
+    </service></serviceList>
+   </device></deviceList>
+  </device></deviceList>
+ </device>
+</root>`)
+	})
+	mux.HandleFunc("POST /ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		action = strings.Trim(action[strings.Index(action, "#")+1:], `"`)
+		lease := xmlValue(body, "NewLeaseDuration")
+		f.mu.Lock()
+		f.actions = append(f.actions, action)
+		f.mu.Unlock()
+		switch {
+		case action == "AddPortMapping" && lease != "0":
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail>
+<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError>
+</detail></s:Fault></s:Body></s:Envelope>`)
+		case action == "GetExternalIPAddress":
+			fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"><NewExternalIPAddress>%s</NewExternalIPAddress></u:GetExternalIPAddressResponse>
+</s:Body></s:Envelope>`, publicIP)
+		default:
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`)
+		}
+	})
+	f.http = httptest.NewServer(mux)
+	t.Cleanup(f.http.Close)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ssdp = conn
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH") {
+				continue
+			}
+			resp := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\n" +
+				"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+				"LOCATION: " + f.http.URL + "/desc.xml\r\n\r\n"
+			_, _ = conn.WriteToUDPAddrPort([]byte(resp), from)
+		}
+	}()
+	return f
+}
+
+func TestMap_UPnPFallback(t *testing.T) {
+	// Nothing listens on the PCP/NAT-PMP port.
+	dead, _ := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	deadPort := uint16(dead.LocalAddr().(*net.UDPAddr).Port)
+	dead.Close()
+	igd := newFakeIGD(t)
+	c := testClient(deadPort)
+	c.ssdpAddr = igd.ssdp.LocalAddr().String()
+	ctx := context.Background()
+
+	m, err := c.Map(ctx, 7777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Protocol != ProtoUPnP || m.External != netip.AddrPortFrom(publicIP, 7777) {
+		t.Fatalf("mapping = %+v", m)
+	}
+	if m.Lifetime != 0 {
+		t.Errorf("lifetime = %v; the gateway only does permanent leases", m.Lifetime)
+	}
+	if err := c.Unmap(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	igd.mu.Lock()
+	defer igd.mu.Unlock()
+	want := []string{"AddPortMapping", "AddPortMapping", "GetExternalIPAddress", "DeletePortMapping"}
+	if strings.Join(igd.actions, ",") != strings.Join(want, ",") {
+		t.Errorf("actions = %v, want %v", igd.actions, want)
+	}
+}
+
+func TestMap_NoGateway(t *testing.T) {
+	dead, _ := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	deadAddr := dead.LocalAddr().(*net.UDPAddr)
+	dead.Close()
+	c := testClient(uint16(deadAddr.Port))
+	c.ssdpAddr = deadAddr.String()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.Map(ctx, 7777)
+	if err == nil {
+		t.Fatal("mapped with no gateway")
+	}
+	if errors.Is(err, ErrRefused) {
+		t.Errorf("err = %v; nobody answered, nobody refused", err)
+	}
+}
+
+func TestParseProcRoute(t *testing.T) {
+	const table = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	000200C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	00000000	010200C0	0003	0	0	0	00000000	0	0	0
+`
+	gw, err := parseProcRoute(strings.NewReader(table))
+	if err != nil || gw != netip.MustParseAddr("192.0.2.1") {
+		t.Fatalf("gateway = %v, %v", gw, err)
+	}
+	if _, err := parseProcRoute(strings.NewReader(strings.SplitN(table, "\n", 3)[0] + "\n" + strings.SplitN(table, "\n", 3)[1])); err == nil {
+		t.Error("found a default route in a table without one")
+	}
+}
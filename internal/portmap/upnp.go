@@ -0,0 +1,294 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upnpTimeout caps SSDP discovery; gateways answer within MX seconds.
+const upnpTimeout = 2 * time.Second
+
+// UPnP error codes worth reacting to (WANIPConnection:2 §2.4.16).
+const upnpOnlyPermanentLeases = 725
+
+// upnpSearch is the SSDP M-SEARCH for an Internet Gateway Device.
+const upnpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n\r\n"
+
+// upnpService is the gateway's WAN connection service, the one that takes
+// AddPortMapping.
+type upnpService struct {
+	typ     string // e.g. urn:schemas-upnp-org:service:WANIPConnection:1
+	control string // absolute control URL
+}
+
+// upnpDevice is the part of an IGD device description we read. Services
+// sit a few devices deep, so it recurses.
+type upnpDevice struct {
+	Services []struct {
+		Type    string `xml:"serviceType"`
+		Control string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// upnpMap adds (or, for a renewal, re-adds) the UDP forward. A lifetime of
+// zero here means permanent; upnpDelete removes mappings.
+func (c *Client) upnpMap(ctx context.Context, internal, external uint16, lifetime time.Duration) (Mapping, error) {
+	svc, err := c.upnpService(ctx)
+	if err != nil {
+		return Mapping{}, err
+	}
+	args := func(lease time.Duration) [][2]string {
+		return [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", strconv.Itoa(int(external))},
+			{"NewProtocol", "UDP"},
+			{"NewInternalPort", strconv.Itoa(int(internal))},
+			{"NewInternalClient", c.local.String()},
+			{"NewEnabled", "1"},
+			{"NewPortMappingDescription", "gretun"},
+			{"NewLeaseDuration", strconv.Itoa(int(lease / time.Second))},
+		}
+	}
+	_, err = c.soap(ctx, svc, "AddPortMapping", args(lifetime))
+	var ue *upnpError
+	if errors.As(err, &ue) && ue.code == upnpOnlyPermanentLeases {
+		lifetime = 0
+		_, err = c.soap(ctx, svc, "AddPortMapping", args(0))
+	}
+	if err != nil {
+		return Mapping{}, err
+	}
+	resp, err := c.soap(ctx, svc, "GetExternalIPAddress", nil)
+	if err != nil {
+		return Mapping{}, fmt.Errorf("external address: %w", err)
+	}
+	ip, err := netip.ParseAddr(xmlValue(resp, "NewExternalIPAddress"))
+	if err != nil {
+		return Mapping{}, fmt.Errorf("external address: %w", err)
+	}
+	return Mapping{
+		Protocol: ProtoUPnP,
+		Internal: internal,
+		External: netip.AddrPortFrom(ip, external),
+		Lifetime: lifetime,
+		Granted:  time.Now(),
+	}, nil
+}
+
+func (c *Client) upnpDelete(ctx context.Context, m Mapping) error {
+	svc, err := c.upnpService(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = c.soap(ctx, svc, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(int(m.External.Port()))},
+		{"NewProtocol", "UDP"},
+	})
+	return err
+}
+
+// upnpService returns the gateway's WAN connection service, discovering
+// it the first time.
+func (c *Client) upnpService(ctx context.Context) (*upnpService, error) {
+	c.mu.Lock()
+	svc := c.upnp
+	c.mu.Unlock()
+	if svc != nil {
+		return svc, nil
+	}
+	loc, err := c.ssdpSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc, err = c.describe(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.upnp = svc
+	c.mu.Unlock()
+	return svc, nil
+}
+
+// ssdpSearch multicasts an M-SEARCH and returns the LOCATION of the first
+// IGD that answers from the gateway's address. Answers from other LAN
+// hosts are ignored: anyone could claim to be a gateway.
+func (c *Client) ssdpSearch(ctx context.Context) (*url.URL, error) {
+	conn, err := net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(c.local, 0)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	to, err := net.ResolveUDPAddr("udp4", c.ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP([]byte(upnpSearch), to); err != nil {
+		return nil, fmt.Errorf("ssdp: %w", err)
+	}
+	deadline := time.Now().Add(upnpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, errors.New("no UPnP gateway answered")
+		}
+		if from.Addr().Unmap() != c.gateway {
+			continue
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		loc, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || (loc.Scheme != "http" && loc.Scheme != "https") {
+			continue
+		}
+		return loc, nil
+	}
+}
+
+// describe fetches the device description at loc and picks out the WAN
+// connection service.
+func (c *Client) describe(ctx context.Context, loc *url.URL) (*upnpService, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", loc.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upnp description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upnp description: %s", resp.Status)
+	}
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("upnp description: %w", err)
+	}
+	base := loc
+	if root.URLBase != "" {
+		if u, err := url.Parse(root.URLBase); err == nil {
+			base = u
+		}
+	}
+	var find func(d upnpDevice) *upnpService
+	find = func(d upnpDevice) *upnpService {
+		for _, s := range d.Services {
+			if strings.Contains(s.Type, ":WANIPConnection:") || strings.Contains(s.Type, ":WANPPPConnection:") {
+				ctl, err := base.Parse(s.Control)
+				if err != nil {
+					continue
+				}
+				return &upnpService{typ: s.Type, control: ctl.String()}
+			}
+		}
+		for _, sub := range d.Devices {
+			if svc := find(sub); svc != nil {
+				return svc
+			}
+		}
+		return nil
+	}
+	if svc := find(root.Device); svc != nil {
+		return svc, nil
+	}
+	return nil, errors.New("upnp gateway has no WAN connection service")
+}
+
+// upnpError is a SOAP fault carrying a UPnP error code.
+type upnpError struct {
+	code int
+	desc string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("%v: upnp error %d %s", ErrRefused, e.code, e.desc)
+}
+
+func (e *upnpError) Unwrap() error { return ErrRefused }
+
+// soap calls action on svc with args, in order, and returns the response
+// body.
+func (c *Client) soap(ctx context.Context, svc *upnpService, action string, args [][2]string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, html.EscapeString(svc.typ))
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>%s</%s>", a[0], html.EscapeString(a[1]), a[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", svc.control, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, svc.typ, action))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upnp %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		if code, err := strconv.Atoi(xmlValue(b, "errorCode")); err == nil {
+			return nil, &upnpError{code: code, desc: xmlValue(b, "errorDescription")}
+		}
+		return nil, fmt.Errorf("upnp %s: %s", action, resp.Status)
+	}
+	return b, nil
+}
+
+// xmlValue returns the text of the first element named name in b, or "".
+func xmlValue(b []byte, name string) string {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == name {
+			var v string
+			if d.DecodeElement(&v, &se) != nil {
+				return ""
+			}
+			return strings.TrimSpace(v)
+		}
+	}
+}
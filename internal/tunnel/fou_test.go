@@ -68,6 +68,28 @@ func TestCreate_FOU(t *testing.T) {
 	}
 }
 
+func TestCreate_FOU_IPv6(t *testing.T) {
+	m := newMockNetlinker()
+	cfg := fouCfg("tun0", 7777)
+	cfg.LocalIP, cfg.RemoteIP = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+
+	if err := Create(context.Background(), m, cfg); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if fou := m.fous[7777]; fou.Family != unix.AF_INET6 {
+		t.Errorf("FOU Family = %d, want AF_INET6", fou.Family)
+	}
+	if link := m.links["tun0"]; link.Type() != "ip6gre" {
+		t.Errorf("link type = %q, want ip6gre", link.Type())
+	}
+	if m.lastMTU != DefaultFOU6MTU {
+		t.Errorf("MTU = %d, want %d", m.lastMTU, DefaultFOU6MTU)
+	}
+	if err := Delete(context.Background(), m, "tun0"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+}
+
 func TestCreate_FOU_PortReuse(t *testing.T) {
 	m := newMockNetlinker()
 	if err := Create(context.Background(), m, fouCfg("tun0", 7777)); err != nil {
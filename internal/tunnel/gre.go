@@ -41,6 +41,10 @@ func Create(ctx context.Context, nl Netlinker, cfg Config) error {
 	if ttl == 0 {
 		ttl = defaultTTL
 	}
+	dev, err := devIndex(nl, cfg)
+	if err != nil {
+		return err
+	}
 
 	createdFou := false
 	if cfg.Encap != EncapNone {
@@ -57,6 +61,7 @@ func Create(ctx context.Context, nl Netlinker, cfg Config) error {
 		},
 		Local:  cfg.LocalIP,
 		Remote: cfg.RemoteIP,
+		Link:   dev,
 		IKey:   cfg.Key,
 		OKey:   cfg.Key,
 		Ttl:    ttl,
@@ -104,14 +109,24 @@ func Create(ctx context.Context, nl Netlinker, cfg Config) error {
 // ensureFOU adds a FOU RX port if one does not already exist for (family, port, proto).
 // Returns true iff this call created the port (caller must FouDel on rollback).
 func ensureFOU(nl Netlinker, cfg Config) (bool, error) {
-	return EnsureFOU(nl, cfg.EncapDport, cfg.Encap)
+	return ensureFOUFamily(nl, family(cfg.LocalIP), cfg.EncapDport, cfg.Encap)
 }
 
 // EnsureFOU opens a kernel FOU RX port, tolerating an already-present port.
 // Returns true iff this call created the port.
 func EnsureFOU(nl Netlinker, port uint16, encap EncapType) (bool, error) {
+	return ensureFOUFamily(nl, unix.AF_INET, port, encap)
+}
+
+// EnsureFOU6 is EnsureFOU for datagrams arriving over IPv6, which the
+// kernel keeps a separate FOU port for.
+func EnsureFOU6(nl Netlinker, port uint16, encap EncapType) (bool, error) {
+	return ensureFOUFamily(nl, unix.AF_INET6, port, encap)
+}
+
+func ensureFOUFamily(nl Netlinker, family int, port uint16, encap EncapType) (bool, error) {
 	fou := netlink.Fou{
-		Family:    unix.AF_INET,
+		Family:    family,
 		Port:      int(port),
 		Protocol:  unix.IPPROTO_GRE,
 		EncapType: fouEncapConst(encap),
@@ -134,9 +149,14 @@ func RemoveFOU(nl Netlinker, port uint16) {
 	rollbackFOU(nl, Config{EncapDport: port})
 }
 
+// RemoveFOU6 drops a FOU RX port opened by EnsureFOU6.
+func RemoveFOU6(nl Netlinker, port uint16) {
+	rollbackFOU(nl, Config{LocalIP: net.IPv6loopback, EncapDport: port})
+}
+
 func rollbackFOU(nl Netlinker, cfg Config) {
 	err := nl.FouDel(netlink.Fou{
-		Family: unix.AF_INET,
+		Family: family(cfg.LocalIP),
 		Port:   int(cfg.EncapDport),
 	})
 	if err != nil {
@@ -159,6 +179,37 @@ func applyEncap(gre *netlink.Gretun, cfg Config) {
 	}
 }
 
+// devIndex is the index of cfg.Dev, or 0 if the outer path isn't bound.
+func devIndex(nl Netlinker, cfg Config) (uint32, error) {
+	if cfg.Dev == "" {
+		return 0, nil
+	}
+	link, err := nl.LinkByName(cfg.Dev)
+	if err != nil {
+		return 0, &TunnelError{Op: "bind", Tunnel: cfg.Name, Message: fmt.Sprintf("no device %s", cfg.Dev), Err: err}
+	}
+	return uint32(link.Attrs().Index), nil
+}
+
+// isIPv6 reports whether ip is an IPv6 address, not an IPv4 one in
+// either of its forms.
+func isIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil
+}
+
+// family is the address family of ip; IPv4 when ip is unset.
+func family(ip net.IP) int {
+	if isIPv6(ip) {
+		return unix.AF_INET6
+	}
+	return unix.AF_INET
+}
+
+// isGRE reports whether link is a GRE tunnel over either IP version.
+func isGRE(link netlink.Link) bool {
+	return link.Type() == "gre" || link.Type() == "ip6gre"
+}
+
 func fouEncapConst(e EncapType) int {
 	switch e {
 	case EncapGUE:
@@ -193,6 +244,9 @@ func mtuOrDefault(cfg Config) int {
 		return cfg.MTU
 	}
 	if cfg.Encap != EncapNone {
+		if isIPv6(cfg.LocalIP) {
+			return DefaultFOU6MTU
+		}
 		return DefaultFOUMTU
 	}
 	return 0
@@ -215,7 +269,7 @@ func Delete(ctx context.Context, nl Netlinker, name string) error {
 		return &TunnelNotFoundError{Name: name}
 	}
 
-	if link.Type() != "gre" {
+	if !isGRE(link) {
 		return &InvalidTypeError{
 			Name:       name,
 			ActualType: link.Type(),
@@ -231,6 +285,61 @@ func Delete(ctx context.Context, nl Netlinker, name string) error {
 	return nil
 }
 
+// Retarget points the existing FOU tunnel cfg.Name at a new outer path:
+// cfg's local and remote IPs and encap ports replace the link's. The link
+// is changed in place, so its index, addresses and routes survive.
+func Retarget(ctx context.Context, nl Netlinker, cfg Config) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if err := ValidateConfig(cfg); err != nil {
+		return err
+	}
+
+	link, err := nl.LinkByName(cfg.Name)
+	if err != nil {
+		return &TunnelNotFoundError{Name: cfg.Name}
+	}
+
+	gre, ok := link.(*netlink.Gretun)
+	if !ok {
+		return &InvalidTypeError{
+			Name:       cfg.Name,
+			ActualType: link.Type(),
+		}
+	}
+	if isIPv6(gre.Local) != isIPv6(cfg.LocalIP) {
+		// gre and ip6gre are different link kinds; only a new link will do.
+		return &TunnelError{Op: "retarget", Tunnel: cfg.Name, Message: "outer address family changed; delete and recreate the link"}
+	}
+
+	dev, err := devIndex(nl, cfg)
+	if err != nil {
+		return err
+	}
+
+	if gre.Local.Equal(cfg.LocalIP) && gre.Remote.Equal(cfg.RemoteIP) && gre.Link == dev &&
+		gre.EncapSport == cfg.EncapSport && gre.EncapDport == cfg.EncapDport {
+		return nil
+	}
+	gre.Local = cfg.LocalIP
+	gre.Remote = cfg.RemoteIP
+	gre.Link = dev
+	applyEncap(gre, cfg)
+
+	if err := nl.LinkModify(gre); err != nil {
+		return TranslateNetlinkError(err, "retarget", cfg.Name)
+	}
+
+	slog.Info("retargeted tunnel", "name", cfg.Name,
+		"local", cfg.LocalIP, "dev", cfg.Dev, "remote", cfg.RemoteIP, "encap_dport", cfg.EncapDport)
+
+	return nil
+}
+
 // AssignIP assigns an IP address in CIDR notation to the tunnel interface.
 func AssignIP(ctx context.Context, nl Netlinker, name string, cidr string) error {
 	select {
@@ -252,6 +252,90 @@ func TestAssignIP(t *testing.T) {
 	}
 }
 
+func TestRetarget(t *testing.T) {
+	relayed := Config{
+		Name: "tun0", LocalIP: net.IPv4(127, 0, 0, 1), RemoteIP: net.IPv4(127, 77, 0, 1),
+		Encap: EncapFOU, EncapSport: 7777, EncapDport: 40000, Loopback: true,
+	}
+	direct := Config{
+		Name: "tun0", LocalIP: net.IPv4(10, 0, 0, 1), RemoteIP: net.IPv4(203, 0, 113, 5),
+		Encap: EncapFOU, EncapSport: 7777, EncapDport: 41641,
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		err := Retarget(context.Background(), newMockNetlinker(), direct)
+		if !IsTunnelNotFound(err) {
+			t.Fatalf("err = %v, want TunnelNotFoundError", err)
+		}
+	})
+
+	t.Run("relay to direct", func(t *testing.T) {
+		m := newMockNetlinker()
+		if err := Create(context.Background(), m, relayed); err != nil {
+			t.Fatal(err)
+		}
+		if err := Retarget(context.Background(), m, direct); err != nil {
+			t.Fatal(err)
+		}
+		if m.linkModifyCalls != 1 || m.linkDelCalled {
+			t.Fatalf("modify calls = %d, deleted = %v; want one in-place change", m.linkModifyCalls, m.linkDelCalled)
+		}
+		gre := m.links["tun0"].(*netlink.Gretun)
+		if !gre.Local.Equal(direct.LocalIP) || !gre.Remote.Equal(direct.RemoteIP) || gre.EncapDport != 41641 {
+			t.Errorf("link = %v -> %v:%d", gre.Local, gre.Remote, gre.EncapDport)
+		}
+
+		// Nothing changed: no netlink call.
+		if err := Retarget(context.Background(), m, direct); err != nil {
+			t.Fatal(err)
+		}
+		if m.linkModifyCalls != 1 {
+			t.Errorf("modify calls = %d after a no-op retarget", m.linkModifyCalls)
+		}
+	})
+
+	t.Run("rebinds device", func(t *testing.T) {
+		m := newMockNetlinker()
+		m.links["eth1"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth1", Index: 3}}
+		if err := Create(context.Background(), m, direct); err != nil {
+			t.Fatal(err)
+		}
+		bound := direct
+		bound.Dev = "eth1"
+		if err := Retarget(context.Background(), m, bound); err != nil {
+			t.Fatal(err)
+		}
+		if gre := m.links["tun0"].(*netlink.Gretun); gre.Link != 3 || m.linkModifyCalls != 1 {
+			t.Errorf("link dev = %d after %d modify calls, want 3 after 1", gre.Link, m.linkModifyCalls)
+		}
+		bound.Dev = "eth9"
+		if err := Retarget(context.Background(), m, bound); err == nil {
+			t.Error("retarget onto a missing device: expected error")
+		}
+	})
+
+	t.Run("LinkModify fails", func(t *testing.T) {
+		m := newMockNetlinker()
+		m.links["tun0"] = greLink("tun0", net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 0, 64, true)
+		m.linkModifyErr = fmt.Errorf("no such device")
+		if err := Retarget(context.Background(), m, direct); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("family change", func(t *testing.T) {
+		m := newMockNetlinker()
+		if err := Create(context.Background(), m, direct); err != nil {
+			t.Fatal(err)
+		}
+		v6 := direct
+		v6.LocalIP, v6.RemoteIP = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+		if err := Retarget(context.Background(), m, v6); err == nil || m.linkModifyCalls != 0 {
+			t.Fatalf("err = %v, modify calls = %d; a gre link can't become ip6gre", err, m.linkModifyCalls)
+		}
+	})
+}
+
 func TestGet(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -23,7 +23,7 @@ func List(ctx context.Context, nl Netlinker) ([]Status, error) {
 
 	var tunnels []Status
 	for _, link := range links {
-		if link.Type() != "gre" {
+		if !isGRE(link) {
 			continue
 		}
 
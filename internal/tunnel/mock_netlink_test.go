@@ -13,9 +13,12 @@ type mockNetlinker struct {
 	links map[string]netlink.Link
 	addrs map[string][]netlink.Addr
 	fous  map[int]netlink.Fou
+	// routes is what RouteGet answers, whatever the destination.
+	routes []netlink.Route
 
 	linkAddErr    error
 	linkDelErr    error
+	linkModifyErr error
 	linkSetUpErr  error
 	linkSetMTUErr error
 	linkListErr   error
@@ -24,9 +27,11 @@ type mockNetlinker struct {
 	fouAddErr     error
 	fouDelErr     error
 	fouListErr    error
+	routeGetErr   error
 
 	linkAddCalled    bool
 	linkDelCalled    bool
+	linkModifyCalls  int
 	linkSetUpCalled  bool
 	linkSetMTUCalled bool
 	addrAddCalled    bool
@@ -52,6 +57,15 @@ func (m *mockNetlinker) LinkAdd(link netlink.Link) error {
 	return nil
 }
 
+func (m *mockNetlinker) LinkModify(link netlink.Link) error {
+	m.linkModifyCalls++
+	if m.linkModifyErr != nil {
+		return m.linkModifyErr
+	}
+	m.links[link.Attrs().Name] = link
+	return nil
+}
+
 func (m *mockNetlinker) LinkDel(link netlink.Link) error {
 	m.linkDelCalled = true
 	if m.linkDelErr != nil {
@@ -166,3 +180,19 @@ func greLink(name string, local, remote net.IP, key uint32, ttl uint8, up bool)
 		Ttl:    ttl,
 	}
 }
+
+func (m *mockNetlinker) LinkByIndex(index int) (netlink.Link, error) {
+	for _, l := range m.links {
+		if l.Attrs().Index == index {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("link index %d not found", index)
+}
+
+func (m *mockNetlinker) RouteGet(dst net.IP) ([]netlink.Route, error) {
+	if m.routeGetErr != nil {
+		return nil, m.routeGetErr
+	}
+	return m.routes, nil
+}
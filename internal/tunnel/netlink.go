@@ -3,14 +3,21 @@
 package tunnel
 
 import (
+	"encoding/binary"
+	"net"
+
 	"github.com/vishvananda/netlink"
+	rtnl "github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 // Netlinker abstracts netlink operations for testability.
 type Netlinker interface {
 	LinkAdd(link netlink.Link) error
+	LinkModify(link netlink.Link) error
 	LinkDel(link netlink.Link) error
 	LinkByName(name string) (netlink.Link, error)
+	LinkByIndex(index int) (netlink.Link, error)
 	LinkSetUp(link netlink.Link) error
 	LinkSetMTU(link netlink.Link, mtu int) error
 	LinkList() ([]netlink.Link, error)
@@ -19,6 +26,7 @@ type Netlinker interface {
 	FouAdd(fou netlink.Fou) error
 	FouDel(fou netlink.Fou) error
 	FouList(family int) ([]netlink.Fou, error)
+	RouteGet(dst net.IP) ([]netlink.Route, error)
 }
 
 // DefaultNetlinker implements Netlinker using a single persistent netlink.Handle.
@@ -48,6 +56,66 @@ func (nl *DefaultNetlinker) LinkAdd(link netlink.Link) error {
 	return nl.handle.LinkAdd(link)
 }
 
+// LinkModify changes an existing GRE link in place, as `ip link change`
+// does: the link keeps its index, addresses and routes. Only *netlink.Gretun
+// is supported, and every GRE attribute is sent since the kernel resets
+// the ones left out.
+func (nl *DefaultNetlinker) LinkModify(link netlink.Link) error {
+	gre, ok := link.(*netlink.Gretun)
+	if !ok {
+		return &InvalidTypeError{Name: link.Attrs().Name, ActualType: link.Type(), ExpectedType: "gre"}
+	}
+	// netlink v1.1.0 only creates links, so the request is built here.
+	req := rtnl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+	msg := rtnl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(gre.Index)
+	req.AddData(msg)
+	linkInfo := rtnl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(rtnl.IFLA_INFO_KIND, rtnl.NonZeroTerminated(gre.Type()))
+	addGretunData(linkInfo, gre)
+	req.AddData(linkInfo)
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// addGretunData mirrors the attributes netlink's LinkAdd sends for a Gretun.
+func addGretunData(linkInfo *rtnl.RtAttr, gre *netlink.Gretun) {
+	data := linkInfo.AddRtAttr(rtnl.IFLA_INFO_DATA, nil)
+	if ip := gre.Local; ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		data.AddRtAttr(rtnl.IFLA_GRE_LOCAL, []byte(ip))
+	}
+	if ip := gre.Remote; ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		data.AddRtAttr(rtnl.IFLA_GRE_REMOTE, []byte(ip))
+	}
+	iflags, oflags := gre.IFlags, gre.OFlags
+	if gre.IKey != 0 {
+		data.AddRtAttr(rtnl.IFLA_GRE_IKEY, binary.BigEndian.AppendUint32(nil, gre.IKey))
+		iflags |= uint16(rtnl.GRE_KEY)
+	}
+	if gre.OKey != 0 {
+		data.AddRtAttr(rtnl.IFLA_GRE_OKEY, binary.BigEndian.AppendUint32(nil, gre.OKey))
+		oflags |= uint16(rtnl.GRE_KEY)
+	}
+	data.AddRtAttr(rtnl.IFLA_GRE_IFLAGS, binary.BigEndian.AppendUint16(nil, iflags))
+	data.AddRtAttr(rtnl.IFLA_GRE_OFLAGS, binary.BigEndian.AppendUint16(nil, oflags))
+	if gre.Link != 0 {
+		data.AddRtAttr(rtnl.IFLA_GRE_LINK, rtnl.Uint32Attr(gre.Link))
+	}
+	data.AddRtAttr(rtnl.IFLA_GRE_PMTUDISC, rtnl.Uint8Attr(gre.PMtuDisc))
+	data.AddRtAttr(rtnl.IFLA_GRE_TTL, rtnl.Uint8Attr(gre.Ttl))
+	data.AddRtAttr(rtnl.IFLA_GRE_TOS, rtnl.Uint8Attr(gre.Tos))
+	data.AddRtAttr(rtnl.IFLA_GRE_ENCAP_TYPE, rtnl.Uint16Attr(gre.EncapType))
+	data.AddRtAttr(rtnl.IFLA_GRE_ENCAP_FLAGS, rtnl.Uint16Attr(gre.EncapFlags))
+	data.AddRtAttr(rtnl.IFLA_GRE_ENCAP_SPORT, binary.BigEndian.AppendUint16(nil, gre.EncapSport))
+	data.AddRtAttr(rtnl.IFLA_GRE_ENCAP_DPORT, binary.BigEndian.AppendUint16(nil, gre.EncapDport))
+}
+
 // LinkDel removes a network link.
 func (nl *DefaultNetlinker) LinkDel(link netlink.Link) error {
 	return nl.handle.LinkDel(link)
@@ -58,6 +126,11 @@ func (nl *DefaultNetlinker) LinkByName(name string) (netlink.Link, error) {
 	return nl.handle.LinkByName(name)
 }
 
+// LinkByIndex returns the link with the given index.
+func (nl *DefaultNetlinker) LinkByIndex(index int) (netlink.Link, error) {
+	return nl.handle.LinkByIndex(index)
+}
+
 // LinkSetUp brings a network link up.
 func (nl *DefaultNetlinker) LinkSetUp(link netlink.Link) error {
 	return nl.handle.LinkSetUp(link)
@@ -98,3 +171,9 @@ func (nl *DefaultNetlinker) FouDel(fou netlink.Fou) error {
 func (nl *DefaultNetlinker) FouList(family int) ([]netlink.Fou, error) {
 	return nl.handle.FouList(family)
 }
+
+// RouteGet returns the route the kernel would use to reach dst, as
+// `ip route get` does.
+func (nl *DefaultNetlinker) RouteGet(dst net.IP) ([]netlink.Route, error) {
+	return nl.handle.RouteGet(dst)
+}
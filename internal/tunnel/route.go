@@ -0,0 +1,77 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Source is the local end of a tunnel's outer path.
+type Source struct {
+	IP  netip.Addr
+	Dev string
+}
+
+// LocalFor picks the local address for an outer path to dst. If dev is
+// set, the address is the first global one of dst's family on dev;
+// otherwise it is the kernel's preferred source on its route to dst, the
+// one `ip route get` prints.
+func LocalFor(nl Netlinker, dst netip.Addr, dev string) (Source, error) {
+	dst = dst.Unmap()
+	if dev != "" {
+		link, err := nl.LinkByName(dev)
+		if err != nil {
+			return Source{}, fmt.Errorf("device %s: %w", dev, err)
+		}
+		ip, err := globalAddr(nl, link, dst.Is6())
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{IP: ip, Dev: dev}, nil
+	}
+
+	routes, err := nl.RouteGet(net.IP(dst.AsSlice()))
+	if err != nil {
+		return Source{}, fmt.Errorf("route to %s: %w", dst, err)
+	}
+	if len(routes) == 0 {
+		return Source{}, fmt.Errorf("no route to %s", dst)
+	}
+	r := routes[0]
+	link, err := nl.LinkByIndex(r.LinkIndex)
+	if err != nil {
+		return Source{}, fmt.Errorf("route to %s: device %d: %w", dst, r.LinkIndex, err)
+	}
+	if src, ok := netip.AddrFromSlice(r.Src); ok && src.Unmap().Is6() == dst.Is6() {
+		return Source{IP: src.Unmap(), Dev: link.Attrs().Name}, nil
+	}
+	// A route with no preferred source: the kernel would use the device's
+	// first address of the family.
+	ip, err := globalAddr(nl, link, dst.Is6())
+	if err != nil {
+		return Source{}, fmt.Errorf("route to %s: %w", dst, err)
+	}
+	return Source{IP: ip, Dev: link.Attrs().Name}, nil
+}
+
+func globalAddr(nl Netlinker, link netlink.Link, v6 bool) (netip.Addr, error) {
+	family, name := netlink.FAMILY_V4, "IPv4"
+	if v6 {
+		family, name = netlink.FAMILY_V6, "IPv6"
+	}
+	addrs, err := nl.AddrList(link, family)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("addresses of %s: %w", link.Attrs().Name, err)
+	}
+	for _, a := range addrs {
+		ip, ok := netip.AddrFromSlice(a.IP)
+		if ok && ip.Unmap().Is6() == v6 && ip.Unmap().IsGlobalUnicast() {
+			return ip.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no global %s address on %s", name, link.Attrs().Name)
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestLocalFor(t *testing.T) {
+	addr := func(s string) netlink.Addr {
+		p := netip.MustParsePrefix(s)
+		return netlink.Addr{IPNet: &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}}
+	}
+	setup := func(m *mockNetlinker) {
+		m.links["eth0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+		m.links["wlan0"] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "wlan0", Index: 3}}
+		m.addrs["eth0"] = []netlink.Addr{addr("fe80::1/64"), addr("10.0.0.5/24"), addr("2001:db8::5/64")}
+		m.addrs["wlan0"] = []netlink.Addr{addr("192.168.1.7/24")}
+	}
+	dst := netip.MustParseAddr("203.0.113.5")
+
+	tests := []struct {
+		name     string
+		dst      netip.Addr
+		dev      string
+		routes   []netlink.Route
+		routeErr error
+		want     Source
+		wantErr  string
+	}{
+		{
+			name:   "route source",
+			dst:    dst,
+			routes: []netlink.Route{{LinkIndex: 3, Src: net.ParseIP("192.168.1.7")}},
+			want:   Source{IP: netip.MustParseAddr("192.168.1.7"), Dev: "wlan0"},
+		},
+		{
+			name:   "route without a source uses the device's address",
+			dst:    dst,
+			routes: []netlink.Route{{LinkIndex: 2}},
+			want:   Source{IP: netip.MustParseAddr("10.0.0.5"), Dev: "eth0"},
+		},
+		{
+			name:   "IPv6 skips link-local",
+			dst:    netip.MustParseAddr("2001:db8:1::9"),
+			routes: []netlink.Route{{LinkIndex: 2}},
+			want:   Source{IP: netip.MustParseAddr("2001:db8::5"), Dev: "eth0"},
+		},
+		{
+			name:     "no route",
+			dst:      dst,
+			routeErr: fmt.Errorf("network is unreachable"),
+			wantErr:  "network is unreachable",
+		},
+		{
+			name: "bound device wins over the route",
+			dst:  dst,
+			dev:  "eth0",
+			// The route would pick wlan0.
+			routes: []netlink.Route{{LinkIndex: 3, Src: net.ParseIP("192.168.1.7")}},
+			want:   Source{IP: netip.MustParseAddr("10.0.0.5"), Dev: "eth0"},
+		},
+		{
+			name:    "bound device lacks the family",
+			dst:     netip.MustParseAddr("2001:db8:1::9"),
+			dev:     "wlan0",
+			wantErr: "no global IPv6 address on wlan0",
+		},
+		{
+			name:    "bound device missing",
+			dst:     dst,
+			dev:     "eth9",
+			wantErr: "device eth9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockNetlinker()
+			setup(m)
+			m.routes, m.routeGetErr = tt.routes, tt.routeErr
+			got, err := LocalFor(m, tt.dst, tt.dev)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("LocalFor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,20 +18,33 @@ const (
 // Outer: IP(20) + UDP(8) + GRE(4) = 32 bytes; 1500 - 32 = 1468.
 const DefaultFOUMTU = 1468
 
+// DefaultFOU6MTU is the same for tunnels whose outer path is IPv6.
+// Outer: IPv6(40) + UDP(8) + GRE(4) = 52 bytes; 1500 - 52 = 1448.
+const DefaultFOU6MTU = 1448
+
 // Config holds the configuration for a GRE tunnel.
 type Config struct {
-	Name     string
+	Name string
+	// LocalIP and RemoteIP are the outer path. Both IPv4 makes a gre link,
+	// both IPv6 an ip6gre one.
 	LocalIP  net.IP
 	RemoteIP net.IP
-	Key      uint32
-	TTL      uint8
-	MTU      int
+	// Dev, if set, binds the outer path to one device, as `ip link add
+	// ... dev` does.
+	Dev string
+	Key uint32
+	TTL uint8
+	MTU int
 
 	// Encapsulation. EncapNone preserves the legacy bare-GRE behaviour.
 	Encap         EncapType
 	EncapSport    uint16
 	EncapDport    uint16
 	EncapChecksum bool
+
+	// Loopback allows, and then requires, loopback local and remote IPs:
+	// the outer path of a relayed tunnel ends at a shim on this host.
+	Loopback bool
 }
 
 // Status represents the current state of a GRE tunnel.
@@ -44,7 +44,8 @@ func ValidateTunnelName(name string) error {
 	return nil
 }
 
-// ValidateCIDR validates a CIDR notation IP address, rejecting network and broadcast addresses.
+// ValidateCIDR validates a CIDR notation IP address, rejecting network and broadcast
+// addresses and IPv6 link-local ones.
 func ValidateCIDR(cidr string) error {
 	if cidr == "" {
 		return fmt.Errorf("CIDR cannot be empty")
@@ -55,14 +56,19 @@ func ValidateCIDR(cidr string) error {
 		return fmt.Errorf("invalid CIDR notation %q: %w", cidr, err)
 	}
 
+	ones, bits := ipNet.Mask.Size()
+
 	if ip.To4() == nil {
-		return fmt.Errorf("CIDR %q is not an IPv4 address", cidr)
+		// IPv6 has no broadcast address, and the subnet-router anycast
+		// address is only reserved on real subnets, not on tunnels.
+		if ip.IsLinkLocalUnicast() {
+			return fmt.Errorf("CIDR %q is an IPv6 link-local address", cidr)
+		}
+		return nil
 	}
 
-	ones, _ := ipNet.Mask.Size()
-
 	// /32 has a single address — network/broadcast checks don't apply.
-	if ones == 32 {
+	if ones == bits {
 		return nil
 	}
 
@@ -85,28 +91,41 @@ func ValidateCIDR(cidr string) error {
 	return nil
 }
 
-// ValidateIP validates an IP address, rejecting loopback, unspecified, multicast, and IPv6.
+// ValidateIP validates an IPv4 or IPv6 address, rejecting loopback, unspecified,
+// multicast, and IPv6 link-local addresses, which would need a zone.
 func ValidateIP(ip net.IP, fieldName string) error {
 	if ip == nil {
 		return fmt.Errorf("%s is required", fieldName)
 	}
 
 	if ip.IsUnspecified() {
-		return fmt.Errorf("%s cannot be unspecified (0.0.0.0)", fieldName)
+		return fmt.Errorf("%s cannot be unspecified (%s)", fieldName, ip.String())
 	}
 
 	if ip.IsLoopback() {
 		return fmt.Errorf("%s cannot be loopback address (%s)", fieldName, ip.String())
 	}
 
-	if ip.To4() == nil {
-		return fmt.Errorf("%s must be an IPv4 address (got %s)", fieldName, ip.String())
-	}
-
 	if ip.IsMulticast() {
 		return fmt.Errorf("%s cannot be a multicast address (%s)", fieldName, ip.String())
 	}
 
+	if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%s cannot be an IPv6 link-local address (%s)", fieldName, ip.String())
+	}
+
+	return nil
+}
+
+func validateLoopbackIP(ip net.IP, fieldName string) error {
+	if ip == nil {
+		return fmt.Errorf("%s is required", fieldName)
+	}
+
+	if ip.To4() == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s must be an IPv4 loopback address for a loopback tunnel (got %s)", fieldName, ip.String())
+	}
+
 	return nil
 }
 
@@ -173,12 +192,26 @@ func ValidateConfig(cfg Config) error {
 		}
 	}
 
-	if err := ValidateIP(cfg.LocalIP, "local IP"); err != nil {
-		return err
+	if cfg.Loopback {
+		if err := validateLoopbackIP(cfg.LocalIP, "local IP"); err != nil {
+			return err
+		}
+		if err := validateLoopbackIP(cfg.RemoteIP, "remote IP"); err != nil {
+			return err
+		}
+	} else {
+		if err := ValidateIP(cfg.LocalIP, "local IP"); err != nil {
+			return err
+		}
+
+		if err := ValidateIP(cfg.RemoteIP, "remote IP"); err != nil {
+			return err
+		}
 	}
 
-	if err := ValidateIP(cfg.RemoteIP, "remote IP"); err != nil {
-		return err
+	if isIPv6(cfg.LocalIP) != isIPv6(cfg.RemoteIP) {
+		return fmt.Errorf("local IP %s and remote IP %s are different address families",
+			cfg.LocalIP.String(), cfg.RemoteIP.String())
 	}
 
 	if cfg.LocalIP.Equal(cfg.RemoteIP) {
@@ -160,10 +160,15 @@ func TestValidateCIDR(t *testing.T) {
 			errMsg:  "broadcast address",
 		},
 		{
-			name:    "IPv6 address",
+			name:    "IPv6 link-local address",
 			input:   "fe80::1/64",
 			wantErr: true,
-			errMsg:  "not an IPv4 address",
+			errMsg:  "link-local",
+		},
+		{
+			name:    "IPv6 ULA address",
+			input:   "fd7a:115c:a1e0::1/64",
+			wantErr: false,
 		},
 		{
 			name:    "network address /16",
@@ -242,11 +247,17 @@ func TestValidateIP(t *testing.T) {
 			errMsg:    "cannot be loopback",
 		},
 		{
-			name:      "IPv6 address",
+			name:      "IPv6 link-local address",
 			ip:        net.ParseIP("fe80::1"),
 			fieldName: "test IP",
 			wantErr:   true,
-			errMsg:    "must be an IPv4 address",
+			errMsg:    "link-local",
+		},
+		{
+			name:      "global IPv6",
+			ip:        net.ParseIP("2001:db8::1"),
+			fieldName: "test IP",
+			wantErr:   false,
 		},
 		{
 			name:      "multicast IPv4",
@@ -366,6 +377,25 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid IPv6 outer path",
+			cfg: Config{
+				Name:     "gre0",
+				LocalIP:  net.ParseIP("2001:db8::1"),
+				RemoteIP: net.ParseIP("2001:db8::2"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "mixed address families",
+			cfg: Config{
+				Name:     "gre0",
+				LocalIP:  net.ParseIP("10.0.0.1"),
+				RemoteIP: net.ParseIP("2001:db8::2"),
+			},
+			wantErr: true,
+			errMsg:  "different address families",
+		},
 		{
 			name: "invalid tunnel name",
 			cfg: Config{
@@ -416,6 +446,27 @@ func TestValidateConfig(t *testing.T) {
 			wantErr: true,
 			errMsg:  "cannot be loopback",
 		},
+		{
+			name: "loopback tunnel",
+			cfg: Config{
+				Name:     "gre0",
+				LocalIP:  net.ParseIP("127.0.0.1"),
+				RemoteIP: net.ParseIP("127.77.0.1"),
+				Loopback: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "loopback tunnel to a routable IP",
+			cfg: Config{
+				Name:     "gre0",
+				LocalIP:  net.ParseIP("127.0.0.1"),
+				RemoteIP: net.ParseIP("10.0.0.2"),
+				Loopback: true,
+			},
+			wantErr: true,
+			errMsg:  "must be an IPv4 loopback address",
+		},
 		{
 			name: "multicast remote IP",
 			cfg: Config{